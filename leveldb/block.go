@@ -5,22 +5,284 @@ import "unsafe"
 type Block struct {
 	data []byte
 	size uint
-	restartOffset uint32
-	owned bool
+	restartOffset uint32	// Offset in data of restart array
+	owned bool	// Block owns data[]
 }
 
-func (this *Block) NewIterator(comparator Comparator) Iterator {
+// Initialize the block with the specified contents.
+func newBlock(contents *BlockContents) *Block {
+	var s uint32
+	result := &Block{
+		data: contents.data,
+		size: uint(len(contents.data)),
+		owned: contents.heapAllocated,
+	}
+
+	if result.size < uint(unsafe.Sizeof(s)) {
+		result.size = 0	// Error marker
+	} else {
+		maxRestartsAllowed := (result.size - uint(unsafe.Sizeof(s))) / uint(unsafe.Sizeof(s))
+		if uint(result.NumRestarts()) > maxRestartsAllowed {
+			// The size is too small for NumRestarts()
+			result.size = 0
+		} else {
+			result.restartOffset = uint32(result.size) - (1 + result.NumRestarts()) * uint32(unsafe.Sizeof(s))
+		}
+	}
+
+	return result
+}
+
+func (this *Block) Size() uint {
+	return this.size
+}
+
+func (this *Block) NumRestarts() uint32 {
+	return decodeFixed32(bytesToString(this.data[this.size - 4 : this.size]))
+}
+
+func (this *Block) NewIterator(comparator Comparator) iterator {
 	var s uint32
 	if (this.size < uint(unsafe.Sizeof(s)) ) {
 		return NewErrorIterator(Corruption("bad block contents"))
 	}
-	numRestarts := 1 // NumRestarts();
+	numRestarts := this.NumRestarts()
 
 	if (numRestarts == 0) {
 		return NewEmptyIterator()
+	}
+
+	iter := newBlockIter(comparator, this.data[:this.size], this.restartOffset, numRestarts)
+	iter.owned = this.owned
+
+	return iter
+}
+
+// Helper routine: decode the next block entry starting at "p",
+// storing the number of shared key bytes, non_shared key bytes,
+// and the length of the value in "*shared", "*nonShared", and
+// "*valueLength", respectively.  Will not dereference past "limit".
+//
+// If any errors are detected, returns -1.  Otherwise, returns the
+// offset of the key delta (just past the three decoded values).
+func decodeEntry(data []byte, p uint32, limit uint32, shared *uint32, nonShared *uint32, valueLength *uint32) int {
+	if limit - p < 3 {
+		return -1
+	}
+	*shared = uint32(data[p])
+	*nonShared = uint32(data[p + 1])
+	*valueLength = uint32(data[p + 2])
+	if (*shared | *nonShared | *valueLength) < 128 {
+		// Fast path: all three values are encoded in one byte each
+		p += 3
 	} else {
-		// new Iter(cmp, data_, restart_offset_, num_restarts);
+		input := data[p:limit]
+		if !getVarint32(&input, shared) ||
+			!getVarint32(&input, nonShared) ||
+			!getVarint32(&input, valueLength) {
+			return -1
+		}
+		p = limit - uint32(len(input))
+	}
+
+	if uint64(limit - p) < uint64(*nonShared) + uint64(*valueLength) {
+		return -1
+	}
+
+	return int(p)
+}
+
+type blockIter struct {
+	comparator Comparator
+	data []byte	// underlying block contents
+	restarts uint32	// Offset of restart array (list of fixed32)
+	numRestarts uint32	// Number of uint32 entries in restart array
+
+	// current is offset in data of current entry.  >= restarts if !Valid
+	current uint32
+	restartIndex uint32	// Index of restart block in which current falls
+	key string
+	valueOffset uint32
+	valueLength uint32
+	s Status
+	owned bool	// data is on the heap rather than in a mapping of the file
+}
+
+func newBlockIter(comparator Comparator, data []byte, restarts uint32, numRestarts uint32) *blockIter {
+	return &blockIter{
+		comparator: comparator,
+		data: data,
+		restarts: restarts,
+		numRestarts: numRestarts,
+		current: restarts,
+		restartIndex: numRestarts,
+	}
+}
+
+func (this *blockIter) compare(a string, b string) int {
+	return this.comparator.Compare(a, b)
+}
+
+// Return the offset in data just past the end of the current entry.
+func (this *blockIter) nextEntryOffset() uint32 {
+	return this.valueOffset + this.valueLength
+}
+
+func (this *blockIter) getRestartPoint(index uint32) uint32 {
+	offset := this.restarts + index * 4
+	return decodeFixed32(bytesToString(this.data[offset : offset + 4]))
+}
+
+func (this *blockIter) seekToRestartPoint(index uint32) {
+	this.key = ""
+	this.restartIndex = index
+	// current will be fixed by parseNextKey()
+
+	// parseNextKey() starts at the end of value, so set value accordingly
+	this.valueOffset = this.getRestartPoint(index)
+	this.valueLength = 0
+}
+
+func (this *blockIter) Valid() bool {
+	return this.current < this.restarts
+}
+
+func (this *blockIter) Status() Status {
+	return this.s
+}
+
+func (this *blockIter) Close() {
+}
+
+func (this *blockIter) Key() string {
+	return this.key
+}
+
+func (this *blockIter) Value() string {
+	value := this.data[this.valueOffset : this.valueOffset + this.valueLength]
+	if this.owned {
+		// Heap blocks are never modified, and the collector keeps them alive
+		// as long as the value is referenced.
+		return bytesToString(value)
+	}
+
+	// A mapped block is unmapped when its table is evicted from the table
+	// cache, while the caller may still hold the value, so it must be copied.
+	return string(value)
+}
+
+func (this *blockIter) Next() {
+	this.parseNextKey()
+}
+
+func (this *blockIter) Prev() {
+	// Scan backwards to a restart point before current
+	original := this.current
+	for this.getRestartPoint(this.restartIndex) >= original {
+		if this.restartIndex == 0 {
+			// No more entries
+			this.current = this.restarts
+			this.restartIndex = this.numRestarts
+			return
+		}
+		this.restartIndex--
+	}
+
+	this.seekToRestartPoint(this.restartIndex)
+	for {
+		// Loop until end of current entry hits the start of original entry
+		if !this.parseNextKey() || this.nextEntryOffset() >= original {
+			break
+		}
+	}
+}
+
+func (this *blockIter) Seek(target string) {
+	// Binary search in restart array to find the last restart point
+	// with a key < target
+	left := uint32(0)
+	right := this.numRestarts - 1
+	for left < right {
+		mid := (left + right + 1) / 2
+		regionOffset := this.getRestartPoint(mid)
+		var shared, nonShared, valueLength uint32
+		keyPtr := decodeEntry(this.data, regionOffset, this.restarts, &shared, &nonShared, &valueLength)
+		if keyPtr < 0 || shared != 0 {
+			this.corruptionError()
+			return
+		}
+
+		midKey := string(this.data[keyPtr : uint32(keyPtr) + nonShared])
+		if this.compare(midKey, target) < 0 {
+			// Key at "mid" is smaller than "target".  Therefore all
+			// blocks before "mid" are uninteresting.
+			left = mid
+		} else {
+			// Key at "mid" is >= "target".  Therefore all blocks at or
+			// after "mid" are uninteresting.
+			right = mid - 1
+		}
+	}
+
+	// Linear search (within restart block) for first key >= target
+	this.seekToRestartPoint(left)
+	for {
+		if !this.parseNextKey() {
+			return
+		}
+		if this.compare(this.key, target) >= 0 {
+			return
+		}
+	}
+}
+
+func (this *blockIter) SeekToFirst() {
+	this.seekToRestartPoint(0)
+	this.parseNextKey()
+}
+
+func (this *blockIter) SeekToLast() {
+	this.seekToRestartPoint(this.numRestarts - 1)
+	for this.parseNextKey() && this.nextEntryOffset() < this.restarts {
+		// Keep skipping
 	}
+}
 
-	return NewEmptyIterator()
-}
\ No newline at end of file
+func (this *blockIter) corruptionError() {
+	this.current = this.restarts
+	this.restartIndex = this.numRestarts
+	this.s = Corruption("bad entry in block")
+	this.key = ""
+	this.valueOffset = 0
+	this.valueLength = 0
+}
+
+func (this *blockIter) parseNextKey() bool {
+	this.current = this.nextEntryOffset()
+	p := this.current
+	limit := this.restarts	// Restarts come right after data
+	if p >= limit {
+		// No more entries to return.  Mark as invalid.
+		this.current = this.restarts
+		this.restartIndex = this.numRestarts
+		return false
+	}
+
+	// Decode next entry
+	var shared, nonShared, valueLength uint32
+	keyPtr := decodeEntry(this.data, p, limit, &shared, &nonShared, &valueLength)
+	if keyPtr < 0 || uint32(len(this.key)) < shared {
+		this.corruptionError()
+		return false
+	}
+
+	this.key = this.key[:shared] + string(this.data[keyPtr : uint32(keyPtr) + nonShared])
+	this.valueOffset = uint32(keyPtr) + nonShared
+	this.valueLength = valueLength
+	for this.restartIndex + 1 < this.numRestarts &&
+		this.getRestartPoint(this.restartIndex + 1) < this.current {
+		this.restartIndex++
+	}
+
+	return true
+}
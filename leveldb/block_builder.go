@@ -1,12 +1,34 @@
 package leveldb
 
 import (
-	"unsafe"
-	"encoding/binary"
 	"./utilties"
 	"fmt"
 )
 
+// BlockBuilder generates blocks where keys are prefix-compressed:
+//
+// When we store a key, we drop the prefix shared with the previous
+// string.  This helps reduce the space requirement significantly.
+// Furthermore, once every K keys, we do not apply the prefix
+// compression and store the entire key.  We call this a "restart
+// point".  The tail end of the block stores the offsets of all of the
+// restart points, and can be used to do a binary search when looking
+// for a particular key.  Values are stored as-is (without compression)
+// immediately following the corresponding key.
+//
+// An entry for a particular key-value pair has the form:
+//     shared_bytes: varint32
+//     unshared_bytes: varint32
+//     value_length: varint32
+//     key_delta: char[unshared_bytes]
+//     value: char[value_length]
+// shared_bytes == 0 for restart points.
+//
+// The trailer of the block has the form:
+//     restarts: uint32[num_restarts]
+//     num_restarts: uint32
+// restarts[i] contains the offset within the block of the ith restart point.
+
 type BlockBuilder struct {
 	options *Options
 	buffer []byte	// Destination buffer
@@ -19,6 +41,7 @@ type BlockBuilder struct {
 func newBlockBuilder(options *Options) *BlockBuilder {
 	return &BlockBuilder{
 		options: options,
+		restarts: []int{0},	// First restart point is at offset 0
 		counter: 0,
 		finished: false,
 	}
@@ -27,7 +50,7 @@ func newBlockBuilder(options *Options) *BlockBuilder {
 // Reset the contents as if the BlockBuilder was just constructed.
 func (this *BlockBuilder) Reset() {
 	this.buffer = this.buffer[:0]
-	this.restarts = this.restarts[ :0]
+	this.restarts = append(this.restarts[ :0], 0)	// First restart point is at offset 0
 	this.counter = 0
 	this.finished = false
 	this.lastKey = this.lastKey[ :0]
@@ -52,22 +75,18 @@ func (this *BlockBuilder) Add(key []byte, value []byte) {
 
 	nonShared := len(key) - shared
 
-	// Add "shared nonShared valueSize" to buffer
-	tmpBuffer := make([]byte, 4 * 3)
-
-	binary.PutUvarint(tmpBuffer[0 * 4: ], uint64(shared) )
-	binary.PutUvarint(tmpBuffer[1 * 4: ], uint64(nonShared) )
-	binary.PutUvarint(tmpBuffer[2 * 4: ], uint64(len(value)) )
-
-	this.buffer = append(this.buffer, tmpBuffer ...)
+	// Add "<shared><nonShared><valueSize>" to buffer
+	putVarint32(&this.buffer, uint32(shared))
+	putVarint32(&this.buffer, uint32(nonShared))
+	putVarint32(&this.buffer, uint32(len(value)))
 	
 	// Add string delta to buffer followed by value
-	this.buffer = append(this.buffer, []byte(key[shared:nonShared]) ...) 
+	this.buffer = append(this.buffer, key[shared:] ...)
 	this.buffer = append(this.buffer, value ...)
 	
 	// update status
 	this.lastKey = this.lastKey[:shared]
-	this.lastKey = append(this.lastKey, key[shared: nonShared] ...)
+	this.lastKey = append(this.lastKey, key[shared:] ...)
 	
 	if (len(this.lastKey) != len(key) ) {
 		panic( fmt.Sprintf("inequal key: %s %s\n", this.lastKey, key ) )
@@ -85,33 +104,27 @@ func (this *BlockBuilder) Add(key []byte, value []byte) {
 // Finish building the block and return a []byte that refers to the
 // block contents.
 func (this *BlockBuilder) Finish() []byte {
-	if this.restarts == nil {
-		return nil
-	}
-
 	if this.finished {
-		return nil
+		return this.buffer
 	}
 
-	restartNum := len(this.restarts)
-
-	tmpBuffer := make([]byte, restartNum + 1)
-
-	for index, key := range this.restarts {
-		binary.PutUvarint(tmpBuffer[index* 4: ], uint64(key) )
+	// Append restart array
+	for _, restart := range this.restarts {
+		putFixed32(&this.buffer, uint32(restart))
 	}
-
-	binary.PutUvarint(tmpBuffer[restartNum * 4 : ], uint64(restartNum) )
+	putFixed32(&this.buffer, uint32(len(this.restarts)))
 
 	this.finished = true
 
-	return append(this.buffer, tmpBuffer ...)
+	return this.buffer
 }
 
 // Returns an estimate of the current size (uncompressed) of the block
 // we are building
 func (this *BlockBuilder) CurrentSizeEstimate() uint {
-	return uint(len(this.buffer)) + uint(len(this.restarts) * 4 ) + uint(unsafe.Sizeof(this.counter) )
+	return uint(len(this.buffer)) +	// Raw data buffer
+		uint(len(this.restarts) * 4) +	// Restart array
+		4	// Restart array length
 }
 
 // Return true if no entries have been added since the last Reset()
@@ -0,0 +1,69 @@
+package leveldb
+
+// Build a Table file from the contents of *iter.  The generated file
+// will be named according to meta.number.  On success, the rest of
+// *meta will be filled with metadata about the generated table.
+// If no data is present in *iter, meta.fileSize will be set to
+// zero, and no Table file will be produced.
+func BuildTable(dbName string, env Env, options *Options, tableCache *TableCache, iter iterator, meta *FileMetaData) Status {
+	s := OK()
+	meta.fileSize = 0
+	iter.SeekToFirst()
+
+	fname := TableFileName(dbName, meta.number)
+	if iter.Valid() {
+		var file WritableFile
+		s = env.NewWritableFile(fname, &file)
+		if !s.OK() {
+			return s
+		}
+
+		builder := NewTableBuilder(options, file)
+		meta.smallest = new(internalKey)
+		meta.smallest.decodeFrom(iter.Key())
+
+		var key string
+		for ; iter.Valid(); iter.Next() {
+			key = iter.Key()
+			builder.Add(key, iter.Value())
+		}
+
+		meta.largest = new(internalKey)
+		meta.largest.decodeFrom(key)
+
+		// Finish and check for builder errors
+		s = builder.Finish()
+		if s.OK() {
+			meta.fileSize = builder.FileSize()
+		}
+
+		// Finish and check for file errors
+		if s.OK() {
+			s = file.Sync()
+		}
+		if s.OK() {
+			s = file.Close()
+		}
+		file = nil
+
+		if s.OK() {
+			// Verify that the table is usable
+			it := tableCache.NewIterator(&ReadOptions{}, meta.number, meta.fileSize, 0, nil)
+			s = it.Status()
+			it.Close()
+		}
+	}
+
+	// Check for input iterator errors
+	if iterStatus := iter.Status(); !iterStatus.OK() {
+		s = iterStatus
+	}
+
+	if s.OK() && meta.fileSize > 0 {
+		// Keep it
+	} else {
+		env.DeleteFile(fname)
+	}
+
+	return s
+}
@@ -1,6 +1,7 @@
 package leveldb
 
 import (
+	"hash/fnv"
 	"sync"
 )
 
@@ -10,8 +11,61 @@ const kNumShardBits = 4
 
 const kNumShards = 1 << kNumShardBits
 
+// A Cache is an interface that maps keys to values.  It has internal
+// synchronization and may be safely accessed concurrently from
+// multiple threads.  It may automatically evict entries to make room
+// for new entries.  Values have a specified charge against the cache
+// capacity.  For example, a cache where the values are variable
+// length strings, may use the length of the string as the charge for
+// the string.
 type Cache interface {
+	// Insert a mapping from key->value into the cache and assign it
+	// the specified charge against the total cache capacity.
+	//
+	// Returns a handle that corresponds to the mapping.  The caller
+	// must call Release(handle) when the returned mapping is no
+	// longer needed.
+	//
+	// When the inserted entry is no longer needed, the key and
+	// value will be passed to "deleter".
+	Insert(key string, value *interface{}, charge uint, deleter func(string, *interface{})) interface{}
+
+	// If the cache has no mapping for "key", returns nil.
+	//
+	// Else return a handle that corresponds to the mapping.  The caller
+	// must call Release(handle) when the returned mapping is no
+	// longer needed.
+	Lookup(key string) interface{}
+
+	// Release a mapping returned by a previous Lookup().
+	// REQUIRES: handle must not have been released yet.
+	// REQUIRES: handle must have been returned by a method on this instance.
+	Release(handle interface{})
+
+	// Return the value encapsulated in a handle returned by a
+	// successful Lookup().
+	// REQUIRES: handle must not have been released yet.
+	// REQUIRES: handle must have been returned by a method on this instance.
 	Value(handle *interface{}) *interface{}
+
+	// If the cache contains entry for key, erase it.  Note that the
+	// underlying entry will be kept around until all existing handles
+	// to it have been released.
+	Erase(key string)
+
+	// Return a new numeric id.  May be used by multiple clients who are
+	// sharing the same cache to partition the key space.  Typically the
+	// client will allocate a new id at startup and prepend the id to
+	// its cache keys.
+	NewId() uint64
+
+	// Remove all cache entries that are not actively in use.  Memory-constrained
+	// applications may wish to call this method to reduce memory usage.
+	Prune()
+
+	// Return an estimate of the combined charges of all elements stored in the
+	// cache.
+	TotalCharge() uint
 }
 
 
@@ -20,45 +74,160 @@ type Cache interface {
 type LRUHandle struct {
 	value *interface{}
 	deleter func(string, *interface{})
-	nextHash *LRUHandle
 	next *LRUHandle
 	prev *LRUHandle
 	charge uint
 	hash uint32
+	inCache bool	// Whether entry is in the cache.
+	refs uint32		// References, including cache reference, if present.
 	keyData []byte
 }
 
 func (this *LRUHandle) key() string {
-	if this.next == this {
-		value, _ := (*this.value).(string)
-		return value
-	}
-
 	return string(this.keyData)
 }
 
-type HandleTable struct {
-	// The table consists of an array of buckets where each bucket is
-  	// a linked list of cache entries that hash into the bucket.
-	length uint32
-	elems uint32
-	list **LRUHandle
-
-}
-
+// A single shard of sharded cache.
+//
+// The leveldb hash table is replaced by a go map for now, the lru
+// list keeps the same semantics.
 type LRUCache struct {
 	capacity uint
 	mutex *sync.Mutex
+	usage uint
 
+	// Dummy head of LRU list.
+	// lru.prev is newest entry, lru.next is oldest entry.
 	lru LRUHandle
 
-	table HandleTable
+	table map[string]*LRUHandle
+}
+
+func newLRUCache() *LRUCache {
+	var result LRUCache
+	result.mutex = &sync.Mutex{}
+	result.table = make(map[string]*LRUHandle)
+
+	// Make empty circular linked list
+	result.lru.next = &result.lru
+	result.lru.prev = &result.lru
+
+	return &result
 }
 
 func (this *LRUCache) SetCapacity(capacity uint) {
 	this.capacity = capacity
 }
 
+func (this *LRUCache) unref(e *LRUHandle) {
+	e.refs--
+	if e.refs == 0 {
+		e.deleter(e.key(), e.value)
+	}
+}
+
+func (this *LRUCache) lruRemove(e *LRUHandle) {
+	e.next.prev = e.prev
+	e.prev.next = e.next
+}
+
+func (this *LRUCache) lruAppend(e *LRUHandle) {
+	// Make "e" newest entry by inserting just before lru
+	e.next = &this.lru
+	e.prev = this.lru.prev
+	e.prev.next = e
+	e.next.prev = e
+}
+
+// Remove e from the cache; the entry itself lives on until its last
+// handle is released.
+func (this *LRUCache) finishErase(e *LRUHandle) {
+	if e != nil {
+		delete(this.table, e.key())
+		this.lruRemove(e)
+		e.inCache = false
+		this.usage -= e.charge
+		this.unref(e)
+	}
+}
+
+func (this *LRUCache) Insert(key string, hash uint32, value *interface{}, charge uint, deleter func(string, *interface{})) *LRUHandle {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e := &LRUHandle{
+		value: value,
+		deleter: deleter,
+		charge: charge,
+		hash: hash,
+		inCache: true,
+		refs: 2,	// One from LRUCache, one for the returned handle
+		keyData: []byte(key),
+	}
+
+	this.finishErase(this.table[key])
+	this.table[key] = e
+	this.lruAppend(e)
+	this.usage += charge
+
+	for this.usage > this.capacity && this.lru.next != &this.lru {
+		old := this.lru.next
+		this.finishErase(old)
+	}
+
+	return e
+}
+
+func (this *LRUCache) Lookup(key string) *LRUHandle {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	e, ok := this.table[key]
+	if ok {
+		e.refs++
+		this.lruRemove(e)
+		this.lruAppend(e)
+		return e
+	}
+
+	return nil
+}
+
+func (this *LRUCache) Release(e *LRUHandle) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.unref(e)
+}
+
+func (this *LRUCache) Erase(key string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.finishErase(this.table[key])
+}
+
+func (this *LRUCache) Prune() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for e := this.lru.next; e != &this.lru; {
+		next := e.next
+		if e.refs == 1 {
+			// Only the cache holds a reference to this entry.
+			this.finishErase(e)
+		}
+		e = next
+	}
+}
+
+func (this *LRUCache) TotalCharge() uint {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.usage
+}
+
 
 type ShardedLRUCache struct {
 	shared [kNumShards]*LRUCache
@@ -66,24 +235,85 @@ type ShardedLRUCache struct {
 	lastID	uint64
 }
 
+func hashSlice(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+func shard(hash uint32) uint32 {
+	return hash >> (32 - kNumShardBits)
+}
+
+func (this *ShardedLRUCache) Insert(key string, value *interface{}, charge uint, deleter func(string, *interface{})) interface{} {
+	hash := hashSlice(key)
+	return this.shared[shard(hash)].Insert(key, hash, value, charge, deleter)
+}
+
+func (this *ShardedLRUCache) Lookup(key string) interface{} {
+	hash := hashSlice(key)
+	e := this.shared[shard(hash)].Lookup(key)
+	if e == nil {
+		return nil
+	}
+
+	return e
+}
+
+func (this *ShardedLRUCache) Release(handle interface{}) {
+	e := handle.(*LRUHandle)
+	this.shared[shard(e.hash)].Release(e)
+}
+
+func (this *ShardedLRUCache) Erase(key string) {
+	hash := hashSlice(key)
+	this.shared[shard(hash)].Erase(key)
+}
+
 func (this *ShardedLRUCache) Value(handle *interface{}) *interface{} {
-	lruHandle, ok := (*handle).(LRUHandle)
-	if !ok {
+	lruHandle, ok := (*handle).(*LRUHandle)
+	if ok {
 		return lruHandle.value
 	}
 
 	return nil
 }
 
+func (this *ShardedLRUCache) NewId() uint64 {
+	this.idMutex.Lock()
+	defer this.idMutex.Unlock()
+
+	this.lastID++
+	return this.lastID
+}
+
+func (this *ShardedLRUCache) Prune() {
+	for s := 0; s < kNumShards; s++ {
+		this.shared[s].Prune()
+	}
+}
+
+func (this *ShardedLRUCache) TotalCharge() uint {
+	var total uint
+	for s := 0; s < kNumShards; s++ {
+		total += this.shared[s].TotalCharge()
+	}
+
+	return total
+}
+
+// Create a new cache with a fixed size capacity.  This implementation
+// of Cache uses a least-recently-used eviction policy.
 func NewLRUCache(capacity int) Cache {
 	var result ShardedLRUCache
+	result.idMutex = &sync.Mutex{}
 
 	perShared := (capacity + (kNumShards - 1) ) / kNumShards
 
 	for i := 0; i < kNumShards; i++ {
+		result.shared[i] = newLRUCache()
 		result.shared[i].SetCapacity(uint(perShared) )
 	}
 
 	return &result
 }
-
@@ -0,0 +1,195 @@
+package leveldb
+
+// Endian-neutral encoding:
+// * Fixed-length numbers are encoded with least-significant byte first
+// * In addition we support variable length "varint" encoding
+// * Strings are encoded prefixed by their length in varint format
+//
+// This is the encoding used by the C++ LevelDB, so files written by
+// either implementation can be read by the other.
+
+const (
+	kMaxVarint32Length = 5
+	kMaxVarint64Length = 10
+)
+
+// Lower-level versions of Put... that write directly into a byte
+// buffer.
+// REQUIRES: "buf" has enough space for the value being written
+
+func encodeFixed32(buf []byte, value uint32) {
+	_ = buf[3]	// Bounds check hint to compiler
+	buf[0] = byte(value)
+	buf[1] = byte(value >> 8)
+	buf[2] = byte(value >> 16)
+	buf[3] = byte(value >> 24)
+}
+
+func encodeFixed64(buf []byte, value uint64) {
+	_ = buf[7]	// Bounds check hint to compiler
+	buf[0] = byte(value)
+	buf[1] = byte(value >> 8)
+	buf[2] = byte(value >> 16)
+	buf[3] = byte(value >> 24)
+	buf[4] = byte(value >> 32)
+	buf[5] = byte(value >> 40)
+	buf[6] = byte(value >> 48)
+	buf[7] = byte(value >> 56)
+}
+
+// Lower-level versions of Put... that write directly into a byte buffer
+// and return the number of bytes written.
+// REQUIRES: "buf" has enough space for the value being written
+
+func encodeVarint32(buf []byte, value uint32) int {
+	return encodeVarint64(buf, uint64(value))
+}
+
+func encodeVarint64(buf []byte, value uint64) int {
+	const B = 128
+	n := 0
+	for value >= B {
+		buf[n] = byte(value | B)
+		value >>= 7
+		n++
+	}
+	buf[n] = byte(value)
+
+	return n + 1
+}
+
+// Lower-level versions of Get... that read directly from a string.
+// REQUIRES: "value" holds at least as many bytes as the number decoded
+
+func decodeFixed32(value string) uint32 {
+	_ = value[3]	// Bounds check hint to compiler
+	return uint32(value[0]) |
+		uint32(value[1]) << 8 |
+		uint32(value[2]) << 16 |
+		uint32(value[3]) << 24
+}
+
+func decodeFixed64(value string) uint64 {
+	_ = value[7]	// Bounds check hint to compiler
+	lo := uint64(decodeFixed32(value))
+	hi := uint64(decodeFixed32(value[4:]))
+
+	return (hi << 32) | lo
+}
+
+// Standard Put... routines append to a buffer
+func putFixed32(dst *[]byte, value uint32) {
+	var buf [4]byte
+	encodeFixed32(buf[:], value)
+	*dst = append(*dst, buf[:]...)
+}
+
+func putFixed64(dst *[]byte, value uint64) {
+	var buf [8]byte
+	encodeFixed64(buf[:], value)
+	*dst = append(*dst, buf[:]...)
+}
+
+func putVarint32(dst *[]byte, value uint32) {
+	var buf [kMaxVarint32Length]byte
+	n := encodeVarint32(buf[:], value)
+	*dst = append(*dst, buf[:n]...)
+}
+
+func putVarint64(dst *[]byte, value uint64) {
+	var buf [kMaxVarint64Length]byte
+	n := encodeVarint64(buf[:], value)
+	*dst = append(*dst, buf[:n]...)
+}
+
+func putLengthPrefixedSlice(dst *[]byte, value string) {
+	putVarint32(dst, uint32(len(value)))
+	*dst = append(*dst, value...)
+}
+
+// Returns the length of the varint32 or varint64 encoding of "v"
+func varintLength(v uint64) int {
+	l := 1
+	for v >= 128 {
+		v >>= 7
+		l++
+	}
+	return l
+}
+
+// Decode a varint64 of at most "maxLength" bytes from the front of
+// "input".  Returns the value and the number of bytes it occupied, or
+// 0 bytes if "input" does not start with a valid varint.
+func decodeVarint(input string, maxLength int) (uint64, int) {
+	var result uint64
+	for shift, i := uint(0), 0; i < maxLength && i < len(input); shift, i = shift + 7, i + 1 {
+		b := uint64(input[i])
+		if b & 128 == 0 {
+			return result | (b << shift), i + 1
+		}
+		result |= (b & 127) << shift
+	}
+
+	return 0, 0
+}
+
+// Standard Get... routines parse a value from the beginning of a Slice
+// and advance the slice past the parsed value.
+func getVarint32(input *[]byte, value *uint32) bool {
+	result, n := decodeVarint(bytesToString(*input), kMaxVarint32Length)
+	if n == 0 || result > 0xffffffff {
+		return false
+	}
+	*value = uint32(result)
+	*input = (*input)[n:]
+
+	return true
+}
+
+func getVarint64(input *[]byte, value *uint64) bool {
+	result, n := decodeVarint(bytesToString(*input), kMaxVarint64Length)
+	if n == 0 {
+		return false
+	}
+	*value = result
+	*input = (*input)[n:]
+
+	return true
+}
+
+// Like getLengthPrefixedBytes, but "*result" shares storage with
+// "*input" instead of holding a copy.
+func getLengthPrefixedView(input *[]byte, result *[]byte) bool {
+	var l uint32
+	in := *input
+	if getVarint32(&in, &l) && uint64(len(in)) >= uint64(l) {
+		*result = in[:l:l]
+		*input = in[l:]
+		return true
+	}
+
+	return false
+}
+
+func getLengthPrefixedBytes(input *[]byte, result *string) bool {
+	var l uint32
+	in := *input
+	if getVarint32(&in, &l) && uint64(len(in)) >= uint64(l) {
+		*result = string(in[:l])
+		*input = in[l:]
+		return true
+	}
+
+	return false
+}
+
+// Decode a varint32 length followed by that many bytes from the front
+// of str.  Returns the decoded bytes and whatever follows them.
+func getLengthPrefixedSlice(str string) (string, string) {
+	l, n := decodeVarint(str, kMaxVarint32Length)
+	if n == 0 || uint64(len(str) - n) < l {
+		return "", ""
+	}
+
+	return str[n : n + int(l)], str[n + int(l):]
+}
@@ -1,7 +1,9 @@
 package leveldb
 
 import (
+	"encoding/binary"
 	"strings"
+	"sync"
 	"./utilties"
 )
 
@@ -27,14 +29,71 @@ type Comparator interface {
 	FindShortSuccessor(key *string)
 }
 
+// A comparator for internal keys that uses a specified comparator for
+// the user key portion and breaks ties by decreasing sequence number.
+// It does not embed the user comparator, so none of its methods can
+// end up being applied to whole internal keys.
 type internalKeyComparator struct {
-	Comparator
+	user Comparator
 }
 
+var comparators = struct {
+	sync.RWMutex
+	m map[string]Comparator
+}{
+	m: make(map[string]Comparator),
+}
+
+// Make "comparator" available under its Name(), replacing any
+// Comparator registered under that name before.  A DB records the
+// name of its comparator in its MANIFEST, so tools can use
+// ReadComparatorName and GetComparator to open a DB without knowing
+// its key order in advance.
+func RegisterComparator(comparator Comparator) Status {
+	if comparator == nil {
+		return InvalidArgument("nil comparator")
+	}
+
+	comparators.Lock()
+	defer comparators.Unlock()
+
+	comparators.m[comparator.Name()] = comparator
+	return OK()
+}
+
+// Return the Comparator registered under "name", or nil if there is none.
+func GetComparator(name string) Comparator {
+	comparators.RLock()
+	defer comparators.RUnlock()
+
+	return comparators.m[name]
+}
+
+func init() {
+	RegisterComparator(BytewiseComparator())
+	RegisterComparator(ReverseBytewiseComparator())
+	RegisterComparator(Uint64Comparator())
+}
+
+// Return a builtin comparator that uses lexicographic byte-wise
+// ordering.
 func BytewiseComparator() Comparator {
 	return &bytewiseComparator{}
 }
 
+// Return a builtin comparator that orders keys in the reverse of the
+// order BytewiseComparator uses.
+func ReverseBytewiseComparator() Comparator {
+	return &reverseBytewiseComparator{}
+}
+
+// Return a builtin comparator for keys that are 64-bit unsigned
+// integers encoded in 8 bytes, most significant byte first (see
+// EncodeUint64Key).  Keys are ordered numerically.
+func Uint64Comparator() Comparator {
+	return &uint64Comparator{}
+}
+
 
 type bytewiseComparator struct {
 }
@@ -55,27 +114,97 @@ func (this *bytewiseComparator) FindShortestSeparator(start *string, limit strin
 	}
 
 	if diffIndex >= minLength {
-
+		// Do not shorten if one string is a prefix of the other
 	} else {
 		diffByte := (*start)[diffIndex]
 		if (diffByte < 0xFF) && (diffByte + 1) < limit[diffIndex] {
-			char := diffByte + 1
-			*start = (*start)[0 : diffIndex]
-			*start += string(char +1)
+			b := []byte((*start)[0 : diffIndex + 1])
+			b[diffIndex]++
+			*start = string(b)
 
-			//assert(strings.Compare(start, limit) < 0)
+			//assert(strings.Compare(*start, limit) < 0)
 		}
 	}
 }
 
 func (this *bytewiseComparator) FindShortSuccessor(key *string) {
-	for i, c := range *key {
-		if c != 0xFF {
-			(*key) = (*key)[0: i]
-			(*key) += string(c + 1)
-			break
+	// Find first character that can be incremented
+	for i := 0; i < len(*key); i++ {
+		if (*key)[i] != 0xFF {
+			b := []byte((*key)[0 : i + 1])
+			b[i]++
+			*key = string(b)
+			return
 		}
 	}
+	// *key is a run of 0xffs.  Leave it alone.
+}
+
+type reverseBytewiseComparator struct {
+}
+
+func (this *reverseBytewiseComparator) Compare(aKey string, bKey string) int {
+	return -strings.Compare(aKey, bKey)
+}
+
+func (this *reverseBytewiseComparator) Name() string {
+	return "leveldb.ReverseBytewiseComparator"
+}
+
+func (this *reverseBytewiseComparator) FindShortestSeparator(start *string, limit string) {
+	minLength := utilties.Min(len(*start), len(limit))
+	diffIndex := 0
+	for((diffIndex < minLength) && (*start)[diffIndex] == limit[diffIndex]) {
+		diffIndex += 1
+	}
+
+	if diffIndex >= minLength {
+		// Do not shorten if one string is a prefix of the other
+	} else if (*start)[diffIndex] > limit[diffIndex] && diffIndex < len(*start) - 1 {
+		// A prefix of *start sorts after it in reverse order, and the
+		// one ending at diffIndex still sorts before limit.
+		*start = (*start)[0 : diffIndex + 1]
+
+		//assert(this.Compare(*start, limit) < 0)
+	}
+}
+
+func (this *reverseBytewiseComparator) FindShortSuccessor(key *string) {
+	// Don't do anything for simplicity.
+}
+
+type uint64Comparator struct {
+}
+
+// Store "v" in the form the keys of Uint64Comparator take.
+func EncodeUint64Key(v uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, v)
+	return key
+}
+
+// Return the number stored in a key of Uint64Comparator.
+// REQUIRES: len(key) == 8
+func DecodeUint64Key(key []byte) uint64 {
+	return binary.BigEndian.Uint64(key)
+}
+
+func (this *uint64Comparator) Compare(aKey string, bKey string) int {
+	// assert(len(aKey) == 8 && len(bKey) == 8)
+	// Fixed width big-endian numbers compare like their bytes.
+	return strings.Compare(aKey, bKey)
+}
+
+func (this *uint64Comparator) Name() string {
+	return "leveldb.Uint64Comparator"
+}
+
+func (this *uint64Comparator) FindShortestSeparator(start *string, limit string) {
+	// Keys must keep their width, so they cannot be shortened.
+}
+
+func (this *uint64Comparator) FindShortSuccessor(key *string) {
+	// Keys must keep their width, so they cannot be shortened.
 }
 
 func (this *internalKeyComparator) Name() string {
@@ -83,7 +212,7 @@ func (this *internalKeyComparator) Name() string {
 }
 
 func (this *internalKeyComparator) Compare(aKey string, bKey string) int {
-	result := this.Comparator.Compare(extractUserKey(aKey), extractUserKey(bKey) )
+	result := this.user.Compare(extractUserKey(aKey), extractUserKey(bKey) )
 
 	// Order by:
   	//    increasing user key (according to user-supplied comparator)
@@ -109,9 +238,9 @@ func (this *internalKeyComparator) FindShortestSeparator(start *string, limit st
 
 	tmp := userStart
 
-	this.Comparator.FindShortestSeparator(&tmp, userLimit)
+	this.user.FindShortestSeparator(&tmp, userLimit)
 
-	if (len(tmp) < len(userStart) ) && (this.Comparator.Compare(userStart, tmp) < 0) {
+	if (len(tmp) < len(userStart) ) && (this.user.Compare(userStart, tmp) < 0) {
 		// User key has become shorter physically, but larger logically.
 		// Tack on the earliest possible number to the shortened user key.
 		b := make([]byte, 8)
@@ -125,9 +254,9 @@ func (this *internalKeyComparator) FindShortSuccessor(key *string) {
 	userKey := extractUserKey(*key)
 	tmp := userKey
 
-	this.Comparator.FindShortSuccessor(&tmp)
+	this.user.FindShortSuccessor(&tmp)
 
-	if (len(tmp) < len(userKey)) && (this.Comparator.Compare(userKey, tmp) < 0) {
+	if (len(tmp) < len(userKey)) && (this.user.Compare(userKey, tmp) < 0) {
 		// User key has become shorter physically, but larger logically.
 		// Tack on the earliest possible number to the shortened user key.
 		b := make([]byte, 8)
@@ -138,11 +267,11 @@ func (this *internalKeyComparator) FindShortSuccessor(key *string) {
 }
 
 func (this *internalKeyComparator) userComparator() Comparator {
-	return this.Comparator
+	return this.user
 }
 
 func makeInternalKeyComparator(c Comparator) *internalKeyComparator {
 	return &internalKeyComparator {
-		Comparator: c,
+		user: c,
 	}
 }
\ No newline at end of file
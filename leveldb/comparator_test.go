@@ -0,0 +1,213 @@
+package leveldb
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBytewiseFindShortestSeparator(t *testing.T) {
+	tests := []struct {
+		start, limit, want string
+	}{
+		{"abcd", "abzz", "abd"},
+		{"abc", "abd", "abc"},	// Adjacent bytes leave no room
+		{"abc", "abcde", "abc"},	// start is a prefix of limit
+		{"abcde", "abc", "abcde"},	// limit is a prefix of start
+		{"", "a", ""},
+		{"\x00\x01", "\x00\x05", "\x00\x02"},
+		{"\x80\x00", "\x90", "\x81"},	// Bytes, not UTF-8 runes
+		{"\xfe\x01", "\xff", "\xfe\x01"},
+		{"a\xff\xff", "c", "b"},
+		{"\xff", "\xff\xff", "\xff"},
+		{"\xff\x01", "\xff\x05", "\xff\x02"},
+		{"\xff\xff\x01", "\xff\xff\x03", "\xff\xff\x02"},
+	}
+	cmp := BytewiseComparator()
+	for _, test := range tests {
+		got := test.start
+		cmp.FindShortestSeparator(&got, test.limit)
+		if got != test.want {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q, want %q", test.start, test.limit, got, test.want)
+		}
+		if test.start < test.limit && (got < test.start || got >= test.limit) {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q is outside [start,limit)", test.start, test.limit, got)
+		}
+	}
+}
+
+func TestBytewiseFindShortSuccessor(t *testing.T) {
+	tests := []struct {
+		key, want string
+	}{
+		{"abc", "b"},
+		{"\xff\xffa", "\xff\xffb"},
+		{"\xfe\xff", "\xff"},
+		{"\x80", "\x81"},
+		{"\xff\xff", "\xff\xff"},	// A run of 0xffs has no shorter successor
+		{"", ""},
+	}
+	cmp := BytewiseComparator()
+	for _, test := range tests {
+		got := test.key
+		cmp.FindShortSuccessor(&got)
+		if got != test.want {
+			t.Errorf("FindShortSuccessor(%q) = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+func TestReverseBytewiseComparator(t *testing.T) {
+	cmp := ReverseBytewiseComparator()
+	if cmp.Compare("a", "b") <= 0 || cmp.Compare("ab", "a") >= 0 || cmp.Compare("a", "a") != 0 {
+		t.Fatal("keys are not in reverse byte-wise order")
+	}
+
+	tests := []struct {
+		start, limit, want string
+	}{
+		{"zzz", "abc", "z"},
+		{"bcd", "bab", "bc"},
+		{"b", "a", "b"},	// Already the shortest
+		{"abc", "ab", "abc"},	// limit is a prefix of start
+		{"\xff\xff", "\xff\x00", "\xff\xff"},
+	}
+	for _, test := range tests {
+		got := test.start
+		cmp.FindShortestSeparator(&got, test.limit)
+		if got != test.want {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q, want %q", test.start, test.limit, got, test.want)
+		}
+		if cmp.Compare(got, test.start) < 0 || cmp.Compare(got, test.limit) >= 0 {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q is outside [start,limit)", test.start, test.limit, got)
+		}
+	}
+
+	key := "abc"
+	cmp.FindShortSuccessor(&key)
+	if key != "abc" {
+		t.Errorf("FindShortSuccessor changed the key to %q", key)
+	}
+}
+
+func TestUint64Comparator(t *testing.T) {
+	cmp := Uint64Comparator()
+	numbers := []uint64{0, 1, 255, 256, 1 << 32, math.MaxUint64 - 1, math.MaxUint64}
+	for i, a := range numbers {
+		if DecodeUint64Key(EncodeUint64Key(a)) != a {
+			t.Errorf("%d does not survive encoding", a)
+		}
+		for j, b := range numbers {
+			got := cmp.Compare(string(EncodeUint64Key(a)), string(EncodeUint64Key(b)))
+			if (i < j && got >= 0) || (i == j && got != 0) || (i > j && got <= 0) {
+				t.Errorf("Compare(%d, %d) = %d", a, b, got)
+			}
+		}
+	}
+
+	start, limit := string(EncodeUint64Key(1)), string(EncodeUint64Key(1000))
+	key := start
+	cmp.FindShortestSeparator(&key, limit)
+	cmp.FindShortSuccessor(&key)
+	if key != start {
+		t.Errorf("a key was shortened to %q", key)
+	}
+}
+
+// A comparator registered under a name of our choosing.
+type namedComparator struct {
+	Comparator
+	name string
+}
+
+func (this *namedComparator) Name() string {
+	return this.name
+}
+
+func TestComparatorRegistry(t *testing.T) {
+	for _, cmp := range []Comparator{BytewiseComparator(), ReverseBytewiseComparator(), Uint64Comparator()} {
+		if got := GetComparator(cmp.Name()); got == nil || got.Name() != cmp.Name() {
+			t.Errorf("GetComparator(%s) = %v", cmp.Name(), got)
+		}
+	}
+	if got := GetComparator("test.Unregistered"); got != nil {
+		t.Errorf("GetComparator of an unknown name = %v", got)
+	}
+	if s := RegisterComparator(nil); !s.IsInvalidArgument() {
+		t.Errorf("RegisterComparator(nil): %v", s)
+	}
+
+	// A second comparator with the same name replaces the first.
+	first := &namedComparator{BytewiseComparator(), "test.Duplicate"}
+	second := &namedComparator{ReverseBytewiseComparator(), "test.Duplicate"}
+	if s := RegisterComparator(first); !s.OK() {
+		t.Fatal(s)
+	}
+	if s := RegisterComparator(second); !s.OK() {
+		t.Fatal(s)
+	}
+	if got := GetComparator("test.Duplicate"); got != Comparator(second) {
+		t.Errorf("GetComparator after re-registering returned %v", got)
+	}
+}
+
+func TestComparatorOrdersDB(t *testing.T) {
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.Comparator = Uint64Comparator()
+	impl := openTestDB(t, "/uint64", options)
+	defer impl.Close()
+
+	for _, v := range []uint64{1000, 2, 1 << 40, 300} {
+		if err := impl.Put(EncodeUint64Key(v), []byte("v"), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+
+	var got []uint64
+	iter := impl.NewIterator(nil)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		got = append(got, DecodeUint64Key(iter.Key()))
+	}
+	iter.Close()
+	want := []uint64{2, 300, 1000, 1 << 40}
+	if len(got) != len(want) {
+		t.Fatalf("iterated %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("iterated %v, want %v", got, want)
+		}
+	}
+}
+
+func TestReopenWithMismatchedComparator(t *testing.T) {
+	const dbName = "/comparator"
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	impl := openTestDB(t, dbName, options)
+	mustPut(t, impl, "key", "value")
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	options.Comparator = ReverseBytewiseComparator()
+	_, err := Open(dbName, options)
+	if !errors.Is(err, ErrInvalidArgument) || !strings.Contains(err.Error(), "does not match existing comparator") {
+		t.Fatalf("Open with a different comparator: %v", err)
+	}
+
+	// The recorded name finds the comparator the DB was created with.
+	name, err := ReadComparatorName(dbName, options)
+	if err != nil || name != BytewiseComparator().Name() {
+		t.Fatalf("ReadComparatorName = %q, %v", name, err)
+	}
+	options.Comparator = GetComparator(name)
+	impl = openTestDB(t, dbName, options)
+	defer impl.Close()
+	if got := getValue(t, impl, "key", nil); got != "value" {
+		t.Fatalf("Get(key) = %s", got)
+	}
+}
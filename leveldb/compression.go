@@ -0,0 +1,236 @@
+package leveldb
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+	"./utilties"
+)
+
+// A Compressor turns block contents into the form stored in a table
+// file and back.  The CompressionType it is registered under is
+// written into the trailer of every block it compresses, so a type
+// must keep meaning the same codec for as long as files using it exist.
+//
+// Multiple threads can invoke methods on a Compressor without any
+// external synchronization.
+type Compressor interface {
+	// The name of the codec, used in error messages.
+	Name() string
+
+	// Store the compressed form of "input" in "*output", reusing its
+	// storage if possible.  Returns false if the input cannot be
+	// compressed, in which case the block is stored uncompressed.
+	Compress(input []byte, output *[]byte) bool
+
+	// Store the data "input" was compressed from in "*output".
+	// Returns a non-OK status if "input" is corrupted.
+	Uncompress(input []byte, output *[]byte) Status
+}
+
+var compressors = struct {
+	sync.RWMutex
+	m map[CompressionType]Compressor
+}{
+	m: make(map[CompressionType]Compressor),
+}
+
+// Make "compressor" handle blocks of type "compressionType", replacing
+// any Compressor registered for it before.  NoCompression cannot be
+// registered.
+func RegisterCompressor(compressionType CompressionType, compressor Compressor) Status {
+	if compressionType == NoCompression {
+		return InvalidArgument("cannot register a compressor for NoCompression")
+	}
+	if compressor == nil {
+		return InvalidArgument("nil compressor")
+	}
+
+	compressors.Lock()
+	defer compressors.Unlock()
+
+	compressors.m[compressionType] = compressor
+	return OK()
+}
+
+// Return the Compressor registered for "compressionType", or nil if
+// there is none.
+func GetCompressor(compressionType CompressionType) Compressor {
+	compressors.RLock()
+	defer compressors.RUnlock()
+
+	return compressors.m[compressionType]
+}
+
+func init() {
+	RegisterCompressor(SnappyCompression, snappyCompressor{})
+	RegisterCompressor(FlateCompression, flateCompressor{})
+	RegisterCompressor(LZ4Compression, lz4Compressor{})
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string {
+	return "snappy"
+}
+
+func (snappyCompressor) Compress(input []byte, output *[]byte) bool {
+	return utilties.SnappyCompress(input, output)
+}
+
+func (snappyCompressor) Uncompress(input []byte, output *[]byte) Status {
+	var ulength int
+	if !utilties.SnappyGetUncompressedLength(input, &ulength) {
+		return Corruption("corrupted snappy compressed block length")
+	}
+	if s := checkUncompressedLength(input, "snappy", ulength, kSnappyMaxExpansion); !s.OK() {
+		return s
+	}
+	ubuf := make([]byte, ulength)
+	if !utilties.SnappyUncompress(input, ubuf) {
+		return Corruption("corrupted snappy compressed block contents")
+	}
+
+	*output = ubuf
+	return OK()
+}
+
+// Flate and LZ4 blocks are the varint32 length of the uncompressed data
+// followed by the raw deflate stream or LZ4 block respectively.
+
+func putUncompressedLength(output *[]byte, length int) {
+	*output = (*output)[:0]
+	putVarint32(output, uint32(length))
+}
+
+func getUncompressedLength(input []byte, name string, ulength *int, payload *[]byte) Status {
+	var v uint32
+	if !getVarint32(&input, &v) {
+		return Corruption("corrupted " + name + " compressed block length")
+	}
+
+	*ulength = int(v)
+	*payload = input
+	return OK()
+}
+
+// The most bytes of uncompressed data each codec can produce from one
+// byte of compressed input: a 3-byte snappy copy yields at most 64 bytes,
+// a deflate stream at most 258 bytes per 2 bits, and every length byte of
+// an LZ4 sequence at most 255 bytes.
+const (
+	kSnappyMaxExpansion = 22
+	kFlateMaxExpansion = 1032
+	kLZ4MaxExpansion = 255
+)
+
+// A recorded length beyond what "input" can expand to cannot come from an
+// intact block.  Reject it before allocating the output buffer, so that a
+// corrupted length cannot make us allocate up to 4GB.
+func checkUncompressedLength(input []byte, name string, ulength int, maxExpansion int) Status {
+	if uint64(ulength) > uint64(len(input)) * uint64(maxExpansion) {
+		return Corruption("corrupted " + name + " compressed block length")
+	}
+
+	return OK()
+}
+
+type flateCompressor struct{}
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	},
+}
+
+func (flateCompressor) Name() string {
+	return "flate"
+}
+
+func (flateCompressor) Compress(input []byte, output *[]byte) bool {
+	if uint64(len(input)) > 0xffffffff {
+		return false
+	}
+
+	putUncompressedLength(output, len(input))
+	buf := bytes.NewBuffer(*output)
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(buf)
+	if _, err := w.Write(input); err != nil {
+		return false
+	}
+	if err := w.Close(); err != nil {
+		return false
+	}
+
+	*output = buf.Bytes()
+	return true
+}
+
+func (flateCompressor) Uncompress(input []byte, output *[]byte) Status {
+	var ulength int
+	var payload []byte
+	s := getUncompressedLength(input, "flate", &ulength, &payload)
+	if !s.OK() {
+		return s
+	}
+	s = checkUncompressedLength(input, "flate", ulength, kFlateMaxExpansion)
+	if !s.OK() {
+		return s
+	}
+
+	ubuf := make([]byte, ulength)
+	r := flate.NewReader(bytes.NewReader(payload))
+	defer r.Close()
+	if _, err := io.ReadFull(r, ubuf); err != nil {
+		return Corruption("corrupted flate compressed block contents")
+	}
+	// The stream must end exactly where the recorded length says.
+	var extra [1]byte
+	if n, err := r.Read(extra[:]); n != 0 || err != io.EOF {
+		return Corruption("corrupted flate compressed block contents")
+	}
+
+	*output = ubuf
+	return OK()
+}
+
+type lz4Compressor struct{}
+
+func (lz4Compressor) Name() string {
+	return "lz4"
+}
+
+func (lz4Compressor) Compress(input []byte, output *[]byte) bool {
+	if uint64(len(input)) > 0xffffffff {
+		return false
+	}
+
+	putUncompressedLength(output, len(input))
+	utilties.LZ4CompressBlock(input, output)
+	return true
+}
+
+func (lz4Compressor) Uncompress(input []byte, output *[]byte) Status {
+	var ulength int
+	var payload []byte
+	s := getUncompressedLength(input, "lz4", &ulength, &payload)
+	if !s.OK() {
+		return s
+	}
+	s = checkUncompressedLength(input, "lz4", ulength, kLZ4MaxExpansion)
+	if !s.OK() {
+		return s
+	}
+
+	ubuf := make([]byte, ulength)
+	if !utilties.LZ4UncompressBlock(payload, ubuf) {
+		return Corruption("corrupted lz4 compressed block contents")
+	}
+
+	*output = ubuf
+	return OK()
+}
@@ -0,0 +1,143 @@
+package leveldb
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+	"./utilties"
+)
+
+// Write "contents" as a block of type "compressionType" to a MemEnv file
+// and read it back with ReadBlock.
+func readRawBlock(t *testing.T, contents []byte, compressionType CompressionType, result *BlockContents) Status {
+	env := NewMemEnv(DefaultEnv())
+	env.CreateDir("/dir")
+
+	var trailer [kBlockTrailerSize]byte
+	trailer[0] = byte(compressionType)
+	crc := utilties.Extend(utilties.Value(contents), trailer[:1])
+	encodeFixed32(trailer[1:], utilties.Mask(crc))
+	block := append(append([]byte(nil), contents...), trailer[:]...)
+	if s := WriteStringToFile(env, string(block), "/dir/block"); !s.OK() {
+		t.Fatal(s)
+	}
+
+	var file RandomAccessFile
+	if s := env.NewRandomAccessFile("/dir/block", &file); !s.OK() {
+		t.Fatal(s)
+	}
+	defer file.Close()
+	handle := BlockHandle{offset: 0, size: uint64(len(contents))}
+	return ReadBlock(file, &ReadOptions{VerifyChecksums: true}, &handle, result)
+}
+
+func TestReadBlockUnknownCompressionType(t *testing.T) {
+	var result BlockContents
+	s := readRawBlock(t, []byte("block contents"), CompressionType(2), &result)
+	if !errors.Is(s.Err(), ErrCorruption) || s.String() != Corruption("bad block type").String() {
+		t.Fatalf("ReadBlock: %v", s)
+	}
+}
+
+func TestReadBlockUncompressed(t *testing.T) {
+	var result BlockContents
+	if s := readRawBlock(t, []byte("block contents"), NoCompression, &result); !s.OK() {
+		t.Fatal(s)
+	}
+	if string(result.data) != "block contents" {
+		t.Fatalf("read %q", result.data)
+	}
+}
+
+var codecTests = []struct {
+	compressionType CompressionType
+	name string
+}{
+	{SnappyCompression, "snappy"},
+	{FlateCompression, "flate"},
+	{LZ4Compression, "lz4"},
+}
+
+func codecInputs() map[string][]byte {
+	random := make([]byte, 10000)
+	rand.New(rand.NewSource(301)).Read(random)
+	return map[string][]byte{
+		"empty": nil,
+		"short": []byte("a"),
+		"repeated": bytes.Repeat([]byte("0123456789abcdef"), 4096),
+		"random": random,
+	}
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	for _, test := range codecTests {
+		compressor := GetCompressor(test.compressionType)
+		if compressor == nil || compressor.Name() != test.name {
+			t.Fatalf("compressor for type %d: %v", test.compressionType, compressor)
+		}
+		for inputName, input := range codecInputs() {
+			var compressed, uncompressed []byte
+			if !compressor.Compress(input, &compressed) {
+				continue
+			}
+			if s := compressor.Uncompress(compressed, &uncompressed); !s.OK() {
+				t.Errorf("%s/%s: %v", test.name, inputName, s)
+			} else if !bytes.Equal(uncompressed, input) {
+				t.Errorf("%s/%s: round trip changed the data", test.name, inputName)
+			}
+		}
+
+		// The block reader must dispatch on the trailer type.
+		input := codecInputs()["repeated"]
+		var compressed []byte
+		compressor.Compress(input, &compressed)
+		var result BlockContents
+		if s := readRawBlock(t, compressed, test.compressionType, &result); !s.OK() {
+			t.Errorf("%s: ReadBlock: %v", test.name, s)
+		} else if !bytes.Equal(result.data, input) || !result.heapAllocated {
+			t.Errorf("%s: ReadBlock returned the wrong block", test.name)
+		}
+	}
+}
+
+func TestCompressorCorruptInput(t *testing.T) {
+	// A length of 4GB-1 in front of a few bytes of payload.
+	hugeLength := []byte{0xff, 0xff, 0xff, 0xff, 0x0f, 1, 2, 3}
+
+	for _, test := range codecTests {
+		compressor := GetCompressor(test.compressionType)
+		var compressed []byte
+		compressor.Compress(codecInputs()["repeated"], &compressed)
+
+		// Rejected from the length alone, before allocating 4GB.
+		var output []byte
+		s := compressor.Uncompress(hugeLength, &output)
+		if s.String() != Corruption("corrupted " + test.name + " compressed block length").String() {
+			t.Errorf("%s/huge length: %v", test.name, s)
+		}
+
+		corrupt := map[string][]byte{
+			"truncated length": {0x80},
+			"truncated": compressed[:len(compressed) / 2],
+		}
+		for corruptName, input := range corrupt {
+			var output []byte
+			if s := compressor.Uncompress(input, &output); !errors.Is(s.Err(), ErrCorruption) {
+				t.Errorf("%s/%s: %v", test.name, corruptName, s)
+			}
+		}
+
+		// Random bytes can happen to form a valid block, but must never
+		// panic or report anything but corruption.
+		random := rand.New(rand.NewSource(301))
+		for i := 0; i < 1000; i++ {
+			garbage := make([]byte, 1 + random.Intn(100))
+			random.Read(garbage)
+			var output []byte
+			if s := compressor.Uncompress(garbage, &output); !s.OK() && !errors.Is(s.Err(), ErrCorruption) {
+				t.Errorf("%s/garbage %x: %v", test.name, garbage, s)
+			}
+		}
+	}
+}
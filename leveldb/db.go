@@ -1,23 +1,126 @@
 package leveldb
 
 import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	//"sync/atomic"
+	"sync/atomic"
 )
 
 const kNumNonTableCacheFiles = 10
 
+// Background work that fails pauses before letting go of the DB,
+// starting at one second and doubling on every consecutive failure up
+// to kMaxBackgroundErrorBackoffShift doublings.  The pause is cut short
+// in steps of kBackgroundErrorPollMicros once the DB shuts down.
+const kBackgroundErrorBackoffMicros = 1000000
+const kMaxBackgroundErrorBackoffShift = 6
+const kBackgroundErrorPollMicros = 10000
+
+// A DB is a persistent ordered map from keys to values.
+// A DB is safe for concurrent access from multiple threads without
+// any external synchronization.
+//
+// A nil *ReadOptions or *WriteOptions means the default options.
+// Errors returned by a DB are Status values; use errors.Is with
+// ErrNotFound, ErrCorruption, etc. to tell them apart.
 type DB interface {
-	Put(writeOptions WriteOptions, key string, value string) Status
-	Delete(writeOptions WriteOptions, key string) Status
-	Write(writeOptions WriteOptions, updates *WriteBatch) Status
-	Get(readOptions ReadOptions, key string, value *string) Status
-	NewIterator(readOptions ReadOptions) *Iterator
+	// Set the database entry for "key" to "value".  Returns nil on
+	// success, and a non-nil error on failure.
+	// Note: consider setting writeOptions.Sync = true.
+	Put(key []byte, value []byte, writeOptions *WriteOptions) error
+
+	// Remove the database entry (if any) for "key".  Returns nil on
+	// success, and a non-nil error on failure.  It is not an error if
+	// "key" did not exist in the database.
+	// Note: consider setting writeOptions.Sync = true.
+	Delete(key []byte, writeOptions *WriteOptions) error
+
+	// Apply the specified updates to the database.
+	// Returns nil on success, non-nil on failure.
+	// Note: consider setting writeOptions.Sync = true.
+	Write(updates *WriteBatch, writeOptions *WriteOptions) error
+
+	// If the database contains an entry for "key" return a copy of its
+	// value.  If there is no entry for "key" return an error for which
+	// errors.Is(err, ErrNotFound) is true.  May return some other error
+	// on failure.
+	Get(key []byte, readOptions *ReadOptions) ([]byte, error)
+
+	// Return an iterator over the contents of the database.
+	// The result of NewIterator() is initially invalid (caller must
+	// call one of the Seek methods on the iterator before using it).
+	//
+	// Caller should close the iterator when it is no longer needed,
+	// and before the DB is closed.
+	NewIterator(readOptions *ReadOptions) Iterator
+
+	// Return a handle to the current DB state.  Iterators created with
+	// this handle will all observe a stable snapshot of the current DB
+	// state.  The caller must call ReleaseSnapshot(result) when the
+	// snapshot is no longer needed.  Returns nil once the DB is closed.
 	GetSnapshot() *Snapshot
+
+	// Release a previously acquired snapshot.  The caller must not
+	// use "snapshot" after this call.  Releasing nil does nothing.
 	ReleaseSnapshot(snapshot *Snapshot)
-	GetProperty(property string, value *string) bool
-	GetApproximateSizes(kr *keyRange, n int32, sizes *uint64)
-	CompactRange(begin string, end string)
+
+	// DB implementations can export properties about their state via
+	// this method.  If "property" is a valid property understood by this
+	// DB implementation, returns its current value and true.  Otherwise
+	// returns false.
+	//
+	// Valid property names include:
+	//
+	//  "leveldb.num-files-at-level<N>" - return the number of files at level <N>,
+	//     where <N> is an ASCII representation of a level number (e.g. "0").
+	//  "leveldb.stats" - returns a multi-line string that describes statistics
+	//     about the internal operation of the DB.
+	//  "leveldb.sstables" - returns a multi-line string that describes all
+	//     of the sstables that make up the db contents.
+	//  "leveldb.approximate-memory-usage" - returns the approximate number of
+	//     bytes of memory in use by the DB.
+	GetProperty(property string) (string, bool)
+
+	// For each i in [0,len(ranges)), the result holds the approximate
+	// file system space used by keys in "[ranges[i].Start .. ranges[i].Limit)".
+	//
+	// Note that the returned sizes measure file system space usage, so
+	// if the user data compresses by a factor of ten, the returned
+	// sizes will be one-tenth the size of the corresponding user data size.
+	//
+	// The results may not include the sizes of recently written data.
+	GetApproximateSizes(ranges []Range) []uint64
+
+	// Compact the underlying storage for the key range [*begin,*end].
+	// In particular, deleted and overwritten versions are discarded,
+	// and the data is rearranged to reduce the cost of operations
+	// needed to access the data.
+	//
+	// A nil begin is treated as a key before all keys in the database.
+	// A nil end is treated as a key after all keys in the database.
+	// Therefore the following call will compact the entire database:
+	//    db.CompactRange(nil, nil)
+	CompactRange(begin []byte, end []byte)
+
+	// Add the tables at "paths" to the DB as if their entries had been
+	// written in a single batch.  The tables must have been built with
+	// the comparator of the DB (see table.Writer), and their key ranges
+	// must not overlap each other.  The files are linked or copied into
+	// the DB directory and placed at the deepest level they can go to.
+	//
+	// Writes are blocked only while the tables are assigned a sequence
+	// number, unless a memtable holds keys in their ranges, which must
+	// be compacted first.
+	// A nil *IngestOptions means the default options.
+	IngestExternalFiles(paths []string, ingestOptions *IngestOptions) error
+
+	// Close the database: wait for background work to finish and release
+	// every file, the lock and any cache this DB created itself.  Every
+	// call made after Close fails.
+	Close() error
 }
 
 type dbImpl struct {
@@ -59,9 +162,15 @@ type dbImpl struct {
 
 	manualCompaction *ManualCompaction
 
+	// Ingested files waiting for the background thread to install them,
+	// in the order of their sequence numbers.
+	pendingIngestions []*externalIngestion
+
 	versions *VersionSet
 
+	// Have we encountered a background error in paranoid mode?
 	bgError *Status
+	bgErrorCount uint	// Consecutive failed background compactions
 
 	status [kNumLevels]*CompactionStats
 }
@@ -75,8 +184,23 @@ type ManualCompaction struct {
 	tmpStore internalKey	// Used to keep track of compaction progress
 }
 
-type Writer struct {	
+// Information kept for every waiting writer
+type Writer struct {
+	status Status
+	batch *WriteBatch
+	sync bool
+	done bool
+	exclusive bool	// Not grouped with other writers (see IngestExternalFiles)
+	cv *sync.Cond
+}
 
+func newWriter(mu *sync.Mutex) *Writer {
+	return &Writer{
+		batch: nil,
+		sync: false,
+		done: false,
+		cv: sync.NewCond(mu),
+	}
 }
 
 type CompactionStats struct {
@@ -92,24 +216,30 @@ func (this *CompactionStats) Add(other *CompactionStats) {
 }
 
 
-type keyRange struct {
-	start string
-	limit string
+// A range of keys
+type Range struct {
+	Start []byte	// Included in the range
+	Limit []byte	// Not included in the range
 }
 
 func init() {
 }
 
-func Open(options *Options, name string, db *DB) Status {
-	*db = nil
+// Open the database with the specified "name".
+// Returns the DB on success, or nil and a non-nil error on failure.
+// The caller should Close the DB when it is no longer needed.
+func Open(name string, options *Options) (DB, error) {
 	impl := makeDBImpl(options, name)
 
 	impl.mutex.Lock()
 	edit := newVersionEdit()
 
-	s := impl.recover(edit)
+	// Recover handles CreateIfMissing, ErrorIfExists
+	saveManifest := false
+	s := impl.recover(edit, &saveManifest)
 
-	if s.OK() {
+	if s.OK() && impl.log == nil {
+		// Create new log and a corresponding memtable.
 		newLogNumber := impl.versions.NewFileNumber()
 		var lfile WritableFile
 		s = options.Env.NewWritableFile(LogFileName(name, newLogNumber), &lfile)
@@ -117,69 +247,662 @@ func Open(options *Options, name string, db *DB) Status {
 		if s.OK() {
 			edit.SetLogNumber(newLogNumber)
 			impl.logFile = &lfile
+			impl.logFileNumber = newLogNumber
 			impl.log = newLogWriter(&lfile)
+		}
+	}
+
+	if s.OK() && saveManifest {
+		edit.SetPrevLogNumber(0)	// No older logs needed after recovery.
+		edit.SetLogNumber(impl.logFileNumber)
+		s = impl.versions.LogAndApply(edit, &impl.mutex)
+	}
 
-			s = impl.versions.LogAndApply(edit, &impl.mutex)
+	if s.OK() {
+		impl.DeleteObsoleteFiles()
+		impl.MaybeScheduleCompaction()
+	}
+
+	impl.mutex.Unlock()
+
+	if !s.OK() {
+		impl.Close()
+		return nil, s
+	}
+
+	return impl, nil
+}
+
+// Destroy the contents of the specified database.
+// Be very careful using this method.
+func DestroyDB(name string, options *Options) error {
+	env := options.Env
+	filenames, result := env.GetChildren(name)
+	if !result.OK() {
+		// Ignore error in case directory does not exist
+		return nil
+	}
+
+	var lock FileLock
+	lockName := LockFileName(name)
+	result = env.LockFile(lockName, &lock)
+	if result.OK() {
+		var number uint64
+		var fileType FileType
+		for _, filename := range filenames {
+			if ParseFileName(filename, &number, &fileType) &&
+				fileType != kDBLockFile {	// Lock file will be deleted at end
+				del := env.DeleteFile(name + "/" + filename)
+				if result.OK() && !del.OK() {
+					result = del
+				}
+			}
 		}
+		env.UnlockFile(lock)	// Ignore error since state is already gone
+		env.DeleteFile(lockName)
+		env.DeleteDir(name)	// Ignore error in case dir contains other files
+	}
 
-		if s.OK() {
-			//impl.DeleteObsoleteFiles()
-			//impl.MaybeScheduleCompaction()
+	return result.Err()
+}
+
+// Convenience methods
+func (this *dbImpl) Put(key []byte, value []byte, writeOptions *WriteOptions) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+
+	return this.Write(batch, writeOptions)
+}
+
+func (this *dbImpl) Delete(key []byte, writeOptions *WriteOptions) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+
+	return this.Write(batch, writeOptions)
+}
+
+func (this *dbImpl) Write(updates *WriteBatch, writeOptions *WriteOptions) error {
+	if writeOptions == nil {
+		writeOptions = &WriteOptions{}
+	}
+
+	return this.write(writeOptions, updates).Err()
+}
+
+// A nil "updates" just waits for earlier writes and forces the
+// memtable to be compacted.
+func (this *dbImpl) write(writeOptions *WriteOptions, updates *WriteBatch) Status {
+	w := newWriter(&this.mutex)
+	w.batch = updates
+	w.sync = writeOptions.Sync
+	w.done = false
+
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return dbClosed()
+	}
+	this.writers = append(this.writers, w)
+	for !w.done && w != this.writers[0] {
+		w.cv.Wait()
+	}
+	if w.done {
+		this.mutex.Unlock()
+		return w.status
+	}
+
+	// May temporarily unlock and wait.
+	status := this.MakeRoomForWrite(updates == nil)
+	lastSequence := this.versions.LastSequence()
+	lastWriter := w
+	if status.OK() && updates != nil {	// nil batch is for compactions
+		writeBatch := this.BuildBatchGroup(&lastWriter)
+		setWriteBatchSequence(writeBatch, lastSequence + 1)
+		lastSequence += sequenceNumber(writeBatchCount(writeBatch))
+
+		// Add to log and apply to memtable.  We can release the lock
+		// during this phase since w is currently responsible for logging
+		// and protects against concurrent loggers and concurrent writes
+		// into mem.
+		this.mutex.Unlock()
+		status = this.log.AddRecord(writeBatchContents(writeBatch))
+		syncError := false
+		if status.OK() && writeOptions.Sync {
+			status = (*this.logFile).Sync()
+			if !status.OK() {
+				syncError = true
+			}
+		}
+		if status.OK() {
+			status = writeBatchInsertInto(writeBatch, this.mem)
+		}
+		this.mutex.Lock()
+
+		if syncError {
+			// The state of the log file is indeterminate: the log record we
+			// just added may or may not show up when the DB is re-opened.
+			// So we force the DB into a mode where all future writes fail.
+			this.RecordBackgroundError(status)
+		}
+		if writeBatch == this.tmpBatch {
+			this.tmpBatch.Clear()
 		}
+
+		this.versions.SetLastSequence(lastSequence)
 	}
 
-	impl.mutex.Unlock()
+	for {
+		ready := this.writers[0]
+		this.writers = this.writers[1:]
+		if ready != w {
+			ready.status = status
+			ready.done = true
+			ready.cv.Signal()
+		}
+		if ready == lastWriter {
+			break
+		}
+	}
 
-	if s.OK() {
-		*db = impl
-	} 
+	// Notify new head of write queue
+	if len(this.writers) > 0 {
+		this.writers[0].cv.Signal()
+	}
+	this.mutex.Unlock()
 
-	return s
+	return status
+}
+
+// REQUIRES: Writer list must be non-empty
+// REQUIRES: First writer must have a non-nil batch
+func (this *dbImpl) BuildBatchGroup(lastWriter **Writer) *WriteBatch {
+	first := this.writers[0]
+	result := first.batch
+	// assert(result != nil)
+
+	size := writeBatchByteSize(first.batch)
+
+	// Allow the group to grow up to a maximum size, but if the
+	// original write is small, limit the growth so we do not slow
+	// down the small write too much.
+	maxSize := 1 << 20
+	if size <= (128 << 10) {
+		maxSize = size + (128 << 10)
+	}
+
+	*lastWriter = first
+	for _, w := range this.writers[1:] {
+		if w.exclusive {
+			// Needs to be at the front of the queue itself.
+			break
+		}
+
+		if w.sync && !first.sync {
+			// Do not include a sync write into a batch handled by a non-sync write.
+			break
+		}
+
+		if w.batch != nil {
+			size += writeBatchByteSize(w.batch)
+			if size > maxSize {
+				// Do not make batch too big
+				break
+			}
+
+			// Append to result
+			if result == first.batch {
+				// Switch to temporary batch instead of disturbing caller's batch
+				result = this.tmpBatch
+				// assert(writeBatchCount(result) == 0)
+				appendWriteBatch(result, first.batch)
+			}
+			appendWriteBatch(result, w.batch)
+		}
+		*lastWriter = w
+	}
+
+	return result
 }
 
-func (this *dbImpl) Put(writeOptions WriteOptions, key string, value string) Status {
+// REQUIRES: mutex is held
+// REQUIRES: this thread is currently at the front of the writer queue
+func (this *dbImpl) MakeRoomForWrite(force bool) Status {
+	allowDelay := !force
 	s := OK()
+	for {
+		if atomic.LoadInt32(&this.shuttingDown) != 0 {
+			s = dbClosed()
+			break
+		} else if this.bgError != nil {
+			// Yield previous error
+			s = *this.bgError
+			break
+		} else if allowDelay && this.versions.NumLevelFiles(0) >= kL0_SlowdownWritesTrigger {
+			// We are getting close to hitting a hard limit on the number of
+			// L0 files.  Rather than delaying a single write by several
+			// seconds when we hit the hard limit, start delaying each
+			// individual write by 1ms to reduce latency variance.  Also,
+			// this delay hands over some CPU to the compaction thread in
+			// case it is sharing the same core as the writer.
+			this.mutex.Unlock()
+			this.env.SleepForMicroseconds(1000)
+			allowDelay = false	// Do not delay a single write more than once
+			this.mutex.Lock()
+		} else if !force && this.mem.ApproximateMemoryUsage() <= int(this.options.WriteBufferSize) {
+			// There is room in current memtable
+			break
+		} else if this.imm != nil {
+			// We have filled up the current memtable, but the previous
+			// one is still being compacted, so we wait.
+			Log(this.options.InfoLog, "Current memtable full; waiting...")
+			this.bgCV.Wait()
+		} else if this.versions.NumLevelFiles(0) >= kL0_StopWritesTrigger {
+			// There are too many level-0 files.
+			Log(this.options.InfoLog, "Too many L0 files; waiting...")
+			this.bgCV.Wait()
+		} else {
+			// Attempt to switch to a new memtable and trigger compaction of old
+			// assert(this.versions.PrevLogNumber() == 0)
+			newLogNumber := this.versions.NewFileNumber()
+			var lfile WritableFile
+			s = this.env.NewWritableFile(LogFileName(this.dbName, newLogNumber), &lfile)
+			if !s.OK() {
+				// Avoid chewing through file number space in a tight loop.
+				this.versions.ReuseFileNumber(newLogNumber)
+				break
+			}
+
+			(*this.logFile).Close()
+			this.logFile = &lfile
+			this.logFileNumber = newLogNumber
+			this.log = newLogWriter(&lfile)
+			this.imm = this.mem
+			atomic.StoreInt32(&this.hasImm, 1)
+			this.mem = newMemTable(*this.internalKeyComparator)
+			force = false	// Do not force another compaction if have room
+			this.MaybeScheduleCompaction()
+		}
+	}
 
 	return s
 }
 
-func (this *dbImpl) Delete(writeOptions WriteOptions, key string) Status {
+func (this *dbImpl) Get(key []byte, readOptions *ReadOptions) ([]byte, error) {
+	if readOptions == nil {
+		readOptions = &ReadOptions{}
+	}
+
+	var value string
+	s := this.get(readOptions, bytesToString(key), &value)
+	if !s.OK() {
+		return nil, s
+	}
+
+	// "value" may share storage with a memtable, so hand out a copy.
+	return []byte(value), nil
+}
+
+func (this *dbImpl) get(readOptions *ReadOptions, key string, value *string) Status {
 	s := OK()
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return dbClosed()
+	}
+
+	var snapshot sequenceNumber
+	if readOptions.Snapshot != nil {
+		snapshot = (*readOptions.Snapshot).(*snapshotImpl).number
+	} else {
+		snapshot = this.versions.LastSequence()
+	}
+
+	mem := this.mem
+	imm := this.imm
+	current := this.versions.current
+	current.Ref()
+
+	haveStatUpdate := false
+	var seekFile *FileMetaData
+	seekFileLevel := -1
+
+	// Unlock while reading from files and memtables
+	this.mutex.Unlock()
+
+	// First look in the memtable, then in the immutable memtable (if any).
+	lkey := newLookupKey(key, snapshot)
+	if mem.Get(lkey, value, &s) {
+		// Done
+	} else if imm != nil && imm.Get(lkey, value, &s) {
+		// Done
+	} else {
+		seekFile, seekFileLevel, s = current.Get(readOptions, *lkey, value)
+		haveStatUpdate = true
+	}
+
+	this.mutex.Lock()
+
+	if haveStatUpdate && current.UpdateStats(seekFile, seekFileLevel) {
+		this.MaybeScheduleCompaction()
+	}
+	current.Unref()
+
+	this.mutex.Unlock()
 
 	return s
 }
 
-func (this *dbImpl) Write(writeOptions WriteOptions, updates *WriteBatch) Status {
-	return OK()
-}
+func (this *dbImpl) NewIterator(readOptions *ReadOptions) Iterator {
+	// The internal iterators keep a pointer to the options, so give
+	// them a copy of their own.
+	options := new(ReadOptions)
+	if readOptions != nil {
+		*options = *readOptions
+	}
+	// The tables are only skipped by their bounds, so narrow the
+	// bounds to the prefix where the comparator allows it.
+	options.LowerBound, options.UpperBound = iteratorBounds(this.userComparator(), options)
+	prefix := options.Prefix
+	options.Prefix = nil
+
+	var latestSnapshot sequenceNumber
+	iter := this.NewInternalIterator(options, &latestSnapshot)
+	sequence := latestSnapshot
+	if options.Snapshot != nil {
+		sequence = (*options.Snapshot).(*snapshotImpl).number
+	}
 
-func (this *dbImpl) Get(readOptions ReadOptions, key string, value *string) Status {
-	return OK()
+	return newDBIter(this.userComparator(), iter, sequence, options.LowerBound, options.UpperBound, prefix)
 }
 
-func (this *dbImpl) NewIterator( readOptions ReadOptions) *Iterator {
-	return nil
+// Return an iterator over the internal keys of the memtables and the
+// current Version, and store the sequence number of the latest write
+// in "*latestSnapshot".  The iterator holds a reference to the Version
+// until it is closed.
+func (this *dbImpl) NewInternalIterator(readOptions *ReadOptions, latestSnapshot *sequenceNumber) iterator {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		return NewErrorIterator(dbClosed())
+	}
+	*latestSnapshot = this.versions.LastSequence()
+
+	// Collect together all needed child iterators
+	var list []iterator
+	list = append(list, this.mem.NewIterator())
+	if this.imm != nil {
+		list = append(list, this.imm.NewIterator())
+	}
+	current := this.versions.current
+	current.AddIterators(readOptions, &list)
+	internalIter := NewMergingIterator(this.internalKeyComparator, list)
+	current.Ref()
+
+	return newCleanupIterator(internalIter, func() {
+		this.mutex.Lock()
+		current.Unref()
+		this.mutex.Unlock()
+	})
 }
 func (this *dbImpl) GetSnapshot() *Snapshot {
-	return nil
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// Ingested files that have their sequence number but are not
+	// installed yet would appear under the snapshot later on.  After a
+	// background error they never will be.
+	for len(this.pendingIngestions) > 0 && atomic.LoadInt32(&this.shuttingDown) == 0 && this.bgError == nil {
+		this.bgCV.Wait()
+	}
+
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		return nil
+	}
+
+	var result Snapshot = this.snapshots.New(this.versions.LastSequence())
+	return &result
 }
 
 func (this *dbImpl) ReleaseSnapshot(snapshot *Snapshot) {
+	if snapshot == nil {
+		// E.g. what GetSnapshot returned after Close
+		return
+	}
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.snapshots.Delete((*snapshot).(*snapshotImpl))
+}
+
+func (this *dbImpl) GetProperty(property string) (string, bool) {
+	var value string
+	ok := this.getProperty(property, &value)
+
+	return value, ok
+}
+
+func (this *dbImpl) getProperty(property string, value *string) bool {
+	*value = ""
+
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		return false
+	}
+
+	in := property
+	prefix := "leveldb."
+	if !strings.HasPrefix(in, prefix) {
+		return false
+	}
+	in = in[len(prefix):]
+
+	if strings.HasPrefix(in, "num-files-at-level") {
+		level, ok := consumeDecimalNumber(&in, len("num-files-at-level"))
+		if !ok || in != "" || level >= kNumLevels {
+			return false
+		}
+		*value = strconv.Itoa(this.versions.NumLevelFiles(int(level)))
+		return true
+	} else if in == "stats" {
+		*value = "                               Compactions\n" +
+			"Level  Files Size(MB) Time(sec) Read(MB) Write(MB)\n" +
+			"--------------------------------------------------\n"
+		for level := 0; level < kNumLevels; level++ {
+			files := this.versions.NumLevelFiles(level)
+			if this.status[level].micros > 0 || files > 0 {
+				*value += fmt.Sprintf("%3d %8d %8.0f %9.0f %8.0f %9.0f\n",
+					level, files, float64(this.versions.NumLevelBytes(level)) / 1048576.0,
+					float64(this.status[level].micros) / 1e6,
+					float64(this.status[level].bytesRead) / 1048576.0,
+					float64(this.status[level].bytesWritten) / 1048576.0)
+			}
+		}
+		return true
+	} else if in == "sstables" {
+		*value = this.versions.current.DebugString()
+		return true
+	} else if in == "approximate-memory-usage" {
+		totalUsage := uint64(this.options.BlockCache.TotalCharge())
+		if this.mem != nil {
+			totalUsage += uint64(this.mem.ApproximateMemoryUsage())
+		}
+		if this.imm != nil {
+			totalUsage += uint64(this.imm.ApproximateMemoryUsage())
+		}
+		*value = strconv.FormatUint(totalUsage, 10)
+		return true
+	}
+
+	return false
+}
+
+func (this *dbImpl) GetApproximateSizes(ranges []Range) []uint64 {
+	sizes := make([]uint64, len(ranges))
+
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return sizes
+	}
+	v := this.versions.current
+	v.Ref()
+	this.mutex.Unlock()
+
+	for i, r := range ranges {
+		// Convert user keys into corresponding internal keys.
+		k1 := makeInternalKey(bytesToString(r.Start), kMaxSequenceNumber, kValueTypeForSeek)
+		k2 := makeInternalKey(bytesToString(r.Limit), kMaxSequenceNumber, kValueTypeForSeek)
+		start := this.versions.ApproximateOffsetOf(v, &k1)
+		limit := this.versions.ApproximateOffsetOf(v, &k2)
+		if limit >= start {
+			sizes[i] = limit - start
+		} else {
+			sizes[i] = 0
+		}
+	}
+
+	this.mutex.Lock()
+	v.Unref()
+	this.mutex.Unlock()
 
+	return sizes
 }
 
-func (this *dbImpl) GetProperty(property string, value *string) bool {
-	return true
+// Blocks until the compaction is done; writes may proceed concurrently.
+func (this *dbImpl) CompactRange(begin []byte, end []byte) {
+	var beginKey, endKey *internalKey
+	var beginUserKey, endUserKey *string
+	if begin != nil {
+		b := string(begin)
+		k := makeInternalKey(b, kMaxSequenceNumber, kValueTypeForSeek)
+		beginKey = &k
+		beginUserKey = &b
+	}
+	if end != nil {
+		e := string(end)
+		k := makeInternalKey(e, 0, kTypeDeletion)
+		endKey = &k
+		endUserKey = &e
+	}
+
+	maxLevelWithFiles := 1
+	this.mutex.Lock()
+	base := this.versions.current
+	for level := 1; level < kNumLevels; level++ {
+		if base.OverlapInLevel(level, beginUserKey, endUserKey) {
+			maxLevelWithFiles = level
+		}
+	}
+	this.mutex.Unlock()
+
+	this.compactMemTableAndWait()	// TODO: Skip if memtable does not overlap
+	for level := 0; level < maxLevelWithFiles; level++ {
+		this.compactRangeLevel(level, beginKey, endKey)
+	}
 }
 
-func (this *dbImpl) GetApproximateSizes(kr *keyRange, n int32, sizes *uint64) {
+// Compact any files in the named level that overlap [begin,end].
+// A nil begin or end means the range is unbounded on that side.
+func (this *dbImpl) compactRangeLevel(level int, begin *internalKey, end *internalKey) {
+	manual := ManualCompaction{
+		level: level,
+		done: false,
+		begin: begin,
+		end: end,
+	}
 
+	this.mutex.Lock()
+	for !manual.done && atomic.LoadInt32(&this.shuttingDown) == 0 && this.bgError == nil {
+		if this.manualCompaction == nil {	// Idle
+			this.manualCompaction = &manual
+			this.MaybeScheduleCompaction()
+		} else {	// Running either my compaction or another compaction.
+			this.bgCV.Wait()
+		}
+	}
+	if this.manualCompaction == &manual {
+		// Cancel my manual compaction since we aborted early for some reason.
+		this.manualCompaction = nil
+	}
+	this.mutex.Unlock()
+}
+
+// Force the current memtable contents to be compacted and wait for
+// the compaction to finish.
+func (this *dbImpl) compactMemTableAndWait() Status {
+	// nil batch means just wait for earlier writes to be done
+	s := this.write(&WriteOptions{}, nil)
+	if s.OK() {
+		// Wait until the compaction completes
+		this.mutex.Lock()
+		for this.imm != nil && this.bgError == nil && atomic.LoadInt32(&this.shuttingDown) == 0 {
+			this.bgCV.Wait()
+		}
+		if this.bgError != nil {
+			s = *this.bgError
+		} else if atomic.LoadInt32(&this.shuttingDown) != 0 {
+			s = dbClosed()
+		}
+		this.mutex.Unlock()
+	}
+
+	return s
 }
 
-func (this *dbImpl) CompactRange(begin string, end string) {
 
+// Status returned by every operation on a DB that has been closed.
+func dbClosed() Status {
+	return InvalidArgument("leveldb: DB is closed")
 }
 
+func (this *dbImpl) Close() error {
+	// Wait for background work to finish.
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return dbClosed()
+	}
+	atomic.StoreInt32(&this.shuttingDown, 1)	// Any non-zero value is ok
+	for this.bgCompactionScheduled {
+		this.bgCV.Wait()
+	}
+	// Wake up writers and compaction waiters so they notice the shutdown.
+	this.bgCV.Broadcast()
+	this.mutex.Unlock()
+
+	s := OK()
+	if this.log != nil {
+		s = (*this.logFile).Close()
+		this.log = nil
+		this.logFile = nil
+	}
+	if vs := this.versions.Close(); s.OK() {
+		s = vs
+	}
+
+	// Drop every table that is not pinned by a live iterator.
+	this.tableCache.Prune()
+
+	if this.dbLock != nil {
+		this.env.UnlockFile(*this.dbLock)
+		this.dbLock = nil
+	}
+
+	if this.ownsInfoLog {
+		if closer, ok := this.options.InfoLog.(interface{ Close() Status }); ok {
+			closer.Close()
+		}
+	}
+	if this.ownsCache {
+		this.options.BlockCache.Prune()
+	}
+
+	return s.Err()
+}
 
 func makeDBImpl(options *Options, name string) *dbImpl {
 	var impl dbImpl
@@ -200,10 +923,16 @@ func makeDBImpl(options *Options, name string) *dbImpl {
 	impl.logFileNumber = 0
 	impl.log = nil
 	impl.seed = 0
-	impl.tmpBatch = newWriteBatch()
+	impl.tmpBatch = NewWriteBatch()
+	impl.snapshots = newSnapshotList()
 	impl.bgCompactionScheduled = false
 	impl.manualCompaction = nil
 	impl.hasImm = 0
+	impl.pendingOutputs = make(map[uint64]bool)
+
+	for level := 0; level < kNumLevels; level++ {
+		impl.status[level] = &CompactionStats{}
+	}
 	
 	tableCacheSize := impl.options.MaxOpenFiles - kNumNonTableCacheFiles
 	impl.tableCache = newTableCache(impl.dbName, impl.options, int(tableCacheSize) )
@@ -227,9 +956,9 @@ func sanitizeOptions(dbName string, icmp internalKeyComparator, ipolicy internal
 	ClipToRangeUint32(&result.WriteBufferSize, 64<<10, 1<<30)
 	ClipToRangeUint(&result.BlockSize, 1<<10, 4<<20)
 
-	if result.InfoLog != nil {
+	if result.InfoLog == nil {
 		// Open a log file in the same directory as the db
-		options.CreateDir(dbName)
+		options.CreateDir(dbName)	// In case it does not exist
 		options.RenameFile(InfoLogFileName(dbName), OldInfoLogFileName(dbName))
 		s := options.NewLogger(InfoLogFileName(dbName), &result.InfoLog)
 
@@ -246,10 +975,858 @@ func sanitizeOptions(dbName string, icmp internalKeyComparator, ipolicy internal
 	return &result
 }
 
-func (this *dbImpl) recover(edit *VersionEdit) Status {
+func (this *dbImpl) NewDB() Status {
+	newDB := newVersionEdit()
+	newDB.SetComparatorName(this.userComparator().Name())
+	newDB.SetLogNumber(0)
+	newDB.SetNextFile(2)
+	newDB.SetLastSequence(0)
+
+	manifest := DescriptorFileName(this.dbName, 1)
+	var file WritableFile
+	s := this.env.NewWritableFile(manifest, &file)
+	if !s.OK() {
+		return s
+	}
+
+	log := newLogWriter(&file)
+	var record []byte
+	newDB.EncodeTo(&record)
+	s = log.AddRecord(record)
+	if s.OK() {
+		s = file.Sync()
+	}
+	if s.OK() {
+		s = file.Close()
+	} else {
+		file.Close()
+	}
+
+	if s.OK() {
+		// Make "CURRENT" file that points to the new manifest file.
+		s = SetCurrentFile(this.env, this.dbName, 1)
+	} else {
+		this.env.DeleteFile(manifest)
+	}
+
+	return s
+}
+
+func (this *dbImpl) MaybeIgnoreError(s *Status) {
+	if s.OK() || this.options.ParanoidChecks {
+		// No change needed
+	} else {
+		Log(this.options.InfoLog, "Ignoring error %s", s.String())
+		*s = OK()
+	}
+}
+
+// Recover the descriptor from persistent storage.  May do a significant
+// amount of work to recover recently logged updates.  Any changes to
+// be made to the descriptor are added to *edit.
+func (this *dbImpl) recover(edit *VersionEdit, saveManifest *bool) Status {
+	// Ignore error from CreateDir since the creation of the DB is
+	// committed only when the descriptor is created, and this directory
+	// may already exist from a previous failed creation attempt.
+	this.env.CreateDir(this.dbName)
+	// assert(this.dbLock == nil)
+	var lock FileLock
+	s := this.env.LockFile(LockFileName(this.dbName), &lock)
+	if !s.OK() {
+		return s
+	}
+	this.dbLock = &lock
+
+	if !this.env.FileExists(CurrentFileName(this.dbName)) {
+		if this.options.CreateIfMissing {
+			Log(this.options.InfoLog, "Creating DB %s since it was missing.", this.dbName)
+			s = this.NewDB()
+			if !s.OK() {
+				return s
+			}
+		} else {
+			return InvalidArgument(this.dbName + ": does not exist (CreateIfMissing is false)")
+		}
+	} else {
+		if this.options.ErrorIfExists {
+			return InvalidArgument(this.dbName + ": exists (ErrorIfExists is true)")
+		}
+	}
+
+	s = this.versions.Recover(saveManifest)
+	if !s.OK() {
+		return s
+	}
+	var maxSequence sequenceNumber
+
+	// Recover from all newer log files than the ones named in the
+	// descriptor (new log files may have been added by the previous
+	// incarnation without registering them in the descriptor).
+	//
+	// Note that PrevLogNumber() is no longer used, but we pay
+	// attention to it in case we are recovering a database
+	// produced by an older version of leveldb.
+	minLog := this.versions.LogNumber()
+	prevLog := this.versions.PrevLogNumber()
+	filenames, s := this.env.GetChildren(this.dbName)
+	if !s.OK() {
+		return s
+	}
+
+	expected := make(map[uint64]bool)
+	this.versions.AddLiveFiles(expected)
+	var number uint64
+	var fileType FileType
+	var logs []uint64
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) {
+			delete(expected, number)
+			if fileType == kLogFile && (number >= minLog || number == prevLog) {
+				logs = append(logs, number)
+			}
+		}
+	}
+	if len(expected) != 0 {
+		for missing := range expected {
+			return Corruption(fmt.Sprintf("%d missing files; e.g.: %s", len(expected),
+				TableFileName(this.dbName, missing)))
+		}
+	}
+
+	// Recover in the order in which the logs were generated
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i] < logs[j]
+	})
+	for i, logNumber := range logs {
+		s = this.RecoverLogFile(logNumber, i == len(logs) - 1, saveManifest, edit, &maxSequence)
+		if !s.OK() {
+			return s
+		}
+
+		// The previous incarnation may not have written any MANIFEST
+		// records after allocating this log number.  So we manually
+		// update the file number allocation counter in VersionSet.
+		this.versions.MarkFileNumberUsed(logNumber)
+	}
+
+	if this.versions.LastSequence() < maxSequence {
+		this.versions.SetLastSequence(maxSequence)
+	}
+
 	return OK()
 }
 
+type dbLogReporter struct {
+	infoLog Logger
+	fname string
+	status *Status	// nil if options.ParanoidChecks == false
+	dropped bool	// Whether any bytes were dropped
+}
+
+func (this *dbLogReporter) Corruption(bytes int, s Status) {
+	prefix := ""
+	if this.status == nil {
+		prefix = "(ignoring error) "
+	}
+	Log(this.infoLog, "%s%s: dropping %d bytes; %s", prefix, this.fname, bytes, s.String())
+	this.dropped = true
+	if this.status != nil && this.status.OK() {
+		*this.status = s
+	}
+}
+
+func (this *dbImpl) RecoverLogFile(logNumber uint64, lastLog bool, saveManifest *bool,
+	edit *VersionEdit, maxSequence *sequenceNumber) Status {
+	// Open the log file
+	fname := LogFileName(this.dbName, logNumber)
+	var file SequentialFile
+	status := this.env.NewSequentialFile(fname, &file)
+	if !status.OK() {
+		this.MaybeIgnoreError(&status)
+		return status
+	}
+
+	// Create the log reader.
+	reporter := &dbLogReporter{
+		infoLog: this.options.InfoLog,
+		fname: fname,
+	}
+	if this.options.ParanoidChecks {
+		reporter.status = &status
+	}
+	// We intentionally make log.Reader do checksumming even if
+	// ParanoidChecks == false so that corruptions cause entire commits
+	// to be skipped instead of propagating bad information (like overly
+	// large sequence numbers).
+	reader := newLogReader(file, reporter, true /*checksum*/, 0 /*initialOffset*/)
+	Log(this.options.InfoLog, "Recovering log #%d", logNumber)
+
+	// Read all the records and add to a memtable
+	var record, scratch []byte
+	batch := NewWriteBatch()
+	compactions := 0
+	var mem *MemTable
+	for reader.ReadRecord(&record, &scratch) && status.OK() {
+		if len(record) < kWriteBatchHeader {
+			reporter.Corruption(len(record), Corruption("log record too small"))
+			continue
+		}
+		setWriteBatchContents(batch, record)
+
+		if mem == nil {
+			mem = newMemTable(*this.internalKeyComparator)
+		}
+		status = writeBatchInsertInto(batch, mem)
+		this.MaybeIgnoreError(&status)
+		if !status.OK() {
+			break
+		}
+		lastSeq := writeBatchSequence(batch) + sequenceNumber(writeBatchCount(batch)) - 1
+		if lastSeq > *maxSequence {
+			*maxSequence = lastSeq
+		}
+
+		if mem.ApproximateMemoryUsage() > int(this.options.WriteBufferSize) {
+			compactions++
+			*saveManifest = true
+			status = this.WriteLevel0Table(mem, edit, nil)
+			mem = nil
+			if !status.OK() {
+				// Reflect errors immediately so that conditions like full
+				// file-systems cause the DB::Open() to fail.
+				break
+			}
+		}
+	}
+	file.Close()
+
+	// See if we should keep reusing the last log file.  Not if anything
+	// was dropped from it or follows its last record: the records
+	// appended after a damaged tail would be dropped with it the next
+	// time the log is read.
+	if status.OK() && this.options.ReuseLogs && lastLog && compactions == 0 && !reporter.dropped {
+		// assert(this.logFile == nil)
+		// assert(this.log == nil)
+		lfileSize, ss := this.env.GetFileSize(fname)
+		intact := ss.OK() && uint64(lfileSize) == reader.EndOfLastRecord()
+		var lfile WritableFile
+		if intact {
+			ss = this.env.NewAppendableFile(fname, &lfile)
+		}
+		if intact && ss.OK() {
+			Log(this.options.InfoLog, "Reusing old log %s", fname)
+			this.logFile = &lfile
+			this.log = newLogWriterWithLength(this.logFile, uint64(lfileSize))
+			this.logFileNumber = logNumber
+			if mem != nil {
+				this.mem = mem
+				mem = nil
+			} else {
+				// mem can be nil if logNumber exists but was empty.
+				this.mem = newMemTable(*this.internalKeyComparator)
+			}
+		}
+	}
+
+	if mem != nil {
+		// mem did not get reused; compact it.
+		if status.OK() {
+			*saveManifest = true
+			status = this.WriteLevel0Table(mem, edit, nil)
+		}
+	}
+
+	return status
+}
+
+func (this *dbImpl) MaybeScheduleCompaction() {
+	if this.bgCompactionScheduled {
+		// Already scheduled
+	} else if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		// DB is being deleted; no more background compactions
+	} else if this.bgError != nil {
+		// Already got an error; no more changes
+	} else if this.imm == nil &&
+		this.manualCompaction == nil &&
+		len(this.pendingIngestions) == 0 &&
+		!this.versions.NeedsCompaction() {
+		// No work to be done
+	} else {
+		this.bgCompactionScheduled = true
+		this.env.Schedule(this.BackgroundCall)
+	}
+}
+
+func (this *dbImpl) BackgroundCall() {
+	this.mutex.Lock()
+
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		// No more background work when shutting down.
+	} else {
+		s := this.BackgroundCompaction()
+		if s.OK() {
+			this.bgErrorCount = 0
+		} else {
+			this.RecordBackgroundError(s)
+
+			// Wait a little bit before retrying background compaction in
+			// case this is an environmental problem and we do not want to
+			// chew up resources for failed compactions for the duration of
+			// the problem.
+			this.bgCV.Broadcast()	// In case a waiter can proceed despite the error
+			shift := this.bgErrorCount
+			if shift > kMaxBackgroundErrorBackoffShift {
+				shift = kMaxBackgroundErrorBackoffShift
+			}
+			this.bgErrorCount++
+
+			Log(this.options.InfoLog, "Waiting after background compaction error: %s", s.String())
+			this.mutex.Unlock()
+			this.backgroundErrorBackoff(kBackgroundErrorBackoffMicros << shift)
+			this.mutex.Lock()
+		}
+	}
+
+	this.bgCompactionScheduled = false
+
+	// Previous compaction may have produced too many files in a level,
+	// so reschedule another compaction if needed.
+	this.MaybeScheduleCompaction()
+	this.bgCV.Broadcast()
+
+	this.mutex.Unlock()
+}
+
+// Sleep for "micros", but wake up early once the DB starts shutting
+// down, so that Close does not have to wait out the whole backoff.
+func (this *dbImpl) backgroundErrorBackoff(micros uint32) {
+	for micros > 0 && atomic.LoadInt32(&this.shuttingDown) == 0 {
+		step := micros
+		if step > kBackgroundErrorPollMicros {
+			step = kBackgroundErrorPollMicros
+		}
+		this.env.SleepForMicroseconds(step)
+		micros -= step
+	}
+}
+
+func (this *dbImpl) BackgroundCompaction() Status {
+	// Ingested files go first: no entry newer than them may reach a
+	// table before they are installed.
+	if len(this.pendingIngestions) > 0 {
+		return this.InstallIngestedFiles(this.pendingIngestions[0])
+	}
+
+	if this.imm != nil {
+		return this.CompactMemTable()
+	}
+
+	var c *Compaction
+	isManual := this.manualCompaction != nil
+	var manualEnd internalKey
+	if isManual {
+		m := this.manualCompaction
+		c = this.versions.CompactRange(m.level, m.begin, m.end)
+		m.done = c == nil
+		if c != nil {
+			manualEnd = *c.Input(0, c.NumInputFiles(0) - 1).largest
+		}
+
+		beginStr, endStr, stopStr := "(begin)", "(end)", "(end)"
+		if m.begin != nil {
+			beginStr = m.begin.String()
+		}
+		if m.end != nil {
+			endStr = m.end.String()
+		}
+		if !m.done {
+			stopStr = manualEnd.String()
+		}
+		Log(this.options.InfoLog, "Manual compaction at level-%d from %s .. %s; will stop at %s",
+			m.level, beginStr, endStr, stopStr)
+	} else {
+		c = this.versions.PickCompaction()
+	}
+
+	s := OK()
+	if c == nil {
+		// Nothing to do
+	} else if !isManual && c.IsTrivialMove() {
+		// Move file to next level
+		// assert(c.NumInputFiles(0) == 1)
+		f := c.Input(0, 0)
+		c.Edit().DeleteFile(c.Level(), f.number)
+		c.Edit().AddFile(c.Level() + 1, f.number, f.fileSize, f.smallest, f.largest)
+		s = this.versions.LogAndApply(c.Edit(), &this.mutex)
+		if !s.OK() {
+			this.RecordBackgroundError(s)
+		}
+		Log(this.options.InfoLog, "Moved #%d to level-%d %d bytes %s: %s",
+			f.number, c.Level() + 1, f.fileSize, s.String(), this.versions.LevelSummary())
+		c.ReleaseInputs()
+	} else {
+		compact := newCompactionState(c)
+		s = this.DoCompactionWork(compact)
+		this.CleanupCompaction(compact)
+		c.ReleaseInputs()
+		this.DeleteObsoleteFiles()
+	}
+
+	if s.OK() {
+		// Done
+	} else if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		// Ignore compaction errors found during shutting down
+	} else {
+		Log(this.options.InfoLog, "Compaction error: %s", s.String())
+	}
+
+	if isManual {
+		m := this.manualCompaction
+		if !s.OK() {
+			m.done = true
+		}
+		if !m.done {
+			// We only compacted part of the requested range.  Update m
+			// to the range that is left to be compacted.
+			m.tmpStore = manualEnd
+			m.begin = &m.tmpStore
+		}
+		this.manualCompaction = nil
+	}
+
+	return s
+}
+
+func (this *dbImpl) RecordBackgroundError(s Status) {
+	if this.bgError == nil {
+		this.bgError = &s
+		this.bgCV.Broadcast()
+	}
+}
+
+// Compact the in-memory write buffer to disk.  Switches to a new
+// log-file/memtable and writes a new descriptor iff successful.
+// Errors are recorded in bgError.
+func (this *dbImpl) CompactMemTable() Status {
+	// Save the contents of the memtable as a new Table
+	edit := newVersionEdit()
+	base := this.versions.current
+	base.Ref()
+	s := this.WriteLevel0Table(this.imm, edit, base)
+	base.Unref()
+
+	if s.OK() && atomic.LoadInt32(&this.shuttingDown) != 0 {
+		s = IOError("Deleting DB during memtable compaction")
+	}
+
+	// Replace immutable memtable with the generated Table
+	if s.OK() {
+		edit.SetPrevLogNumber(0)
+		edit.SetLogNumber(this.logFileNumber)	// Earlier logs no longer needed
+		s = this.versions.LogAndApply(edit, &this.mutex)
+	}
+
+	if s.OK() {
+		// Commit to the new state
+		this.imm = nil
+		atomic.StoreInt32(&this.hasImm, 0)
+		this.DeleteObsoleteFiles()
+	}
+
+	return s
+}
+
+func (this *dbImpl) WriteLevel0Table(mem *MemTable, edit *VersionEdit, base *Version) Status {
+	startMicros := this.env.NowMicros()
+
+	var meta FileMetaData
+	meta.number = this.versions.NewFileNumber()
+	this.pendingOutputs[meta.number] = true
+	iter := mem.NewIterator()
+	Log(this.options.InfoLog, "Level-0 table #%d: started", meta.number)
+
+	// Pick the output level up front so that the table is built with
+	// the compression chosen for the level it will live in.
+	level := 0
+	iter.SeekToFirst()
+	if base != nil && iter.Valid() {
+		minUserKey := extractUserKey(iter.Key())
+		iter.SeekToLast()
+		maxUserKey := extractUserKey(iter.Key())
+		level = base.PickLevelForMemTableOutput(minUserKey, maxUserKey)
+	}
+
+	var s Status
+	this.mutex.Unlock()
+	s = BuildTable(this.dbName, this.env, optionsForLevel(this.options, level), this.tableCache, iter, &meta)
+	this.mutex.Lock()
+	iter.Close()
+
+	Log(this.options.InfoLog, "Level-0 table #%d: %d bytes %s", meta.number, meta.fileSize, s.String())
+	delete(this.pendingOutputs, meta.number)
+
+	// Note that if fileSize is zero, the file has been deleted and
+	// should not be added to the manifest.
+	if s.OK() && meta.fileSize > 0 {
+		edit.AddFile(level, meta.number, meta.fileSize, meta.smallest, meta.largest)
+	}
+
+	var stats CompactionStats
+	stats.micros = int64(this.env.NowMicros() - startMicros)
+	stats.bytesWritten = int64(meta.fileSize)
+	this.status[level].Add(&stats)
+
+	return s
+}
+
+// Delete any unneeded files and stale in-memory entries.
+func (this *dbImpl) DeleteObsoleteFiles() {
+	if this.bgError != nil {
+		// After a background error, we don't know whether a new version may
+		// or may not have been committed, so we cannot safely garbage collect.
+		return
+	}
+
+	// Make a set of all of the live files
+	live := make(map[uint64]bool)
+	for number := range this.pendingOutputs {
+		live[number] = true
+	}
+	this.versions.AddLiveFiles(live)
+
+	filenames, _ := this.env.GetChildren(this.dbName)	// Ignoring errors on purpose
+	var number uint64
+	var fileType FileType
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) {
+			keep := true
+			switch fileType {
+			case kLogFile:
+				keep = (number >= this.versions.LogNumber()) ||
+					(number == this.versions.PrevLogNumber())
+			case kDescriptorFile:
+				// Keep my manifest file, and any newer incarnations'
+				// (in case there is a race that allows other incarnations)
+				keep = number >= this.versions.ManifestFileNumber()
+			case kTableFile:
+				keep = live[number]
+			case kTempFile:
+				// Any temp files that are currently being written to must
+				// be recorded in pendingOutputs, which is inserted into "live"
+				keep = live[number]
+			case kCurrentFile, kDBLockFile, kInfoLogFile:
+				keep = true
+			}
+
+			if !keep {
+				if fileType == kTableFile {
+					this.tableCache.Evict(number)
+				}
+				Log(this.options.InfoLog, "Delete type=%d #%d", int(fileType), number)
+				this.env.DeleteFile(this.dbName + "/" + filename)
+			}
+		}
+	}
+}
+
+// Files produced by compaction
+type compactionOutput struct {
+	number uint64
+	fileSize uint64
+	smallest internalKey
+	largest internalKey
+}
+
+// State of a single compaction run by the background thread.
+type CompactionState struct {
+	compaction *Compaction
+
+	// Sequence numbers < smallestSnapshot are not significant since we
+	// will never have to service a snapshot below smallestSnapshot.
+	// Therefore if we have seen a sequence number S <= smallestSnapshot,
+	// we can drop all entries for the same key with sequence numbers < S.
+	smallestSnapshot sequenceNumber
+
+	outputs []compactionOutput
+
+	// Set once the outputs are handed to the MANIFEST, which may then
+	// refer to them even if that write fails.
+	installing bool
+
+	// State kept for output being generated
+	outfile WritableFile
+	builder *TableBuilder
+
+	totalBytes uint64
+}
+
+func newCompactionState(c *Compaction) *CompactionState {
+	return &CompactionState{
+		compaction: c,
+		outfile: nil,
+		builder: nil,
+		totalBytes: 0,
+	}
+}
+
+func (this *CompactionState) currentOutput() *compactionOutput {
+	return &this.outputs[len(this.outputs) - 1]
+}
+
+func (this *dbImpl) CleanupCompaction(compact *CompactionState) {
+	if compact.builder != nil {
+		// May happen if we get a shutdown call in the middle of compaction
+		compact.builder.Abandon()
+		compact.builder = nil
+	}
+
+	if compact.outfile != nil {
+		compact.outfile.Close()
+		compact.outfile = nil
+	}
+
+	for _, out := range compact.outputs {
+		if !compact.installing {
+			// Nothing refers to the outputs of a compaction that failed
+			// before installing them, and DeleteObsoleteFiles will not
+			// run after the background error.
+			this.env.DeleteFile(TableFileName(this.dbName, out.number))
+		}
+		delete(this.pendingOutputs, out.number)
+	}
+}
+
+func (this *dbImpl) OpenCompactionOutputFile(compact *CompactionState) Status {
+	var fileNumber uint64
+	this.mutex.Lock()
+	fileNumber = this.versions.NewFileNumber()
+	this.pendingOutputs[fileNumber] = true
+	compact.outputs = append(compact.outputs, compactionOutput{
+		number: fileNumber,
+	})
+	this.mutex.Unlock()
+
+	// Make the output file
+	fname := TableFileName(this.dbName, fileNumber)
+	s := this.env.NewWritableFile(fname, &compact.outfile)
+	if s.OK() {
+		level := compact.compaction.Level() + 1
+		compact.builder = NewTableBuilder(optionsForLevel(this.options, level), compact.outfile)
+	}
+
+	return s
+}
+
+func (this *dbImpl) FinishCompactionOutputFile(compact *CompactionState, input iterator) Status {
+	outputNumber := compact.currentOutput().number
+
+	// Check for iterator errors
+	s := input.Status()
+	currentEntries := compact.builder.NumEntries()
+	if s.OK() {
+		s = compact.builder.Finish()
+	} else {
+		compact.builder.Abandon()
+	}
+
+	currentBytes := compact.builder.FileSize()
+	compact.currentOutput().fileSize = currentBytes
+	compact.totalBytes += currentBytes
+	compact.builder = nil
+
+	// Finish and check for file errors
+	if s.OK() {
+		s = compact.outfile.Sync()
+	}
+	if s.OK() {
+		s = compact.outfile.Close()
+	}
+	compact.outfile = nil
+
+	if s.OK() && currentEntries > 0 {
+		// Verify that the table is usable
+		iter := this.tableCache.NewIterator(&ReadOptions{}, outputNumber, currentBytes, 0, nil)
+		s = iter.Status()
+		iter.Close()
+		if s.OK() {
+			Log(this.options.InfoLog, "Generated table #%d@%d: %d keys, %d bytes",
+				outputNumber, compact.compaction.Level(), currentEntries, currentBytes)
+		}
+	}
+
+	return s
+}
+
+func (this *dbImpl) InstallCompactionResults(compact *CompactionState) Status {
+	c := compact.compaction
+	Log(this.options.InfoLog, "Compacted %d@%d + %d@%d files => %d bytes",
+		c.NumInputFiles(0), c.Level(), c.NumInputFiles(1), c.Level() + 1, compact.totalBytes)
+
+	// Add compaction outputs
+	c.AddInputDeletions(c.Edit())
+	level := c.Level()
+	for i := range compact.outputs {
+		out := &compact.outputs[i]
+		smallest := out.smallest
+		largest := out.largest
+		c.Edit().AddFile(level + 1, out.number, out.fileSize, &smallest, &largest)
+	}
+
+	compact.installing = true
+	return this.versions.LogAndApply(c.Edit(), &this.mutex)
+}
+
+func (this *dbImpl) DoCompactionWork(compact *CompactionState) Status {
+	startMicros := this.env.NowMicros()
+	var immMicros uint64	// Micros spent doing imm compactions
+
+	c := compact.compaction
+	Log(this.options.InfoLog, "Compacting %d@%d + %d@%d files",
+		c.NumInputFiles(0), c.Level(), c.NumInputFiles(1), c.Level() + 1)
+
+	if this.snapshots.Empty() {
+		compact.smallestSnapshot = this.versions.LastSequence()
+	} else {
+		compact.smallestSnapshot = this.snapshots.Oldest().number
+	}
+
+	// Release mutex while we're actually doing the compaction work
+	this.mutex.Unlock()
+
+	input := this.versions.MakeInputIterator(c)
+	input.SeekToFirst()
+
+	s := OK()
+	var ikey parsedInternalKey
+	var currentUserKey string
+	hasCurrentUserKey := false
+	lastSequenceForKey := kMaxSequenceNumber
+
+	for input.Valid() && atomic.LoadInt32(&this.shuttingDown) == 0 {
+		// Prioritize immutable compaction work.  Not while files are
+		// waiting to be ingested, though: the memtable holds writes newer
+		// than them, which must not reach a table first (see
+		// InstallIngestedFiles).
+		if atomic.LoadInt32(&this.hasImm) != 0 {
+			immStart := this.env.NowMicros()
+			this.mutex.Lock()
+			if this.imm != nil && len(this.pendingIngestions) == 0 {
+				if immStatus := this.CompactMemTable(); !immStatus.OK() {
+					this.RecordBackgroundError(immStatus)
+				}
+				this.bgCV.Broadcast()	// Wakeup MakeRoomForWrite() if necessary
+			}
+			this.mutex.Unlock()
+			immMicros += this.env.NowMicros() - immStart
+		}
+
+		key := input.Key()
+		if c.ShouldStopBefore(key) && compact.builder != nil {
+			s = this.FinishCompactionOutputFile(compact, input)
+			if !s.OK() {
+				break
+			}
+		}
+
+		// Handle key/value, add to state, etc.
+		drop := false
+		if !parseInternalKey(key, &ikey) {
+			// Do not hide error keys
+			currentUserKey = ""
+			hasCurrentUserKey = false
+			lastSequenceForKey = kMaxSequenceNumber
+		} else {
+			if !hasCurrentUserKey ||
+				this.userComparator().Compare(ikey.userKey, currentUserKey) != 0 {
+				// First occurrence of this user key
+				currentUserKey = ikey.userKey
+				hasCurrentUserKey = true
+				lastSequenceForKey = kMaxSequenceNumber
+			}
+
+			if lastSequenceForKey <= compact.smallestSnapshot {
+				// Hidden by an newer entry for same user key
+				drop = true	// (A)
+			} else if ikey.vt == kTypeDeletion &&
+				ikey.sequence <= compact.smallestSnapshot &&
+				c.IsBaseLevelForKey(ikey.userKey) {
+				// For this user key:
+				// (1) there is no data in higher levels
+				// (2) data in lower levels will have larger sequence numbers
+				// (3) data in layers that are being compacted here and have
+				//     smaller sequence numbers will be dropped in the next
+				//     few iterations of this loop (by rule (A) above).
+				// Therefore this deletion marker is obsolete and can be dropped.
+				drop = true
+			}
+
+			lastSequenceForKey = ikey.sequence
+		}
+
+		if !drop {
+			// Open output file if necessary
+			if compact.builder == nil {
+				s = this.OpenCompactionOutputFile(compact)
+				if !s.OK() {
+					break
+				}
+			}
+
+			if compact.builder.NumEntries() == 0 {
+				compact.currentOutput().smallest.decodeFrom(key)
+			}
+			compact.currentOutput().largest.decodeFrom(key)
+			compact.builder.Add(key, input.Value())
+
+			// Close output file if it is big enough
+			if compact.builder.FileSize() >= c.MaxOutputFileSize() {
+				s = this.FinishCompactionOutputFile(compact, input)
+				if !s.OK() {
+					break
+				}
+			}
+		}
+
+		input.Next()
+	}
+
+	if s.OK() && atomic.LoadInt32(&this.shuttingDown) != 0 {
+		s = IOError("Deleting DB during compaction")
+	}
+	if s.OK() && compact.builder != nil {
+		s = this.FinishCompactionOutputFile(compact, input)
+	}
+	if s.OK() {
+		s = input.Status()
+	}
+	input.Close()
+	input = nil
+
+	var stats CompactionStats
+	stats.micros = int64(this.env.NowMicros() - startMicros - immMicros)
+	for which := 0; which < 2; which++ {
+		for i := 0; i < c.NumInputFiles(which); i++ {
+			stats.bytesRead += int64(c.Input(which, i).fileSize)
+		}
+	}
+	for _, out := range compact.outputs {
+		stats.bytesWritten += int64(out.fileSize)
+	}
+
+	this.mutex.Lock()
+	this.status[c.Level() + 1].Add(&stats)
+
+	if s.OK() {
+		s = this.InstallCompactionResults(compact)
+	}
+	if !s.OK() {
+		this.RecordBackgroundError(s)
+	}
+
+	Log(this.options.InfoLog, "compacted to: %s", this.versions.LevelSummary())
+	return s
+}
+
 func ClipToRangeUint64(value *uint64, minValue uint64, maxValue uint64) {
 	if *value > maxValue {
 		*value = maxValue
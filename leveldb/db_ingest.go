@@ -0,0 +1,295 @@
+package leveldb
+
+import (
+	"sort"
+	"sync/atomic"
+)
+
+// A table being ingested by IngestExternalFiles
+type ingestedFile struct {
+	path string	// Where the caller built it
+	number uint64	// Its number in the DB directory
+	fileSize uint64
+	smallest string	// Smallest user key
+	largest string	// Largest user key
+}
+
+// Files ingested together, which share a sequence number
+type externalIngestion struct {
+	files []*ingestedFile
+	sequence sequenceNumber
+	done bool	// Installed, or failed to be
+	status Status
+}
+
+func (this *dbImpl) IngestExternalFiles(paths []string, ingestOptions *IngestOptions) error {
+	if ingestOptions == nil {
+		ingestOptions = &IngestOptions{}
+	}
+
+	return this.ingestExternalFiles(paths, ingestOptions).Err()
+}
+
+func (this *dbImpl) ingestExternalFiles(paths []string, ingestOptions *IngestOptions) Status {
+	if len(paths) == 0 {
+		return OK()
+	}
+
+	files := make([]*ingestedFile, len(paths))
+	for i, path := range paths {
+		files[i] = &ingestedFile{path: path}
+		if s := this.readExternalFile(files[i], ingestOptions); !s.OK() {
+			return s
+		}
+	}
+
+	// The files share a sequence number, so no key may be in two of them.
+	ucmp := this.internalKeyComparator.userComparator()
+	sort.Slice(files, func(i, j int) bool {
+		return ucmp.Compare(files[i].smallest, files[j].smallest) < 0
+	})
+	for i := 1; i < len(files); i++ {
+		if ucmp.Compare(files[i - 1].largest, files[i].smallest) >= 0 {
+			return InvalidArgument("the key ranges of " + files[i - 1].path + " and " + files[i].path + " overlap")
+		}
+	}
+
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return dbClosed()
+	}
+	for _, f := range files {
+		f.number = this.versions.NewFileNumber()
+		this.pendingOutputs[f.number] = true
+	}
+	this.mutex.Unlock()
+
+	s := OK()
+	for _, f := range files {
+		s = this.linkOrCopyFile(f.path, TableFileName(this.dbName, f.number), ingestOptions.CopyFiles)
+		if !s.OK() {
+			break
+		}
+	}
+
+	ingestion := &externalIngestion{files: files}
+	if s.OK() {
+		s = this.assignIngestionSequence(ingestion)
+	}
+
+	this.mutex.Lock()
+	if s.OK() {
+		// While shutting down, or after a background error, wait for the
+		// background thread to stop, so that we know whether it installed
+		// the files.
+		for !ingestion.done && (this.bgCompactionScheduled ||
+			(atomic.LoadInt32(&this.shuttingDown) == 0 && this.bgError == nil)) {
+			this.bgCV.Wait()
+		}
+		if ingestion.done {
+			s = ingestion.status
+		} else {
+			// No background work will be scheduled any more.
+			for i, pending := range this.pendingIngestions {
+				if pending == ingestion {
+					this.pendingIngestions = append(this.pendingIngestions[:i], this.pendingIngestions[i + 1:]...)
+					break
+				}
+			}
+			if this.bgError != nil {
+				s = *this.bgError
+			} else {
+				s = dbClosed()
+			}
+		}
+	}
+	installed := ingestion.done
+	for _, f := range files {
+		delete(this.pendingOutputs, f.number)
+	}
+	this.mutex.Unlock()
+
+	if !installed {
+		for _, f := range files {
+			this.env.DeleteFile(TableFileName(this.dbName, f.number))
+		}
+	}
+
+	return s
+}
+
+// Check that the table at f.path is ordered by the comparator of the
+// DB, and fill in its size and key range.
+func (this *dbImpl) readExternalFile(f *ingestedFile, ingestOptions *IngestOptions) Status {
+	fileSize, s := this.env.GetFileSize(f.path)
+	if !s.OK() {
+		return s
+	}
+	f.fileSize = uint64(fileSize)
+
+	var file RandomAccessFile
+	s = this.env.NewRandomAccessFile(f.path, &file)
+	if !s.OK() {
+		return s
+	}
+
+	// The table is read on its own, with the user comparator.
+	ucmp := this.internalKeyComparator.userComparator()
+	options := *this.options
+	options.Comparator = ucmp
+	options.BlockCache = nil
+	options.ParanoidChecks = ingestOptions.VerifyChecksums
+
+	var table *Table
+	s = OpenTable(&options, file, f.fileSize, &table)
+	if s.OK() && table.ComparatorName() == "" {
+		s = InvalidArgument(f.path + " does not record the comparator it was built with")
+	}
+
+	if s.OK() {
+		iter := table.NewIterator(&ReadOptions{VerifyChecksums: ingestOptions.VerifyChecksums})
+		numEntries := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			key := iter.Key()
+			if numEntries == 0 {
+				f.smallest = key
+			} else if ucmp.Compare(f.largest, key) >= 0 {
+				s = InvalidArgument(f.path + " is not sorted")
+				break
+			}
+			f.largest = key
+			numEntries++
+		}
+		if s.OK() {
+			s = iter.Status()
+		}
+		iter.Close()
+
+		if s.OK() && numEntries == 0 {
+			s = InvalidArgument(f.path + " is empty")
+		}
+	}
+	file.Close()
+
+	return s
+}
+
+// Link "src" to "target" if allowed and supported, and copy it
+// otherwise.
+func (this *dbImpl) linkOrCopyFile(src string, target string, copyFiles bool) Status {
+	if linker, ok := this.env.(fileLinker); ok && !copyFiles {
+		if linker.LinkFile(src, target).OK() {
+			return OK()
+		}
+		// E.g. "src" is on another file system: fall back to a copy.
+	}
+
+	return CopyFile(this.env, src, target)
+}
+
+// Assign the next sequence number to the files of "ingestion" and
+// queue them for installation by the background thread.
+//
+// Takes the front of the writer queue to block writes meanwhile: the
+// memtables must hold no entry in the ranges of the files, since such
+// an older entry would shadow them, and every later write must get a
+// larger sequence number.  Overlapping memtables are compacted first.
+func (this *dbImpl) assignIngestionSequence(ingestion *externalIngestion) Status {
+	w := newWriter(&this.mutex)
+	w.exclusive = true
+
+	this.mutex.Lock()
+	if atomic.LoadInt32(&this.shuttingDown) != 0 {
+		this.mutex.Unlock()
+		return dbClosed()
+	}
+	this.writers = append(this.writers, w)
+	for w != this.writers[0] {
+		w.cv.Wait()
+	}
+
+	s := OK()
+	for {
+		if atomic.LoadInt32(&this.shuttingDown) != 0 {
+			s = dbClosed()
+			break
+		} else if this.bgError != nil {
+			s = *this.bgError
+			break
+		} else if this.imm != nil && this.memTableOverlaps(this.imm, ingestion.files) {
+			Log(this.options.InfoLog, "Ingested files overlap the memtable being compacted; waiting...")
+			this.bgCV.Wait()
+		} else if this.memTableOverlaps(this.mem, ingestion.files) {
+			// Switch to a new memtable and compact the old one.
+			s = this.MakeRoomForWrite(true)
+			if !s.OK() {
+				break
+			}
+		} else {
+			break
+		}
+	}
+
+	if s.OK() {
+		ingestion.sequence = this.versions.LastSequence() + 1
+		this.versions.SetLastSequence(ingestion.sequence)
+		this.pendingIngestions = append(this.pendingIngestions, ingestion)
+		this.MaybeScheduleCompaction()
+	}
+
+	this.writers = this.writers[1:]
+	if len(this.writers) > 0 {
+		this.writers[0].cv.Signal()
+	}
+	this.mutex.Unlock()
+
+	return s
+}
+
+// Returns true iff "mem" holds an entry whose user key is in the range
+// of one of "files".
+func (this *dbImpl) memTableOverlaps(mem *MemTable, files []*ingestedFile) bool {
+	ucmp := this.internalKeyComparator.userComparator()
+	iter := mem.NewIterator()
+	overlaps := false
+	for _, f := range files {
+		start := makeInternalKey(f.smallest, kMaxSequenceNumber, kValueTypeForSeek)
+		iter.Seek(start.encode())
+		if iter.Valid() && ucmp.Compare(extractUserKey(iter.Key()), f.largest) <= 0 {
+			overlaps = true
+			break
+		}
+	}
+	iter.Close()
+
+	return overlaps
+}
+
+// Add the files of "ingestion" to a new version, each at the deepest
+// level whose files and the files above do not overlap it.  Every entry
+// in the current version is older than the files: the background
+// thread installs them before any other work, and DoCompactionWork does
+// not flush the memtable while they wait.
+func (this *dbImpl) InstallIngestedFiles(ingestion *externalIngestion) Status {
+	edit := newVersionEdit()
+	base := this.versions.current
+	for _, f := range ingestion.files {
+		level := base.PickLevelForIngestedFile(f.smallest, f.largest)
+		smallest := makeInternalKey(f.smallest, ingestion.sequence, kTypeValue)
+		largest := makeInternalKey(f.largest, ingestion.sequence, kTypeValue)
+		edit.AddFile(level, f.number, f.fileSize, &smallest, &largest)
+		Log(this.options.InfoLog, "Ingesting %s as #%d to level-%d %d bytes",
+			f.path, f.number, level, f.fileSize)
+	}
+
+	s := this.versions.LogAndApply(edit, &this.mutex)
+	Log(this.options.InfoLog, "Ingested %d files at sequence %d: %s",
+		len(ingestion.files), uint64(ingestion.sequence), s.String())
+
+	ingestion.status = s
+	ingestion.done = true
+	this.pendingIngestions = this.pendingIngestions[1:]
+
+	return s
+}
@@ -0,0 +1,356 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Build a table at "fname" holding "kvs", in the given order, as a
+// caller of IngestExternalFiles would.
+func writeExternalFile(t *testing.T, env Env, fname string, kvs ...string) {
+	t.Helper()
+	env.CreateDir("/external")
+	var file WritableFile
+	if s := env.NewWritableFile(fname, &file); !s.OK() {
+		t.Fatal(s)
+	}
+	builder := NewTableBuilder(NewOptions(), file)
+	for i := 0; i + 1 < len(kvs); i += 2 {
+		builder.Add(kvs[i], kvs[i + 1])
+	}
+	if s := builder.Finish(); !s.OK() {
+		t.Fatal(s)
+	}
+	file.Close()
+}
+
+// Return the numbers of the table files in the directory of "impl".
+func tableFiles(t *testing.T, impl *dbImpl) map[uint64]bool {
+	t.Helper()
+	filenames, s := impl.env.GetChildren(impl.dbName)
+	if !s.OK() {
+		t.Fatal(s)
+	}
+	tables := make(map[uint64]bool)
+	var number uint64
+	var fileType FileType
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) && fileType == kTableFile {
+			tables[number] = true
+		}
+	}
+
+	return tables
+}
+
+// Return the level holding table file "number", or -1.
+func levelOfFile(impl *dbImpl, number uint64) int {
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+
+	for level := 0; level < kNumLevels; level++ {
+		for _, f := range impl.versions.current.files[level] {
+			if f.number == number {
+				return level
+			}
+		}
+	}
+
+	return -1
+}
+
+// Ingest "paths" and return the numbers of the table files it added.
+func ingest(t *testing.T, impl *dbImpl, paths ...string) []uint64 {
+	t.Helper()
+	before := tableFiles(t, impl)
+	if err := impl.IngestExternalFiles(paths, nil); err != nil {
+		t.Fatalf("IngestExternalFiles(%v): %v", paths, err)
+	}
+	var added []uint64
+	for number := range tableFiles(t, impl) {
+		if !before[number] {
+			added = append(added, number)
+		}
+	}
+
+	return added
+}
+
+func TestIngestRejectsBadFiles(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	impl := openTestDB(t, "/ingest", newTestOptions(env))
+	defer impl.Close()
+
+	writeExternalFile(t, env, "/external/ac", "a", "1", "c", "1")
+	writeExternalFile(t, env, "/external/bd", "b", "2", "d", "2")
+	writeExternalFile(t, env, "/external/cd", "c", "3", "d", "3")
+	writeExternalFile(t, env, "/external/unsorted", "f", "4", "e", "4")
+	writeExternalFile(t, env, "/external/empty")
+
+	tests := []struct {
+		paths []string
+		message string
+	}{
+		{[]string{"/external/ac", "/external/bd"}, "overlap"},
+		{[]string{"/external/cd", "/external/ac"}, "overlap"},	// Sharing only "c"
+		{[]string{"/external/unsorted"}, "is not sorted"},
+		{[]string{"/external/empty"}, "is empty"},
+		{[]string{"/external/bd", "/external/empty"}, "is empty"},
+	}
+	before := tableFiles(t, impl)
+	for _, test := range tests {
+		err := impl.IngestExternalFiles(test.paths, nil)
+		if !errors.Is(err, ErrInvalidArgument) || !strings.Contains(err.Error(), test.message) {
+			t.Errorf("IngestExternalFiles(%v): %v, want %q", test.paths, err, test.message)
+		}
+	}
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if got := getValue(t, impl, key, nil); got != "NOT_FOUND" {
+			t.Errorf("Get(%s) = %s after a rejected ingestion", key, got)
+		}
+	}
+	if after := tableFiles(t, impl); len(after) != len(before) {
+		t.Errorf("rejected ingestions left %d table files, had %d", len(after), len(before))
+	}
+}
+
+func TestIngestFlushesOverlappingMemTable(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	impl := openTestDB(t, "/ingest", newTestOptions(env))
+	defer impl.Close()
+
+	mustPut(t, impl, "a", "old")
+	mustPut(t, impl, "b", "old")
+	mustPut(t, impl, "z", "memtable")
+	snapshot := impl.GetSnapshot()
+	defer impl.ReleaseSnapshot(snapshot)
+
+	writeExternalFile(t, env, "/external/ab", "a", "ingested", "b", "ingested")
+	if added := ingest(t, impl, "/external/ab"); len(added) != 2 {
+		t.Errorf("ingestion added %d table files, want the file and the flushed memtable", len(added))
+	}
+	for key, want := range map[string]string{"a": "ingested", "b": "ingested", "z": "memtable"} {
+		if got := getValue(t, impl, key, nil); got != want {
+			t.Errorf("Get(%s) = %s, want %s", key, got, want)
+		}
+	}
+	if got := getValue(t, impl, "a", &ReadOptions{Snapshot: snapshot}); got != "old" {
+		t.Errorf("Get(a) at an earlier snapshot = %s, want old", got)
+	}
+}
+
+func TestWritesShadowIngestedValues(t *testing.T) {
+	const dbName = "/ingest"
+	env := NewMemEnv(DefaultEnv())
+	options := newTestOptions(env)
+	impl := openTestDB(t, dbName, options)
+
+	var kvs []string
+	for i := 0; i < 100; i++ {
+		kvs = append(kvs, fmt.Sprintf("key%03d", i), "ingested")
+	}
+	writeExternalFile(t, env, "/external/keys", kvs...)
+	ingest(t, impl, "/external/keys")
+
+	mustPut(t, impl, "key010", "later")
+	if err := impl.Delete([]byte("key020"), nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"key000": "ingested", "key010": "later", "key020": "NOT_FOUND", "key099": "ingested"}
+	check := func(when string) {
+		t.Helper()
+		for key, value := range want {
+			if got := getValue(t, impl, key, nil); got != value {
+				t.Errorf("%s: Get(%s) = %s, want %s", when, key, got, value)
+			}
+		}
+	}
+	check("before compaction")
+	impl.CompactRange(nil, nil)
+	check("after compaction")
+
+	// The ingested data survives a reopen.
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	impl = openTestDB(t, dbName, options)
+	defer impl.Close()
+	check("after reopen")
+}
+
+func TestIngestedFileLevel(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	impl := openTestDB(t, "/ingest", newTestOptions(env))
+	defer impl.Close()
+
+	// Nothing overlaps the file, so it goes to the last level.
+	writeExternalFile(t, env, "/external/a", "a1", "v", "a2", "v")
+	added := ingest(t, impl, "/external/a")
+	if len(added) != 1 || levelOfFile(impl, added[0]) != kNumLevels - 1 {
+		t.Fatalf("a file overlapping nothing went to level %d", levelOfFile(impl, added[0]))
+	}
+
+	// A file overlapping it goes right above it.
+	writeExternalFile(t, env, "/external/a2", "a2", "v2")
+	added = ingest(t, impl, "/external/a2")
+	if len(added) != 1 || levelOfFile(impl, added[0]) != kNumLevels - 2 {
+		t.Fatalf("a file overlapping the last level went to level %d", levelOfFile(impl, added[0]))
+	}
+	if got := getValue(t, impl, "a2", nil); got != "v2" {
+		t.Fatalf("Get(a2) = %s", got)
+	}
+
+	// Directly, for files at several levels.
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+	version := newVersion(impl.versions)
+	for _, f := range []struct {
+		level int
+		smallest, largest string
+	}{
+		{0, "c", "e"},
+		{2, "m", "p"},
+		{5, "x", "y"},
+	} {
+		meta := newFileMetaData()
+		smallest := makeInternalKey(f.smallest, 100, kTypeValue)
+		largest := makeInternalKey(f.largest, 100, kTypeValue)
+		meta.smallest, meta.largest = &smallest, &largest
+		version.files[f.level] = append(version.files[f.level], meta)
+	}
+	tests := []struct {
+		smallest, largest string
+		want int
+	}{
+		{"a", "b", kNumLevels - 1},
+		{"d", "d", 0},
+		{"e", "m", 0},
+		{"n", "o", 1},
+		{"f", "l", kNumLevels - 1},
+		{"q", "w", kNumLevels - 1},
+		{"w", "x", 4},
+		{"y", "z", 4},
+	}
+	for _, test := range tests {
+		if got := version.PickLevelForIngestedFile(test.smallest, test.largest); got != test.want {
+			t.Errorf("PickLevelForIngestedFile(%s, %s) = %d, want %d", test.smallest, test.largest, got, test.want)
+		}
+	}
+}
+
+func TestCloseDuringIngestion(t *testing.T) {
+	env := newTestEnv(NewMemEnv(DefaultEnv()))
+	impl := openTestDB(t, "/ingest", newTestOptions(env))
+	before := tableFiles(t, impl)
+
+	writeExternalFile(t, env, "/external/a", "a", "v")
+	env.HoldBackgroundWork()
+	done := make(chan error)
+	go func() {
+		done <- impl.IngestExternalFiles([]string{"/external/a"}, nil)
+	}()
+	if !waitFor(impl, 10 * time.Second, func() bool { return len(impl.pendingIngestions) > 0 }) {
+		t.Fatal("the ingestion was not queued")
+	}
+
+	// Let the held background work run only once Close has begun.
+	closed := make(chan error)
+	go func() {
+		closed <- impl.Close()
+	}()
+	if !waitFor(impl, 10 * time.Second, func() bool { return atomic.LoadInt32(&impl.shuttingDown) != 0 }) {
+		t.Fatal("Close did not begin")
+	}
+	env.ReleaseBackgroundWork()
+
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err == nil {
+		t.Fatal("the ingestion succeeded after Close")
+	}
+	if after := tableFiles(t, impl); len(after) != len(before) {
+		t.Fatalf("the ingestion left %d table files behind", len(after) - len(before))
+	}
+
+	impl = openTestDB(t, "/ingest", newTestOptions(env))
+	defer impl.Close()
+	if got := getValue(t, impl, "a", nil); got != "NOT_FOUND" {
+		t.Fatalf("Get(a) = %s after a failed ingestion", got)
+	}
+}
+
+// Writes made while an ingestion waits behind a running compaction must
+// not reach a table before the ingested file does.
+func TestWritesDuringCompactionShadowPendingIngestion(t *testing.T) {
+	env := newTestEnv(NewMemEnv(DefaultEnv()))
+	impl := openTestDB(t, "/ingest", newTestOptions(env))
+	defer impl.Close()
+
+	mustPut(t, impl, "x1", "v")
+	mustPut(t, impl, "x2", "v")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	level := 0
+	for numTableFilesAtLevel(t, impl, level) == 0 {
+		level++
+	}
+
+	// Stop the compaction of that level when it creates its output.
+	var blocked int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	env.SetNewWritableFileHook(func(fname string) {
+		if strings.HasSuffix(fname, ".ldb") && atomic.CompareAndSwapInt32(&blocked, 0, 1) {
+			close(entered)
+			<-release
+		}
+	})
+	compacted := make(chan struct{})
+	go func() {
+		impl.compactRangeLevel(level, nil, nil)
+		close(compacted)
+	}()
+	<-entered
+
+	writeExternalFile(t, env, "/external/k", "k", "ingested")
+	done := make(chan error)
+	go func() {
+		done <- impl.IngestExternalFiles([]string{"/external/k"}, nil)
+	}()
+	if !waitFor(impl, 10 * time.Second, func() bool { return len(impl.pendingIngestions) > 0 }) {
+		t.Fatal("the ingestion was not queued")
+	}
+
+	// A newer write, then enough to turn the memtable into the immutable
+	// one while the compaction still runs.
+	mustPut(t, impl, "k", "newer")
+	value := strings.Repeat("v", 1000)
+	for i := 0; ; i++ {
+		impl.mutex.Lock()
+		switched := impl.imm != nil
+		impl.mutex.Unlock()
+		if switched {
+			break
+		}
+		mustPut(t, impl, fmt.Sprintf("f%05d", i), value)
+	}
+	close(release)
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	<-compacted
+	if got := getValue(t, impl, "k", nil); got != "newer" {
+		t.Fatalf("Get(k) = %s, want newer", got)
+	}
+	impl.CompactRange(nil, nil)
+	if got := getValue(t, impl, "k", nil); got != "newer" {
+		t.Fatalf("Get(k) after compaction = %s, want newer", got)
+	}
+}
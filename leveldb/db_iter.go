@@ -0,0 +1,371 @@
+package leveldb
+
+import (
+	"bytes"
+)
+
+// Memtables and sstables that make the DB representation contain
+// (userkey,seq,type) => uservalue entries.  dbIter combines multiple
+// entries for the same userkey found in the DB representation into a
+// single entry while accounting for sequence numbers, deletion markers,
+// overwrites, etc.
+//
+// Which direction is the iterator currently moving?
+// (1) When moving forward, the internal iterator is positioned at
+//     the exact entry that yields this.Key(), this.Value()
+// (2) When moving backwards, the internal iterator is positioned
+//     just before all entries whose user key == this.Key().
+//
+// Only user keys in [lowerBound, upperBound) are yielded, and the
+// iterator becomes invalid as soon as it reaches a key outside them,
+// without skipping over the entries beyond.  Keys that do not start
+// with prefix are skipped.
+type dbIter struct {
+	userComparator Comparator
+	iter iterator
+	sequence sequenceNumber
+	lowerBound []byte	// nil if unbounded
+	upperBound []byte	// nil if unbounded
+	prefix []byte	// nil if every key is yielded
+	s Status
+	savedKey []byte	// == current key when direction==kReverse
+	savedValue []byte	// == current raw value when direction==kReverse
+	direction int
+	valid bool
+}
+
+// Return a new iterator that converts internal keys (yielded by
+// "internalIter") that were live at the specified "sequence" number
+// into appropriate user keys.  Takes ownership of "internalIter".
+func newDBIter(userComparator Comparator, internalIter iterator, sequence sequenceNumber,
+	lowerBound, upperBound, prefix []byte) *dbIter {
+	return &dbIter{
+		userComparator: userComparator,
+		iter: internalIter,
+		sequence: sequence,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+		prefix: prefix,
+		s: OK(),
+		direction: kForward,
+		valid: false,
+	}
+}
+
+func (this *dbIter) Valid() bool {
+	return this.valid
+}
+
+func (this *dbIter) Key() []byte {
+	// assert(this.valid)
+	if this.direction == kForward {
+		return stringToBytes(extractUserKey(this.iter.Key()))
+	}
+
+	return this.savedKey
+}
+
+func (this *dbIter) Value() []byte {
+	// assert(this.valid)
+	if this.direction == kForward {
+		return stringToBytes(this.iter.Value())
+	}
+
+	return this.savedValue
+}
+
+func (this *dbIter) Error() error {
+	if this.s.OK() {
+		return this.iter.Status().Err()
+	}
+
+	return this.s
+}
+
+func (this *dbIter) Close() error {
+	err := this.Error()
+	this.iter.Close()
+	this.valid = false
+
+	return err
+}
+
+func (this *dbIter) parseKey(ikey *parsedInternalKey) bool {
+	if !parseInternalKey(this.iter.Key(), ikey) {
+		this.s = Corruption("corrupted internal key in DBIter")
+		return false
+	}
+
+	return true
+}
+
+// Return the bounds an iterator created with "options" is restricted
+// to.  Under BytewiseComparator the keys that start with options.Prefix
+// are the ones in [prefix, successor of prefix), so LowerBound and
+// UpperBound are narrowed to them.  Other comparators may order those
+// keys anywhere, and their bounds are left alone.
+func iteratorBounds(ucmp Comparator, options *ReadOptions) (lower, upper []byte) {
+	lower, upper = options.LowerBound, options.UpperBound
+	if options.Prefix == nil {
+		return
+	}
+	if _, ok := ucmp.(*bytewiseComparator); !ok {
+		return
+	}
+
+	prefix := options.Prefix
+	if lower == nil || ucmp.Compare(bytesToString(lower), bytesToString(prefix)) < 0 {
+		lower = prefix
+	}
+	// The keys that start with prefix end before its successor.
+	// A prefix made of 0xff bytes only has none.
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			limit := make([]byte, i + 1)
+			copy(limit, prefix)
+			limit[i]++
+			if upper == nil || ucmp.Compare(bytesToString(limit), bytesToString(upper)) < 0 {
+				upper = limit
+			}
+			break
+		}
+	}
+
+	return
+}
+
+func (this *dbIter) beforeLowerBound(userKey string) bool {
+	return this.lowerBound != nil && this.userComparator.Compare(userKey, bytesToString(this.lowerBound)) < 0
+}
+
+func (this *dbIter) pastUpperBound(userKey string) bool {
+	return this.upperBound != nil && this.userComparator.Compare(userKey, bytesToString(this.upperBound)) >= 0
+}
+
+func (this *dbIter) hasPrefix(userKey string) bool {
+	return this.prefix == nil || bytes.HasPrefix(stringToBytes(userKey), this.prefix)
+}
+
+func saveKey(k string, dst *[]byte) {
+	*dst = append((*dst)[:0], k...)
+}
+
+func (this *dbIter) clearSavedValue() {
+	if cap(this.savedValue) > 1048576 {
+		this.savedValue = nil
+	} else {
+		this.savedValue = this.savedValue[:0]
+	}
+}
+
+func (this *dbIter) Next() {
+	// assert(this.valid)
+
+	if this.direction == kReverse {	// Switch directions?
+		this.direction = kForward
+		// iter is pointing just before the entries for this.Key(),
+		// so advance into the range of entries for this.Key() and then
+		// use the normal skipping code below.
+		if !this.iter.Valid() {
+			this.iter.SeekToFirst()
+		} else {
+			this.iter.Next()
+		}
+		if !this.iter.Valid() {
+			this.valid = false
+			this.savedKey = this.savedKey[:0]
+			return
+		}
+		// savedKey already contains the key to skip past.
+	} else {
+		// Store in savedKey the current key so we skip it below.
+		saveKey(extractUserKey(this.iter.Key()), &this.savedKey)
+
+		// iter is pointing to current key. We can now safely move to the
+		// next to avoid checking current key.
+		this.iter.Next()
+		if !this.iter.Valid() {
+			this.valid = false
+			this.savedKey = this.savedKey[:0]
+			return
+		}
+	}
+
+	this.findNextUserEntry(true, &this.savedKey)
+}
+
+func (this *dbIter) findNextUserEntry(skipping bool, skip *[]byte) {
+	// Loop until we hit an acceptable entry to yield
+	// assert(this.iter.Valid())
+	// assert(this.direction == kForward)
+	for {
+		var ikey parsedInternalKey
+		if this.parseKey(&ikey) {
+			if this.pastUpperBound(ikey.userKey) {
+				// Everything from here on is out of bounds.
+				break
+			}
+			if ikey.sequence <= this.sequence {
+				switch ikey.vt {
+				case kTypeDeletion:
+					// Arrange to skip all upcoming entries for this key since
+					// they are hidden by this deletion.
+					saveKey(ikey.userKey, skip)
+					skipping = true
+				case kTypeValue:
+					if skipping && this.userComparator.Compare(ikey.userKey, bytesToString(*skip)) <= 0 {
+						// Entry hidden
+					} else if !this.hasPrefix(ikey.userKey) {
+						// Entry outside the prefix
+					} else {
+						this.valid = true
+						this.savedKey = this.savedKey[:0]
+						return
+					}
+				}
+			}
+		}
+		this.iter.Next()
+		if !this.iter.Valid() {
+			break
+		}
+	}
+
+	this.savedKey = this.savedKey[:0]
+	this.valid = false
+}
+
+func (this *dbIter) Prev() {
+	// assert(this.valid)
+
+	if this.direction == kForward {	// Switch directions?
+		// iter is pointing at the current entry.  Scan backwards until
+		// the key changes so we can use the normal reverse scanning code.
+		// assert(this.iter.Valid())	// Otherwise this.valid would have been false
+		saveKey(extractUserKey(this.iter.Key()), &this.savedKey)
+		for {
+			this.iter.Prev()
+			if !this.iter.Valid() {
+				this.valid = false
+				this.savedKey = this.savedKey[:0]
+				this.clearSavedValue()
+				return
+			}
+			if this.userComparator.Compare(extractUserKey(this.iter.Key()), bytesToString(this.savedKey)) < 0 {
+				break
+			}
+		}
+		this.direction = kReverse
+	}
+
+	this.findPrevUserEntry()
+}
+
+func (this *dbIter) findPrevUserEntry() {
+	// assert(this.direction == kReverse)
+
+	valueType := kTypeDeletion
+	if this.iter.Valid() {
+		for {
+			var ikey parsedInternalKey
+			parsed := this.parseKey(&ikey)
+			if parsed && this.beforeLowerBound(ikey.userKey) {
+				// Everything from here on is out of bounds.  The iterator
+				// is still positioned just before the entries for savedKey.
+				break
+			}
+			if parsed && ikey.sequence <= this.sequence {
+				if valueType != kTypeDeletion &&
+					this.userComparator.Compare(ikey.userKey, bytesToString(this.savedKey)) < 0 {
+					// We encountered a non-deleted value in entries for previous keys,
+					break
+				}
+			}
+			if parsed && ikey.sequence <= this.sequence && this.hasPrefix(ikey.userKey) {
+				valueType = ikey.vt
+				if valueType == kTypeDeletion {
+					this.savedKey = this.savedKey[:0]
+					this.clearSavedValue()
+				} else {
+					rawValue := this.iter.Value()
+					if cap(this.savedValue) > len(rawValue) + 1048576 {
+						this.savedValue = nil
+					}
+					saveKey(extractUserKey(this.iter.Key()), &this.savedKey)
+					this.savedValue = append(this.savedValue[:0], rawValue...)
+				}
+			}
+			this.iter.Prev()
+			if !this.iter.Valid() {
+				break
+			}
+		}
+	}
+
+	if valueType == kTypeDeletion {
+		// End
+		this.valid = false
+		this.savedKey = this.savedKey[:0]
+		this.clearSavedValue()
+		this.direction = kForward
+	} else {
+		this.valid = true
+	}
+}
+
+func (this *dbIter) Seek(target []byte) {
+	this.direction = kForward
+	this.clearSavedValue()
+	this.savedKey = this.savedKey[:0]
+
+	if this.beforeLowerBound(bytesToString(target)) {
+		target = this.lowerBound
+	}
+
+	var k string
+	ikey := makeParsedInternalKey(bytesToString(target), this.sequence, kValueTypeForSeek)
+	appendInternalKey(&k, &ikey)
+	this.iter.Seek(k)
+	if this.iter.Valid() {
+		this.findNextUserEntry(false, &this.savedKey)	// savedKey is used as temporary storage
+	} else {
+		this.valid = false
+	}
+}
+
+func (this *dbIter) SeekToFirst() {
+	if this.lowerBound != nil {
+		this.Seek(this.lowerBound)
+		return
+	}
+
+	this.direction = kForward
+	this.clearSavedValue()
+	this.iter.SeekToFirst()
+	if this.iter.Valid() {
+		this.findNextUserEntry(false, &this.savedKey)	// savedKey is used as temporary storage
+	} else {
+		this.valid = false
+	}
+}
+
+func (this *dbIter) SeekToLast() {
+	this.direction = kReverse
+	this.clearSavedValue()
+	if this.upperBound != nil {
+		// Position just before all entries for the upper bound and the
+		// keys after it.
+		var k string
+		ikey := makeParsedInternalKey(bytesToString(this.upperBound), kMaxSequenceNumber, kValueTypeForSeek)
+		appendInternalKey(&k, &ikey)
+		this.iter.Seek(k)
+		if this.iter.Valid() {
+			this.iter.Prev()
+		} else {
+			this.iter.SeekToLast()
+		}
+	} else {
+		this.iter.SeekToLast()
+	}
+	this.findPrevUserEntry()
+}
@@ -0,0 +1,147 @@
+package leveldb
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Open a DB ordered by "comparator" that holds a, ab, abc, abd, ac, b
+// and \xff\xff, with some of them in a table, some in the memtable, and
+// shadowed or deleted versions of others underneath.
+func openBoundsTestDB(t *testing.T, comparator Comparator) *dbImpl {
+	t.Helper()
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.Comparator = comparator
+	impl := openTestDB(t, "/bounds", options)
+
+	for _, k := range []string{"a", "abb", "abc", "ac", "\xff\xff"} {
+		mustPut(t, impl, k, "old " + k)
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	for _, k := range []string{"ab", "abc", "abd", "b"} {
+		mustPut(t, impl, k, k)
+	}
+	if err := impl.Delete([]byte("abb"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return impl
+}
+
+// Return the keys "iter" yields from SeekToFirst on, and those it
+// yields from SeekToLast back.
+func scanBoth(t *testing.T, iter Iterator) (forward, reverse []string) {
+	t.Helper()
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		forward = append(forward, string(iter.Key()))
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		reverse = append(reverse, string(iter.Key()))
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	return
+}
+
+func reversed(keys []string) []string {
+	var result []string
+	for i := len(keys) - 1; i >= 0; i-- {
+		result = append(result, keys[i])
+	}
+
+	return result
+}
+
+func TestIteratorBounds(t *testing.T) {
+	for _, c := range []struct {
+		comparator Comparator
+		options *ReadOptions
+		want []string	// In the order of the comparator
+	}{
+		{BytewiseComparator(), &ReadOptions{}, []string{"a", "ab", "abc", "abd", "ac", "b", "\xff\xff"}},
+		{BytewiseComparator(), &ReadOptions{LowerBound: []byte("ab"), UpperBound: []byte("b")},
+			[]string{"ab", "abc", "abd", "ac"}},
+		{BytewiseComparator(), &ReadOptions{LowerBound: []byte("abb")}, []string{"abc", "abd", "ac", "b", "\xff\xff"}},
+		{BytewiseComparator(), &ReadOptions{UpperBound: []byte("abc")}, []string{"a", "ab"}},
+		{BytewiseComparator(), &ReadOptions{Prefix: []byte("ab")}, []string{"ab", "abc", "abd"}},
+		{BytewiseComparator(), &ReadOptions{Prefix: []byte("ab"), LowerBound: []byte("abc")}, []string{"abc", "abd"}},
+		{BytewiseComparator(), &ReadOptions{Prefix: []byte("ab"), UpperBound: []byte("abd")}, []string{"ab", "abc"}},
+		{BytewiseComparator(), &ReadOptions{Prefix: []byte("\xff")}, []string{"\xff\xff"}},
+		{BytewiseComparator(), &ReadOptions{Prefix: []byte("abb")}, nil},
+		{ReverseBytewiseComparator(), &ReadOptions{}, []string{"\xff\xff", "b", "ac", "abd", "abc", "ab", "a"}},
+		{ReverseBytewiseComparator(), &ReadOptions{LowerBound: []byte("ac"), UpperBound: []byte("a")},
+			[]string{"ac", "abd", "abc", "ab"}},
+		{ReverseBytewiseComparator(), &ReadOptions{Prefix: []byte("ab")}, []string{"abd", "abc", "ab"}},
+		{ReverseBytewiseComparator(), &ReadOptions{Prefix: []byte("ab"), UpperBound: []byte("ab")}, []string{"abd", "abc"}},
+		{ReverseBytewiseComparator(), &ReadOptions{Prefix: []byte("a"), LowerBound: []byte("abc")},
+			[]string{"abc", "ab", "a"}},
+	} {
+		impl := openBoundsTestDB(t, c.comparator)
+		iter := impl.NewIterator(c.options)
+		forward, reverse := scanBoth(t, iter)
+		if !reflect.DeepEqual(forward, c.want) {
+			t.Errorf("%s %+v: forward %q, want %q", c.comparator.Name(), *c.options, forward, c.want)
+		}
+		if !reflect.DeepEqual(reverse, reversed(c.want)) {
+			t.Errorf("%s %+v: reverse %q, want %q", c.comparator.Name(), *c.options, reverse, reversed(c.want))
+		}
+		iter.Close()
+		impl.Close()
+	}
+}
+
+func TestIteratorBoundsSeekAndSwitchDirection(t *testing.T) {
+	for _, c := range []struct {
+		comparator Comparator
+		before, start, after []byte	// A key before the prefix, the first key in it, one after it
+	}{
+		{BytewiseComparator(), []byte("a"), []byte("ab"), []byte("b")},
+		{ReverseBytewiseComparator(), []byte("b"), []byte("abd"), []byte("a")},
+	} {
+		impl := openBoundsTestDB(t, c.comparator)
+		iter := impl.NewIterator(&ReadOptions{Prefix: []byte("ab")})
+
+		// A target before the keys with the prefix lands on the first.
+		iter.Seek(c.before)
+		if !iter.Valid() || string(iter.Key()) != string(c.start) {
+			t.Fatalf("%s: Seek(%q) did not land on %q", c.comparator.Name(), c.before, c.start)
+		}
+		// Nothing with the prefix precedes it.
+		iter.Prev()
+		if iter.Valid() {
+			t.Errorf("%s: Prev from %q yielded %q", c.comparator.Name(), c.start, iter.Key())
+		}
+
+		// Step forward, back, and forward again over the middle key.
+		iter.Seek(c.start)
+		iter.Next()
+		middle := string(iter.Key())
+		iter.Next()
+		iter.Prev()
+		if !iter.Valid() || string(iter.Key()) != middle {
+			t.Errorf("%s: Next then Prev did not return to %q", c.comparator.Name(), middle)
+		}
+		iter.Prev()
+		if !iter.Valid() || string(iter.Key()) != string(c.start) {
+			t.Errorf("%s: Prev did not return to %q", c.comparator.Name(), c.start)
+		}
+		iter.Next()
+		if !iter.Valid() || string(iter.Key()) != middle {
+			t.Errorf("%s: Prev then Next did not return to %q", c.comparator.Name(), middle)
+		}
+
+		// Nothing with the prefix follows a target after it.
+		iter.Seek(c.after)
+		if iter.Valid() {
+			t.Errorf("%s: Seek(%q) yielded %q", c.comparator.Name(), c.after, iter.Key())
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		impl.Close()
+	}
+}
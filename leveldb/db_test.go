@@ -0,0 +1,1244 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// An Env that counts the background work and the sleeps it is asked
+// for, and can hold background work back until released.
+type testEnv struct {
+	Env
+
+	mutex sync.Mutex
+	schedules int
+	sleeps int
+	holdBackground bool
+	held []func()
+	newWritableFileHook func(fname string)	// If set, called before a file is created
+}
+
+func newTestEnv(base Env) *testEnv {
+	return &testEnv{Env: base}
+}
+
+func (this *testEnv) Schedule(f func()) {
+	this.mutex.Lock()
+	this.schedules++
+	if this.holdBackground {
+		this.held = append(this.held, f)
+		f = nil
+	}
+	this.mutex.Unlock()
+
+	if f != nil {
+		this.Env.Schedule(f)
+	}
+}
+
+func (this *testEnv) NewWritableFile(fname string, result *WritableFile) Status {
+	this.mutex.Lock()
+	hook := this.newWritableFileHook
+	this.mutex.Unlock()
+
+	if hook != nil {
+		hook(fname)
+	}
+	return this.Env.NewWritableFile(fname, result)
+}
+
+// Call "hook" before each file is created, or stop if "hook" is nil.
+func (this *testEnv) SetNewWritableFileHook(hook func(fname string)) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.newWritableFileHook = hook
+}
+
+func (this *testEnv) SleepForMicroseconds(micros uint32) {
+	this.mutex.Lock()
+	this.sleeps++
+	this.mutex.Unlock()
+
+	this.Env.SleepForMicroseconds(micros)
+}
+
+func (this *testEnv) Schedules() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.schedules
+}
+
+func (this *testEnv) Sleeps() int {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.sleeps
+}
+
+// Keep background work from running until ReleaseBackgroundWork.
+func (this *testEnv) HoldBackgroundWork() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.holdBackground = true
+}
+
+func (this *testEnv) ReleaseBackgroundWork() {
+	this.mutex.Lock()
+	held := this.held
+	this.held = nil
+	this.holdBackground = false
+	this.mutex.Unlock()
+
+	for _, f := range held {
+		this.Env.Schedule(f)
+	}
+}
+
+// A Logger that keeps every message.
+type testLogger struct {
+	mutex sync.Mutex
+	lines []string
+}
+
+func (this *testLogger) Logv(format string, a ...interface{}) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.lines = append(this.lines, fmt.Sprintf(format, a...))
+}
+
+func (this *testLogger) Contains(substr string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	for _, line := range this.lines {
+		if strings.Contains(line, substr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newTestOptions(env Env) *Options {
+	options := NewOptions()
+	options.Env = env
+	options.CreateIfMissing = true
+	return options
+}
+
+func openTestDB(t *testing.T, name string, options *Options) *dbImpl {
+	t.Helper()
+	db, err := Open(name, options)
+	if err != nil {
+		t.Fatalf("Open(%s): %v", name, err)
+	}
+
+	return db.(*dbImpl)
+}
+
+func mustPut(t *testing.T, db DB, key string, value string) {
+	t.Helper()
+	if err := db.Put([]byte(key), []byte(value), nil); err != nil {
+		t.Fatalf("Put(%s): %v", key, err)
+	}
+}
+
+// Return the value of "key", or "NOT_FOUND".
+func getValue(t *testing.T, db DB, key string, readOptions *ReadOptions) string {
+	t.Helper()
+	value, err := db.Get([]byte(key), readOptions)
+	if errors.Is(err, ErrNotFound) {
+		return "NOT_FOUND"
+	} else if err != nil {
+		t.Fatalf("Get(%s): %v", key, err)
+	}
+
+	return string(value)
+}
+
+func numTableFilesAtLevel(t *testing.T, db DB, level int) int {
+	t.Helper()
+	value, ok := db.GetProperty("leveldb.num-files-at-level" + strconv.Itoa(level))
+	if !ok {
+		t.Fatalf("no file count for level %d", level)
+	}
+	n, _ := strconv.Atoi(value)
+
+	return n
+}
+
+// Wait up to "timeout" for "cond", which is evaluated with the DB
+// mutex held.
+func waitFor(impl *dbImpl, timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		impl.mutex.Lock()
+		ok := cond()
+		impl.mutex.Unlock()
+		if ok {
+			return true
+		} else if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestWriteThrottling(t *testing.T) {
+	env := newTestEnv(NewMemEnv(DefaultEnv()))
+	logger := &testLogger{}
+	options := newTestOptions(env)
+	options.InfoLog = logger
+	options.WriteBufferSize = 64 << 10
+	impl := openTestDB(t, "/throttle", options)
+	defer impl.Close()
+
+	// Pretend level-0 is full, and keep it that way.
+	env.HoldBackgroundWork()
+	impl.mutex.Lock()
+	level0 := impl.versions.current.files[0]
+	for i := 0; i < kL0_SlowdownWritesTrigger; i++ {
+		f := newFileMetaData()
+		f.number = uint64(1000 + i)
+		impl.versions.current.files[0] = append(impl.versions.current.files[0], f)
+	}
+	impl.mutex.Unlock()
+
+	sleeps := env.Sleeps()
+	mustPut(t, impl, "slow", "v")
+	if got := env.Sleeps() - sleeps; got != 1 {
+		t.Fatalf("write at the slowdown trigger slept %d times, want 1", got)
+	}
+
+	impl.mutex.Lock()
+	for i := kL0_SlowdownWritesTrigger; i < kL0_StopWritesTrigger; i++ {
+		f := newFileMetaData()
+		f.number = uint64(1000 + i)
+		impl.versions.current.files[0] = append(impl.versions.current.files[0], f)
+	}
+	impl.mutex.Unlock()
+
+	// Fill the memtable: the write that needs a new one must stop.
+	done := make(chan error, 1)
+	go func() {
+		value := strings.Repeat("x", 1024)
+		for i := 0; i < 2 * int(options.WriteBufferSize) / len(value); i++ {
+			if err := impl.Put([]byte(fmt.Sprintf("key%06d", i)), []byte(value), nil); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !logger.Contains("Too many L0 files; waiting...") {
+		if time.Now().After(deadline) {
+			t.Fatal("writes did not stop at the stop trigger")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("writes finished at the stop trigger: %v", err)
+	default:
+	}
+
+	// Writes resume once level-0 drains.
+	impl.mutex.Lock()
+	impl.versions.current.files[0] = level0
+	impl.bgCV.Broadcast()
+	impl.mutex.Unlock()
+	env.ReleaseBackgroundWork()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("writes did not resume")
+	}
+}
+
+func TestSeekTriggeredCompaction(t *testing.T) {
+	impl := openTestDB(t, "/seek", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	// A file at level-2, and one at level-1 that overlaps it.
+	mustPut(t, impl, "a", "va")
+	mustPut(t, impl, "z", "vz")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	mustPut(t, impl, "b", "vb")
+	mustPut(t, impl, "y", "vy")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	if numTableFilesAtLevel(t, impl, 1) != 1 || numTableFilesAtLevel(t, impl, 2) != 1 {
+		t.Fatalf("unexpected layout: %d files at level-1, %d at level-2",
+			numTableFilesAtLevel(t, impl, 1), numTableFilesAtLevel(t, impl, 2))
+	}
+
+	// Every miss for "m" reads the level-1 file in vain.  A small file
+	// allows 100 such seeks.
+	for i := 0; i < 99; i++ {
+		if got := getValue(t, impl, "m", nil); got != "NOT_FOUND" {
+			t.Fatalf("Get(m) = %s", got)
+		}
+	}
+	impl.mutex.Lock()
+	fileToCompact := impl.versions.current.fileToCompact
+	impl.mutex.Unlock()
+	if fileToCompact != nil {
+		t.Fatal("compaction triggered before the seeks ran out")
+	}
+
+	getValue(t, impl, "m", nil)
+	if !waitFor(impl, 5 * time.Second, func() bool {
+		return impl.versions.NumLevelFiles(1) == 0 && !impl.bgCompactionScheduled
+	}) {
+		t.Fatal("seeks did not trigger a compaction of level-1")
+	}
+
+	for _, key := range []string{"a", "b", "y", "z"} {
+		if got := getValue(t, impl, key, nil); got != "v" + key {
+			t.Errorf("Get(%s) = %s", key, got)
+		}
+	}
+}
+
+// Make the flush of the memtable fail, and return the status.
+func failMemTableFlush(t *testing.T, impl *dbImpl, fault *FaultInjectionEnv) Status {
+	t.Helper()
+	mustPut(t, impl, "key", "value")
+	fault.SetFailAfter(FaultSync, 1)
+	s := impl.compactMemTableAndWait()
+	if s.OK() {
+		t.Fatal("flush succeeded despite the sync failure")
+	}
+
+	return s
+}
+
+func TestBackgroundErrorStopsCompactions(t *testing.T) {
+	fault := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	env := newTestEnv(fault)
+	impl := openTestDB(t, "/bgerror", newTestOptions(env))
+	defer impl.Close()
+
+	failMemTableFlush(t, impl, fault)
+	if err := impl.Put([]byte("k"), []byte("v"), nil); err == nil {
+		t.Fatal("write succeeded after a background error")
+	}
+
+	// The memtable that failed to flush is still there, but after the
+	// backoff nothing is scheduled again.
+	if !waitFor(impl, 5 * time.Second, func() bool { return !impl.bgCompactionScheduled }) {
+		t.Fatal("background work is still scheduled after the backoff")
+	}
+	schedules := env.Schedules()
+	impl.mutex.Lock()
+	impl.MaybeScheduleCompaction()
+	scheduled := impl.bgCompactionScheduled
+	impl.mutex.Unlock()
+	if scheduled || env.Schedules() != schedules {
+		t.Fatal("compaction scheduled after a background error")
+	}
+}
+
+func TestCloseDuringBackgroundErrorBackoff(t *testing.T) {
+	fault := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	impl := openTestDB(t, "/backoff", newTestOptions(fault))
+
+	// The background thread now backs off for a second at least.
+	failMemTableFlush(t, impl, fault)
+
+	start := time.Now()
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > kBackgroundErrorBackoffMicros * time.Microsecond / 2 {
+		t.Fatalf("Close waited %v for the backoff", elapsed)
+	}
+}
+
+func TestFailedCompactionDeletesOutputs(t *testing.T) {
+	fault := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	impl := openTestDB(t, "/failed", newTestOptions(fault))
+	defer impl.Close()
+
+	mustPut(t, impl, "a", "va")
+	mustPut(t, impl, "z", "vz")
+	impl.compactMemTableAndWait()
+	mustPut(t, impl, "b", "vb")
+	mustPut(t, impl, "y", "vy")
+	impl.compactMemTableAndWait()
+
+	// Merging level-1 into level-2 fails when syncing the output.
+	fault.SetFailAfter(FaultSync, 1)
+	impl.compactRangeLevel(1, nil, nil)
+	impl.mutex.Lock()
+	bgError := impl.bgError
+	live := make(map[uint64]bool)
+	impl.versions.AddLiveFiles(live)
+	impl.mutex.Unlock()
+	if bgError == nil {
+		t.Fatal("compaction succeeded despite the sync failure")
+	}
+
+	filenames, s := fault.GetChildren("/failed")
+	if !s.OK() {
+		t.Fatal(s)
+	}
+	var number uint64
+	var fileType FileType
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) && fileType == kTableFile && !live[number] {
+			t.Errorf("output %s of the failed compaction was left behind", filename)
+		}
+	}
+}
+
+func TestSnapshotsAfterClose(t *testing.T) {
+	impl := openTestDB(t, "/closed", newTestOptions(NewMemEnv(DefaultEnv())))
+	mustPut(t, impl, "k", "v")
+	snapshot := impl.GetSnapshot()
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := impl.GetSnapshot(); got != nil {
+		t.Fatal("GetSnapshot returned a snapshot after Close")
+	}
+	impl.ReleaseSnapshot(nil)
+	impl.ReleaseSnapshot(snapshot)
+
+	if err := impl.Put([]byte("k"), []byte("v2"), nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("Put after Close: %v", err)
+	}
+	if err := impl.Close(); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("second Close: %v", err)
+	}
+}
+
+// A FilterPolicy whose filters list their keys exactly.
+type exactFilterPolicy struct {
+	probes int32
+}
+
+func (this *exactFilterPolicy) Name() string {
+	return "test.ExactFilter"
+}
+
+func (this *exactFilterPolicy) CreateFilter(keys []string, dst *string) {
+	for _, key := range keys {
+		*dst += string(rune(len(key))) + key
+	}
+}
+
+func (this *exactFilterPolicy) KeyMayMatch(key string, filter string) bool {
+	atomic.AddInt32(&this.probes, 1)
+	for len(filter) > 0 {
+		n := int(filter[0])
+		if filter[1 : 1 + n] == key {
+			return true
+		}
+		filter = filter[1 + n:]
+	}
+
+	return false
+}
+
+// The filters of the tables are built from user keys and probed with
+// the user key of each lookup.
+func TestFilterPolicy(t *testing.T) {
+	policy := &exactFilterPolicy{}
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.FilterPolicy = policy
+	impl := openTestDB(t, "/filter", options)
+	defer impl.Close()
+
+	for i := 0; i < 100; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%03d", i * 2), "v")
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+
+	for i := 0; i < 100; i++ {
+		if got := getValue(t, impl, fmt.Sprintf("key%03d", i * 2), nil); got != "v" {
+			t.Fatalf("key%03d = %q", i * 2, got)
+		}
+		if got := getValue(t, impl, fmt.Sprintf("key%03d", i * 2 + 1), nil); got != "NOT_FOUND" {
+			t.Fatalf("key%03d = %q", i * 2 + 1, got)
+		}
+	}
+	if atomic.LoadInt32(&policy.probes) == 0 {
+		t.Fatal("the filters were not consulted")
+	}
+}
+
+// Return the versions of "userKey" the DB holds, newest first, as in
+// "[ v2, DEL, v1 ]".
+func entriesFor(t *testing.T, impl *dbImpl, userKey string) string {
+	t.Helper()
+	var latestSnapshot sequenceNumber
+	iter := impl.NewInternalIterator(&ReadOptions{}, &latestSnapshot)
+	defer iter.Close()
+
+	var entries []string
+	target := makeInternalKey(userKey, kMaxSequenceNumber, kValueTypeForSeek)
+	for iter.Seek(target.encode()); iter.Valid(); iter.Next() {
+		var ikey parsedInternalKey
+		if !parseInternalKey(iter.Key(), &ikey) {
+			entries = append(entries, "CORRUPTED")
+			continue
+		}
+		if ikey.userKey != userKey {
+			break
+		}
+		if ikey.vt == kTypeDeletion {
+			entries = append(entries, "DEL")
+		} else {
+			entries = append(entries, iter.Value())
+		}
+	}
+	if !iter.Status().OK() {
+		t.Fatal(iter.Status())
+	}
+
+	return "[ " + strings.Join(entries, ", ") + " ]"
+}
+
+// Return the files of "level" in the current version.
+func filesAtLevel(impl *dbImpl, level int) []*FileMetaData {
+	impl.mutex.Lock()
+	defer impl.mutex.Unlock()
+
+	return append([]*FileMetaData(nil), impl.versions.current.files[level]...)
+}
+
+func TestCompactionDropsShadowedAndDeletedEntries(t *testing.T) {
+	impl := openTestDB(t, "/drop", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	mustPut(t, impl, "a", "v1")
+	mustPut(t, impl, "b", "v1")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	mustPut(t, impl, "a", "v2")
+	if err := impl.Delete([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	if got := entriesFor(t, impl, "a"); got != "[ v2, v1 ]" {
+		t.Fatalf("a before compaction: %s", got)
+	}
+	if got := entriesFor(t, impl, "b"); got != "[ DEL, v1 ]" {
+		t.Fatalf("b before compaction: %s", got)
+	}
+
+	// Compact every level holding files into the next one.  The older
+	// version of "a" is shadowed, and nothing below the output holds
+	// "b", so its deletion marker is dropped with the value it hides.
+	for level := 0; level < kNumLevels - 1; level++ {
+		if len(filesAtLevel(impl, level)) > 0 {
+			impl.compactRangeLevel(level, nil, nil)
+		}
+	}
+	if got := entriesFor(t, impl, "a"); got != "[ v2 ]" {
+		t.Errorf("a after compaction: %s", got)
+	}
+	if got := entriesFor(t, impl, "b"); got != "[  ]" {
+		t.Errorf("b after compaction: %s", got)
+	}
+	if got := getValue(t, impl, "a", nil); got != "v2" {
+		t.Errorf("a = %q", got)
+	}
+	if got := getValue(t, impl, "b", nil); got != "NOT_FOUND" {
+		t.Errorf("b = %q", got)
+	}
+}
+
+func TestCompactionKeepsVersionsVisibleToSnapshots(t *testing.T) {
+	impl := openTestDB(t, "/snapshots", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	mustPut(t, impl, "a", "v1")
+	mustPut(t, impl, "b", "v1")
+	snapshot := impl.GetSnapshot()
+	mustPut(t, impl, "a", "v2")
+	if err := impl.Delete([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	level := 0
+	for len(filesAtLevel(impl, level)) == 0 {
+		level++
+	}
+
+	impl.compactRangeLevel(level, nil, nil)
+	level++
+	if got := entriesFor(t, impl, "a"); got != "[ v2, v1 ]" {
+		t.Errorf("a while the snapshot is held: %s", got)
+	}
+	if got := entriesFor(t, impl, "b"); got != "[ DEL, v1 ]" {
+		t.Errorf("b while the snapshot is held: %s", got)
+	}
+	ro := &ReadOptions{Snapshot: snapshot}
+	if got := getValue(t, impl, "a", ro); got != "v1" {
+		t.Errorf("a at the snapshot = %q", got)
+	}
+	if got := getValue(t, impl, "b", ro); got != "v1" {
+		t.Errorf("b at the snapshot = %q", got)
+	}
+
+	// Once the snapshot is released, the old versions go.
+	impl.ReleaseSnapshot(snapshot)
+	impl.compactRangeLevel(level, nil, nil)
+	if got := entriesFor(t, impl, "a"); got != "[ v2 ]" {
+		t.Errorf("a after the snapshot was released: %s", got)
+	}
+	if got := entriesFor(t, impl, "b"); got != "[  ]" {
+		t.Errorf("b after the snapshot was released: %s", got)
+	}
+}
+
+func TestCompactionSplitsOutputAtMaxFileSize(t *testing.T) {
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.Compression = NoCompression
+	options.WriteBufferSize = 4 * kTargetFileSize
+	impl := openTestDB(t, "/split", options)
+	defer impl.Close()
+
+	// About three times the target file size, flushed to one file.
+	value := strings.Repeat("v", 10000)
+	n := 3 * kTargetFileSize / len(value)
+	for i := 0; i < n; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%06d", i), value)
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	level := 0
+	for len(filesAtLevel(impl, level)) == 0 {
+		level++
+	}
+	if got := len(filesAtLevel(impl, level)); got != 1 {
+		t.Fatalf("memtable flushed to %d files", got)
+	}
+
+	impl.compactRangeLevel(level, nil, nil)
+	files := filesAtLevel(impl, level + 1)
+	if len(files) < 3 {
+		t.Fatalf("compaction output %d files", len(files))
+	}
+	for _, f := range files {
+		if f.fileSize > MaxFileSizeForLevel(level + 1) + uint64(2 * len(value)) {
+			t.Errorf("output file %d has %d bytes", f.number, f.fileSize)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if got := getValue(t, impl, fmt.Sprintf("key%06d", i), nil); got != value {
+			t.Fatalf("key%06d = %q", i, got)
+		}
+	}
+}
+
+func TestCompactionSplitsOutputAtGrandparentOverlap(t *testing.T) {
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.Compression = NoCompression
+	impl := openTestDB(t, "/grandparents", options)
+	defer impl.Close()
+
+	// Fill level 2 with more than kMaxGrandParentOverlapBytes.
+	value := strings.Repeat("v", 100000)
+	n := kMaxGrandParentOverlapBytes / len(value) * 6 / 5
+	for i := 0; i < n; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%06d", i), value)
+	}
+	impl.compactMemTableAndWait()
+	impl.compactRangeLevel(0, nil, nil)
+	impl.compactRangeLevel(1, nil, nil)
+	if len(filesAtLevel(impl, 0)) != 0 || len(filesAtLevel(impl, 1)) != 0 {
+		t.Fatal("data left above level 2")
+	}
+
+	// Small entries spanning all of level 2, which make a single small
+	// file unless the overlap with level 2 splits it.
+	for i := 0; i < n; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%06d", i), "small")
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	if got := len(filesAtLevel(impl, 0)); got != 1 {
+		t.Fatalf("memtable flushed to %d files in level 0", got)
+	}
+	impl.compactRangeLevel(0, nil, nil)
+
+	files := filesAtLevel(impl, 1)
+	if len(files) < 2 {
+		t.Fatalf("level 1 holds %d files", len(files))
+	}
+	for _, f := range files {
+		var overlap uint64
+		for _, g := range filesAtLevel(impl, 2) {
+			if g.largest.userKey() >= f.smallest.userKey() && g.smallest.userKey() <= f.largest.userKey() {
+				overlap += g.fileSize
+			}
+		}
+		// The grandparents a file starts and ends in are not counted
+		// against the limit, and the last one counted may cross it.
+		if overlap > kMaxGrandParentOverlapBytes + 3 * (MaxFileSizeForLevel(2) + uint64(len(value))) {
+			t.Errorf("file %d overlaps %d bytes of level 2", f.number, overlap)
+		}
+	}
+	for i := 0; i < n; i++ {
+		if got := getValue(t, impl, fmt.Sprintf("key%06d", i), nil); got != "small" {
+			t.Fatalf("key%06d = %q", i, got)
+		}
+	}
+}
+
+// Return the number of files at each level, as in "1,0,2", leaving
+// out the empty levels at the bottom.
+func filesPerLevel(t *testing.T, db DB) string {
+	t.Helper()
+	var result string
+	lastNonEmpty := 0
+	for level := 0; level < kNumLevels; level++ {
+		n := numTableFilesAtLevel(t, db, level)
+		if level > 0 {
+			result += ","
+		}
+		result += strconv.Itoa(n)
+		if n > 0 {
+			lastNonEmpty = len(result)
+		}
+	}
+
+	return result[:lastNonEmpty]
+}
+
+func TestCompactRange(t *testing.T) {
+	impl := openTestDB(t, "/compactrange", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	flush := func() {
+		t.Helper()
+		if s := impl.compactMemTableAndWait(); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+	want := make(map[string]string)
+	put := func(key, value string) {
+		t.Helper()
+		mustPut(t, impl, key, value)
+		want[key] = value
+	}
+	check := func(when string) {
+		t.Helper()
+		for c := 'a'; c <= 'z'; c++ {
+			key := string(c)
+			value, ok := want[key]
+			if !ok {
+				value = "NOT_FOUND"
+			}
+			if got := getValue(t, impl, key, nil); got != value {
+				t.Errorf("%s: %s = %q, want %q", when, key, got, value)
+			}
+		}
+	}
+
+	// Files holding a-m and n-z at level 2, with two files at level 1
+	// above them: one overwriting a-c, and one overwriting x-y and
+	// deleting z.
+	for c := 'a'; c <= 'm'; c++ {
+		put(string(c), "v1")
+	}
+	flush()
+	for c := 'n'; c <= 'z'; c++ {
+		put(string(c), "v1")
+	}
+	flush()
+	for _, key := range []string{"a", "b", "c"} {
+		put(key, "v2")
+	}
+	flush()
+	put("x", "v2")
+	put("y", "v2")
+	if err := impl.Delete([]byte("z"), nil); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "z")
+	flush()
+	if got := filesPerLevel(t, impl); got != "0,2,2" {
+		t.Fatalf("files per level: %s", got)
+	}
+	check("before compaction")
+
+	// Only the level-1 file overlapping [b, c] is compacted, together
+	// with the level-2 file under it.
+	impl.CompactRange([]byte("b"), []byte("c"))
+	if got := filesPerLevel(t, impl); got != "0,1,2" {
+		t.Errorf("files per level after compacting [b, c]: %s", got)
+	}
+	if got := entriesFor(t, impl, "a"); got != "[ v2 ]" {
+		t.Errorf("a after compacting [b, c]: %s", got)
+	}
+	if got := entriesFor(t, impl, "z"); got != "[ DEL, v1 ]" {
+		t.Errorf("z after compacting [b, c]: %s", got)
+	}
+	check("after compacting [b, c]")
+
+	// A range overlapping nothing changes nothing.
+	impl.CompactRange([]byte("zz"), nil)
+	if got := filesPerLevel(t, impl); got != "0,1,2" {
+		t.Errorf("files per level after compacting [zz, ...): %s", got)
+	}
+
+	// Compacting everything merges all the files, which are small, into
+	// one, and leaves no deletion markers behind.
+	put("m", "v3")
+	impl.CompactRange(nil, nil)
+	if got := filesPerLevel(t, impl); got != "0,0,1" {
+		t.Errorf("files per level after compacting everything: %s", got)
+	}
+	if got := entriesFor(t, impl, "z"); got != "[  ]" {
+		t.Errorf("z after compacting everything: %s", got)
+	}
+	if got := entriesFor(t, impl, "m"); got != "[ v3 ]" {
+		t.Errorf("m after compacting everything: %s", got)
+	}
+	check("after compacting everything")
+}
+
+// A memtable is flushed to the deepest level up to kMaxMemCompactLevel
+// whose next level it does not overlap, and a compaction that would only
+// copy a file into an empty range of the next level moves it instead.
+func TestMemTableOutputLevelAndTrivialMove(t *testing.T) {
+	impl := openTestDB(t, "/trivial", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	flush := func(keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			mustPut(t, impl, key, "v" + key)
+		}
+		if s := impl.compactMemTableAndWait(); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+
+	// Nothing overlaps the first file, so it goes to kMaxMemCompactLevel.
+	flush("a", "z")
+	if got := filesPerLevel(t, impl); got != "0,0,1" {
+		t.Fatalf("files per level after the first flush: %s", got)
+	}
+	// The next one stops above the file it overlaps.
+	flush("b", "y")
+	if got := filesPerLevel(t, impl); got != "0,1,1" {
+		t.Fatalf("files per level after the second flush: %s", got)
+	}
+	flush("c", "x")
+	if got := filesPerLevel(t, impl); got != "1,1,1" {
+		t.Fatalf("files per level after the third flush: %s", got)
+	}
+
+	// Clear level 1, so that nothing is below the level-0 file there.
+	impl.compactRangeLevel(1, nil, nil)
+	if got := filesPerLevel(t, impl); got != "1,0,1" {
+		t.Fatalf("files per level after compacting level 1: %s", got)
+	}
+	moved := filesAtLevel(impl, 0)[0].number
+
+	// Every miss for "m" reads the level-0 file in vain, until the
+	// seeks it is allowed run out and it is compacted.
+	for i := 0; i < 100; i++ {
+		getValue(t, impl, "m", nil)
+	}
+	if !waitFor(impl, 5 * time.Second, func() bool {
+		return impl.versions.NumLevelFiles(0) == 0 && !impl.bgCompactionScheduled
+	}) {
+		t.Fatal("seeks did not trigger a compaction of level 0")
+	}
+	if got := filesPerLevel(t, impl); got != "0,1,1" {
+		t.Fatalf("files per level after the move: %s", got)
+	}
+	if got := filesAtLevel(impl, 1)[0].number; got != moved {
+		t.Errorf("level 1 holds file %d, not the moved file %d", got, moved)
+	}
+
+	for _, key := range []string{"a", "b", "c", "x", "y", "z"} {
+		if got := getValue(t, impl, key, nil); got != "v" + key {
+			t.Errorf("Get(%s) = %s", key, got)
+		}
+	}
+}
+
+// An iterator merges the memtable, the immutable memtable and the files
+// of every level, newest first.
+func TestIteratorMergesAllSources(t *testing.T) {
+	env := newTestEnv(NewMemEnv(DefaultEnv()))
+	options := newTestOptions(env)
+	options.WriteBufferSize = 100000
+	impl := openTestDB(t, "/merge", options)
+	defer impl.Close()
+
+	want := make(map[string]string)
+	put := func(key, value string) {
+		t.Helper()
+		mustPut(t, impl, key, value)
+		want[key] = value
+	}
+	del := func(key string) {
+		t.Helper()
+		if err := impl.Delete([]byte(key), nil); err != nil {
+			t.Fatal(err)
+		}
+		delete(want, key)
+	}
+	flush := func() {
+		t.Helper()
+		if s := impl.compactMemTableAndWait(); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+
+	// A file at each of levels 2, 1 and 0, each shadowing some of the
+	// entries below.
+	put("a", "l2-a")
+	put("d", "l2-d")
+	put("z", "l2-z")
+	flush()
+	put("b", "l1-b")
+	put("y", "l1-y")
+	put("z", "l1-z")
+	flush()
+	put("c", "l0-c")
+	put("x", "l0-x")
+	del("d")
+	flush()
+	if got := filesPerLevel(t, impl); got != "1,1,1" {
+		t.Fatalf("files per level: %s", got)
+	}
+
+	// An immutable memtable that is not flushed, then the memtable.
+	env.HoldBackgroundWork()
+	defer env.ReleaseBackgroundWork()
+	put("b", "imm-b")
+	del("y")
+	value := strings.Repeat("v", 1000)
+	for i := 0; ; i++ {
+		impl.mutex.Lock()
+		switched := impl.imm != nil
+		impl.mutex.Unlock()
+		if switched {
+			break
+		}
+		put(fmt.Sprintf("m%05d", i), value)
+	}
+	put("c", "mem-c")
+	del("x")
+	put("n", "mem-n")
+
+	var keys []string
+	for key := range want {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	iter := impl.NewIterator(nil)
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if i >= len(keys) || string(iter.Key()) != keys[i] || string(iter.Value()) != want[keys[i]] {
+			t.Fatalf("entry %d is %q=%.10q", i, iter.Key(), iter.Value())
+		}
+		i++
+	}
+	if i != len(keys) {
+		t.Fatalf("iterated over %d of %d entries", i, len(keys))
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i--
+		if i < 0 || string(iter.Key()) != keys[i] || string(iter.Value()) != want[keys[i]] {
+			t.Fatalf("entry %d from the end is %q=%.10q", len(keys) - i, iter.Key(), iter.Value())
+		}
+	}
+	if i != 0 {
+		t.Fatalf("iterated back over %d of %d entries", len(keys) - i, len(keys))
+	}
+
+	// Change direction next to the entries that are shadowed or deleted.
+	at := func() string {
+		if !iter.Valid() {
+			return "(invalid)"
+		}
+		return string(iter.Key())
+	}
+	for _, c := range []struct {
+		target, seek, prev, next string
+	}{
+		{"b", "b", "a", "c"},
+		{"d", "m00000", "c", "m00001"},
+		{"x", "z", "n", "(invalid)"},
+	} {
+		iter.Seek([]byte(c.target))
+		if got := at(); got != c.seek {
+			t.Errorf("Seek(%s) yielded %s, want %s", c.target, got, c.seek)
+			continue
+		}
+		iter.Prev()
+		if got := at(); got != c.prev {
+			t.Errorf("Seek(%s), Prev yielded %s, want %s", c.target, got, c.prev)
+			continue
+		}
+		iter.Next()
+		iter.Next()
+		if got := at(); got != c.next {
+			t.Errorf("Seek(%s), Prev, Next, Next yielded %s, want %s", c.target, got, c.next)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetApproximateSizes(t *testing.T) {
+	options := newTestOptions(NewMemEnv(DefaultEnv()))
+	options.Compression = NoCompression
+	options.WriteBufferSize = 100 << 20	// Keep everything in the memtable
+	impl := openTestDB(t, "/sizes", options)
+	defer impl.Close()
+
+	key := func(i int) string {
+		return fmt.Sprintf("key%06d", i)
+	}
+	size := func(start, limit string) uint64 {
+		return impl.GetApproximateSizes([]Range{{Start: []byte(start), Limit: []byte(limit)}})[0]
+	}
+	between := func(got uint64, low, high uint64, what string) {
+		t.Helper()
+		if got < low || got > high {
+			t.Errorf("%s: %d not in [%d, %d]", what, got, low, high)
+		}
+	}
+
+	// Values that do not compress, each larger than a block.
+	const n, valueSize = 80, 100000
+	const low, high = valueSize, valueSize + 5000	// Bounds on the size of an entry
+	rnd := rand.New(rand.NewSource(301))
+	for i := 0; i < n; i++ {
+		value := make([]byte, valueSize)
+		rnd.Read(value)
+		mustPut(t, impl, key(i), string(value))
+	}
+
+	// The memtable is not counted.
+	if got := size("", "xyz"); got != 0 {
+		t.Fatalf("size with everything in the memtable: %d", got)
+	}
+
+	impl.CompactRange(nil, nil)
+	for i := 0; i < n; i += 10 {
+		between(size("", key(i)), low * uint64(i), high * uint64(i), "[\"\", " + key(i) + ")")
+		between(size("", key(i) + ".suffix"), low * uint64(i + 1), high * uint64(i + 1),
+			"[\"\", " + key(i) + ".suffix)")
+		between(size(key(i), key(i + 10)), low * 10, high * 10, "[" + key(i) + ", " + key(i + 10) + ")")
+	}
+	between(size("", "xyz"), low * n, high * n, "[\"\", xyz)")
+
+	// An empty range and one past the last key hold nothing.
+	if got := size(key(10), key(10)); got != 0 {
+		t.Errorf("empty range: %d", got)
+	}
+	if got := size(key(n), "xyz"); got >= low {
+		t.Errorf("range past the last key: %d", got)
+	}
+
+	// Several ranges at once.
+	sizes := impl.GetApproximateSizes([]Range{
+		{Start: []byte(key(0)), Limit: []byte(key(40))},
+		{Start: []byte(key(40)), Limit: []byte(key(n))},
+	})
+	between(sizes[0], low * 40, high * 40, "first of two ranges")
+	between(sizes[1], low * (n - 40), high * (n - 40), "second of two ranges")
+}
+
+func TestGetProperty(t *testing.T) {
+	impl := openTestDB(t, "/properties", newTestOptions(NewMemEnv(DefaultEnv())))
+
+	property := func(name string) string {
+		t.Helper()
+		value, ok := impl.GetProperty(name)
+		if !ok {
+			t.Fatalf("no property %s", name)
+		}
+		return value
+	}
+	memoryUsage := func() uint64 {
+		t.Helper()
+		n, err := strconv.ParseUint(property("leveldb.approximate-memory-usage"), 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	empty := memoryUsage()
+	mustPut(t, impl, "a", strings.Repeat("v", 10000))
+	if got := memoryUsage(); got < empty + 10000 {
+		t.Errorf("approximate-memory-usage grew from %d to %d after a 10000-byte write", empty, got)
+	}
+
+	// One file at level 2, and one at level 1 above it.
+	mustPut(t, impl, "z", "v")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	mustPut(t, impl, "m", "v")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	for level, want := range []string{"0", "1", "1", "0", "0", "0", "0"} {
+		if got := property(fmt.Sprintf("leveldb.num-files-at-level%d", level)); got != want {
+			t.Errorf("num-files-at-level%d = %s, want %s", level, got, want)
+		}
+	}
+
+	// Every level is listed, with its files.
+	sstables := property("leveldb.sstables")
+	for level := 0; level < kNumLevels; level++ {
+		if !strings.Contains(sstables, fmt.Sprintf("--- level %d ---\n", level)) {
+			t.Errorf("sstables does not list level %d:\n%s", level, sstables)
+		}
+	}
+	for level := 1; level <= 2; level++ {
+		f := filesAtLevel(impl, level)[0]
+		line := fmt.Sprintf("--- level %d ---\n %d:%d[", level, f.number, f.fileSize)
+		if !strings.Contains(sstables, line) {
+			t.Errorf("sstables does not list file %d at level %d:\n%s", f.number, level, sstables)
+		}
+	}
+
+	// Only the levels with files or compactions have a line.
+	impl.compactRangeLevel(1, nil, nil)
+	stats := property("leveldb.stats")
+	lines := strings.Split(strings.TrimSuffix(stats, "\n"), "\n")
+	if len(lines) != 5 || !strings.Contains(lines[1], "Level  Files Size(MB)") {
+		t.Fatalf("stats:\n%s", stats)
+	}
+	for i, level := range []string{"1", "2"} {
+		fields := strings.Fields(lines[3 + i])
+		if len(fields) != 6 || fields[0] != level {
+			t.Errorf("stats line for level %s: %q", level, lines[3 + i])
+		}
+	}
+	if fields := strings.Fields(lines[3]); len(fields) == 6 && fields[1] != "0" {
+		t.Errorf("level 1 holds %s files after its compaction", fields[1])
+	}
+	if fields := strings.Fields(lines[4]); len(fields) == 6 && fields[1] != "1" {
+		t.Errorf("level 2 holds %s files after the compaction", fields[1])
+	}
+
+	for _, name := range []string{
+		"leveldb.num-files-at-level7",
+		"leveldb.num-files-at-level",
+		"leveldb.num-files-at-level1x",
+		"leveldb.no-such-property",
+		"leveldb.",
+		"rocksdb.stats",
+		"stats",
+	} {
+		if value, ok := impl.GetProperty(name); ok {
+			t.Errorf("GetProperty(%s) = %q", name, value)
+		}
+	}
+
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := impl.GetProperty("leveldb.stats"); ok {
+		t.Error("GetProperty succeeded after Close")
+	}
+}
+
+// Get hands out values the caller owns, and Put and Delete do not hold
+// on to the caller's buffers.
+func TestBuffersAreNotRetained(t *testing.T) {
+	impl := openTestDB(t, "/db", newTestOptions(NewMemEnv(DefaultEnv())))
+	defer impl.Close()
+
+	key := []byte("key")
+	value := []byte("value")
+	if err := impl.Put(key, value, nil); err != nil {
+		t.Fatal(err)
+	}
+	doomed := []byte("doomed")
+	mustPut(t, impl, "doomed", "value")
+	if err := impl.Delete(doomed, nil); err != nil {
+		t.Fatal(err)
+	}
+	batch := NewWriteBatch()
+	batchKey := []byte("batch")
+	batch.Put(batchKey, value)
+	if err := impl.Write(batch, nil); err != nil {
+		t.Fatal(err)
+	}
+	copy(key, "XXX")
+	copy(value, "XXXXX")
+	copy(doomed, "XXXXXX")
+	copy(batchKey, "XXXXX")
+
+	check := func(where string) {
+		t.Helper()
+		for _, kv := range [][2]string{
+			{"key", "value"},
+			{"batch", "value"},
+			{"doomed", "NOT_FOUND"},
+			{"XXX", "NOT_FOUND"},
+			{"XXXXX", "NOT_FOUND"},
+			{"XXXXXX", "NOT_FOUND"},
+		} {
+			if got := getValue(t, impl, kv[0], nil); got != kv[1] {
+				t.Fatalf("%s: Get(%s) = %s, want %s", where, kv[0], got, kv[1])
+			}
+		}
+
+		// Overwriting a value returned by Get leaves the stored one alone.
+		got, err := impl.Get([]byte("key"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		copy(got, "XXXXX")
+		if got := getValue(t, impl, "key", nil); got != "value" {
+			t.Fatalf("%s: Get(key) = %s after modifying an earlier result", where, got)
+		}
+	}
+	check("memtable")
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	check("table")
+}
@@ -2,7 +2,7 @@ package leveldb
 
 import (
 	"fmt"
-	"encoding/binary"
+	"unsafe"
 	"./utilties"
 )
 
@@ -14,6 +14,20 @@ const (
 	// Level-0 compaction is started when we hit this many files.
 	kL0_CompactionTrigger = 4
 
+	// Soft limit on number of level-0 files.  We slow down writes at this point.
+	kL0_SlowdownWritesTrigger = 8
+
+	// Maximum number of level-0 files.  We stop writes at this point.
+	kL0_StopWritesTrigger = 12
+
+	// Maximum level to which a new compacted memtable is pushed if it
+	// does not create overlap.  We try to push to level 2 to avoid the
+	// relatively expensive level 0=>1 compactions and to avoid some
+	// expensive manifest file operations.  We do not push all the way to
+	// the largest level since that can generate a lot of wasted disk
+	// space if the same key space is being repeatedly overwritten.
+	kMaxMemCompactLevel = 2
+
 )
 
 type ValueType uint8
@@ -57,6 +71,15 @@ func (this *internalKey) userKey() string {
 	return extractUserKey(this.rep)
 }
 
+func (this *internalKey) sequence() sequenceNumber {
+	var parsed parsedInternalKey
+	if !parseInternalKey(this.rep, &parsed) {
+		return 0
+	}
+
+	return parsed.sequence
+}
+
 func (this *internalKey) decodeFrom(s string) {
 	this.rep = s
 }
@@ -71,6 +94,15 @@ func (this *internalKey) setFrom(p *parsedInternalKey) {
 	appendInternalKey(&this.rep, p)
 }
 
+func (this *internalKey) String() string {
+	var parsed parsedInternalKey
+	if parseInternalKey(this.rep, &parsed) {
+		return parsed.String()
+	}
+
+	return "(bad)" + utilties.EscapeString(this.rep)
+}
+
 type LookupKey struct {
 	// We construct a char array of the form:
 	//    klength  varint32               <-- 0
@@ -85,16 +117,17 @@ type LookupKey struct {
 func newLookupKey(userKey string, sequence sequenceNumber) *LookupKey {
 	var lookupKey LookupKey
 
-	userKeyByte := []byte(userKey)
-
-	lookupKey.space = make([]byte, len(userKeyByte) + kKeyHead +  (kKeyHead / 2) )
+	lookupKey.space = make([]byte, kMaxVarint32Length + len(userKey) + kKeyHead)
 
 	lookupKey.kStart = encodeVarint32(lookupKey.space, uint32(len(userKey) + kKeyHead) )
 	
-	userKeyLen := copy(lookupKey.space[lookupKey.kStart:], userKeyByte)
+	userKeyLen := copy(lookupKey.space[lookupKey.kStart:], userKey)
 
 	encodeFixed64(lookupKey.space[lookupKey.kStart + userKeyLen:], packSequenceAndType(uint64(sequence), kValueTypeForSeek) )
 
+	// The length prefix is usually shorter than its reserved space.
+	lookupKey.space = lookupKey.space[ : lookupKey.kStart + userKeyLen + kKeyHead]
+
 	return &lookupKey
 }
 
@@ -169,112 +202,14 @@ func parseInternalKey(internalKey string, result *parsedInternalKey) bool {
 	return ValueType(c) <= kTypeValue
 }
 
-func encodeFixed64(buf []byte, value uint64) int {
-	binary.PutUvarint(buf, value)
-
-	return kKeyHead
+// Return a string that shares storage with "b", without copying.  The
+// bytes must not be modified while the string is in use.
+func bytesToString(b []byte) string {
+	return unsafe.String(unsafe.SliceData(b), len(b))
 }
 
-func encodeFixed32(buf []byte, value uint32) int {
-	binary.PutUvarint(buf, uint64(value) )
-	return kKeyHead / 2
-}
-
-func encodeVarint64(buf []byte, value uint64) int {
-	return binary.PutUvarint(buf, value)
-}
-
-func encodeVarint32(buf []byte, value uint32) int {
-	return binary.PutUvarint(buf, uint64(value) )
-}
-
-func decodeFixed64(value string) uint64 {
-	if len(value) != kKeyHead {
-		return 0
-	}
-
-	buf := []byte(value)
-
-	result, _ := binary.Uvarint(buf)
-
-	return result
-}
-
-func decodeFix32(value string) uint32 {
-	if len(value) != (kKeyHead / 2) {
-		return 0
-	}
-
-	buf := []byte(value)
-
-	result, _ := binary.Uvarint(buf)
-
-	return uint32(result)
-}
-
-// Standard Get... routines parse a value from the beginning of a Slice
-// and advance the slice past the parsed value.
-func getVarint64(value string) (string, uint64) {
-	if len(value) != kKeyHead {
-		return value, 0
-	}
-
-	buf := []byte(value)
-
-	result, l := binary.Uvarint(buf)
-
-	return value[l:], result
-}
-
-// Standard Get... routines parse a value from the beginning of a Slice
-// and advance the slice past the parsed value.
-func getVarint32(value []byte) ([]byte, uint32) {
-	if len(value) != (kKeyHead / 2) {
-		return value, 0
-	}
-
-	buf := []byte(value)
-
-	result, l := binary.Uvarint(buf)
-
-	return value[l:], uint32(result)
-}
-
-
-func putVarint32(dst *[]byte, start int, value uint32) int {
-	buffLen := len((*dst)[:start] )
-	if buffLen < kKeyHead / 2 {
-		tmp := make([]byte, start + kKeyHead / 2)
-		copy(tmp, *dst)
-		*dst = tmp
-	}
-
-	return encodeVarint32((*dst)[:start], value)
-}
-
-func putVarint64(dst *[]byte, start int, value uint64) int {
-	buffLen := len(*dst)
-	if buffLen < kKeyHead  {
-		tmp := make([]byte, start + kKeyHead)
-		copy(tmp, *dst)
-		*dst = tmp
-	}
-
-	return encodeVarint64((*dst)[:start], value)
-}
-
-
-func putLengthPrefixedSlice(dst *[]byte, start int, value string) int {
-	l := putVarint32(dst, start, uint32(len(value)) )
-
-	bytes := []byte(value)
-	if len((*dst)[:start + l]) < len(bytes) {
-		tmp := make([]byte, start + len(bytes) + l)
-		copy(tmp, *dst)
-		*dst = tmp
-	}
-
-	copy((*dst)[:start + l], bytes)
-
-	return l + len(bytes)
+// Return a slice that shares storage with "s", without copying.  The
+// slice must not be modified.
+func stringToBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
 }
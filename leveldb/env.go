@@ -1,10 +1,14 @@
 package leveldb
 
 import (
+	"errors"
 	"time"
+	"io"
 	"os"
-	"fmt"
-	//"syscall"
+	"math"
+	"sync"
+	"sync/atomic"
+	"syscall"
 )
 
 type Env interface {
@@ -33,6 +37,15 @@ type Env interface {
 	//
 	// The returned file will only be accessed by one thread at a time.
 	NewWritableFile(fname string, result *WritableFile) Status
+
+	// Create an object that either appends to an existing file, or
+	// writes to a new file (if the file does not exist to begin with).
+	// On success, stores a pointer to the new file in *result and
+	// returns OK.  On failure stores NULL in *result and returns
+	// non-OK.
+	//
+	// The returned file will only be accessed by one thread at a time.
+	NewAppendableFile(fname string, result *WritableFile) Status
 	  
 	FileExists(fname string) bool
 
@@ -45,6 +58,9 @@ type Env interface {
 
 	CreateDir(dirname string) Status
 
+	// Delete the specified directory.
+	DeleteDir(dirname string) Status
+
 	GetFileSize(fname string) (fileSize int64, status Status)
 
 	RenameFile(src string, target string) Status
@@ -90,16 +106,185 @@ type Env interface {
 }
 
 
+// A utility routine: write "data" to the named file.
+func WriteStringToFile(env Env, data string, fname string) Status {
+	return doWriteStringToFile(env, data, fname, false)
+}
+
+// A utility routine: write "data" to the named file and Sync() it.
+func WriteStringToFileSync(env Env, data string, fname string) Status {
+	return doWriteStringToFile(env, data, fname, true)
+}
+
+func doWriteStringToFile(env Env, data string, fname string, shouldSync bool) Status {
+	var file WritableFile
+	s := env.NewWritableFile(fname, &file)
+	if !s.OK() {
+		return s
+	}
+
+	s = file.Append([]byte(data))
+	if s.OK() && shouldSync {
+		s = file.Sync()
+	}
+	if s.OK() {
+		s = file.Close()
+	}
+	if !s.OK() {
+		env.DeleteFile(fname)
+	}
+
+	return s
+}
+
+// A utility routine: read contents of named file into *data
+func ReadFileToString(env Env, fname string, data *string) Status {
+	*data = ""
+	var file SequentialFile
+	s := env.NewSequentialFile(fname, &file)
+	if !s.OK() {
+		return s
+	}
+
+	const kBufferSize = 8192
+	space := make([]byte, kBufferSize)
+	for {
+		var fragment []byte
+		s = file.Read(space, &fragment)
+		if !s.OK() {
+			break
+		}
+		*data += string(fragment)
+		if len(fragment) == 0 {
+			break
+		}
+	}
+	file.Close()
+
+	return s
+}
+
+// Implemented by an Env that can hard link files.
+type fileLinker interface {
+	// Create "target" as a hard link to "src".
+	LinkFile(src string, target string) Status
+}
+
+// A utility routine: copy the named file "src" to "target" and Sync()
+// the copy.
+func CopyFile(env Env, src string, target string) Status {
+	var file SequentialFile
+	s := env.NewSequentialFile(src, &file)
+	if !s.OK() {
+		return s
+	}
+
+	var out WritableFile
+	s = env.NewWritableFile(target, &out)
+	if !s.OK() {
+		file.Close()
+		return s
+	}
+
+	const kBufferSize = 64 << 10
+	space := make([]byte, kBufferSize)
+	for {
+		var fragment []byte
+		s = file.Read(space, &fragment)
+		if !s.OK() || len(fragment) == 0 {
+			break
+		}
+		s = out.Append(fragment)
+		if !s.OK() {
+			break
+		}
+	}
+	file.Close()
+
+	if s.OK() {
+		s = out.Sync()
+	}
+	if closeStatus := out.Close(); s.OK() {
+		s = closeStatus
+	}
+	if !s.OK() {
+		env.DeleteFile(target)
+	}
+
+	return s
+}
+
+// Can be set using SetReadOnlyMMapLimit().
+var mmapLimit = kDefaultMmapLimit
+
+// The fd limit is derived from RLIMIT_NOFILE unless set explicitly.
+const kDefaultFdLimitUnset = -1
+
+// Can be set using SetReadOnlyFDLimit().
+var openReadOnlyFileLimit = kDefaultFdLimitUnset
+
+// Set the maximum number of read-only files that will be opened.
+// Must be called before the first call to DefaultEnv().
+func SetReadOnlyFDLimit(limit int) {
+	openReadOnlyFileLimit = limit
+}
+
+// Set the maximum number of read-only files that will be mapped via mmap.
+// Must be called before the first call to DefaultEnv(). Files are only
+// mapped on 64-bit Linux; elsewhere the limit is ignored.
+func SetReadOnlyMMapLimit(limit int) {
+	mmapLimit = limit
+}
+
+// Return the maximum number of read-only files to keep open.
+func maxOpenFiles() int {
+	if openReadOnlyFileLimit >= 0 {
+		return openReadOnlyFileLimit
+	}
+
+	if limit, ok := openFileLimit(); !ok {
+		// getrlimit failed, fallback to hard-coded default.
+		openReadOnlyFileLimit = 50
+	} else if limit == math.MaxUint64 {	// RLIM_INFINITY
+		openReadOnlyFileLimit = math.MaxInt32
+	} else {
+		// Allow use of 20% of available file descriptors for read-only files.
+		openReadOnlyFileLimit = int(limit / 5)
+	}
+
+	return openReadOnlyFileLimit
+}
+
 type defaultEnv struct {
+	mmapLimiter *Limiter	// Thread-safe.
+	fdLimiter *Limiter	// Thread-safe.
 }
 
+var defaultEnvOnce sync.Once
+var defaultEnvInstance *defaultEnv
+
+// Return the default environment suitable for the current operating
+// system.  The result belongs to the package and is shared by every
+// caller.
 func DefaultEnv() Env {
-	return &defaultEnv{}
+	defaultEnvOnce.Do(func() {
+		defaultEnvInstance = &defaultEnv{
+			mmapLimiter: newLimiter(mmapLimit),
+			fdLimiter: newLimiter(maxOpenFiles()),
+		}
+	})
+
+	return defaultEnvInstance
 }
 
 type SequentialFile interface {
-	
-	Read(scratch []byte) Status
+	// Read up to len(scratch) bytes from the file.  "scratch" may be
+	// written by this routine.  Sets "*result" to the data that was
+	// read (including if fewer bytes were successfully read).  Reaching
+	// the end of the file is not an error: "*result" is simply short.
+	//
+	// REQUIRES: External synchronization
+	Read(scratch []byte, result *[]byte) Status
 
 	// Skip "n" bytes from the file. This is guaranteed to be no
 	// slower that reading the same data, but may be faster.
@@ -109,17 +294,40 @@ type SequentialFile interface {
 	//
 	// REQUIRES: External synchronization
 	Skip(n int64) Status
+
+	Close() Status
+}
+
+// Return a status for the error "err" returned by the operating system,
+// keeping "err" as its cause.  A missing file is NotFound, as in
+// PosixError() of C++ LevelDB.  "context" is prepended to the message
+// unless it is empty.
+func posixError(context string, err error) Status {
+	msg := err.Error()
+	if context != "" {
+		msg = context + ": " + msg
+	}
+
+	var s Status
+	if errors.Is(err, os.ErrNotExist) {
+		s = NotFound(msg)
+	} else if errors.Is(err, syscall.ENOSPC) {
+		s = NoSpace(msg)
+	} else {
+		s = IOError(msg)
+	}
+	return s.WithCause(err)
 }
 
 type defaultSequentialFile struct {
 	*os.File
 }
 
-func (this *defaultSequentialFile) Read(scratch []byte) Status {
-	
-	_, err := this.File.Read(scratch)
-	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+func (this *defaultSequentialFile) Read(scratch []byte, result *[]byte) Status {
+	n, err := io.ReadFull(this.File, scratch)
+	*result = scratch[:n]
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return posixError("", err)
 	}
 
 	return OK()
@@ -129,39 +337,141 @@ func (this *defaultSequentialFile) Skip(n int64) Status {
 	_, err := this.File.Seek(n, 1)
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
+	}
+
+	return OK()
+}
+
+func (this *defaultSequentialFile) Close() Status {
+	err := this.File.Close()
+
+	if err != nil {
+		return posixError("", err)
 	}
 
 	return OK()
 }
 
 type RandomAccessFile interface {
-	// Read up to "n" bytes from the file starting at "offset".
-	// "scratch[0..n-1]" may be written by this routine.  Sets "*result"
-	// to the data that was read (including if fewer than "n" bytes were
-	// successfully read).  May set "*result" to point at data in
-	// "scratch[0..n-1]", so "scratch[0..n-1]" must be live when
-	// "*result" is used.  If an error was encountered, returns a non-OK
-	// status.
-	Read(offset int64, scratch []byte) Status
+	// Read up to len(scratch) bytes from the file starting at "offset".
+	// "scratch" may be written by this routine.  Sets "*result" to the
+	// data that was read (including if fewer bytes were successfully
+	// read).  May set "*result" to point at data in "scratch", so
+	// "scratch" must be live when "*result" is used.  May also set
+	// "*result" to memory owned by the file, which stays valid until
+	// the file is closed.  If an error was encountered, returns a
+	// non-OK status.
+	//
+	// Safe for concurrent use by multiple threads.
+	Read(offset int64, scratch []byte, result *[]byte) Status
+
+	Close() Status
 }
 
-type defaultRandomAccessFile struct {
-	*os.File
+// Helper class to limit resource usage to avoid exhaustion.
+// Currently used to limit read-only file descriptors and mmap file usage
+// so that we do not run out of file descriptors or virtual memory, or run into
+// kernel performance problems for very large databases.
+type Limiter struct {
+	// The number of available resources.
+	//
+	// This is a counter and is not tied to the invariants of any other class, so
+	// it can be operated on safely using atomic operations.
+	acquiresAllowed int64
 }
 
-func (this *defaultRandomAccessFile) Read(offset int64, scratch []byte) Status {
-	_, err := this.File.ReadAt(scratch, offset)
+// Limit maximum number of resources to "maxAcquires".
+func newLimiter(maxAcquires int) *Limiter {
+	return &Limiter{
+		acquiresAllowed: int64(maxAcquires),
+	}
+}
 
-	if err != nil {
+// If another resource is available, acquire it and return true.
+// Else return false.
+func (this *Limiter) Acquire() bool {
+	oldAcquiresAllowed := atomic.AddInt64(&this.acquiresAllowed, -1) + 1
+
+	if oldAcquiresAllowed > 0 {
+		return true
+	}
+
+	atomic.AddInt64(&this.acquiresAllowed, 1)
+	return false
+}
+
+// Release a resource acquired by a previous call to Acquire() that returned
+// true.
+func (this *Limiter) Release() {
+	atomic.AddInt64(&this.acquiresAllowed, 1)
+}
+
+// Implements random read access in a file using pread().
+//
+// Instances of this class are thread-safe, as required by the RandomAccessFile
+// API. Instances are immutable and Read() only calls thread-safe library
+// functions.
+type defaultRandomAccessFile struct {
+	file *os.File	// nil if !hasPermanentFd
+	hasPermanentFd bool	// If false, the file is opened on every read.
+	fdLimiter *Limiter
+	fname string
+}
+
+// The new instance takes ownership of "file". "fdLimiter" must outlive this
+// instance, and will be used to determine if the file is kept open.
+func newDefaultRandomAccessFile(fname string, file *os.File, fdLimiter *Limiter) *defaultRandomAccessFile {
+	result := &defaultRandomAccessFile{
+		hasPermanentFd: fdLimiter.Acquire(),
+		fdLimiter: fdLimiter,
+		fname: fname,
+	}
+
+	if result.hasPermanentFd {
+		result.file = file
+	} else {
+		file.Close()
+	}
+
+	return result
+}
+
+func (this *defaultRandomAccessFile) Read(offset int64, scratch []byte, result *[]byte) Status {
+	file := this.file
+	if !this.hasPermanentFd {
+		f, err := os.OpenFile(this.fname, os.O_RDONLY, 0)
 		if err != nil {
-			return IOError(fmt.Sprintf("%v", err) )
+			*result = nil
+			return posixError("", err)
 		}
+		defer f.Close()
+		file = f
+	}
+
+	n, err := file.ReadAt(scratch, offset)
+	*result = scratch[:n]
+	if err != nil && err != io.EOF {
+		return posixError("", err)
 	}
 
 	return OK()
 }
 
+func (this *defaultRandomAccessFile) Close() Status {
+	if !this.hasPermanentFd {
+		return OK()
+	}
+
+	err := this.file.Close()
+	this.fdLimiter.Release()
+
+	if err != nil {
+		return posixError("", err)
+	}
+
+	return OK()
+}
 
 type WritableFile interface {
 	Append(data []byte) Status 
@@ -177,7 +487,7 @@ type defaultWritableFile struct {
 func (this *defaultWritableFile) Append(data []byte) Status {
 	_, err := this.File.Write(data)
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
 	}
 
 	return OK()
@@ -187,7 +497,7 @@ func (this *defaultWritableFile) Close() Status {
 	err := this.File.Close()
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
 	}
 
 	return OK()
@@ -201,7 +511,7 @@ func (this *defaultWritableFile) Sync() Status {
 	err := this.File.Sync()
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
 	}
 
 	return OK()
@@ -212,46 +522,45 @@ type FileLock interface {
 	Unlock() Status
 }
 
-type defaultFileLock struct {
-	*os.File
+// Tracks the files locked by this process.  flock() locks are owned by
+// the open file description, so a second open of the same LOCK file in
+// this process would not conflict with the first; the table catches
+// that case instead.
+type lockTable struct {
+	mutex sync.Mutex
+	lockedFiles map[string]bool
 }
 
-func (this *defaultFileLock) Lock(fname string) Status {
-	// f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0755)
-	// if err != nil {
-	// 	return IOError(fmt.Sprintf("%v", err) )
-	// }
-	// todo:先省略不实现
-	return OK()
+var locks = lockTable{
+	lockedFiles: make(map[string]bool),
 }
 
-func (this *defaultFileLock) Unlock() Status {
-	// todo: 同上
-	return OK()
-}
+func (this *lockTable) Insert(fname string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-func (this *defaultEnv) NewSequentialFile(fname string, result *SequentialFile) Status {
-	f, err := os.OpenFile(fname, os.O_RDONLY, 0755)
-
-	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+	if this.lockedFiles[fname] {
+		return false
 	}
+	this.lockedFiles[fname] = true
+	return true
+}
 
-	*result = &defaultSequentialFile{
-		File: f,
-	}
+func (this *lockTable) Remove(fname string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
 
-	return OK()
+	delete(this.lockedFiles, fname)
 }
 
-func (this *defaultEnv) NewRandomAccessFile(fname string, result *RandomAccessFile) Status {
+func (this *defaultEnv) NewSequentialFile(fname string, result *SequentialFile) Status {
 	f, err := os.OpenFile(fname, os.O_RDONLY, 0755)
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
 	}
 
-	*result = &defaultRandomAccessFile{
+	*result = &defaultSequentialFile{
 		File: f,
 	}
 
@@ -259,11 +568,19 @@ func (this *defaultEnv) NewRandomAccessFile(fname string, result *RandomAccessFi
 }
 
 func (this *defaultEnv) NewWritableFile(fname string, result *WritableFile) Status {
+	return this.newWritableFile(fname, os.O_TRUNC, result)
+}
+
+func (this *defaultEnv) NewAppendableFile(fname string, result *WritableFile) Status {
+	return this.newWritableFile(fname, os.O_APPEND, result)
+}
 
-	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0755)
+func (this *defaultEnv) newWritableFile(fname string, flag int, result *WritableFile) Status {
+	*result = nil
+	f, err := os.OpenFile(fname, os.O_WRONLY|os.O_CREATE|flag, 0644)
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
 	}
 
 	*result = &defaultWritableFile{
@@ -285,13 +602,14 @@ func (this *defaultEnv) GetChildren(dir string) ([]string, Status) {
 	f, err := os.Open(dir)
 
 	if err != nil {
-		return  nil, IOError(fmt.Sprintf("%v", err) )
+		return  nil, posixError("", err)
 	}
+	defer f.Close()
 
 	names, err := f.Readdirnames(-1)
 
 	if err != nil {
-		return  nil, IOError(fmt.Sprintf("%v", err) )
+		return  nil, posixError("", err)
 	}
 
 	return names, OK()
@@ -300,7 +618,7 @@ func (this *defaultEnv) GetChildren(dir string) ([]string, Status) {
 func (this *defaultEnv) DeleteFile(fname string) Status {
 	err := os.Remove(fname)
 	if err != nil {
-		return  IOError(fmt.Sprintf("%v", err) )
+		return  posixError("", err)
 	}
 
 	return OK()
@@ -313,7 +631,16 @@ func (this *defaultEnv) CreateDir(dirname string) Status {
 	err := os.Mkdir(dirname, os.ModePerm)
 
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
+	}
+
+	return OK()
+}
+
+func (this *defaultEnv) DeleteDir(dirname string) Status {
+	err := os.Remove(dirname)
+	if err != nil {
+		return posixError("", err)
 	}
 
 	return OK()
@@ -321,8 +648,8 @@ func (this *defaultEnv) CreateDir(dirname string) Status {
 
 func (this *defaultEnv) GetFileSize(fname string) (fileSize int64, status Status) {
 	f, err := os.Stat(fname)
-	if os.IsNotExist(err) {
-		return 0, IOError(fmt.Sprintf("%v", err) )
+	if err != nil {
+		return 0, posixError("", err)
 	}
 	return f.Size(), OK()
 }
@@ -330,17 +657,30 @@ func (this *defaultEnv) GetFileSize(fname string) (fileSize int64, status Status
 func (this *defaultEnv) RenameFile(src string, target string) Status {
 	err := os.Rename(src, target)
 	if err != nil {
-		return IOError(fmt.Sprintf("%v", err) )
+		return posixError("", err)
+	}
+
+	return OK()
+}
+
+func (this *defaultEnv) LinkFile(src string, target string) Status {
+	err := os.Link(src, target)
+	if err != nil {
+		return posixError("", err)
 	}
 
 	return OK()
 }
 
 func (this *defaultEnv) LockFile(fname string, lock *FileLock) Status {
+	*lock = nil
 	l := &defaultFileLock{}
-	*lock = l
+	s := l.Lock(fname)
+	if s.OK() {
+		*lock = l
+	}
 
-	return (*lock).Lock(fname)
+	return s
 }
 
 func (this *defaultEnv) UnlockFile(lock FileLock) Status {
@@ -0,0 +1,97 @@
+//go:build linux && (amd64 || arm64)
+
+package leveldb
+
+import (
+	"os"
+	"syscall"
+)
+
+// Up to 1000 mmap regions on the 64-bit platforms this file is built
+// for.
+const kDefaultMmapLimit = 1000
+
+// Implements random read access in a file using mmap().
+//
+// Instances of this class are thread-safe, as required by the RandomAccessFile
+// API. Instances are immutable and Read() only calls thread-safe library
+// functions.
+type mmapReadableFile struct {
+	mmapBase []byte
+	mmapLimiter *Limiter
+	fname string
+}
+
+// "mmapBase" must be the result of a successful call to mmap(). This
+// instance takes over the ownership of the region.
+//
+// "mmapLimiter" must outlive this instance. The caller must have already
+// acquired the right to use one mmap region, which will be released when this
+// instance is closed.
+func newMmapReadableFile(fname string, mmapBase []byte, mmapLimiter *Limiter) *mmapReadableFile {
+	return &mmapReadableFile{
+		mmapBase: mmapBase,
+		mmapLimiter: mmapLimiter,
+		fname: fname,
+	}
+}
+
+func (this *mmapReadableFile) Read(offset int64, scratch []byte, result *[]byte) Status {
+	n := int64(len(scratch))
+	if offset < 0 || offset + n > int64(len(this.mmapBase)) {
+		*result = nil
+		return posixError(this.fname, syscall.EINVAL)
+	}
+
+	*result = this.mmapBase[offset : offset + n]
+	return OK()
+}
+
+func (this *mmapReadableFile) Close() Status {
+	err := syscall.Munmap(this.mmapBase)
+	this.mmapBase = nil
+	this.mmapLimiter.Release()
+
+	if err != nil {
+		return posixError(this.fname, err)
+	}
+
+	return OK()
+}
+
+func (this *defaultEnv) NewRandomAccessFile(fname string, result *RandomAccessFile) Status {
+	*result = nil
+	f, err := os.OpenFile(fname, os.O_RDONLY, 0)
+
+	if err != nil {
+		return posixError("", err)
+	}
+
+	if !this.mmapLimiter.Acquire() {
+		*result = newDefaultRandomAccessFile(fname, f, this.fdLimiter)
+		return OK()
+	}
+
+	fileSize, status := this.GetFileSize(fname)
+	if status.OK() {
+		if fileSize == 0 {
+			// Empty files cannot be mapped; nothing can be read from them anyway.
+			this.mmapLimiter.Release()
+			*result = newDefaultRandomAccessFile(fname, f, this.fdLimiter)
+			return OK()
+		}
+
+		mmapBase, err := syscall.Mmap(int(f.Fd()), 0, int(fileSize), syscall.PROT_READ, syscall.MAP_SHARED)
+		if err == nil {
+			*result = newMmapReadableFile(fname, mmapBase, this.mmapLimiter)
+		} else {
+			status = posixError(fname, err)
+		}
+	}
+	f.Close()
+	if !status.OK() {
+		this.mmapLimiter.Release()
+	}
+
+	return status
+}
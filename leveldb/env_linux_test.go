@@ -0,0 +1,152 @@
+//go:build linux && (amd64 || arm64)
+
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Return an Env like DefaultEnv() that maps up to "mmapLimit" files.
+func newMmapTestEnv(mmapLimit int) *defaultEnv {
+	return &defaultEnv{
+		mmapLimiter: newLimiter(mmapLimit),
+		fdLimiter: newLimiter(maxOpenFiles()),
+	}
+}
+
+func TestRandomAccessFilesAreMapped(t *testing.T) {
+	env := newMmapTestEnv(1)
+	dir := t.TempDir()
+	contents := "0123456789"
+	for _, name := range []string{"a", "b", "empty"} {
+		data := contents
+		if name == "empty" {
+			data = ""
+		}
+		if s := WriteStringToFile(env, data, dir + "/" + name); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+
+	read := func(file RandomAccessFile, what string) []byte {
+		t.Helper()
+		scratch := make([]byte, 3)
+		var result []byte
+		if s := file.Read(2, scratch, &result); !s.OK() || string(result) != "234" {
+			t.Fatalf("%s: read %q: %v", what, result, s)
+		}
+		return result
+	}
+
+	var first RandomAccessFile
+	if s := env.NewRandomAccessFile(dir + "/a", &first); !s.OK() {
+		t.Fatal(s)
+	}
+	if _, ok := first.(*mmapReadableFile); !ok {
+		t.Fatalf("first file is a %T, not mapped", first)
+	}
+	read(first, "mapped file")
+
+	// An empty file is not mapped, and does not use up the limit.
+	var empty RandomAccessFile
+	if s := env.NewRandomAccessFile(dir + "/empty", &empty); !s.OK() {
+		t.Fatal(s)
+	}
+	if _, ok := empty.(*defaultRandomAccessFile); !ok {
+		t.Fatalf("empty file is a %T", empty)
+	}
+	empty.Close()
+
+	// With the limit used up, files are read with pread().
+	var second RandomAccessFile
+	if s := env.NewRandomAccessFile(dir + "/b", &second); !s.OK() {
+		t.Fatal(s)
+	}
+	if _, ok := second.(*defaultRandomAccessFile); !ok {
+		t.Fatalf("file past the mmap limit is a %T", second)
+	}
+	read(second, "file past the mmap limit")
+	second.Close()
+
+	// Closing the mapped file makes room for another.
+	first.Close()
+	if s := env.NewRandomAccessFile(dir + "/b", &second); !s.OK() {
+		t.Fatal(s)
+	}
+	if _, ok := second.(*mmapReadableFile); !ok {
+		t.Fatalf("file opened after the mapped one was closed is a %T", second)
+	}
+	second.Close()
+	if n := atomic.LoadInt64(&env.mmapLimiter.acquiresAllowed); n != 1 {
+		t.Fatalf("%d mappings left after closing every file, want 1", n)
+	}
+}
+
+// Values read from a mapped table are copied out of the mapping, so
+// they stay valid once the table is evicted and unmapped.
+func TestValuesOutliveMappedTables(t *testing.T) {
+	env := newMmapTestEnv(1000)
+	options := newTestOptions(env)
+	options.Compression = NoCompression	// Uncompressed blocks are read in place
+	name := t.TempDir() + "/db"
+	impl := openTestDB(t, name, options)
+	defer impl.Close()
+
+	value := func(i int) string {
+		return fmt.Sprintf("%03d", i) + strings.Repeat("v", 1000)
+	}
+	for i := 0; i < 100; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%03d", i), value(i))
+	}
+	if s := impl.compactMemTableAndWait(); !s.OK() {
+		t.Fatal(s)
+	}
+	var tables []uint64
+	for level := 0; level < kNumLevels; level++ {
+		for _, f := range filesAtLevel(impl, level) {
+			tables = append(tables, f.number)
+		}
+	}
+
+	got, err := impl.Get([]byte("key042"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&env.mmapLimiter.acquiresAllowed) == 1000 {
+		t.Fatal("the table was not mapped")
+	}
+	var values [][]byte
+	iter := impl.NewIterator(nil)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		values = append(values, iter.Value())
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewrite the data, which evicts the tables read above and deletes
+	// them.
+	mustPut(t, impl, "key000", value(0))
+	impl.CompactRange(nil, nil)
+	for _, number := range tables {
+		if env.FileExists(TableFileName(name, number)) {
+			t.Fatalf("table %d is still live", number)
+		}
+	}
+
+	if string(got) != value(42) {
+		t.Errorf("Get(key042) = %.10q after the table was evicted", got)
+	}
+	if len(values) != 100 {
+		t.Fatalf("iterated over %d values", len(values))
+	}
+	for i, v := range values {
+		if !bytes.Equal(v, []byte(value(i))) {
+			t.Fatalf("value %d = %.10q after the table was evicted", i, v)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+//go:build !unix
+
+package leveldb
+
+import (
+	"os"
+)
+
+// RLIMIT_NOFILE is not read on this platform.
+func openFileLimit() (uint64, bool) {
+	return 0, false
+}
+
+// Without fcntl() the lock only excludes other users in this process.
+// Other processes opening the same DB are not excluded.
+type defaultFileLock struct {
+	*os.File
+	fname string
+}
+
+func (this *defaultFileLock) Lock(fname string) Status {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return posixError("", err)
+	}
+
+	if !locks.Insert(fname) {
+		f.Close()
+		return IOError("lock " + fname + ": already held by process")
+	}
+
+	this.File = f
+	this.fname = fname
+
+	return OK()
+}
+
+func (this *defaultFileLock) Unlock() Status {
+	locks.Remove(this.fname)
+	if err := this.File.Close(); err != nil {
+		return posixError("unlock " + this.fname, err)
+	}
+
+	return OK()
+}
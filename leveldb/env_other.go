@@ -0,0 +1,22 @@
+//go:build !(linux && (amd64 || arm64))
+
+package leveldb
+
+import (
+	"os"
+)
+
+// No table files are mapped on this platform; every read goes through
+// pread().
+const kDefaultMmapLimit = 0
+
+func (this *defaultEnv) NewRandomAccessFile(fname string, result *RandomAccessFile) Status {
+	*result = nil
+	f, err := os.OpenFile(fname, os.O_RDONLY, 0)
+	if err != nil {
+		return posixError("", err)
+	}
+
+	*result = newDefaultRandomAccessFile(fname, f, this.fdLimiter)
+	return OK()
+}
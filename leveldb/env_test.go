@@ -0,0 +1,81 @@
+package leveldb
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestLockFileHeldByThisProcess(t *testing.T) {
+	env := DefaultEnv()
+	fname := t.TempDir() + "/LOCK"
+
+	var lock FileLock
+	if s := env.LockFile(fname, &lock); !s.OK() {
+		t.Fatal(s)
+	}
+	var again FileLock
+	if s := env.LockFile(fname, &again); !errors.Is(s.Err(), ErrIOError) || again != nil {
+		t.Fatalf("second LockFile: %v", s)
+	}
+	if s := env.UnlockFile(lock); !s.OK() {
+		t.Fatal(s)
+	}
+	if s := env.LockFile(fname, &again); !s.OK() {
+		t.Fatalf("LockFile after UnlockFile: %v", s)
+	}
+	env.UnlockFile(again)
+}
+
+func TestOpenLockedDB(t *testing.T) {
+	name := t.TempDir() + "/db"
+	options := newTestOptions(DefaultEnv())
+	db := openTestDB(t, name, options)
+
+	if _, err := Open(name, options); !errors.Is(err, ErrIOError) {
+		t.Fatalf("second Open of a DB: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db = openTestDB(t, name, options)
+	db.Close()
+}
+
+func TestPosixError(t *testing.T) {
+	tests := []struct {
+		err error
+		want error
+	}{
+		{&os.PathError{Op: "open", Path: "/f", Err: syscall.ENOENT}, ErrNotFound},
+		{os.ErrNotExist, ErrNotFound},
+		{&os.PathError{Op: "write", Path: "/f", Err: syscall.ENOSPC}, ErrNoSpace},
+		{&os.PathError{Op: "open", Path: "/f", Err: syscall.EACCES}, ErrIOError},
+	}
+	for _, test := range tests {
+		s := posixError("context", test.err)
+		if !errors.Is(s, test.want) {
+			t.Errorf("posixError(%v) = %v, want %v", test.err, s, test.want)
+		}
+		if errors.Unwrap(s) != test.err {
+			t.Errorf("posixError(%v) lost its cause", test.err)
+		}
+		if s.String() == "" || s.Err() == nil {
+			t.Errorf("posixError(%v) = %v", test.err, s)
+		}
+	}
+}
+
+func TestMissingFileIsNotFound(t *testing.T) {
+	var file SequentialFile
+	s := DefaultEnv().NewSequentialFile(t.TempDir() + "/missing", &file)
+	if !s.IsNotFound() || !errors.Is(s.Err(), ErrNotFound) {
+		t.Fatalf("NewSequentialFile: %v", s)
+	}
+	var pathError *os.PathError
+	if !errors.As(s.Err(), &pathError) || !errors.Is(s.Err(), os.ErrNotExist) {
+		t.Fatalf("NewSequentialFile lost the cause: %v", s)
+	}
+}
@@ -0,0 +1,71 @@
+//go:build unix
+
+package leveldb
+
+import (
+	"os"
+	"syscall"
+)
+
+// Return RLIMIT_NOFILE, or false if it cannot be read.
+func openFileLimit() (uint64, bool) {
+	var rlim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+		return 0, false
+	}
+
+	return uint64(rlim.Cur), true
+}
+
+// Locks are taken with fcntl(F_SETLK), which every unix provides (flock()
+// is missing on some). fcntl() locks are held per process, so the locks
+// table is what excludes other users in this process.
+type defaultFileLock struct {
+	*os.File
+	fname string
+}
+
+func lockOrUnlock(f *os.File, lock bool) error {
+	l := syscall.Flock_t{Whence: 0, Start: 0, Len: 0}	// Lock/unlock entire file
+	if lock {
+		l.Type = syscall.F_WRLCK
+	} else {
+		l.Type = syscall.F_UNLCK
+	}
+
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &l)
+}
+
+func (this *defaultFileLock) Lock(fname string) Status {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return posixError("", err)
+	}
+
+	if !locks.Insert(fname) {
+		f.Close()
+		return IOError("lock " + fname + ": already held by process")
+	}
+
+	if err = lockOrUnlock(f, true); err != nil {
+		f.Close()
+		locks.Remove(fname)
+		return posixError("lock " + fname, err)
+	}
+
+	this.File = f
+	this.fname = fname
+
+	return OK()
+}
+
+func (this *defaultFileLock) Unlock() Status {
+	err := lockOrUnlock(this.File, false)
+	locks.Remove(this.fname)
+	this.File.Close()
+	if err != nil {
+		return posixError("unlock " + this.fname, err)
+	}
+
+	return OK()
+}
@@ -0,0 +1,422 @@
+package leveldb
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sync"
+)
+
+// Operations of a FaultInjectionEnv that can be made to fail.
+type FaultOp int
+
+const (
+	FaultAppend FaultOp = iota
+	FaultSync
+	FaultRename
+	FaultNewWritableFile
+	kNumFaultOps
+)
+
+var faultOpNames = [kNumFaultOps]string{"append", "sync", "rename", "new writable file"}
+
+// State of a file written through a FaultInjectionEnv.
+type faultFileState struct {
+	fname string
+	pos int64	// Bytes appended so far
+	posAtLastSync int64	// Bytes that survive a crash
+}
+
+func (this *faultFileState) IsFullySynced() bool {
+	return this.pos <= 0 || this.pos == this.posAtLastSync
+}
+
+// A FaultInjectionEnv wraps another Env, remembers how much of every
+// file it writes has been synced, and can make the file operations it
+// forwards fail on demand.
+//
+// SimulateCrash() brings the files back to the state a power loss
+// would leave them in: unsynced data is dropped and files whose
+// directory entry was never synced disappear.  A directory counts as
+// synced once any file created in it since the last directory sync is
+// synced itself.
+type FaultInjectionEnv struct {
+	Env
+
+	mutex sync.Mutex
+	dbFileState map[string]*faultFileState
+	newFilesSinceLastDirSync map[string]bool
+	readOnly bool
+	failAfter [kNumFaultOps]int
+	rnd *rand.Rand
+	oneIn int
+}
+
+func NewFaultInjectionEnv(base Env) *FaultInjectionEnv {
+	return &FaultInjectionEnv{
+		Env: base,
+		dbFileState: make(map[string]*faultFileState),
+		newFilesSinceLastDirSync: make(map[string]bool),
+	}
+}
+
+// Make the "n"th next call of "op" fail with an IOError.  Later calls
+// succeed again.  n <= 0 disables the failure.
+func (this *FaultInjectionEnv) SetFailAfter(op FaultOp, n int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+	this.failAfter[op] = n
+}
+
+// Make every Append, Sync, RenameFile, NewWritableFile and
+// NewAppendableFile fail with probability 1/oneIn, drawing from a
+// generator seeded with "seed".  oneIn <= 0 disables random failures.
+func (this *FaultInjectionEnv) SetRandomFailures(seed int64, oneIn int) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if oneIn <= 0 {
+		this.rnd = nil
+		this.oneIn = 0
+		return
+	}
+	this.rnd = rand.New(rand.NewSource(seed))
+	this.oneIn = oneIn
+}
+
+// While "readOnly" is true every operation that would modify the
+// filesystem fails with an IOError.
+func (this *FaultInjectionEnv) SetReadOnly(readOnly bool) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.readOnly = readOnly
+}
+
+func (this *FaultInjectionEnv) maybeFail(op FaultOp, fname string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.readOnly {
+		return IOError(fname + ": read-only filesystem")
+	}
+	if this.failAfter[op] > 0 {
+		this.failAfter[op]--
+		if this.failAfter[op] == 0 {
+			return IOError(fname + ": injected " + faultOpNames[op] + " error")
+		}
+	}
+	if this.rnd != nil && this.rnd.Intn(this.oneIn) == 0 {
+		return IOError(fname + ": injected random " + faultOpNames[op] + " error")
+	}
+
+	return OK()
+}
+
+func (this *FaultInjectionEnv) checkWritable(fname string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if this.readOnly {
+		return IOError(fname + ": read-only filesystem")
+	}
+
+	return OK()
+}
+
+func (this *FaultInjectionEnv) NewWritableFile(fname string, result *WritableFile) Status {
+	*result = nil
+	s := this.maybeFail(FaultNewWritableFile, fname)
+	if !s.OK() {
+		return s
+	}
+
+	var target WritableFile
+	s = this.Env.NewWritableFile(fname, &target)
+	if s.OK() {
+		state := &faultFileState{
+			fname: fname,
+		}
+		*result = &faultWritableFile{
+			env: this,
+			state: state,
+			target: target,
+		}
+
+		// NewWritableFile starts the file over, so any state saved for
+		// an earlier incarnation of "fname" is replaced.
+		this.mutex.Lock()
+		this.dbFileState[fname] = state
+		this.newFilesSinceLastDirSync[fname] = true
+		this.mutex.Unlock()
+	}
+
+	return s
+}
+
+func (this *FaultInjectionEnv) NewAppendableFile(fname string, result *WritableFile) Status {
+	*result = nil
+	s := this.maybeFail(FaultNewWritableFile, fname)
+	if !s.OK() {
+		return s
+	}
+
+	existed := this.Env.FileExists(fname)
+	var target WritableFile
+	s = this.Env.NewAppendableFile(fname, &target)
+	if s.OK() {
+		this.mutex.Lock()
+		state, ok := this.dbFileState[fname]
+		if !ok {
+			// Whatever the file already holds was written before we
+			// started tracking it, so treat it as synced.
+			state = &faultFileState{
+				fname: fname,
+			}
+			if size, ss := this.Env.GetFileSize(fname); ss.OK() {
+				state.pos = size
+				state.posAtLastSync = size
+			}
+			this.dbFileState[fname] = state
+		}
+		if !existed {
+			this.newFilesSinceLastDirSync[fname] = true
+		}
+		this.mutex.Unlock()
+
+		*result = &faultWritableFile{
+			env: this,
+			state: state,
+			target: target,
+		}
+	}
+
+	return s
+}
+
+func (this *FaultInjectionEnv) DeleteFile(fname string) Status {
+	s := this.checkWritable(fname)
+	if !s.OK() {
+		return s
+	}
+
+	s = this.Env.DeleteFile(fname)
+	if s.OK() {
+		this.mutex.Lock()
+		delete(this.dbFileState, fname)
+		delete(this.newFilesSinceLastDirSync, fname)
+		this.mutex.Unlock()
+	}
+
+	return s
+}
+
+func (this *FaultInjectionEnv) CreateDir(dirname string) Status {
+	s := this.checkWritable(dirname)
+	if !s.OK() {
+		return s
+	}
+
+	return this.Env.CreateDir(dirname)
+}
+
+func (this *FaultInjectionEnv) DeleteDir(dirname string) Status {
+	s := this.checkWritable(dirname)
+	if !s.OK() {
+		return s
+	}
+
+	return this.Env.DeleteDir(dirname)
+}
+
+func (this *FaultInjectionEnv) RenameFile(src string, target string) Status {
+	s := this.maybeFail(FaultRename, src)
+	if !s.OK() {
+		return s
+	}
+
+	s = this.Env.RenameFile(src, target)
+	if s.OK() {
+		this.mutex.Lock()
+		delete(this.dbFileState, target)
+		if state, ok := this.dbFileState[src]; ok {
+			state.fname = target
+			this.dbFileState[target] = state
+			delete(this.dbFileState, src)
+		}
+		delete(this.newFilesSinceLastDirSync, target)
+		if this.newFilesSinceLastDirSync[src] {
+			delete(this.newFilesSinceLastDirSync, src)
+			this.newFilesSinceLastDirSync[target] = true
+		}
+		this.mutex.Unlock()
+	}
+
+	return s
+}
+
+func (this *FaultInjectionEnv) LockFile(fname string, lock *FileLock) Status {
+	*lock = nil
+	s := this.checkWritable(fname)
+	if !s.OK() {
+		return s
+	}
+
+	return this.Env.LockFile(fname, lock)
+}
+
+// Record that the directory holding "fname" has been synced: every
+// file created in it so far now survives a crash.
+func (this *FaultInjectionEnv) syncDir(fname string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	dir := filepath.Dir(fname)
+	for f := range this.newFilesSinceLastDirSync {
+		if filepath.Dir(f) == dir {
+			delete(this.newFilesSinceLastDirSync, f)
+		}
+	}
+}
+
+func (this *FaultInjectionEnv) isFileCreatedSinceLastDirSync(fname string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return this.newFilesSinceLastDirSync[fname]
+}
+
+// Truncate every file to the length it had when it was last synced.
+func (this *FaultInjectionEnv) DropUnsyncedFileData() Status {
+	this.mutex.Lock()
+	var states []faultFileState
+	for _, state := range this.dbFileState {
+		if !state.IsFullySynced() {
+			states = append(states, *state)
+		}
+	}
+	this.mutex.Unlock()
+
+	s := OK()
+	for _, state := range states {
+		if ds := this.truncate(state.fname, state.posAtLastSync); s.OK() {
+			s = ds
+		}
+	}
+
+	return s
+}
+
+func (this *FaultInjectionEnv) truncate(fname string, size int64) Status {
+	var data string
+	s := ReadFileToString(this.Env, fname, &data)
+	if !s.OK() {
+		return s
+	}
+	if int64(len(data)) <= size {
+		return OK()
+	}
+
+	return WriteStringToFile(this.Env, data[:size], fname)
+}
+
+// Delete the files whose directory entry was never synced.
+func (this *FaultInjectionEnv) DeleteFilesCreatedAfterLastDirSync() Status {
+	this.mutex.Lock()
+	var fnames []string
+	for fname := range this.newFilesSinceLastDirSync {
+		fnames = append(fnames, fname)
+	}
+	this.mutex.Unlock()
+
+	s := OK()
+	for _, fname := range fnames {
+		if ds := this.Env.DeleteFile(fname); s.OK() {
+			s = ds
+		}
+	}
+
+	return s
+}
+
+// Forget everything that has been written so far.
+func (this *FaultInjectionEnv) ResetState() {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.dbFileState = make(map[string]*faultFileState)
+	this.newFilesSinceLastDirSync = make(map[string]bool)
+	this.readOnly = false
+	this.failAfter = [kNumFaultOps]int{}
+	this.rnd = nil
+	this.oneIn = 0
+}
+
+// Leave the files as a power loss at this point would: drop unsynced
+// data and files that were never made durable, then reset all state
+// and injected failures.
+// REQUIRES: no DB is using this Env.
+func (this *FaultInjectionEnv) SimulateCrash() Status {
+	s := this.DropUnsyncedFileData()
+	if ds := this.DeleteFilesCreatedAfterLastDirSync(); s.OK() {
+		s = ds
+	}
+	this.ResetState()
+
+	return s
+}
+
+type faultWritableFile struct {
+	env *FaultInjectionEnv
+	state *faultFileState
+	target WritableFile
+}
+
+func (this *faultWritableFile) Append(data []byte) Status {
+	s := this.env.maybeFail(FaultAppend, this.state.fname)
+	if !s.OK() {
+		return s
+	}
+
+	s = this.target.Append(data)
+	if s.OK() {
+		this.env.mutex.Lock()
+		this.state.pos += int64(len(data))
+		this.env.mutex.Unlock()
+	}
+
+	return s
+}
+
+func (this *faultWritableFile) Close() Status {
+	return this.target.Close()
+}
+
+func (this *faultWritableFile) Flush() Status {
+	return this.target.Flush()
+}
+
+func (this *faultWritableFile) Sync() Status {
+	s := this.env.maybeFail(FaultSync, this.state.fname)
+	if !s.OK() {
+		return s
+	}
+
+	s = this.target.Sync()
+	if s.OK() {
+		this.env.mutex.Lock()
+		this.state.posAtLastSync = this.state.pos
+		fname := this.state.fname
+		this.env.mutex.Unlock()
+
+		// Ensure the new file itself is in the filesystem.
+		if this.env.isFileCreatedSinceLastDirSync(fname) {
+			this.env.syncDir(fname)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,269 @@
+package leveldb
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFaultInjectionFailAfter(t *testing.T) {
+	env := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	env.CreateDir("/dir")
+
+	env.SetFailAfter(FaultNewWritableFile, 1)
+	var file WritableFile
+	if s := env.NewWritableFile("/dir/f", &file); !errors.Is(s.Err(), ErrIOError) {
+		t.Fatalf("NewWritableFile: %v", s)
+	}
+	if s := env.NewWritableFile("/dir/f", &file); !s.OK() {
+		t.Fatal(s)
+	}
+
+	env.SetFailAfter(FaultAppend, 2)
+	for i, wantOK := range []bool{true, false, true} {
+		if s := file.Append([]byte("x")); s.OK() != wantOK {
+			t.Fatalf("Append #%d: %v", i + 1, s)
+		}
+	}
+	env.SetFailAfter(FaultSync, 1)
+	if s := file.Sync(); s.OK() {
+		t.Fatal("Sync succeeded")
+	}
+	file.Close()
+
+	env.SetFailAfter(FaultRename, 1)
+	env.SetFailAfter(FaultRename, 0)
+	if s := env.RenameFile("/dir/f", "/dir/g"); !s.OK() {
+		t.Fatalf("RenameFile with the failure disabled: %v", s)
+	}
+}
+
+func TestFaultInjectionReadOnly(t *testing.T) {
+	env := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	env.CreateDir("/dir")
+	if s := WriteStringToFile(env, "data", "/dir/f"); !s.OK() {
+		t.Fatal(s)
+	}
+
+	env.SetReadOnly(true)
+	var file WritableFile
+	if s := env.NewWritableFile("/dir/g", &file); s.OK() {
+		t.Error("NewWritableFile succeeded")
+	}
+	if s := env.NewAppendableFile("/dir/f", &file); s.OK() {
+		t.Error("NewAppendableFile succeeded")
+	}
+	if s := env.RenameFile("/dir/f", "/dir/g"); s.OK() {
+		t.Error("RenameFile succeeded")
+	}
+	if s := env.DeleteFile("/dir/f"); s.OK() {
+		t.Error("DeleteFile succeeded")
+	}
+	var data string
+	if s := ReadFileToString(env, "/dir/f", &data); !s.OK() || data != "data" {
+		t.Errorf("read %q: %v", data, s)
+	}
+
+	env.SetReadOnly(false)
+	if s := env.DeleteFile("/dir/f"); !s.OK() {
+		t.Error(s)
+	}
+}
+
+func TestFaultInjectionSimulateCrash(t *testing.T) {
+	env := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	env.CreateDir("/dir")
+
+	var synced, unsynced WritableFile
+	env.NewWritableFile("/dir/synced", &synced)
+	synced.Append([]byte("durable"))
+	synced.Sync()
+	synced.Append([]byte(" lost"))
+	synced.Close()
+	env.NewWritableFile("/dir/unsynced", &unsynced)
+	unsynced.Append([]byte("lost"))
+	unsynced.Close()
+
+	if s := env.SimulateCrash(); !s.OK() {
+		t.Fatal(s)
+	}
+	var data string
+	if s := ReadFileToString(env, "/dir/synced", &data); !s.OK() || data != "durable" {
+		t.Errorf("synced file holds %q: %v", data, s)
+	}
+	if env.FileExists("/dir/unsynced") {
+		t.Error("a file that was never synced survived the crash")
+	}
+}
+
+func TestFaultInjectionDBCrash(t *testing.T) {
+	env := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	options := newTestOptions(env)
+	impl := openTestDB(t, "/crash", options)
+	if err := impl.Put([]byte("synced"), []byte("v1"), &WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+	mustPut(t, impl, "unsynced", "v2")
+	impl.Close()
+
+	if s := env.SimulateCrash(); !s.OK() {
+		t.Fatal(s)
+	}
+	impl = openTestDB(t, "/crash", options)
+	defer impl.Close()
+	if got := getValue(t, impl, "synced", nil); got != "v1" {
+		t.Errorf("synced write: %s", got)
+	}
+	if got := getValue(t, impl, "unsynced", nil); got != "NOT_FOUND" {
+		t.Errorf("unsynced write survived the crash: %s", got)
+	}
+}
+
+// Open "/reuse" with ReuseLogs, check that it holds exactly the keys
+// of "want" (each mapped to "v" + key), and return it.
+func openReusingLogs(t *testing.T, env Env, paranoid bool, want ...string) *dbImpl {
+	t.Helper()
+	options := newTestOptions(env)
+	options.ReuseLogs = true
+	options.ParanoidChecks = paranoid
+	impl := openTestDB(t, "/reuse", options)
+
+	var got []string
+	iter := impl.NewIterator(nil)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.Value()) != "v" + string(iter.Key()) {
+			t.Errorf("%s = %q", iter.Key(), iter.Value())
+		}
+		got = append(got, string(iter.Key()))
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("DB holds %q, want %q", got, want)
+	}
+
+	return impl
+}
+
+func syncPut(t *testing.T, impl *dbImpl, key string) {
+	t.Helper()
+	if err := impl.Put([]byte(key), []byte("v" + key), &WriteOptions{Sync: true}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReuseLogs(t *testing.T) {
+	env := NewFaultInjectionEnv(NewMemEnv(DefaultEnv()))
+	impl := openReusingLogs(t, env, false)
+	syncPut(t, impl, "a")
+	logNumber := impl.logFileNumber
+	impl.Close()
+
+	// The log is appended to instead of being flushed to a table.
+	impl = openReusingLogs(t, env, false, "a")
+	if impl.logFileNumber != logNumber || filesPerLevel(t, impl) != "" {
+		t.Fatalf("log %d and files %q after reopening, want log %d and no files",
+			impl.logFileNumber, filesPerLevel(t, impl), logNumber)
+	}
+	syncPut(t, impl, "b")
+	mustPut(t, impl, "c", "vc")	// Not synced
+	impl.Close()
+
+	// A crash loses what was appended but not synced.
+	if s := env.SimulateCrash(); !s.OK() {
+		t.Fatal(s)
+	}
+	impl = openReusingLogs(t, env, false, "a", "b")
+	if impl.logFileNumber != logNumber {
+		t.Fatalf("log %d after the crash, want %d", impl.logFileNumber, logNumber)
+	}
+	syncPut(t, impl, "d")
+	impl.Close()
+
+	impl = openReusingLogs(t, env, false, "a", "b", "d")
+	impl.Close()
+}
+
+// Damage the tail of the only log of "/reuse" with "damage", which is
+// given the contents of the log and returns its new contents.
+func damageLog(t *testing.T, env Env, damage func(contents string) string) {
+	t.Helper()
+	filenames, _ := env.GetChildren("/reuse")
+	var number uint64
+	var fileType FileType
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) && fileType == kLogFile {
+			fname := "/reuse/" + filename
+			var contents string
+			if s := ReadFileToString(env, fname, &contents); !s.OK() {
+				t.Fatal(s)
+			}
+			if s := WriteStringToFile(env, damage(contents), fname); !s.OK() {
+				t.Fatal(s)
+			}
+			return
+		}
+	}
+	t.Fatal("no log")
+}
+
+func TestReuseLogsAfterDamagedTail(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		damage func(contents string) string
+	}{
+		{"truncated record", func(contents string) string {
+			return contents[:len(contents) - 3]
+		}},
+		{"truncated header", func(contents string) string {
+			return contents + "\x01\x02\x03"
+		}},
+		{"corrupt record", func(contents string) string {
+			b := []byte(contents)
+			b[len(b) - 1] ^= 0xff
+			return string(b)
+		}},
+	} {
+		base := NewMemEnv(DefaultEnv())
+		env := NewFaultInjectionEnv(base)
+		impl := openReusingLogs(t, env, false)
+		syncPut(t, impl, "a")
+		syncPut(t, impl, "b")
+		logNumber := impl.logFileNumber
+		impl.Close()
+		damageLog(t, base, c.damage)
+
+		// The damaged record is dropped, and what is appended after it
+		// must survive the next recovery.
+		want := []string{"a"}
+		if c.name == "truncated header" {
+			want = append(want, "b")
+		}
+		impl = openReusingLogs(t, env, false, want...)
+		if impl.logFileNumber == logNumber {
+			t.Errorf("%s: the damaged log was reused", c.name)
+		}
+		syncPut(t, impl, "c")
+		impl.Close()
+		impl = openReusingLogs(t, env, false, append(want, "c")...)
+		impl.Close()
+	}
+
+	// With ParanoidChecks, a corrupt record makes recovery fail.
+	base := NewMemEnv(DefaultEnv())
+	impl := openReusingLogs(t, base, true)
+	syncPut(t, impl, "a")
+	impl.Close()
+	damageLog(t, base, func(contents string) string {
+		b := []byte(contents)
+		b[len(b) - 1] ^= 0xff
+		return string(b)
+	})
+	options := newTestOptions(base)
+	options.ReuseLogs = true
+	options.ParanoidChecks = true
+	if _, err := Open("/reuse", options); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("paranoid open of a corrupt log: %v", err)
+	}
+}
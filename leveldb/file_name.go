@@ -1,9 +1,15 @@
 package leveldb
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type FileType int
 
 const (
-	kLogFile = iota
+	kLogFile FileType = iota
 	kDBLockFile
 	kTableFile
 	kDescriptorFile
@@ -24,6 +30,48 @@ func LogFileName(name string, number uint64) string {
 	return makeFileName(name, number, "log");
 }
 
+// Return the name of the sstable with the specified number
+// in the db named by "dbname".  The result will be prefixed with
+// "dbname".
+func TableFileName(name string, number uint64) string {
+	return makeFileName(name, number, "ldb");
+}
+
+// Return the legacy file name for an sstable with the specified number
+// in the db named by "dbname". The result will be prefixed with
+// "dbname".
+func SSTTableFileName(name string, number uint64) string {
+	return makeFileName(name, number, "sst");
+}
+
+// Return the name of the descriptor file for the db named by
+// "dbname" and the specified incarnation number.  The result will be
+// prefixed with "dbname".
+func DescriptorFileName(name string, number uint64) string {
+	// assert(number > 0)
+	return fmt.Sprintf("%s/MANIFEST-%06d", name, number)
+}
+
+// Return the name of the current file.  This file contains the name
+// of the current manifest file.  The result will be prefixed with
+// "dbname".
+func CurrentFileName(name string) string {
+	return name + "/CURRENT"
+}
+
+// Return the name of the lock file for the db named by
+// "dbname".  The result will be prefixed with "dbname".
+func LockFileName(name string) string {
+	return name + "/LOCK"
+}
+
+// Return the name of a temporary file owned by the db named "dbname".
+// The result will be prefixed with "dbname".
+func TempFileName(name string, number uint64) string {
+	// assert(number > 0)
+	return makeFileName(name, number, "dbtmp")
+}
+
 // Return the name of the info log file for "dbname".
 func InfoLogFileName(name string) string {
 	return name + "/LOG";
@@ -32,4 +80,91 @@ func InfoLogFileName(name string) string {
 // Return the name of the old info log file for "dbname".
 func OldInfoLogFileName(name string) string {
 	return name + "/LOG.old";
-}
\ No newline at end of file
+}
+
+// Owned filenames have the form:
+//    dbname/CURRENT
+//    dbname/LOCK
+//    dbname/LOG
+//    dbname/LOG.old
+//    dbname/MANIFEST-[0-9]+
+//    dbname/[0-9]+.(log|sst|ldb)
+//
+// If filename is a leveldb file, store the type of the file in *type.
+// The number encoded in the filename is stored in *number.  If the
+// filename was successfully parsed, returns true.  Else return false.
+func ParseFileName(fname string, number *uint64, fileType *FileType) bool {
+	rest := fname
+	if rest == "CURRENT" {
+		*number = 0
+		*fileType = kCurrentFile
+	} else if rest == "LOCK" {
+		*number = 0
+		*fileType = kDBLockFile
+	} else if rest == "LOG" || rest == "LOG.old" {
+		*number = 0
+		*fileType = kInfoLogFile
+	} else if strings.HasPrefix(rest, "MANIFEST-") {
+		num, ok := consumeDecimalNumber(&rest, len("MANIFEST-"))
+		if !ok || rest != "" {
+			return false
+		}
+		*fileType = kDescriptorFile
+		*number = num
+	} else {
+		num, ok := consumeDecimalNumber(&rest, 0)
+		if !ok {
+			return false
+		}
+
+		switch rest {
+		case ".log":
+			*fileType = kLogFile
+		case ".sst", ".ldb":
+			*fileType = kTableFile
+		case ".dbtmp":
+			*fileType = kTempFile
+		default:
+			return false
+		}
+		*number = num
+	}
+
+	return true
+}
+
+// Parse the decimal number that starts at *in[offset:], and leave the
+// rest of the string in *in.
+func consumeDecimalNumber(in *string, offset int) (uint64, bool) {
+	digits := (*in)[offset:]
+	end := 0
+	for end < len(digits) && digits[end] >= '0' && digits[end] <= '9' {
+		end++
+	}
+
+	num, err := strconv.ParseUint(digits[:end], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	*in = digits[end:]
+	return num, true
+}
+
+// Make the CURRENT file point to the descriptor file with the
+// specified number.
+func SetCurrentFile(env Env, dbName string, descriptorNumber uint64) Status {
+	// Remove leading "dbname/" and add newline to manifest file name
+	manifest := DescriptorFileName(dbName, descriptorNumber)
+	contents := strings.TrimPrefix(manifest, dbName + "/")
+	tmp := TempFileName(dbName, descriptorNumber)
+	s := WriteStringToFileSync(env, contents + "\n", tmp)
+	if s.OK() {
+		s = env.RenameFile(tmp, CurrentFileName(dbName))
+	}
+	if !s.OK() {
+		env.DeleteFile(tmp)
+	}
+
+	return s
+}
@@ -9,7 +9,10 @@ const (
 
 type FilterBlockReader struct {
 	policy FilterPolicy
-	
+	data []byte	// Filter data (at block-start)
+	offset []byte	// Beginning of offset array (at block-end)
+	num uint64	// Number of entries in offset array
+	baseLg uint	// Encoding parameter (see kFilterBaseLg)
 }
 
 type FilterBlockBuilder struct {
@@ -40,10 +43,85 @@ func (this *FilterBlockBuilder) AddKey(key []byte) {
 	this.keys = append(this.keys, key ...)
 }
 
+func (this *FilterBlockBuilder) Finish() []byte {
+	if len(this.start) != 0 {
+		this.generateFilter()
+	}
+
+	// Append array of per-filter offsets
+	arrayOffset := len(this.result)
+	buf := make([]byte, 4)
+	for _, offset := range this.filterOffsets {
+		encodeFixed32(buf, uint32(offset))
+		this.result += string(buf)
+	}
+
+	encodeFixed32(buf, uint32(arrayOffset))
+	this.result += string(buf)
+	this.result += string([]byte{kFilterBaseLg})	// Save encoding parameter in result
+
+	return []byte(this.result)
+}
+
 func (this *FilterBlockBuilder) generateFilter() {
 	numKeys := len(this.start)
 	if (numKeys == 0) {
 		// Fast path if there are no keys for this filter
 		this.filterOffsets = append(this.filterOffsets, len(this.result) )
+		return
+	}
+
+	// Make list of keys from flattened key structure
+	this.start = append(this.start, len(this.keys))	// Simplify length computation
+	this.tmpKeys = this.tmpKeys[:0]
+	for i := 0; i < numKeys; i++ {
+		this.tmpKeys = append(this.tmpKeys, string(this.keys[this.start[i]:this.start[i + 1]]))
 	}
+
+	// Generate filter for current set of keys and append to result.
+	this.filterOffsets = append(this.filterOffsets, len(this.result))
+	this.policy.CreateFilter(this.tmpKeys, &this.result)
+
+	this.tmpKeys = this.tmpKeys[:0]
+	this.keys = this.keys[:0]
+	this.start = this.start[:0]
+}
+
+// REQUIRES: "contents" and "policy" must stay live while the result
+// is live.
+func newFilterBlockReader(policy FilterPolicy, contents []byte) *FilterBlockReader {
+	result := &FilterBlockReader{
+		policy: policy,
+	}
+	n := uint64(len(contents))
+	if n < 5 {
+		return result	// 1 byte for baseLg and 4 for start of offset array
+	}
+	result.baseLg = uint(contents[n - 1])
+	lastWord := uint64(decodeFixed32(bytesToString(contents[n - 5:])))
+	if lastWord > n - 5 {
+		return result
+	}
+	result.data = contents[:lastWord]
+	result.offset = contents[lastWord:]
+	result.num = (n - 5 - lastWord) / 4
+
+	return result
+}
+
+func (this *FilterBlockReader) KeyMayMatch(blockOffset uint64, key string) bool {
+	index := blockOffset >> this.baseLg
+	if index < this.num {
+		start := uint64(decodeFixed32(bytesToString(this.offset[index * 4:])))
+		limit := uint64(decodeFixed32(bytesToString(this.offset[index * 4 + 4:])))
+		if start <= limit && limit <= uint64(len(this.data)) {
+			filter := this.data[start:limit]
+			return this.policy.KeyMayMatch(key, bytesToString(filter))
+		} else if start == limit {
+			// Empty filters do not match any keys
+			return false
+		}
+	}
+
+	return true	// Errors are treated as potential matches
 }
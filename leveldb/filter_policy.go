@@ -26,7 +26,7 @@ func (this *internalFilterPolicy) CreateFilter(keys []string, dst *string) {
 }
 
 func (this *internalFilterPolicy) KeyMayMatch(key string, filter string) bool {
-	return this.userPolicy.KeyMayMatch(key, filter)
+	return this.userPolicy.KeyMayMatch(extractUserKey(key), filter)
 }
 
 func makeInternalFilterPolicy(p FilterPolicy) *internalFilterPolicy {
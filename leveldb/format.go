@@ -1,12 +1,49 @@
 package leveldb
 
-import "encoding/binary"
+import (
+	"./utilties"
+)
 // Maximum encoding length of a BlockHandle
 const kMaxEncodedLength = 10 + 10
 
 // 1 byte type + 32bit crc
 const kBlockTrailerSize = 5
 
+// kTableMagicNumber was picked by running
+//    echo http://code.google.com/p/leveldb/ | sha1sum
+// and taking the leading 64 bits.
+const kTableMagicNumber uint64 = 0xdb4775248b80fb57
+
+// Encoded length of a Footer.  Note that the serialization of a
+// Footer will always occupy exactly this many bytes.  It consists
+// of two block handles and a magic number.
+const kEncodedLength = 2 * kMaxEncodedLength + 8
+
+// Key of the metaindex entry that holds the name of the comparator the
+// keys of a table are ordered by.  C++ LevelDB ignores the entry.
+const kComparatorMetaKey = "leveldb.comparator"
+
+// Footer encapsulates the fixed information stored at the tail
+// end of every table file.
+type Footer struct {
+	metaindexHandle BlockHandle
+	indexHandle BlockHandle
+}
+
+func (this *Footer) EncodeTo(dst *[]byte) {
+	original := len(*dst)
+	this.metaindexHandle.EncodeTo(dst)
+	this.indexHandle.EncodeTo(dst)
+
+	// Padding
+	for len(*dst) < original + 2 * kMaxEncodedLength {
+		*dst = append(*dst, 0)
+	}
+
+	putFixed32(dst, uint32(kTableMagicNumber & 0xffffffff))
+	putFixed32(dst, uint32(kTableMagicNumber >> 32))
+}
+
 type BlockContents struct {
 	data []byte
 	cachable bool
@@ -19,31 +56,103 @@ type BlockHandle struct {
 }
 
 func (this *BlockHandle) EncodeTo(dst *[]byte) {
-	tmp := make([]byte, 8)
+	putVarint64(dst, this.offset)
+	putVarint64(dst, this.size)
+}
 
-	l := binary.PutUvarint(tmp, this.offset)
-	*dst = append(*dst, tmp[:l] ...)
+func (this *BlockHandle) DecodeFrom(input *[]byte) Status {
+	if getVarint64(input, &this.offset) && getVarint64(input, &this.size) {
+		return OK()
+	}
 
-	l = binary.PutUvarint(tmp[0:], this.size)
-	*dst = append(*dst, tmp[:l] ...)
-	
+	return Corruption("bad block handle")
 }
 
-func (this *BlockHandle) DecodeFrom(input []byte) Status {
-	value, n := binary.Uvarint(input)
-	if n > 0 {
-		this.offset = value
-	} else {
-		return Corruption("bad block handle")
+func (this *Footer) DecodeFrom(input *[]byte) Status {
+	if len(*input) < kEncodedLength {
+		return Corruption("not an sstable (footer too short)")
 	}
 
-	value, n = binary.Uvarint(input[n:])
+	magicPtr := bytesToString((*input)[kEncodedLength - 8:])
+	magicLo := decodeFixed32(magicPtr)
+	magicHi := decodeFixed32(magicPtr[4:])
+	magic := (uint64(magicHi) << 32) | uint64(magicLo)
+	if magic != kTableMagicNumber {
+		return Corruption("not an sstable (bad magic number)")
+	}
+
+	handles := (*input)[:kEncodedLength - 8]
+	s := this.metaindexHandle.DecodeFrom(&handles)
+	if s.OK() {
+		s = this.indexHandle.DecodeFrom(&handles)
+	}
+
+	if s.OK() {
+		// We skip over any leftover data (just padding for now) in "input"
+		*input = (*input)[kEncodedLength:]
+	}
+
+	return s
+}
+
+// Read the block identified by "handle" from "file".  On failure
+// return non-OK.  On success fill *result and return OK.
+func ReadBlock(file RandomAccessFile, options *ReadOptions, handle *BlockHandle, result *BlockContents) Status {
+	result.data = nil
+	result.cachable = false
+	result.heapAllocated = false
+
+	// Read the block contents as well as the type/crc footer.
+	// See table_builder.go for the code that built this structure.
+	n := int(handle.size)
+	buf := make([]byte, n + kBlockTrailerSize)
+	var contents []byte
+	s := file.Read(int64(handle.offset), buf, &contents)
+	if !s.OK() {
+		return s
+	}
+	if len(contents) != n + kBlockTrailerSize {
+		return Corruption("truncated block read")
+	}
+
+	// Check the crc of the type and the block contents
+	data := contents	// Pointer to where Read put the data
+	if options.VerifyChecksums {
+		crc := utilties.Unmask(decodeFixed32(bytesToString(data[n + 1 : n + 5])))
+		actual := utilties.Value(data[:n + 1])
+		if actual != crc {
+			return Corruption("block checksum mismatch")
+		}
+	}
 
-	if n > 0 {
-		this.size = value
+	compressionType := CompressionType(data[n])
+	if compressionType == NoCompression {
+		if &data[0] != &buf[0] {
+			// File implementation gave us pointer to some other data.
+			// Use it directly under the assumption that it will be live
+			// while the file is open.
+			result.data = data[:n]
+			result.heapAllocated = false
+			result.cachable = false	// Do not double-cache
+		} else {
+			result.data = buf[:n]
+			result.heapAllocated = true
+			result.cachable = true
+		}
 	} else {
-		Corruption("bad block handle")
+		compressor := GetCompressor(compressionType)
+		if compressor == nil {
+			return Corruption("bad block type")
+		}
+		var ubuf []byte
+		s = compressor.Uncompress(data[:n], &ubuf)
+		if !s.OK() {
+			return s
+		}
+		result.data = ubuf
+		result.heapAllocated = true
+		result.cachable = true
 	}
 
-	return OK()	
+	return OK()
 }
\ No newline at end of file
@@ -0,0 +1,574 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+	"./utilties"
+)
+
+// The expected bytes below are assembled by hand from the LevelDB table
+// and log format specifications (doc/table_format.md and doc/log_format.md
+// of C++ LevelDB) and from the VersionEdit and WriteBatch encodings of
+// db/version_edit.cc and db/write_batch.cc, not with the encoders under
+// test.
+
+// Return the block trailer C++ LevelDB writes after "block": the type
+// byte, then the masked crc32c of the block and the type.
+func goldenTrailer(block []byte, compressionType byte) []byte {
+	crc := crc32.Update(0, crc32.MakeTable(crc32.Castagnoli), block)
+	crc = crc32.Update(crc, crc32.MakeTable(crc32.Castagnoli), []byte{compressionType})
+	return append([]byte{compressionType}, goldenFixed32(goldenMask(crc))...)
+}
+
+func goldenMask(crc uint32) uint32 {
+	return ((crc >> 15) | (crc << 17)) + 0xa282ead8
+}
+
+func goldenFixed32(v uint32) []byte {
+	return []byte{byte(v), byte(v >> 8), byte(v >> 16), byte(v >> 24)}
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// A data block holding apple=red and banana=yellow, with one restart.
+var goldenDataBlock = concat(
+	[]byte{0, 5, 3}, []byte("apple"), []byte("red"),
+	[]byte{0, 6, 6}, []byte("banana"), []byte("yellow"),
+	goldenFixed32(0),	// Restart array
+	goldenFixed32(1),	// Number of restarts
+)
+
+// The metaindex block maps "leveldb.comparator" to the comparator name.
+var goldenMetaindexBlock = concat(
+	[]byte{0, 18, 26}, []byte("leveldb.comparator"), []byte("leveldb.BytewiseComparator"),
+	goldenFixed32(0),
+	goldenFixed32(1),
+)
+
+// The index block maps "c", the short successor of "banana", to the
+// handle of the data block: offset 0, size 34.
+var goldenIndexBlock = concat(
+	[]byte{0, 1, 2}, []byte("c"), []byte{0, 34},
+	goldenFixed32(0),
+	goldenFixed32(1),
+)
+
+// The data block sits at offset 0, the metaindex block at 39 and the
+// index block at 99; each block is followed by its 5-byte trailer.
+var goldenFooter = concat(
+	[]byte{39, 55},	// Metaindex handle
+	[]byte{99, 14},	// Index handle
+	make([]byte, 2 * kMaxEncodedLength - 4),	// Padding
+	[]byte{0x57, 0xfb, 0x80, 0x8b, 0x24, 0x75, 0x47, 0xdb},	// Magic number
+)
+
+var goldenTable = concat(
+	goldenDataBlock, goldenTrailer(goldenDataBlock, 0),
+	goldenMetaindexBlock, goldenTrailer(goldenMetaindexBlock, 0),
+	goldenIndexBlock, goldenTrailer(goldenIndexBlock, 0),
+	goldenFooter,
+)
+
+func goldenTableOptions() *Options {
+	options := NewOptions()
+	options.Compression = NoCompression
+	options.ParanoidChecks = true
+	return options
+}
+
+func TestCRC32C(t *testing.T) {
+	// From the crc32c test vectors of RFC 3720 and C++ LevelDB.
+	if got := crc32.Checksum([]byte("123456789"), crc32.MakeTable(crc32.Castagnoli)); got != 0xe3069283 {
+		t.Fatalf("stdlib crc32c = %#x", got)
+	}
+	tests := []struct {
+		data []byte
+		want uint32
+	}{
+		{make([]byte, 32), 0x8a9136aa},
+		{bytes.Repeat([]byte{0xff}, 32), 0x62a8ab43},
+		{[]byte("123456789"), 0xe3069283},
+	}
+	for _, test := range tests {
+		if got := utilties.Value(test.data); got != test.want {
+			t.Errorf("crc32c(%x) = %#x, want %#x", test.data, got, test.want)
+		}
+		if got := utilties.Mask(test.want); got != goldenMask(test.want) {
+			t.Errorf("Mask(%#x) = %#x, want %#x", test.want, got, goldenMask(test.want))
+		}
+	}
+}
+
+func TestBlockFormat(t *testing.T) {
+	options := goldenTableOptions()
+	options.BlockRestartInterval = 2
+	builder := newBlockBuilder(options)
+	builder.Add([]byte("apple"), []byte("red"))
+	builder.Add([]byte("apricot"), []byte("orange"))
+	builder.Add([]byte("apricots"), []byte(""))	// Starts the second restart
+	builder.Add([]byte("apricotz"), []byte("x"))
+	want := concat(
+		[]byte{0, 5, 3}, []byte("apple"), []byte("red"),
+		[]byte{2, 5, 6}, []byte("ricot"), []byte("orange"),
+		[]byte{0, 8, 0}, []byte("apricots"),
+		[]byte{7, 1, 1}, []byte("z"), []byte("x"),
+		goldenFixed32(0), goldenFixed32(25),
+		goldenFixed32(2),
+	)
+	if got := builder.Finish(); !bytes.Equal(got, want) {
+		t.Fatalf("block\n got %x\nwant %x", got, want)
+	}
+
+	// Lengths of 128 and more take multi-byte varints.
+	builder = newBlockBuilder(goldenTableOptions())
+	builder.Add([]byte(strings.Repeat("k", 200)), []byte(strings.Repeat("v", 300)))
+	want = concat(
+		[]byte{0, 0xc8, 0x01, 0xac, 0x02},
+		[]byte(strings.Repeat("k", 200)), []byte(strings.Repeat("v", 300)),
+		goldenFixed32(0), goldenFixed32(1),
+	)
+	if got := builder.Finish(); !bytes.Equal(got, want) {
+		t.Fatalf("block with long entries\n got %x\nwant %x", got, want)
+	}
+}
+
+func TestFooterFormat(t *testing.T) {
+	footer := Footer{
+		metaindexHandle: BlockHandle{offset: 39, size: 55},
+		indexHandle: BlockHandle{offset: 99, size: 14},
+	}
+	var encoding []byte
+	footer.EncodeTo(&encoding)
+	if !bytes.Equal(encoding, goldenFooter) || len(encoding) != kEncodedLength {
+		t.Fatalf("footer\n got %x\nwant %x", encoding, goldenFooter)
+	}
+
+	var decoded Footer
+	input := goldenFooter
+	if s := decoded.DecodeFrom(&input); !s.OK() || decoded != footer {
+		t.Fatalf("decoded %+v: %v", decoded, s)
+	}
+
+	badMagic := concat(goldenFooter[:kEncodedLength - 1], []byte{0})
+	if s := decoded.DecodeFrom(&badMagic); !s.IsCorruption() {
+		t.Fatalf("footer with a bad magic number: %v", s)
+	}
+}
+
+func TestTableFormat(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	env.CreateDir("/dir")
+	var file WritableFile
+	if s := env.NewWritableFile("/dir/table.ldb", &file); !s.OK() {
+		t.Fatal(s)
+	}
+	builder := NewTableBuilder(goldenTableOptions(), file)
+	builder.Add("apple", "red")
+	builder.Add("banana", "yellow")
+	if s := builder.Finish(); !s.OK() {
+		t.Fatal(s)
+	}
+	file.Close()
+
+	var got string
+	if s := ReadFileToString(env, "/dir/table.ldb", &got); !s.OK() {
+		t.Fatal(s)
+	}
+	if got != string(goldenTable) {
+		t.Fatalf("table\n got %x\nwant %x", got, goldenTable)
+	}
+}
+
+// Open the table in "contents" and check that it holds exactly "want",
+// in order.
+func checkTableContents(t *testing.T, options *Options, contents []byte, want [][2]string) {
+	t.Helper()
+	env := NewMemEnv(DefaultEnv())
+	env.CreateDir("/dir")
+	if s := WriteStringToFile(env, string(contents), "/dir/table.ldb"); !s.OK() {
+		t.Fatal(s)
+	}
+	var file RandomAccessFile
+	if s := env.NewRandomAccessFile("/dir/table.ldb", &file); !s.OK() {
+		t.Fatal(s)
+	}
+	defer file.Close()
+	var table *Table
+	if s := OpenTable(options, file, uint64(len(contents)), &table); !s.OK() {
+		t.Fatal(s)
+	}
+
+	iter := table.NewIterator(&ReadOptions{VerifyChecksums: true})
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if i >= len(want) || iter.Key() != want[i][0] || iter.Value() != want[i][1] {
+			t.Fatalf("entry %d is %q=%q", i, iter.Key(), iter.Value())
+		}
+		i++
+	}
+	if !iter.Status().OK() || i != len(want) {
+		t.Fatalf("read %d of %d entries: %v", i, len(want), iter.Status())
+	}
+	for _, kv := range want {
+		iter.Seek(kv[0])
+		if !iter.Valid() || iter.Key() != kv[0] || iter.Value() != kv[1] {
+			t.Fatalf("Seek(%q) failed", kv[0])
+		}
+	}
+}
+
+func TestReadGoldenTable(t *testing.T) {
+	checkTableContents(t, goldenTableOptions(), goldenTable, [][2]string{
+		{"apple", "red"},
+		{"banana", "yellow"},
+	})
+
+	// A table without the comparator entry, as C++ LevelDB writes it.
+	metaindex := concat(goldenFixed32(0), goldenFixed32(1))
+	index := goldenIndexBlock
+	table := concat(
+		goldenDataBlock, goldenTrailer(goldenDataBlock, 0),
+		metaindex, goldenTrailer(metaindex, 0),
+		index, goldenTrailer(index, 0),
+		[]byte{39, 8}, []byte{52, 14},
+		make([]byte, 2 * kMaxEncodedLength - 4),
+		goldenFooter[2 * kMaxEncodedLength:],
+	)
+	checkTableContents(t, goldenTableOptions(), table, [][2]string{
+		{"apple", "red"},
+		{"banana", "yellow"},
+	})
+}
+
+// Tables written through the DB, with every compression type, must hold
+// every key that was written.
+func TestReadTablesWrittenByDB(t *testing.T) {
+	for _, compressionType := range []CompressionType{NoCompression, SnappyCompression, FlateCompression, LZ4Compression} {
+		env := NewMemEnv(DefaultEnv())
+		options := newTestOptions(env)
+		options.Compression = compressionType
+		options.BlockSize = 256
+		impl := openTestDB(t, "/tables", options)
+		want := make(map[string]string)
+		for i := 0; i < 1000; i++ {
+			key, value := fmt.Sprintf("key%06d", i), fmt.Sprintf("value%d-%s", i, strings.Repeat("x", i % 50))
+			mustPut(t, impl, key, value)
+			want[key] = value
+		}
+		if s := impl.compactMemTableAndWait(); !s.OK() {
+			t.Fatal(s)
+		}
+		icmp := impl.internalKeyComparator
+		if err := impl.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		filenames, _ := env.GetChildren("/tables")
+		tableOptions := goldenTableOptions()
+		tableOptions.Comparator = icmp
+		got := make(map[string]string)
+		var number uint64
+		var fileType FileType
+		for _, filename := range filenames {
+			if !ParseFileName(filename, &number, &fileType) || fileType != kTableFile {
+				continue
+			}
+			var contents string
+			if s := ReadFileToString(env, "/tables/" + filename, &contents); !s.OK() {
+				t.Fatal(s)
+			}
+			var file RandomAccessFile
+			env.NewRandomAccessFile("/tables/" + filename, &file)
+			var table *Table
+			if s := OpenTable(tableOptions, file, uint64(len(contents)), &table); !s.OK() {
+				t.Fatalf("compression %d: %v", compressionType, s)
+			}
+			iter := table.NewIterator(&ReadOptions{VerifyChecksums: true})
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				got[extractUserKey(iter.Key())] = iter.Value()
+			}
+			if !iter.Status().OK() {
+				t.Fatalf("compression %d: %v", compressionType, iter.Status())
+			}
+			iter.Close()
+			file.Close()
+		}
+		if len(got) != len(want) {
+			t.Fatalf("compression %d: read %d keys, want %d", compressionType, len(got), len(want))
+		}
+		for key, value := range want {
+			if got[key] != value {
+				t.Fatalf("compression %d: %s=%q, want %q", compressionType, key, got[key], value)
+			}
+		}
+	}
+}
+
+func goldenLogRecord(recordType byte, data []byte) []byte {
+	crc := crc32.Update(0, crc32.MakeTable(crc32.Castagnoli), []byte{recordType})
+	crc = crc32.Update(crc, crc32.MakeTable(crc32.Castagnoli), data)
+	header := concat(goldenFixed32(goldenMask(crc)), []byte{byte(len(data)), byte(len(data) >> 8), recordType})
+	return concat(header, data)
+}
+
+func TestLogFormat(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	env.CreateDir("/dir")
+	var file WritableFile
+	if s := env.NewWritableFile("/dir/log", &file); !s.OK() {
+		t.Fatal(s)
+	}
+	writer := newLogWriter(&file)
+
+	// The first record leaves 4 bytes in the block, too few for a header,
+	// so they are zero-filled.  The last record spans two blocks.
+	first := bytes.Repeat([]byte("a"), kLogBlockSize - kHeaderSize - 4)
+	last := bytes.Repeat([]byte("b"), kLogBlockSize)
+	records := [][]byte{first, []byte("foo"), []byte(""), last}
+	for _, record := range records {
+		if s := writer.AddRecord(record); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+	file.Close()
+
+	fragment := kLogBlockSize - 2 * kHeaderSize - 3 - kHeaderSize
+	want := concat(
+		goldenLogRecord(kFullType, first),
+		make([]byte, 4),
+		goldenLogRecord(kFullType, []byte("foo")),
+		goldenLogRecord(kFullType, nil),
+		goldenLogRecord(kFirstType, last[:fragment]),
+		goldenLogRecord(kLastType, last[fragment:]),
+	)
+	var got string
+	if s := ReadFileToString(env, "/dir/log", &got); !s.OK() {
+		t.Fatal(s)
+	}
+	if got != string(want) {
+		t.Fatalf("log of %d bytes differs from the expected %d bytes", len(got), len(want))
+	}
+
+	var sequential SequentialFile
+	if s := env.NewSequentialFile("/dir/log", &sequential); !s.OK() {
+		t.Fatal(s)
+	}
+	defer sequential.Close()
+	reader := newLogReader(sequential, nil, true, 0)
+	var record, scratch []byte
+	for i, want := range records {
+		if !reader.ReadRecord(&record, &scratch) || !bytes.Equal(record, want) {
+			t.Fatalf("record %d differs", i)
+		}
+	}
+	if reader.ReadRecord(&record, &scratch) {
+		t.Fatal("read a record past the end")
+	}
+}
+
+func goldenFixed64(v uint64) []byte {
+	return concat(goldenFixed32(uint32(v)), goldenFixed32(uint32(v >> 32)))
+}
+
+// Return "userKey" followed by the packed sequence number and type.
+func goldenInternalKey(userKey string, sequence uint64, valueType byte) []byte {
+	return concat([]byte(userKey), goldenFixed64(sequence << 8 | uint64(valueType)))
+}
+
+func TestVersionEditFormat(t *testing.T) {
+	edit := newVersionEdit()
+	edit.SetComparatorName("leveldb.BytewiseComparator")
+	edit.SetLogNumber(3)
+	edit.SetPrevLogNumber(1)
+	edit.SetNextFile(5)
+	edit.SetLastSequence(300)
+	edit.SetCompactPointer(1, makeInternalKey("m", 7, kTypeValue))
+	edit.DeleteFile(2, 9)
+	smallest, largest := makeInternalKey("apple", 1, kTypeValue), makeInternalKey("banana", 2, kTypeDeletion)
+	edit.AddFile(0, 4, 1000, &smallest, &largest)
+
+	want := concat(
+		[]byte{1, 26}, []byte("leveldb.BytewiseComparator"),
+		[]byte{2, 3},	// Log number
+		[]byte{9, 1},	// Previous log number
+		[]byte{3, 5},	// Next file number
+		[]byte{4, 0xac, 0x02},	// Last sequence
+		[]byte{5, 1, 9}, goldenInternalKey("m", 7, 1),	// Compact pointer
+		[]byte{6, 2, 9},	// Deleted file
+		[]byte{7, 0, 4, 0xe8, 0x07},	// New file: level, number, size
+		[]byte{13}, goldenInternalKey("apple", 1, 1),
+		[]byte{14}, goldenInternalKey("banana", 2, 0),
+	)
+	var got []byte
+	edit.EncodeTo(&got)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("edit\n got %x\nwant %x", got, want)
+	}
+
+	decoded := newVersionEdit()
+	if s := decoded.DecodeFrom(want); !s.OK() {
+		t.Fatal(s)
+	}
+	var reencoded []byte
+	decoded.EncodeTo(&reencoded)
+	if !bytes.Equal(reencoded, want) {
+		t.Fatalf("decoded edit encodes to %x", reencoded)
+	}
+
+	// Tag 8, once used for large value refs, is not understood.
+	if s := decoded.DecodeFrom([]byte{8, 0}); !s.IsCorruption() {
+		t.Fatalf("edit with tag 8: %v", s)
+	}
+}
+
+// A WriteBatchHandler that records the operations it is given.
+type batchRecorder struct {
+	ops []string
+}
+
+func (this *batchRecorder) Put(key []byte, value []byte) {
+	this.ops = append(this.ops, "Put(" + string(key) + ", " + string(value) + ")")
+}
+
+func (this *batchRecorder) Delete(key []byte) {
+	this.ops = append(this.ops, "Delete(" + string(key) + ")")
+}
+
+func TestWriteBatchFormat(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("apple"), []byte("red"))
+	batch.Delete([]byte("banana"))
+	batch.Put(nil, nil)
+	setWriteBatchSequence(batch, 300)
+
+	want := concat(
+		goldenFixed64(300),	// Sequence
+		goldenFixed32(3),	// Count
+		[]byte{1, 5}, []byte("apple"), []byte{3}, []byte("red"),
+		[]byte{0, 6}, []byte("banana"),
+		[]byte{1, 0, 0},
+	)
+	if got := writeBatchContents(batch); !bytes.Equal(got, want) {
+		t.Fatalf("batch\n got %x\nwant %x", got, want)
+	}
+
+	decoded := NewWriteBatch()
+	setWriteBatchContents(decoded, want)
+	var recorder batchRecorder
+	if s := decoded.Iterate(&recorder); !s.OK() {
+		t.Fatal(s)
+	}
+	wantOps := "Put(apple, red) Delete(banana) Put(, )"
+	if got := strings.Join(recorder.ops, " "); got != wantOps || writeBatchSequence(decoded) != 300 {
+		t.Fatalf("decoded %q at %d", got, writeBatchSequence(decoded))
+	}
+
+	// A count that does not match the records is corruption.
+	setWriteBatchContents(decoded, concat(want[:8], goldenFixed32(4), want[12:]))
+	if s := decoded.Iterate(&batchRecorder{}); !s.IsCorruption() {
+		t.Fatalf("batch with a wrong count: %v", s)
+	}
+}
+
+// The files of a DB in the state C++ LevelDB leaves it in after
+// writing apple=red and banana=yellow, flushing them to table 000004,
+// then writing cherry=dark red and deleting apple in log 000003.
+func goldenDBFiles() map[string][]byte {
+	dataBlock := concat(
+		[]byte{0, 13, 3}, goldenInternalKey("apple", 1, 1), []byte("red"),
+		[]byte{0, 14, 6}, goldenInternalKey("banana", 2, 1), []byte("yellow"),
+		goldenFixed32(0), goldenFixed32(1),
+	)
+	// No filter policy, so the metaindex block is empty.
+	metaindexBlock := concat(goldenFixed32(0), goldenFixed32(1))
+	// "c", the short successor of "banana", at the highest sequence.
+	indexBlock := concat(
+		[]byte{0, 9, 2}, []byte("c"), goldenFixed64(0xffffffffffffff01), []byte{0, 50},
+		goldenFixed32(0), goldenFixed32(1),
+	)
+	table := concat(
+		dataBlock, goldenTrailer(dataBlock, 0),	// Offset 0, 50 bytes
+		metaindexBlock, goldenTrailer(metaindexBlock, 0),	// Offset 55, 8 bytes
+		indexBlock, goldenTrailer(indexBlock, 0),	// Offset 68, 22 bytes
+		[]byte{55, 8}, []byte{68, 22},
+		make([]byte, 2 * kMaxEncodedLength - 4),
+		goldenFooter[2 * kMaxEncodedLength:],
+	)
+
+	// The edit that creates the DB, then the one that records the flush.
+	newDB := concat(
+		[]byte{1, 26}, []byte("leveldb.BytewiseComparator"),
+		[]byte{2, 0}, []byte{3, 2}, []byte{4, 0},
+	)
+	flush := concat(
+		[]byte{2, 3}, []byte{9, 0}, []byte{3, 5}, []byte{4, 2},
+		[]byte{7, 0, 4, 0x8f, 0x01},	// 143 bytes
+		[]byte{13}, goldenInternalKey("apple", 1, 1),
+		[]byte{14}, goldenInternalKey("banana", 2, 1),
+	)
+
+	batch := concat(
+		goldenFixed64(3), goldenFixed32(2),
+		[]byte{1, 6}, []byte("cherry"), []byte{8}, []byte("dark red"),
+		[]byte{0, 5}, []byte("apple"),
+	)
+
+	return map[string][]byte{
+		"CURRENT": []byte("MANIFEST-000002\n"),
+		"MANIFEST-000002": concat(goldenLogRecord(kFullType, newDB), goldenLogRecord(kFullType, flush)),
+		"000003.log": goldenLogRecord(kFullType, batch),
+		"000004.ldb": table,
+	}
+}
+
+func TestOpenGoldenDB(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	env.CreateDir("/golden")
+	for name, contents := range goldenDBFiles() {
+		if s := WriteStringToFile(env, string(contents), "/golden/" + name); !s.OK() {
+			t.Fatal(s)
+		}
+	}
+
+	check := func(impl *dbImpl, when string) {
+		t.Helper()
+		for _, kv := range [][2]string{{"apple", "NOT_FOUND"}, {"banana", "yellow"}, {"cherry", "dark red"}} {
+			if got := getValue(t, impl, kv[0], nil); got != kv[1] {
+				t.Errorf("%s: %s=%q, want %q", when, kv[0], got, kv[1])
+			}
+		}
+		var keys []string
+		iter := impl.NewIterator(nil)
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			keys = append(keys, string(iter.Key()))
+		}
+		if err := iter.Close(); err != nil || strings.Join(keys, ",") != "banana,cherry" {
+			t.Errorf("%s: iterated over %q: %v", when, keys, err)
+		}
+	}
+
+	options := NewOptions()
+	options.Env = env
+	options.ParanoidChecks = true
+	impl := openTestDB(t, "/golden", options)
+	check(impl, "after open")
+
+	impl.CompactRange(nil, nil)
+	check(impl, "after compaction")
+	if n := numTableFilesAtLevel(t, impl, 0); n != 0 {
+		t.Errorf("%d files left in level 0 after compaction", n)
+	}
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	impl = openTestDB(t, "/golden", options)
+	check(impl, "after reopen")
+	impl.Close()
+}
@@ -1,15 +1,72 @@
 package leveldb
 
+// An Iterator yields the key/value pairs of a DB in key order.  See
+// DB.NewIterator.
+//
+// Multiple threads can invoke const methods on an Iterator without
+// external synchronization, but if any of the threads may call a
+// non-const method, all threads accessing the same Iterator must use
+// external synchronization.
+type Iterator interface {
+	// An iterator is either positioned at a key/value pair, or
+	// not valid.  This method returns true iff the iterator is valid.
+	Valid() bool
+
+	// Position at the first key in the source.  The iterator is Valid()
+	// after this call iff the source is not empty.
+	SeekToFirst()
+
+	// Position at the last key in the source.  The iterator is
+	// Valid() after this call iff the source is not empty.
+	SeekToLast()
+
+	// Position at the first key in the source that at or past target
+	// The iterator is Valid() after this call iff the source contains
+	// an entry that comes at or past target.
+	Seek(target []byte)
+
+	// Moves to the next entry in the source.  After this call, Valid() is
+	// true iff the iterator was not positioned at the last entry in the source.
+	// REQUIRES: Valid()
+	Next()
+
+	// Moves to the previous entry in the source.  After this call, Valid() is
+	// true iff the iterator was not positioned at the first entry in source.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Return the key for the current entry.  The returned slice must not
+	// be modified, and is valid only until the next modification of the
+	// iterator.
+	// REQUIRES: Valid()
+	Key() []byte
+
+	// Return the value for the current entry.  The returned slice must
+	// not be modified, and is valid only until the next modification of
+	// the iterator.
+	// REQUIRES: Valid()
+	Value() []byte
+
+	// If an error has occurred, return it.  Else return nil.
+	Error() error
+
+	// Release the files and memory pinned by the iterator and return
+	// Error().  The iterator must not be used after Close.
+	Close() error
+}
+
 // An iterator yields a sequence of key/value pairs from a source.
 // The following class defines the interface.  Multiple implementations
 // are provided by this library.  In particular, iterators are provided
-// to access the contents of a Table or a DB.
+// to access the contents of a Table or the internal state of a DB.
+// Unlike Iterator, keys and values are strings that the iterator never
+// modifies.
 //
 // Multiple threads can invoke const methods on an Iterator without
 // external synchronization, but if any of the threads may call a
 // non-const method, all threads accessing the same Iterator must use
 // external synchronization.
-type Iterator interface {
+type iterator interface {
  	// An iterator is either positioned at a key/value pair, or
   	// not valid.  This method returns true iff the iterator is valid.
 	Valid() bool
@@ -51,7 +108,10 @@ type Iterator interface {
 
 	// If an error has occurred, return it.  Else return an ok status.
 	Status() Status
-	
+
+	// Release the resources held by the iterator.  The iterator must
+	// not be used after Close.
+	Close()
 }
 
 type EmptyIterator struct {
@@ -89,9 +149,12 @@ func (this *EmptyIterator) Status() Status {
 	return this.s
 }
 
+func (this *EmptyIterator) Close() {
+}
+
 
 type TwoLevelIterator struct {
-	blockFunction func(arg interface{}, options *ReadOptions, indexValue string) Iterator
+	blockFunction func(arg interface{}, options *ReadOptions, indexValue string) iterator
 	arg interface{}
 	options *ReadOptions
 	s Status
@@ -105,72 +168,190 @@ type TwoLevelIterator struct {
 }
 
 func (this *TwoLevelIterator) Valid() bool {
-	return false
+	return this.dataIter.Valid()
 }
 
 func (this *TwoLevelIterator) SeekToFirst() {
+	this.indexIter.SeekToFirst()
+	this.initDataBlock()
+	if this.dataIter.Iter() != nil {
+		this.dataIter.SeekToFirst()
+	}
+	this.skipEmptyDataBlocksForward()
 }
 
 func (this *TwoLevelIterator) SeekToLast() {
+	this.indexIter.SeekToLast()
+	this.initDataBlock()
+	if this.dataIter.Iter() != nil {
+		this.dataIter.SeekToLast()
+	}
+	this.skipEmptyDataBlocksBackward()
 }
 
 func (this *TwoLevelIterator) Seek(target string) {
+	this.indexIter.Seek(target)
+	this.initDataBlock()
+	if this.dataIter.Iter() != nil {
+		this.dataIter.Seek(target)
+	}
+	this.skipEmptyDataBlocksForward()
 }
 
 func (this *TwoLevelIterator) Next() {
+	this.dataIter.Next()
+	this.skipEmptyDataBlocksForward()
 }
 
 func (this *TwoLevelIterator) Prev() {
+	this.dataIter.Prev()
+	this.skipEmptyDataBlocksBackward()
 }
 
 func (this *TwoLevelIterator) Key() string {
-	return ""
+	return this.dataIter.Key()
 }
 
 func (this *TwoLevelIterator) Value() string {
-	return ""
+	return this.dataIter.Value()
 }
 
 func (this *TwoLevelIterator) Status() Status {
-	return OK()
+	if s := this.indexIter.Status(); !s.OK() {
+		return s
+	} else if this.dataIter.Iter() != nil {
+		if s := this.dataIter.Status(); !s.OK() {
+			return s
+		}
+	}
+
+	return this.s
 }
 
+func (this *TwoLevelIterator) Close() {
+	this.dataIter.Set(nil)
+	this.indexIter.Set(nil)
+}
 
-func NewTwoLevelIterator(indexIter Iterator, blockFunction func(arg interface{}, options *ReadOptions, indexValue string) Iterator, arg interface{}, options *ReadOptions ) Iterator {
+func (this *TwoLevelIterator) saveError(s Status) {
+	if this.s.OK() && !s.OK() {
+		this.s = s
+	}
+}
+
+func (this *TwoLevelIterator) skipEmptyDataBlocksForward() {
+	for this.dataIter.Iter() == nil || !this.dataIter.Valid() {
+		// Move to next block
+		if !this.indexIter.Valid() {
+			this.setDataIterator(nil)
+			return
+		}
+		this.indexIter.Next()
+		this.initDataBlock()
+		if this.dataIter.Iter() != nil {
+			this.dataIter.SeekToFirst()
+		}
+	}
+}
+
+func (this *TwoLevelIterator) skipEmptyDataBlocksBackward() {
+	for this.dataIter.Iter() == nil || !this.dataIter.Valid() {
+		// Move to next block
+		if !this.indexIter.Valid() {
+			this.setDataIterator(nil)
+			return
+		}
+		this.indexIter.Prev()
+		this.initDataBlock()
+		if this.dataIter.Iter() != nil {
+			this.dataIter.SeekToLast()
+		}
+	}
+}
+
+func (this *TwoLevelIterator) setDataIterator(dataIter iterator) {
+	if this.dataIter.Iter() != nil {
+		this.saveError(this.dataIter.Status())
+	}
+	this.dataIter.Set(dataIter)
+}
+
+func (this *TwoLevelIterator) initDataBlock() {
+	if !this.indexIter.Valid() {
+		this.setDataIterator(nil)
+	} else {
+		handle := this.indexIter.Value()
+		if this.dataIter.Iter() != nil && handle == this.dataBlockHandle {
+			// dataIter is already constructed with this iterator, so
+			// no need to change anything
+		} else {
+			iter := this.blockFunction(this.arg, this.options, handle)
+			this.dataBlockHandle = handle
+			this.setDataIterator(iter)
+		}
+	}
+}
+
+
+// Return a new two level iterator.  A two-level iterator contains an
+// index iterator whose values point to a sequence of blocks where
+// each block is itself a sequence of key,value pairs.  The returned
+// two-level iterator yields the concatenation of all key/value pairs
+// in the sequence of blocks.  Takes ownership of "indexIter" and
+// will delete it when no longer needed.
+//
+// Uses a supplied function to convert an index_iter value into
+// an iterator over the contents of the corresponding block.
+func NewTwoLevelIterator(indexIter iterator, blockFunction func(arg interface{}, options *ReadOptions, indexValue string) iterator, arg interface{}, options *ReadOptions ) iterator {
 	return &TwoLevelIterator{
 		blockFunction: blockFunction,
 		arg: arg,
 		options: options,
+		s: OK(),
 		indexIter: IteratorToIteratorWrapper(indexIter),
-		dataIter: nil,
+		dataIter: IteratorToIteratorWrapper(nil),
 	}
 }
 
-func NewEmptyIterator() Iterator {
+func NewEmptyIterator() iterator {
 	return &EmptyIterator{
 		s: OK(),
 	}
 }
 
-func NewErrorIterator(s Status) Iterator {
+func NewErrorIterator(s Status) iterator {
 	return &EmptyIterator{
 		s: s,
 	}
 }
 
-func IteratorToIteratorWrapper(iter Iterator) *IteratorWrapper {
+func IteratorToIteratorWrapper(iter iterator) *IteratorWrapper {
 	var result IteratorWrapper
 	result.Set(iter)
 	return &result
 }
 
+// A internal wrapper class with an interface similar to Iterator that
+// caches the valid() and key() results for an underlying iterator.
+// This can help avoid virtual function calls and also gives better
+// cache locality.
 type IteratorWrapper struct {
-	iter Iterator
+	iter iterator
 	valid bool
 	key string
 }
 
-func (this *IteratorWrapper) Set(iter Iterator) {
+// Iter() returns the wrapped iterator, or nil if none has been set.
+func (this *IteratorWrapper) Iter() iterator {
+	return this.iter
+}
+
+// Set the underlying iterator.  Takes ownership of "iter" and closes
+// the iterator it replaces.
+func (this *IteratorWrapper) Set(iter iterator) {
+	if this.iter != nil {
+		this.iter.Close()
+	}
 	this.iter = iter
 	if this.iter == nil {
 		this.valid = false
@@ -180,12 +361,13 @@ func (this *IteratorWrapper) Set(iter Iterator) {
 }
 
 func (this *IteratorWrapper) Update() {
-	this.valid = this.Valid()
+	this.valid = this.iter.Valid()
 	if this.valid {
-		this.key = this.Key()
+		this.key = this.iter.Key()
 	}
 }
 
+// Iterator interface methods
 func (this *IteratorWrapper) Key() string {
 	return this.key
 }
@@ -193,3 +375,56 @@ func (this *IteratorWrapper) Key() string {
 func (this *IteratorWrapper) Valid() bool {
 	return this.valid
 }
+
+func (this *IteratorWrapper) Value() string {
+	return this.iter.Value()
+}
+
+// Methods below require iter() != nil
+func (this *IteratorWrapper) Status() Status {
+	return this.iter.Status()
+}
+
+func (this *IteratorWrapper) Next() {
+	this.iter.Next()
+	this.Update()
+}
+
+func (this *IteratorWrapper) Prev() {
+	this.iter.Prev()
+	this.Update()
+}
+
+func (this *IteratorWrapper) Seek(k string) {
+	this.iter.Seek(k)
+	this.Update()
+}
+
+func (this *IteratorWrapper) SeekToFirst() {
+	this.iter.SeekToFirst()
+	this.Update()
+}
+
+func (this *IteratorWrapper) SeekToLast() {
+	this.iter.SeekToLast()
+	this.Update()
+}
+
+// An iterator that runs a cleanup function after closing the iterator
+// it wraps, e.g. to release the cache handle that keeps its table open.
+type cleanupIterator struct {
+	iterator
+	cleanup func()
+}
+
+func newCleanupIterator(iter iterator, cleanup func()) iterator {
+	return &cleanupIterator{
+		iterator: iter,
+		cleanup: cleanup,
+	}
+}
+
+func (this *cleanupIterator) Close() {
+	this.iterator.Close()
+	this.cleanup()
+}
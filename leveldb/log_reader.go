@@ -0,0 +1,328 @@
+package leveldb
+
+import (
+	"./utilties"
+)
+
+// Extend record types with the following special values
+const (
+	kEof = kMaxRecordType + 1
+
+	// Returned whenever we find an invalid physical record.
+	// Currently there are three situations in which this happens:
+	// * The record has an invalid CRC (ReadPhysicalRecord reports a drop)
+	// * The record is a 0-length record (No drop is reported)
+	// * The record is below constructor's initial_offset (No drop is reported)
+	kBadRecord = kMaxRecordType + 2
+)
+
+// Interface for reporting errors.
+type LogReporter interface {
+	// Some corruption was detected.  "bytes" is the approximate number
+	// of bytes dropped due to the corruption.
+	Corruption(bytes int, status Status)
+}
+
+type LogReader struct {
+	file SequentialFile
+	reporter LogReporter
+	checksum bool
+	backingStore []byte
+	buffer []byte
+	eof bool	// Last Read() indicated EOF by returning < kLogBlockSize
+
+	// Offset of the last record returned by ReadRecord.
+	lastRecordOffset uint64
+	// Offset of the first location past the last record returned by
+	// ReadRecord.
+	endOfLastRecord uint64
+	// Offset of the first location past the end of buffer.
+	endOfBufferOffset uint64
+
+	// Offset at which to start looking for the first record to return
+	initialOffset uint64
+
+	// True if we are resynchronizing after a seek (initialOffset > 0). In
+	// particular, a run of kMiddleType and kLastType records can be silently
+	// skipped in this mode
+	resyncing bool
+}
+
+// Create a reader that will return log records from "file".
+// "file" must remain live while this Reader is in use.
+//
+// If "reporter" is non-nil, it is notified whenever some data is
+// dropped due to a detected corruption.  "reporter" must remain
+// live while this Reader is in use.
+//
+// If "checksum" is true, verify checksums if available.
+//
+// The Reader will start reading at the first record located at physical
+// position >= initialOffset within the file.
+func newLogReader(file SequentialFile, reporter LogReporter, checksum bool, initialOffset uint64) *LogReader {
+	return &LogReader{
+		file: file,
+		reporter: reporter,
+		checksum: checksum,
+		backingStore: make([]byte, kLogBlockSize),
+		eof: false,
+		lastRecordOffset: 0,
+		endOfBufferOffset: 0,
+		initialOffset: initialOffset,
+		resyncing: initialOffset > 0,
+	}
+}
+
+// Skips all blocks that are completely before "initialOffset".
+//
+// Returns true on success. Handles reporting.
+func (this *LogReader) skipToInitialBlock() bool {
+	offsetInBlock := this.initialOffset % kLogBlockSize
+	blockStartLocation := this.initialOffset - offsetInBlock
+
+	// Don't search a block if we'd be in the trailer
+	if offsetInBlock > kLogBlockSize - 6 {
+		blockStartLocation += kLogBlockSize
+	}
+
+	this.endOfBufferOffset = blockStartLocation
+
+	// Skip to start of first block that can contain the initial record
+	if blockStartLocation > 0 {
+		skipStatus := this.file.Skip(int64(blockStartLocation))
+		if !skipStatus.OK() {
+			this.reportDrop(int(blockStartLocation), skipStatus)
+			return false
+		}
+	}
+
+	return true
+}
+
+// Read the next record into *record.  Returns true if read
+// successfully, false if we hit end of the input.  May use
+// "*scratch" as temporary storage.  The contents filled in *record
+// will only be valid until the next mutating operation on this
+// reader or the next mutation to *scratch.
+func (this *LogReader) ReadRecord(record *[]byte, scratch *[]byte) bool {
+	if this.lastRecordOffset < this.initialOffset {
+		if !this.skipToInitialBlock() {
+			return false
+		}
+	}
+
+	*scratch = (*scratch)[:0]
+	*record = nil
+	inFragmentedRecord := false
+	// Record offset of the logical record that we're reading
+	// 0 is a dummy value to make compilers happy
+	var prospectiveRecordOffset uint64
+
+	var fragment []byte
+	for {
+		recordType := this.readPhysicalRecord(&fragment)
+
+		// readPhysicalRecord may have only had an empty trailer remaining in its
+		// internal buffer. Calculate the offset of the next physical record now
+		// that it has returned, properly accounting for its header size.
+		physicalRecordOffset := this.endOfBufferOffset - uint64(len(this.buffer)) - kHeaderSize - uint64(len(fragment))
+
+		if this.resyncing {
+			if recordType == kMiddleType {
+				continue
+			} else if recordType == kLastType {
+				this.resyncing = false
+				continue
+			} else {
+				this.resyncing = false
+			}
+		}
+
+		switch recordType {
+		case kFullType:
+			if inFragmentedRecord {
+				// Handle bug in earlier versions of log::Writer where
+				// it could emit an empty kFirstType record at the tail end
+				// of a block followed by a kFullType or kFirstType record
+				// at the beginning of the next block.
+				if len(*scratch) > 0 {
+					this.reportCorruption(len(*scratch), "partial record without end(1)")
+				}
+			}
+			prospectiveRecordOffset = physicalRecordOffset
+			*scratch = (*scratch)[:0]
+			*record = fragment
+			this.lastRecordOffset = prospectiveRecordOffset
+			this.endOfLastRecord = this.endOfBufferOffset - uint64(len(this.buffer))
+			return true
+
+		case kFirstType:
+			if inFragmentedRecord {
+				// Handle bug in earlier versions of log::Writer where
+				// it could emit an empty kFirstType record at the tail end
+				// of a block followed by a kFullType or kFirstType record
+				// at the beginning of the next block.
+				if len(*scratch) > 0 {
+					this.reportCorruption(len(*scratch), "partial record without end(2)")
+				}
+			}
+			prospectiveRecordOffset = physicalRecordOffset
+			*scratch = append((*scratch)[:0], fragment...)
+			inFragmentedRecord = true
+
+		case kMiddleType:
+			if !inFragmentedRecord {
+				this.reportCorruption(len(fragment), "missing start of fragmented record(1)")
+			} else {
+				*scratch = append(*scratch, fragment...)
+			}
+
+		case kLastType:
+			if !inFragmentedRecord {
+				this.reportCorruption(len(fragment), "missing start of fragmented record(2)")
+			} else {
+				*scratch = append(*scratch, fragment...)
+				*record = *scratch
+				this.lastRecordOffset = prospectiveRecordOffset
+				this.endOfLastRecord = this.endOfBufferOffset - uint64(len(this.buffer))
+				return true
+			}
+
+		case kEof:
+			if inFragmentedRecord {
+				// This can be caused by the writer dying immediately after
+				// writing a physical record but before completing the next; don't
+				// treat it as a corruption, just ignore the entire logical record.
+				*scratch = (*scratch)[:0]
+			}
+			return false
+
+		case kBadRecord:
+			if inFragmentedRecord {
+				this.reportCorruption(len(*scratch), "error in middle of record")
+				inFragmentedRecord = false
+				*scratch = (*scratch)[:0]
+			}
+
+		default:
+			n := len(fragment)
+			if inFragmentedRecord {
+				n += len(*scratch)
+			}
+			this.reportCorruption(n, "unknown record type")
+			inFragmentedRecord = false
+			*scratch = (*scratch)[:0]
+		}
+	}
+}
+
+// Returns the physical offset of the last record returned by ReadRecord.
+//
+// Undefined before the first call to ReadRecord.
+func (this *LogReader) LastRecordOffset() uint64 {
+	return this.lastRecordOffset
+}
+
+// Returns the physical offset just past the last record returned by
+// ReadRecord, or 0 if there is none.  Whatever follows it up to the
+// end of the file was dropped or not yet read.
+func (this *LogReader) EndOfLastRecord() uint64 {
+	return this.endOfLastRecord
+}
+
+// Reports dropped bytes to the reporter.
+// buffer must be updated to remove the dropped bytes prior to invocation.
+func (this *LogReader) reportCorruption(bytes int, reason string) {
+	this.reportDrop(bytes, Corruption(reason))
+}
+
+func (this *LogReader) reportDrop(bytes int, reason Status) {
+	if this.reporter != nil &&
+		this.endOfBufferOffset - uint64(len(this.buffer)) - uint64(bytes) >= this.initialOffset {
+		this.reporter.Corruption(bytes, reason)
+	}
+}
+
+// Return type, or one of the preceding special values
+func (this *LogReader) readPhysicalRecord(result *[]byte) int {
+	for {
+		if len(this.buffer) < kHeaderSize {
+			if !this.eof {
+				// Last read was a full read, so this is a trailer to skip
+				this.buffer = nil
+				s := this.file.Read(this.backingStore, &this.buffer)
+				this.endOfBufferOffset += uint64(len(this.buffer))
+				if !s.OK() {
+					this.buffer = nil
+					this.reportDrop(kLogBlockSize, s)
+					this.eof = true
+					return kEof
+				} else if len(this.buffer) < kLogBlockSize {
+					this.eof = true
+				}
+				continue
+			} else {
+				// Note that if buffer is non-empty, we have a truncated header at the
+				// end of the file, which can be caused by the writer crashing in the
+				// middle of writing the header. Instead of considering this an error,
+				// just report EOF.
+				this.buffer = nil
+				return kEof
+			}
+		}
+
+		// Parse the header
+		header := this.buffer
+		a := uint32(header[4])
+		b := uint32(header[5])
+		recordType := int(header[6])
+		length := int(a | (b << 8))
+		if kHeaderSize + length > len(this.buffer) {
+			dropSize := len(this.buffer)
+			this.buffer = nil
+			if !this.eof {
+				this.reportCorruption(dropSize, "bad record length")
+				return kBadRecord
+			}
+			// If the end of the file has been reached without reading |length| bytes
+			// of payload, assume the writer died in the middle of writing the record.
+			// Don't report a corruption.
+			return kEof
+		}
+
+		if recordType == kZeroType && length == 0 {
+			// Skip zero length record without reporting any drops since
+			// such records are produced by the mmap based writing code in
+			// env_posix.cc that preallocates file regions.
+			this.buffer = nil
+			return kBadRecord
+		}
+
+		// Check crc
+		if this.checksum {
+			expectedCRC := utilties.Unmask(decodeFixed32(bytesToString(header)))
+			actualCRC := utilties.Value(header[6 : kHeaderSize + length])
+			if actualCRC != expectedCRC {
+				// Drop the rest of the buffer since "length" itself may have
+				// been corrupted and if we trust it, we could find some
+				// fragment of a real log record that just happens to look
+				// like a valid log record.
+				dropSize := len(this.buffer)
+				this.buffer = nil
+				this.reportCorruption(dropSize, "checksum mismatch")
+				return kBadRecord
+			}
+		}
+
+		this.buffer = this.buffer[kHeaderSize + length:]
+
+		// Skip physical record that started before initialOffset
+		if this.endOfBufferOffset - uint64(len(this.buffer)) - kHeaderSize - uint64(length) < this.initialOffset {
+			*result = nil
+			return kBadRecord
+		}
+
+		*result = header[kHeaderSize : kHeaderSize + length]
+		return recordType
+	}
+}
@@ -1,18 +1,33 @@
 package leveldb
 
-import "./utilties"
+import (
+	"./utilties"
+)
 
 type LogWriter struct {
 	dest *WritableFile
 	blockOffset int
 
+	// crc32c values for all supported record types.  These are
+	// pre-computed to reduce the overhead of computing the crc of the
+	// record type stored in the header.
 	typeCRC [kMaxRecordType + 1]uint32
 }
 
+// Create a writer that will append data to "*dst".
+// "*dst" must be initially empty.
+// "*dst" must remain live while this Writer is in use.
 func newLogWriter(dst *WritableFile) *LogWriter {
+	return newLogWriterWithLength(dst, 0)
+}
+
+// Create a writer that will append data to "*dst".
+// "*dst" must have initial length "destLength".
+// "*dst" must remain live while this Writer is in use.
+func newLogWriterWithLength(dst *WritableFile, destLength uint64) *LogWriter {
 	var result LogWriter
 	result.dest = dst
-	result.blockOffset = 0
+	result.blockOffset = int(destLength % kLogBlockSize)
 
 	a := make([]byte, 1)
 	for i:= 0; i <= kMaxRecordType; i += 1 {
@@ -22,4 +37,88 @@ func newLogWriter(dst *WritableFile) *LogWriter {
 	}
 
 	return &result
-}
\ No newline at end of file
+}
+
+func (this *LogWriter) AddRecord(slice []byte) Status {
+	ptr := slice
+	left := len(slice)
+
+	// Fragment the record if necessary and emit it.  Note that if slice
+	// is empty, we still want to iterate once to emit a single
+	// zero-length record
+	s := OK()
+	begin := true
+	for {
+		leftover := kLogBlockSize - this.blockOffset
+		// assert(leftover >= 0)
+		if leftover < kHeaderSize {
+			// Switch to a new block
+			if leftover > 0 {
+				// Fill the trailer
+				s = (*this.dest).Append(make([]byte, leftover))
+			}
+			this.blockOffset = 0
+		}
+
+		// Invariant: we never leave < kHeaderSize bytes in a block.
+		// assert(kLogBlockSize - this.blockOffset - kHeaderSize >= 0)
+
+		avail := kLogBlockSize - this.blockOffset - kHeaderSize
+		fragmentLength := left
+		if fragmentLength > avail {
+			fragmentLength = avail
+		}
+
+		var recordType int
+		end := left == fragmentLength
+		if begin && end {
+			recordType = kFullType
+		} else if begin {
+			recordType = kFirstType
+		} else if end {
+			recordType = kLastType
+		} else {
+			recordType = kMiddleType
+		}
+
+		s = this.emitPhysicalRecord(recordType, ptr[:fragmentLength])
+		ptr = ptr[fragmentLength:]
+		left -= fragmentLength
+		begin = false
+
+		if !s.OK() || left <= 0 {
+			break
+		}
+	}
+
+	return s
+}
+
+func (this *LogWriter) emitPhysicalRecord(t int, data []byte) Status {
+	n := len(data)
+	// assert(n <= 0xffff)  // Must fit in two bytes
+	// assert(this.blockOffset + kHeaderSize + n <= kLogBlockSize)
+
+	// Format the header
+	var buf [kHeaderSize]byte
+	buf[4] = byte(n & 0xff)
+	buf[5] = byte(n >> 8)
+	buf[6] = byte(t)
+
+	// Compute the crc of the record type and the payload.
+	crc := utilties.Extend(this.typeCRC[t], data)
+	crc = utilties.Mask(crc)	// Adjust for storage
+	encodeFixed32(buf[:], crc)
+
+	// Write the header and the payload
+	s := (*this.dest).Append(buf[:])
+	if s.OK() {
+		s = (*this.dest).Append(data)
+		if s.OK() {
+			s = (*this.dest).Flush()
+		}
+	}
+	this.blockOffset += kHeaderSize + n
+
+	return s
+}
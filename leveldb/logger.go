@@ -13,8 +13,18 @@ type defaultLogger struct{
 func (this *defaultLogger) Logv(format string, a ...interface{}) {
 
 	str := fmt.Sprintf(format, a ... )
+	if len(str) == 0 || str[len(str) - 1] != '\n' {
+		str += "\n"
+	}
 	
 	this.Append([]byte(str) )
 	
 	this.Flush()
-}
\ No newline at end of file
+}
+
+// Log the specified data to *infoLog if infoLog is non-nil.
+func Log(infoLog Logger, format string, a ...interface{}) {
+	if infoLog != nil {
+		infoLog.Logv(format, a ...)
+	}
+}
@@ -0,0 +1,342 @@
+package leveldb
+
+import (
+	"strings"
+	"sync"
+)
+
+type memFileState struct {
+	mutex sync.Mutex
+	data []byte
+}
+
+func (this *memFileState) Size() uint64 {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	return uint64(len(this.data))
+}
+
+func (this *memFileState) Read(offset uint64, scratch []byte) (int, Status) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if offset > uint64(len(this.data)) {
+		return 0, IOError("Offset greater than file size.")
+	}
+
+	return copy(scratch, this.data[offset:]), OK()
+}
+
+func (this *memFileState) Append(data []byte) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.data = append(this.data, data...)
+	return OK()
+}
+
+type memSequentialFile struct {
+	file *memFileState
+	pos uint64
+}
+
+func (this *memSequentialFile) Read(scratch []byte, result *[]byte) Status {
+	n, s := this.file.Read(this.pos, scratch)
+	*result = scratch[:n]
+	if s.OK() {
+		this.pos += uint64(n)
+	}
+
+	return s
+}
+
+func (this *memSequentialFile) Skip(n int64) Status {
+	available := this.file.Size()
+	if this.pos > available {
+		return IOError("pos > file size")
+	}
+	if uint64(n) > available - this.pos {
+		n = int64(available - this.pos)
+	}
+	this.pos += uint64(n)
+
+	return OK()
+}
+
+func (this *memSequentialFile) Close() Status {
+	return OK()
+}
+
+type memRandomAccessFile struct {
+	file *memFileState
+}
+
+func (this *memRandomAccessFile) Read(offset int64, scratch []byte, result *[]byte) Status {
+	n, s := this.file.Read(uint64(offset), scratch)
+	*result = scratch[:n]
+
+	return s
+}
+
+func (this *memRandomAccessFile) Close() Status {
+	return OK()
+}
+
+type memWritableFile struct {
+	file *memFileState
+}
+
+func (this *memWritableFile) Append(data []byte) Status {
+	return this.file.Append(data)
+}
+
+func (this *memWritableFile) Close() Status {
+	return OK()
+}
+
+func (this *memWritableFile) Flush() Status {
+	return OK()
+}
+
+func (this *memWritableFile) Sync() Status {
+	return OK()
+}
+
+type memFileLock struct {
+	env *memEnv
+	fname string
+}
+
+func (this *memFileLock) Lock(fname string) Status {
+	this.env.mutex.Lock()
+	defer this.env.mutex.Unlock()
+
+	if this.env.locks[fname] {
+		return IOError("lock " + fname + ": already held by process")
+	}
+	if _, ok := this.env.fileMap[fname]; !ok {
+		this.env.fileMap[fname] = &memFileState{}
+	}
+	this.env.locks[fname] = true
+	this.fname = fname
+
+	return OK()
+}
+
+func (this *memFileLock) Unlock() Status {
+	this.env.mutex.Lock()
+	defer this.env.mutex.Unlock()
+
+	delete(this.env.locks, this.fname)
+	return OK()
+}
+
+type memEnv struct {
+	// Clock and background work are delegated to the base Env.
+	Env
+
+	mutex sync.Mutex
+	fileMap map[string]*memFileState
+	dirs map[string]bool
+	locks map[string]bool
+}
+
+// Returns a new environment that stores its data in memory and delegates
+// all non-file-storage tasks to base.  "base" must remain live while the
+// result is in use.
+func NewMemEnv(base Env) Env {
+	return &memEnv{
+		Env: base,
+		fileMap: make(map[string]*memFileState),
+		dirs: make(map[string]bool),
+		locks: make(map[string]bool),
+	}
+}
+
+func (this *memEnv) NewSequentialFile(fname string, result *SequentialFile) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	file, ok := this.fileMap[fname]
+	if !ok {
+		*result = nil
+		return NotFound(fname + ": File not found")
+	}
+
+	*result = &memSequentialFile{
+		file: file,
+	}
+	return OK()
+}
+
+func (this *memEnv) NewRandomAccessFile(fname string, result *RandomAccessFile) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	file, ok := this.fileMap[fname]
+	if !ok {
+		*result = nil
+		return NotFound(fname + ": File not found")
+	}
+
+	*result = &memRandomAccessFile{
+		file: file,
+	}
+	return OK()
+}
+
+func (this *memEnv) NewWritableFile(fname string, result *WritableFile) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	// Files already open on the old contents keep reading them.
+	file := &memFileState{}
+	this.fileMap[fname] = file
+
+	*result = &memWritableFile{
+		file: file,
+	}
+	return OK()
+}
+
+func (this *memEnv) NewAppendableFile(fname string, result *WritableFile) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	file, ok := this.fileMap[fname]
+	if !ok {
+		file = &memFileState{}
+		this.fileMap[fname] = file
+	}
+
+	*result = &memWritableFile{
+		file: file,
+	}
+	return OK()
+}
+
+func (this *memEnv) FileExists(fname string) bool {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	_, ok := this.fileMap[fname]
+	return ok || this.dirs[fname]
+}
+
+func (this *memEnv) GetChildren(dir string) ([]string, Status) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	var result []string
+	for fname := range this.fileMap {
+		if strings.HasPrefix(fname, prefix) && !strings.Contains(fname[len(prefix):], "/") {
+			result = append(result, fname[len(prefix):])
+		}
+	}
+	for d := range this.dirs {
+		if strings.HasPrefix(d, prefix) && !strings.Contains(d[len(prefix):], "/") {
+			result = append(result, d[len(prefix):])
+		}
+	}
+
+	if len(result) == 0 && !this.dirs[dir] {
+		return nil, IOError(dir + ": No such file or directory")
+	}
+	return result, OK()
+}
+
+func (this *memEnv) DeleteFile(fname string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if _, ok := this.fileMap[fname]; !ok {
+		return NotFound(fname + ": File not found")
+	}
+
+	delete(this.fileMap, fname)
+	return OK()
+}
+
+func (this *memEnv) CreateDir(dirname string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	this.dirs[dirname] = true
+	return OK()
+}
+
+func (this *memEnv) DeleteDir(dirname string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	if !this.dirs[dirname] {
+		return IOError(dirname + ": No such file or directory")
+	}
+	prefix := strings.TrimSuffix(dirname, "/") + "/"
+	for fname := range this.fileMap {
+		if strings.HasPrefix(fname, prefix) {
+			return IOError(dirname + ": Directory not empty")
+		}
+	}
+	for d := range this.dirs {
+		if strings.HasPrefix(d, prefix) {
+			return IOError(dirname + ": Directory not empty")
+		}
+	}
+
+	delete(this.dirs, dirname)
+	return OK()
+}
+
+func (this *memEnv) GetFileSize(fname string) (fileSize int64, status Status) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	file, ok := this.fileMap[fname]
+	if !ok {
+		return 0, NotFound(fname + ": File not found")
+	}
+
+	return int64(file.Size()), OK()
+}
+
+func (this *memEnv) RenameFile(src string, target string) Status {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	file, ok := this.fileMap[src]
+	if !ok {
+		return NotFound(src + ": File not found")
+	}
+
+	this.fileMap[target] = file
+	delete(this.fileMap, src)
+	return OK()
+}
+
+func (this *memEnv) LockFile(fname string, lock *FileLock) Status {
+	*lock = nil
+	l := &memFileLock{
+		env: this,
+	}
+	s := l.Lock(fname)
+	if s.OK() {
+		*lock = l
+	}
+
+	return s
+}
+
+func (this *memEnv) UnlockFile(lock FileLock) Status {
+	return lock.Unlock()
+}
+
+func (this *memEnv) NewLogger(fname string, result *Logger) Status {
+	dl := new(defaultLogger)
+
+	*result = dl
+
+	return this.NewWritableFile(fname, &(dl.WritableFile))
+}
@@ -0,0 +1,130 @@
+package leveldb
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestMemEnvDB(t *testing.T) {
+	const dbName = "/leveldb-mem-env-test"
+	const numKeys = 5000
+	env := NewMemEnv(DefaultEnv())
+	options := newTestOptions(env)
+	options.WriteBufferSize = 64 << 10
+	impl := openTestDB(t, dbName, options)
+
+	// Enough data for memtable flushes and compactions.
+	for i := 0; i < numKeys; i++ {
+		mustPut(t, impl, fmt.Sprintf("key%06d", i), fmt.Sprintf("value%d", i))
+	}
+	for i := 0; i < numKeys; i += 2 {
+		if err := impl.Delete([]byte(fmt.Sprintf("key%06d", i)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	impl.CompactRange(nil, nil)
+	if err := impl.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	impl = openTestDB(t, dbName, options)
+	defer impl.Close()
+	iter := impl.NewIterator(nil)
+	i := 1
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key, value := fmt.Sprintf("key%06d", i), fmt.Sprintf("value%d", i)
+		if string(iter.Key()) != key || string(iter.Value()) != value {
+			t.Fatalf("got %s=%s, want %s=%s", iter.Key(), iter.Value(), key, value)
+		}
+		i += 2
+	}
+	if err := iter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if i != numKeys + 1 {
+		t.Fatalf("iterated to key %d, want %d", i, numKeys + 1)
+	}
+
+	if _, err := os.Stat(dbName); !os.IsNotExist(err) {
+		t.Fatalf("the DB reached the file system: %v", err)
+	}
+}
+
+func TestMemEnvFiles(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	if s := env.CreateDir("/dir"); !s.OK() {
+		t.Fatal(s)
+	}
+	if env.FileExists("/dir/f") {
+		t.Fatal("file exists before it is written")
+	}
+	if s := WriteStringToFile(env, "hello", "/dir/f"); !s.OK() {
+		t.Fatal(s)
+	}
+
+	var file WritableFile
+	if s := env.NewAppendableFile("/dir/f", &file); !s.OK() {
+		t.Fatal(s)
+	}
+	file.Append([]byte(", world"))
+	file.Close()
+
+	var data string
+	if s := ReadFileToString(env, "/dir/f", &data); !s.OK() || data != "hello, world" {
+		t.Fatalf("read %q: %v", data, s)
+	}
+	if size, s := env.GetFileSize("/dir/f"); !s.OK() || size != int64(len(data)) {
+		t.Fatalf("size %d: %v", size, s)
+	}
+
+	if s := env.RenameFile("/dir/f", "/dir/g"); !s.OK() {
+		t.Fatal(s)
+	}
+	if children, s := env.GetChildren("/dir"); !s.OK() || len(children) != 1 || children[0] != "g" {
+		t.Fatalf("children %v: %v", children, s)
+	}
+	if s := env.DeleteFile("/dir/g"); !s.OK() {
+		t.Fatal(s)
+	}
+	if s := env.DeleteFile("/dir/g"); s.OK() {
+		t.Fatal("deleted a missing file")
+	}
+}
+
+func TestMemEnvMissingFileIsNotFound(t *testing.T) {
+	env := NewMemEnv(DefaultEnv())
+	var sequential SequentialFile
+	var random RandomAccessFile
+	_, sizeStatus := env.GetFileSize("/missing")
+	for what, s := range map[string]Status{
+		"NewSequentialFile": env.NewSequentialFile("/missing", &sequential),
+		"NewRandomAccessFile": env.NewRandomAccessFile("/missing", &random),
+		"DeleteFile": env.DeleteFile("/missing"),
+		"GetFileSize": sizeStatus,
+		"RenameFile": env.RenameFile("/missing", "/target"),
+	} {
+		if !s.IsNotFound() {
+			t.Errorf("%s: %v", what, s)
+		}
+	}
+	if env.FileExists("/target") {
+		t.Error("renaming a missing file created its target")
+	}
+
+	// A DB whose CURRENT names a missing MANIFEST is corrupt.
+	const dbName = "/db"
+	impl := openTestDB(t, dbName, newTestOptions(env))
+	impl.Close()
+	var current string
+	if s := ReadFileToString(env, CurrentFileName(dbName), &current); !s.OK() {
+		t.Fatal(s)
+	}
+	if s := env.DeleteFile(dbName + "/" + current[:len(current) - 1]); !s.OK() {
+		t.Fatal(s)
+	}
+	if _, err := Open(dbName, newTestOptions(env)); !errors.Is(err, ErrCorruption) {
+		t.Fatalf("opening a DB without its MANIFEST: %v", err)
+	}
+}
@@ -1,6 +1,8 @@
 package leveldb
 
-import "./structure"
+import (
+	"./structure"
+)
 
 
 type keyComparator struct{
@@ -8,25 +10,164 @@ type keyComparator struct{
 }
 
 type MemTable struct {
-	table structure.SkipList
+	comparator keyComparator
+	table *structure.SkipList
 	arena Arena
 }
 
 func (this *keyComparator) Compare(aKey string, bKey string) int {
-	aKey = getlengthPrefixedSlice(aKey)
-	bKey = getlengthPrefixedSlice(bKey)
+	// Internal keys are encoded as length-prefixed strings.
+	aKey, _ = getLengthPrefixedSlice(aKey)
+	bKey, _ = getLengthPrefixedSlice(bKey)
 	return this.internalKeyComparator.Compare(aKey, bKey)
 }
 
+func newMemTable(comparator internalKeyComparator) *MemTable {
+	result := &MemTable{
+		comparator: keyComparator{
+			internalKeyComparator: comparator,
+		},
+	}
+	result.table = structure.NewSkipList(result.comparator.Compare)
 
-func getlengthPrefixedSlice(str string) string {
-	remainStr, _ := getVarint32(str)
+	return result
+}
 
-	return remainStr
+// Returns an estimate of the number of bytes of data in use by this
+// data structure. It is safe to call when MemTable is being modified.
+func (this *MemTable) ApproximateMemoryUsage() int {
+	return this.arena.MemoryUsage()
 }
 
-func newMemTable(comparator internalKeyComparator) *MemTable {
-	return &MemTable{
-		
+// Return an iterator that yields the contents of the memtable.
+//
+// The caller must ensure that the underlying MemTable remains live
+// while the returned iterator is live.  The keys returned by this
+// iterator are internal keys encoded by AppendInternalKey in the
+// db/format.{h,cc} module.
+func (this *MemTable) NewIterator() iterator {
+	return &memTableIterator{
+		iter: this.table.NewIterator(),
+	}
+}
+
+// Add an entry into memtable that maps key to value at the
+// specified sequence number and with the specified type.
+// Typically value will be empty if type==kTypeDeletion.
+func (this *MemTable) Add(seq sequenceNumber, t ValueType, key string, value string) {
+	// Format of an entry is concatenation of:
+	//  key_size     : varint32 of internal_key.size()
+	//  key bytes    : char[internal_key.size()]
+	//  value_size   : varint32 of value.size()
+	//  value bytes  : char[value.size()]
+	keySize := len(key)
+	valSize := len(value)
+	internalKeySize := keySize + kKeyHead
+	encodedLen := varintLength(uint64(internalKeySize)) + internalKeySize +
+		varintLength(uint64(valSize)) + valSize
+
+	buf := this.arena.Allocate(encodedLen)
+	p := encodeVarint32(buf, uint32(internalKeySize))
+	p += copy(buf[p:], key)
+	encodeFixed64(buf[p:], packSequenceAndType(uint64(seq), t))
+	p += kKeyHead
+	p += encodeVarint32(buf[p:], uint32(valSize))
+	copy(buf[p:], value)
+
+	// The arena never hands out or reuses "buf" again, so the entry can
+	// share its storage.
+	this.table.Insert(bytesToString(buf))
+}
+
+// If memtable contains a value for key, store it in *value and return true.
+// If memtable contains a deletion for key, store a NotFound() error
+// in *status and return true.
+// Else, return false.
+func (this *MemTable) Get(key *LookupKey, value *string, s *Status) bool {
+	memKey := key.memtableKey()
+	iter := this.table.NewIterator()
+	iter.Seek(memKey)
+
+	if iter.Valid() {
+		// entry format is:
+		//    klength  varint32
+		//    userkey  char[klength]
+		//    tag      uint64
+		//    vlength  varint32
+		//    value    char[vlength]
+		// Check that it belongs to same user key.  We do not check the
+		// sequence number since the Seek() call above should have skipped
+		// all entries with overly large sequence numbers.
+		internalKey, rest := getLengthPrefixedSlice(iter.Key())
+
+		if this.comparator.userComparator().Compare(extractUserKey(internalKey), key.userKey()) == 0 {
+			// Correct user key
+			tag := decodeFixed64(internalKey[len(internalKey) - kKeyHead:])
+
+			switch ValueType(tag & 0xff) {
+			case kTypeValue:
+				v, _ := getLengthPrefixedSlice(rest)
+				*value = v
+				return true
+			case kTypeDeletion:
+				*s = NotFound("")
+				return true
+			}
+		}
 	}
-}
\ No newline at end of file
+
+	return false
+}
+
+// Encode a suitable internal key target for "target" and return it.
+func encodeKey(target string) string {
+	buf := make([]byte, kMaxVarint32Length)
+	n := encodeVarint32(buf, uint32(len(target)))
+	return string(buf[:n]) + target
+}
+
+type memTableIterator struct {
+	iter *structure.SkipListIterator
+}
+
+func (this *memTableIterator) Valid() bool {
+	return this.iter.Valid()
+}
+
+func (this *memTableIterator) Seek(k string) {
+	this.iter.Seek(encodeKey(k))
+}
+
+func (this *memTableIterator) SeekToFirst() {
+	this.iter.SeekToFirst()
+}
+
+func (this *memTableIterator) SeekToLast() {
+	this.iter.SeekToLast()
+}
+
+func (this *memTableIterator) Next() {
+	this.iter.Next()
+}
+
+func (this *memTableIterator) Prev() {
+	this.iter.Prev()
+}
+
+func (this *memTableIterator) Key() string {
+	key, _ := getLengthPrefixedSlice(this.iter.Key())
+	return key
+}
+
+func (this *memTableIterator) Value() string {
+	_, rest := getLengthPrefixedSlice(this.iter.Key())
+	value, _ := getLengthPrefixedSlice(rest)
+	return value
+}
+
+func (this *memTableIterator) Status() Status {
+	return OK()
+}
+
+func (this *memTableIterator) Close() {
+}
@@ -0,0 +1,176 @@
+package leveldb
+
+const (
+	kForward = iota
+	kReverse
+)
+
+type mergingIterator struct {
+	// We might want to use a heap in case there are lots of children.
+	// For now we use a simple array since we expect a very small number
+	// of children in leveldb.
+	comparator Comparator
+	children []*IteratorWrapper
+	current *IteratorWrapper
+
+	// Which direction is the iterator moving?
+	direction int
+}
+
+// Return an iterator that provided the union of the data in
+// children[0,n-1].  Takes ownership of the child iterators and
+// will delete them when the result iterator is deleted.
+//
+// The result does no duplicate suppression.  I.e., if a particular
+// key is present in K child iterators, it will be yielded K times.
+//
+// REQUIRES: n >= 0
+func NewMergingIterator(comparator Comparator, children []iterator) iterator {
+	if len(children) == 0 {
+		return NewEmptyIterator()
+	} else if len(children) == 1 {
+		return children[0]
+	}
+
+	result := &mergingIterator{
+		comparator: comparator,
+		children: make([]*IteratorWrapper, len(children)),
+		current: nil,
+		direction: kForward,
+	}
+	for i, child := range children {
+		result.children[i] = IteratorToIteratorWrapper(child)
+	}
+
+	return result
+}
+
+func (this *mergingIterator) Valid() bool {
+	return this.current != nil
+}
+
+func (this *mergingIterator) SeekToFirst() {
+	for _, child := range this.children {
+		child.SeekToFirst()
+	}
+	this.findSmallest()
+	this.direction = kForward
+}
+
+func (this *mergingIterator) SeekToLast() {
+	for _, child := range this.children {
+		child.SeekToLast()
+	}
+	this.findLargest()
+	this.direction = kReverse
+}
+
+func (this *mergingIterator) Seek(target string) {
+	for _, child := range this.children {
+		child.Seek(target)
+	}
+	this.findSmallest()
+	this.direction = kForward
+}
+
+func (this *mergingIterator) Next() {
+	// Ensure that all children are positioned after key().
+	// If we are moving in the forward direction, it is already
+	// true for all of the non-current children since current is
+	// the smallest child and key() == current.Key().  Otherwise,
+	// we explicitly position the non-current children.
+	if this.direction != kForward {
+		for _, child := range this.children {
+			if child != this.current {
+				child.Seek(this.Key())
+				if child.Valid() && this.comparator.Compare(this.Key(), child.Key()) == 0 {
+					child.Next()
+				}
+			}
+		}
+		this.direction = kForward
+	}
+
+	this.current.Next()
+	this.findSmallest()
+}
+
+func (this *mergingIterator) Prev() {
+	// Ensure that all children are positioned before key().
+	// If we are moving in the reverse direction, it is already
+	// true for all of the non-current children since current is
+	// the largest child and key() == current.Key().  Otherwise,
+	// we explicitly position the non-current children.
+	if this.direction != kReverse {
+		for _, child := range this.children {
+			if child != this.current {
+				child.Seek(this.Key())
+				if child.Valid() {
+					// Child is at first entry >= key().  Step back one to be < key()
+					child.Prev()
+				} else {
+					// Child has no entries >= key().  Position at last entry.
+					child.SeekToLast()
+				}
+			}
+		}
+		this.direction = kReverse
+	}
+
+	this.current.Prev()
+	this.findLargest()
+}
+
+func (this *mergingIterator) Key() string {
+	return this.current.Key()
+}
+
+func (this *mergingIterator) Value() string {
+	return this.current.Value()
+}
+
+func (this *mergingIterator) Status() Status {
+	for _, child := range this.children {
+		if s := child.Status(); !s.OK() {
+			return s
+		}
+	}
+
+	return OK()
+}
+
+func (this *mergingIterator) Close() {
+	for _, child := range this.children {
+		child.Set(nil)
+	}
+	this.current = nil
+}
+
+func (this *mergingIterator) findSmallest() {
+	var smallest *IteratorWrapper
+	for _, child := range this.children {
+		if child.Valid() {
+			if smallest == nil {
+				smallest = child
+			} else if this.comparator.Compare(child.Key(), smallest.Key()) < 0 {
+				smallest = child
+			}
+		}
+	}
+	this.current = smallest
+}
+
+func (this *mergingIterator) findLargest() {
+	var largest *IteratorWrapper
+	for i := len(this.children) - 1; i >= 0; i-- {
+		child := this.children[i]
+		if child.Valid() {
+			if largest == nil {
+				largest = child
+			} else if this.comparator.Compare(child.Key(), largest.Key()) > 0 {
+				largest = child
+			}
+		}
+	}
+	this.current = largest
+}
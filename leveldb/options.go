@@ -2,9 +2,14 @@ package leveldb
 
 type CompressionType byte
 
+// The type byte of every block names the Compressor that produced it;
+// see compression.go.  Type 2 is zstd in C++ leveldb and is left
+// unused here so that files stay readable by both.
 const (
-	NoCompression = iota
-	SnappyCompression
+	NoCompression = 0
+	SnappyCompression = 1
+	FlateCompression = 3
+	LZ4Compression = 4
 )
 
 type Options struct {
@@ -20,13 +25,79 @@ type Options struct {
 	BlockSize			uint
 	BlockRestartInterval int
 	Compression			CompressionType
+
+	// If non-empty, the compression used for tables written to level i
+	// is CompressionPerLevel[i], overriding Compression.  Levels past
+	// the end of the slice use its last element, so a fast codec can
+	// be chosen for the upper levels and a strong one for the bottom.
+	CompressionPerLevel	[]CompressionType
 	FilterPolicy
+
+	// EXPERIMENTAL: If true, append to existing MANIFEST and log files
+	// when a database is opened.  This can significantly speed up open.
+	ReuseLogs			bool
 }
 
 type ReadOptions struct {
+	// If true, all data read from underlying storage will be
+	// verified against corresponding checksums.
+	VerifyChecksums bool
+
+	// If "Snapshot" is non-nil, read as of the supplied snapshot
+	// (which must belong to the DB that is being read and which must
+	// not have been released).  If "Snapshot" is nil, use an implicit
+	// snapshot of the state at the beginning of this read operation.
+	Snapshot *Snapshot
+
+	// The bounds below are only used by iterators, which yield just
+	// the keys in [LowerBound, UpperBound) that start with Prefix.  A
+	// nil bound or Prefix does not restrict the keys.
+	//
+	// Iteration stops at the first key outside the bounds, and tables
+	// whose keys all lie outside them are never opened.
+
+	// Inclusive lower bound on the keys yielded.
+	LowerBound []byte
+
+	// Exclusive upper bound on the keys yielded.
+	UpperBound []byte
+
+	// Only keys that start with these bytes are yielded.  Under
+	// BytewiseComparator the prefix also bounds the keys read; under
+	// other comparators the keys without it are read and skipped, so
+	// set LowerBound and UpperBound as well where the comparator allows.
+	Prefix []byte
 }
 
 type WriteOptions struct {
+	// If true, the write will be flushed from the operating system
+	// buffer cache (by calling WritableFile.Sync()) before the write
+	// is considered complete.  If this flag is true, writes will be
+	// slower.
+	//
+	// If this flag is false, and the machine crashes, some recent
+	// writes may be lost.  Note that if it is just the process that
+	// crashes (i.e., the machine does not reboot), no writes will be
+	// lost even if Sync==false.
+	//
+	// In other words, a DB write with Sync==false has similar
+	// crash semantics as the "write()" system call.  A DB write
+	// with Sync==true has similar crash semantics to a "write()"
+	// system call followed by "fsync()".
+	Sync bool
+}
+
+// Options that control DB.IngestExternalFiles
+type IngestOptions struct {
+	// If true, the files are copied into the DB directory.  Otherwise
+	// they are hard linked where the Env supports it, and copied where
+	// it does not.  A linked file is shared with the caller, who must
+	// not modify it afterwards.
+	CopyFiles bool
+
+	// If true, every block of the files is checksummed while the files
+	// are validated.
+	VerifyChecksums bool
 }
 
 func NewOptions() *Options {
@@ -43,6 +114,32 @@ func NewOptions() *Options {
 		BlockSize: 4096,
 		BlockRestartInterval: 16,
 		Compression: NoCompression,
+		CompressionPerLevel: nil,
 		FilterPolicy: nil,
+		ReuseLogs: false,
 	}
+}
+
+// Return the compression to use for tables written to "level".
+func (this *Options) CompressionForLevel(level int) CompressionType {
+	if len(this.CompressionPerLevel) == 0 {
+		return this.Compression
+	}
+	if level >= len(this.CompressionPerLevel) {
+		level = len(this.CompressionPerLevel) - 1
+	}
+
+	return this.CompressionPerLevel[level]
+}
+
+// Return options for building a table at "level": a copy of "options"
+// whose Compression is the one chosen for that level.
+func optionsForLevel(options *Options, level int) *Options {
+	if len(options.CompressionPerLevel) == 0 {
+		return options
+	}
+
+	result := *options
+	result.Compression = options.CompressionForLevel(level)
+	return &result
 }
\ No newline at end of file
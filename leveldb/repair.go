@@ -0,0 +1,431 @@
+package leveldb
+
+import (
+	"strings"
+	"./utilties"
+)
+
+// We recover the contents of the descriptor from the other files we find.
+// (1) Any log files are first converted to tables
+// (2) We scan every table to compute
+//     (a) smallest/largest for the table
+//     (b) largest sequence number in the table
+// (3) We generate descriptor contents:
+//      - log number is set to zero
+//      - next-file-number is set to 1 + largest file number we found
+//      - last-sequence-number is set to largest sequence# found across
+//        all tables (see 2c)
+//      - compaction pointers are cleared
+//      - every table file is added at level 0
+//
+// Possible optimization 1:
+//   (a) Compute total size and use to pick appropriate max-level M
+//   (b) Sort tables by largest sequence# in the table
+//   (c) For each table: if it overlaps earlier table, place in level-0,
+//       else place in level-M.
+// Possible optimization 2:
+//   Store per-table metadata (smallest, largest, largest-seq#, ...)
+//   in the table's meta section to speed up ScanTable.
+
+type tableInfo struct {
+	meta FileMetaData
+	maxSequence sequenceNumber
+}
+
+type repairer struct {
+	dbName string
+	env Env
+	*internalKeyComparator
+	*internalFilterPolicy
+	options *Options
+	ownsInfoLog bool
+	ownsCache bool
+	tableCache *TableCache
+	edit *VersionEdit
+
+	manifests []string
+	tableNumbers []uint64
+	logs []uint64
+	tables []tableInfo
+	nextFileNumber uint64
+}
+
+func newRepairer(dbName string, options *Options) *repairer {
+	var r repairer
+
+	r.dbName = dbName
+	r.env = options.Env
+	r.internalKeyComparator = makeInternalKeyComparator(options.Comparator)
+	r.internalFilterPolicy = makeInternalFilterPolicy(options.FilterPolicy)
+	r.options = sanitizeOptions(dbName, *r.internalKeyComparator, *r.internalFilterPolicy, options)
+	r.ownsInfoLog = options.InfoLog != r.options.InfoLog
+	r.ownsCache = options.BlockCache != r.options.BlockCache
+	r.edit = newVersionEdit()
+	r.nextFileNumber = 1
+
+	// TableCache can be small since we expect each table to be opened once.
+	r.tableCache = newTableCache(dbName, r.options, 10)
+
+	return &r
+}
+
+func (this *repairer) Close() {
+	this.tableCache.Prune()
+	if this.ownsInfoLog {
+		if closer, ok := this.options.InfoLog.(interface{ Close() Status }); ok {
+			closer.Close()
+		}
+	}
+	if this.ownsCache {
+		this.options.BlockCache.Prune()
+	}
+}
+
+func (this *repairer) Run() Status {
+	status := this.FindFiles()
+	if status.OK() {
+		this.ConvertLogFilesToTables()
+		this.ExtractMetaData()
+		status = this.WriteDescriptor()
+	}
+	if status.OK() {
+		var bytes uint64
+		for _, t := range this.tables {
+			bytes += t.meta.fileSize
+		}
+		Log(this.options.InfoLog,
+			"**** Repaired leveldb %s; recovered %d files; %d bytes. Some data may have been lost. ****",
+			this.dbName, len(this.tables), bytes)
+	}
+
+	return status
+}
+
+func (this *repairer) FindFiles() Status {
+	filenames, status := this.env.GetChildren(this.dbName)
+	if !status.OK() {
+		return status
+	}
+	if len(filenames) == 0 {
+		return IOError(this.dbName + ": repair found no files")
+	}
+
+	var number uint64
+	var fileType FileType
+	for _, filename := range filenames {
+		if ParseFileName(filename, &number, &fileType) {
+			if fileType == kDescriptorFile {
+				this.manifests = append(this.manifests, filename)
+			} else {
+				if number + 1 > this.nextFileNumber {
+					this.nextFileNumber = number + 1
+				}
+				if fileType == kLogFile {
+					this.logs = append(this.logs, number)
+				} else if fileType == kTableFile {
+					this.tableNumbers = append(this.tableNumbers, number)
+				} else {
+					// Ignore other files
+				}
+			}
+		}
+	}
+
+	return status
+}
+
+func (this *repairer) ConvertLogFilesToTables() {
+	for _, logNumber := range this.logs {
+		logName := LogFileName(this.dbName, logNumber)
+		status := this.ConvertLogToTable(logNumber)
+		if !status.OK() {
+			Log(this.options.InfoLog, "Log #%d: ignoring conversion error: %s", logNumber, status.String())
+		}
+		this.ArchiveFile(logName)
+	}
+}
+
+type repairLogReporter struct {
+	infoLog Logger
+	logNumber uint64
+}
+
+func (this *repairLogReporter) Corruption(bytes int, s Status) {
+	// We print error messages for corruption, but continue repairing.
+	Log(this.infoLog, "Log #%d: dropping %d bytes; %s", this.logNumber, bytes, s.String())
+}
+
+func (this *repairer) ConvertLogToTable(logNumber uint64) Status {
+	// Open the log file
+	logName := LogFileName(this.dbName, logNumber)
+	var lfile SequentialFile
+	status := this.env.NewSequentialFile(logName, &lfile)
+	if !status.OK() {
+		return status
+	}
+
+	// Create the log reader.
+	reporter := &repairLogReporter{
+		infoLog: this.options.InfoLog,
+		logNumber: logNumber,
+	}
+
+	// We intentionally make LogReader do checksumming so that
+	// corruptions cause entire commits to be skipped instead of
+	// propagating bad information (like overly large sequence
+	// numbers).
+	reader := newLogReader(lfile, reporter, true /*checksum*/, 0 /*initialOffset*/)
+
+	// Read all the records and add to a memtable
+	var record, scratch []byte
+	batch := NewWriteBatch()
+	mem := newMemTable(*this.internalKeyComparator)
+	counter := 0
+	for reader.ReadRecord(&record, &scratch) {
+		if len(record) < kWriteBatchHeader {
+			reporter.Corruption(len(record), Corruption("log record too small"))
+			continue
+		}
+		setWriteBatchContents(batch, record)
+		status = writeBatchInsertInto(batch, mem)
+		if status.OK() {
+			counter += int(writeBatchCount(batch))
+		} else {
+			Log(this.options.InfoLog, "Log #%d: ignoring %s", logNumber, status.String())
+			status = OK()	// Keep going with rest of file
+		}
+	}
+	lfile.Close()
+
+	// Do not record a version edit for this conversion to a Table
+	// since ExtractMetaData() will also generate edits.
+	meta := newFileMetaData()
+	meta.number = this.nextFileNumber
+	this.nextFileNumber++
+	iter := mem.NewIterator()
+	status = BuildTable(this.dbName, this.env, optionsForLevel(this.options, 0), this.tableCache, iter, meta)
+	iter.Close()
+	if status.OK() && meta.fileSize > 0 {
+		this.tableNumbers = append(this.tableNumbers, meta.number)
+	}
+	Log(this.options.InfoLog, "Log #%d: %d ops saved to Table #%d %s",
+		logNumber, counter, meta.number, status.String())
+
+	return status
+}
+
+func (this *repairer) ExtractMetaData() {
+	for _, number := range this.tableNumbers {
+		this.ScanTable(number)
+	}
+}
+
+func (this *repairer) NewTableIterator(meta *FileMetaData) iterator {
+	// Same as compaction iterators: if paranoid_checks are on, turn
+	// on checksum verification.
+	readOptions := ReadOptions{
+		VerifyChecksums: this.options.ParanoidChecks,
+	}
+
+	// The sequence number an ingested table was assigned is lost with
+	// the manifest, so its entries are read as older than all others.
+	return this.tableCache.NewIterator(&readOptions, meta.number, meta.fileSize, 0, nil)
+}
+
+func (this *repairer) ScanTable(number uint64) {
+	var t tableInfo
+	t.meta.number = number
+	t.meta.smallest = new(internalKey)
+	t.meta.largest = new(internalKey)
+	fname := TableFileName(this.dbName, number)
+	fileSize, status := this.env.GetFileSize(fname)
+	if !status.OK() {
+		// Try alternate file name.
+		fname = SSTTableFileName(this.dbName, number)
+		var s2 Status
+		if fileSize, s2 = this.env.GetFileSize(fname); s2.OK() {
+			status = OK()
+		}
+	}
+	if !status.OK() {
+		this.ArchiveFile(TableFileName(this.dbName, number))
+		Log(this.options.InfoLog, "Table #%d: dropped: %s", t.meta.number, status.String())
+		return
+	}
+	t.meta.fileSize = uint64(fileSize)
+
+	// Extract metadata by scanning through table.
+	counter := 0
+	iter := this.NewTableIterator(&t.meta)
+	empty := true
+	var parsed parsedInternalKey
+	t.maxSequence = 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		key := iter.Key()
+		if !parseInternalKey(key, &parsed) {
+			Log(this.options.InfoLog, "Table #%d: unparsable key %s", t.meta.number, utilties.EscapeString(key))
+			continue
+		}
+
+		counter++
+		if empty {
+			empty = false
+			t.meta.smallest.decodeFrom(key)
+		}
+		t.meta.largest.decodeFrom(key)
+		if parsed.sequence > t.maxSequence {
+			t.maxSequence = parsed.sequence
+		}
+	}
+	if s := iter.Status(); !s.OK() {
+		status = s
+	}
+	iter.Close()
+	Log(this.options.InfoLog, "Table #%d: %d entries %s", t.meta.number, counter, status.String())
+
+	if status.OK() {
+		this.tables = append(this.tables, t)
+	} else {
+		this.RepairTable(fname, t)	// RepairTable archives input file.
+	}
+}
+
+func (this *repairer) RepairTable(src string, t tableInfo) {
+	// We will copy src contents to a new table and then rename the
+	// new table over the source.
+
+	// Create builder.
+	copyName := TableFileName(this.dbName, this.nextFileNumber)
+	this.nextFileNumber++
+	var file WritableFile
+	s := this.env.NewWritableFile(copyName, &file)
+	if !s.OK() {
+		return
+	}
+	// Repaired tables are all placed in level 0.
+	builder := NewTableBuilder(optionsForLevel(this.options, 0), file)
+
+	// Copy data.
+	iter := this.NewTableIterator(&t.meta)
+	counter := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		builder.Add(iter.Key(), iter.Value())
+		counter++
+	}
+	iter.Close()
+
+	this.ArchiveFile(src)
+	if counter == 0 {
+		builder.Abandon()	// Nothing to save
+	} else {
+		s = builder.Finish()
+		if s.OK() {
+			t.meta.fileSize = builder.FileSize()
+		}
+	}
+	file.Close()
+
+	if counter > 0 && s.OK() {
+		orig := TableFileName(this.dbName, t.meta.number)
+		s = this.env.RenameFile(copyName, orig)
+		if s.OK() {
+			Log(this.options.InfoLog, "Table #%d: %d entries repaired", t.meta.number, counter)
+			this.tables = append(this.tables, t)
+		}
+	}
+	if counter == 0 || !s.OK() {
+		this.env.DeleteFile(copyName)
+	}
+}
+
+func (this *repairer) WriteDescriptor() Status {
+	tmp := TempFileName(this.dbName, 1)
+	var file WritableFile
+	status := this.env.NewWritableFile(tmp, &file)
+	if !status.OK() {
+		return status
+	}
+
+	var maxSequence sequenceNumber
+	for _, t := range this.tables {
+		if maxSequence < t.maxSequence {
+			maxSequence = t.maxSequence
+		}
+	}
+
+	this.edit.SetComparatorName(this.userComparator().Name())
+	this.edit.SetLogNumber(0)
+	this.edit.SetNextFile(this.nextFileNumber)
+	this.edit.SetLastSequence(maxSequence)
+
+	for _, t := range this.tables {
+		// TODO(opt): separate out into multiple levels
+		this.edit.AddFile(0, t.meta.number, t.meta.fileSize, t.meta.smallest, t.meta.largest)
+	}
+
+	log := newLogWriter(&file)
+	var record []byte
+	this.edit.EncodeTo(&record)
+	status = log.AddRecord(record)
+	if status.OK() {
+		status = file.Sync()
+	}
+	if status.OK() {
+		status = file.Close()
+	} else {
+		file.Close()
+	}
+
+	if !status.OK() {
+		this.env.DeleteFile(tmp)
+	} else {
+		// Discard older manifests
+		for _, manifest := range this.manifests {
+			this.ArchiveFile(this.dbName + "/" + manifest)
+		}
+
+		// Install new manifest
+		status = this.env.RenameFile(tmp, DescriptorFileName(this.dbName, 1))
+		if status.OK() {
+			status = SetCurrentFile(this.env, this.dbName, 1)
+		} else {
+			this.env.DeleteFile(tmp)
+		}
+	}
+
+	return status
+}
+
+func (this *repairer) ArchiveFile(fname string) {
+	// Move into another directory.  E.g., for
+	//    dir/foo
+	// rename to
+	//    dir/lost/foo
+	newDir := ""
+	base := fname
+	if slash := strings.LastIndexByte(fname, '/'); slash >= 0 {
+		newDir = fname[:slash]
+		base = fname[slash + 1:]
+	}
+	newDir += "/lost"
+	this.env.CreateDir(newDir)	// Ignore error
+	newFile := newDir + "/" + base
+	s := this.env.RenameFile(fname, newFile)
+	Log(this.options.InfoLog, "Archiving %s: %s", fname, s.String())
+}
+
+// If a DB cannot be opened, you may attempt to call this method to
+// resurrect as much of the contents of the database as possible.
+// Some data may be lost, so be careful when calling this function
+// on a database that contains important information.
+//
+// The sequence number of a table added by DB.IngestExternalFiles is
+// recorded only in the descriptor, so the repaired DB treats the
+// entries of such a table as older than every other entry: where a key
+// was written before the ingestion, it reads the earlier value again.
+func RepairDB(name string, options *Options) error {
+	repairer := newRepairer(name, options)
+	defer repairer.Close()
+
+	return repairer.Run().Err()
+}
@@ -1,8 +1,65 @@
 package leveldb
 
+// Abstract handle to particular state of a DB.
+// A Snapshot is an immutable object and can therefore be safely
+// accessed from multiple threads without any external synchronization.
 type Snapshot interface {
 }
 
+// Snapshots are kept in a doubly-linked list in the DB.
+// Each snapshotImpl corresponds to a particular sequence number.
+type snapshotImpl struct {
+	number sequenceNumber	// const after creation
+
+	// snapshotImpl is kept in a doubly-linked circular list
+	prev *snapshotImpl
+	next *snapshotImpl
+
+	list *SnapshotList	// just for sanity checks
+}
+
 type SnapshotList struct {
-	
-}
\ No newline at end of file
+	// Dummy head of doubly-linked list of snapshots
+	head snapshotImpl
+}
+
+func newSnapshotList() *SnapshotList {
+	var list SnapshotList
+	list.head.next = &list.head
+	list.head.prev = &list.head
+
+	return &list
+}
+
+func (this *SnapshotList) Empty() bool {
+	return this.head.next == &this.head
+}
+
+func (this *SnapshotList) Oldest() *snapshotImpl {
+	return this.head.next
+}
+
+func (this *SnapshotList) Newest() *snapshotImpl {
+	return this.head.prev
+}
+
+func (this *SnapshotList) New(seq sequenceNumber) *snapshotImpl {
+	s := &snapshotImpl{
+		number: seq,
+		list: this,
+	}
+	s.next = &this.head
+	s.prev = this.head.prev
+	s.prev.next = s
+	s.next.prev = s
+
+	return s
+}
+
+func (this *SnapshotList) Delete(s *snapshotImpl) {
+	if s.list != this {
+		panic("snapshot released to the wrong list")
+	}
+	s.prev.next = s.next
+	s.next.prev = s.prev
+}
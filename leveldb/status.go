@@ -1,10 +1,22 @@
 package leveldb
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
+// A Status encapsulates the result of an operation.  It may indicate
+// success, or it may indicate an error with an associated error message.
+//
+// Status implements error, so a non-OK Status can be handed to code that
+// only understands standard Go errors.  errors.Is(s, ErrNotFound) and
+// friends test its code, and errors.Unwrap(s) returns the error from the
+// operating system that caused it, if any.  Note that an OK Status is
+// not a nil error; use Err() to get one.
 type Status struct {
 	code uint8
 	msg string
+	cause error
 }
 
 const(
@@ -14,25 +26,80 @@ const(
 	kNotSupported
 	kInvalidArgument
 	kIOError
+	kBusy
+	kTimedOut
+	kAborted
+	kNoSpace
+	kMaxCode
+)
+
+// Sentinel errors matched by errors.Is against a Status of the
+// corresponding code.
+var (
+	ErrNotFound = errors.New("leveldb: not found")
+	ErrCorruption = errors.New("leveldb: corruption")
+	ErrNotSupported = errors.New("leveldb: not supported")
+	ErrInvalidArgument = errors.New("leveldb: invalid argument")
+	ErrIOError = errors.New("leveldb: IO error")
+	ErrBusy = errors.New("leveldb: busy")
+	ErrTimedOut = errors.New("leveldb: timed out")
+	ErrAborted = errors.New("leveldb: aborted")
+	ErrNoSpace = errors.New("leveldb: no space")
 )
 
-func (this *Status) OK() bool {
+var codeErrors = [kMaxCode]error{
+	kNotFound: ErrNotFound,
+	kCorruption: ErrCorruption,
+	kNotSupported: ErrNotSupported,
+	kInvalidArgument: ErrInvalidArgument,
+	kIOError: ErrIOError,
+	kBusy: ErrBusy,
+	kTimedOut: ErrTimedOut,
+	kAborted: ErrAborted,
+	kNoSpace: ErrNoSpace,
+}
+
+func (this Status) OK() bool {
 	return this.code == kOK
 }
 
-func (this *Status) IsNotFound() bool {
+func (this Status) IsNotFound() bool {
 	return this.code == kNotFound
 }
 
-func (this *Status) IsCorruption() bool {
+func (this Status) IsCorruption() bool {
 	return this.code == kCorruption
 }
 
-func (this *Status) IsIOError() bool {
+func (this Status) IsNotSupported() bool {
+	return this.code == kNotSupported
+}
+
+func (this Status) IsInvalidArgument() bool {
+	return this.code == kInvalidArgument
+}
+
+func (this Status) IsIOError() bool {
 	return this.code == kIOError
 }
 
-func (this *Status) String() string {
+func (this Status) IsBusy() bool {
+	return this.code == kBusy
+}
+
+func (this Status) IsTimedOut() bool {
+	return this.code == kTimedOut
+}
+
+func (this Status) IsAborted() bool {
+	return this.code == kAborted
+}
+
+func (this Status) IsNoSpace() bool {
+	return this.code == kNoSpace
+}
+
+func (this Status) String() string {
 	var sCode string
 	switch this.code {
 	case kOK:
@@ -40,20 +107,51 @@ func (this *Status) String() string {
 	case kNotFound:
 		sCode = "NotFound: "
 	case kCorruption:
-		sCode = "Corrupution: "
+		sCode = "Corruption: "
 	case kNotSupported:
 		sCode = "Not implemented: "
 	case kInvalidArgument:
 		sCode = "Invalid argument: "
 	case kIOError:
 		sCode = "IO error: "
+	case kBusy:
+		sCode = "Busy: "
+	case kTimedOut:
+		sCode = "Timed out: "
+	case kAborted:
+		sCode = "Aborted: "
+	case kNoSpace:
+		sCode = "No space: "
 	default:
-		sCode = fmt.Sprintf("unknow code (%v): ", this.code)
+		sCode = fmt.Sprintf("unknown code (%v): ", this.code)
 	}
 
 	return sCode + this.msg
 }
 
+func (this Status) Error() string {
+	return this.String()
+}
+
+// Return the error that caused this status, or nil.
+func (this Status) Unwrap() error {
+	return this.cause
+}
+
+// Report whether "target" is the sentinel error for this status's code.
+func (this Status) Is(target error) bool {
+	return this.code < kMaxCode && codeErrors[this.code] != nil && codeErrors[this.code] == target
+}
+
+// Return nil if this status is OK, and the status itself otherwise.
+func (this Status) Err() error {
+	if this.OK() {
+		return nil
+	}
+
+	return this
+}
+
 func makeStatus(code uint8, msg string) Status {
 	return Status {
 		code: code,
@@ -84,3 +182,25 @@ func InvalidArgument(msg string) Status {
 func IOError(msg string) Status {
 	return makeStatus(kIOError, msg)
 }
+
+func Busy(msg string) Status {
+	return makeStatus(kBusy, msg)
+}
+
+func TimedOut(msg string) Status {
+	return makeStatus(kTimedOut, msg)
+}
+
+func Aborted(msg string) Status {
+	return makeStatus(kAborted, msg)
+}
+
+func NoSpace(msg string) Status {
+	return makeStatus(kNoSpace, msg)
+}
+
+// Return a copy of "s" that records "cause" as the error that led to it.
+func (this Status) WithCause(cause error) Status {
+	this.cause = cause
+	return this
+}
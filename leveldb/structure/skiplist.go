@@ -1,8 +1,239 @@
 package structure
 
+import (
+	"math/rand"
+	"sync"
+)
+
 // leveldb 是使用 skiplist 作为容器的
 // 这里先抽象出语义
 
+const kMaxHeight = 12
+
+type skipListNode struct {
+	key string
+	next []*skipListNode
+}
+
+// Thread safety
+// -------------
+//
+// Writes and reads are serialized by an internal RWMutex, so that a
+// single writer may insert while readers iterate.  Nodes are never
+// deleted until the SkipList is destroyed.
 type SkipList struct {
-	
-}
\ No newline at end of file
+	compare func(a, b string) int
+	head *skipListNode
+
+	// Height of the entire list
+	maxHeight int
+
+	rnd *rand.Rand
+	mutex sync.RWMutex
+}
+
+// Create a new SkipList object that will use "compare" for comparing keys.
+func NewSkipList(compare func(a, b string) int) *SkipList {
+	return &SkipList{
+		compare: compare,
+		head: &skipListNode{
+			next: make([]*skipListNode, kMaxHeight),
+		},
+		maxHeight: 1,
+		rnd: rand.New(rand.NewSource(0xdeadbeef)),
+	}
+}
+
+func (this *SkipList) randomHeight() int {
+	// Increase height with probability 1 in kBranching
+	const kBranching = 4
+	height := 1
+	for height < kMaxHeight && this.rnd.Intn(kBranching) == 0 {
+		height++
+	}
+
+	return height
+}
+
+// Return true if key is greater than the data stored in "n"
+func (this *SkipList) keyIsAfterNode(key string, n *skipListNode) bool {
+	return n != nil && this.compare(n.key, key) < 0
+}
+
+// Return the earliest node that comes at or after key.
+// Return nil if there is no such node.
+//
+// If prev is non-nil, fills prev[level] with pointer to previous
+// node at "level" for every level in [0..maxHeight-1].
+func (this *SkipList) findGreaterOrEqual(key string, prev []*skipListNode) *skipListNode {
+	x := this.head
+	level := this.maxHeight - 1
+	for {
+		next := x.next[level]
+		if this.keyIsAfterNode(key, next) {
+			// Keep searching in this list
+			x = next
+		} else {
+			if prev != nil {
+				prev[level] = x
+			}
+			if level == 0 {
+				return next
+			}
+			// Switch to next list
+			level--
+		}
+	}
+}
+
+// Return the latest node with a key < key.
+// Return head if there is no such node.
+func (this *SkipList) findLessThan(key string) *skipListNode {
+	x := this.head
+	level := this.maxHeight - 1
+	for {
+		next := x.next[level]
+		if next == nil || this.compare(next.key, key) >= 0 {
+			if level == 0 {
+				return x
+			}
+			// Switch to next list
+			level--
+		} else {
+			x = next
+		}
+	}
+}
+
+// Return the last node in the list.
+// Return head if list is empty.
+func (this *SkipList) findLast() *skipListNode {
+	x := this.head
+	level := this.maxHeight - 1
+	for {
+		next := x.next[level]
+		if next == nil {
+			if level == 0 {
+				return x
+			}
+			// Switch to next list
+			level--
+		} else {
+			x = next
+		}
+	}
+}
+
+// Insert key into the list.
+// REQUIRES: nothing that compares equal to key is currently in the list.
+func (this *SkipList) Insert(key string) {
+	this.mutex.Lock()
+	defer this.mutex.Unlock()
+
+	prev := make([]*skipListNode, kMaxHeight)
+	this.findGreaterOrEqual(key, prev)
+
+	height := this.randomHeight()
+	if height > this.maxHeight {
+		for i := this.maxHeight; i < height; i++ {
+			prev[i] = this.head
+		}
+		this.maxHeight = height
+	}
+
+	x := &skipListNode{
+		key: key,
+		next: make([]*skipListNode, height),
+	}
+	for i := 0; i < height; i++ {
+		x.next[i] = prev[i].next[i]
+		prev[i].next[i] = x
+	}
+}
+
+// Returns true iff an entry that compares equal to key is in the list.
+func (this *SkipList) Contains(key string) bool {
+	this.mutex.RLock()
+	defer this.mutex.RUnlock()
+
+	x := this.findGreaterOrEqual(key, nil)
+	return x != nil && this.compare(key, x.key) == 0
+}
+
+// Iteration over the contents of a skip list
+type SkipListIterator struct {
+	list *SkipList
+	node *skipListNode
+}
+
+// Initialize an iterator over the specified list.
+// The returned iterator is not valid.
+func (this *SkipList) NewIterator() *SkipListIterator {
+	return &SkipListIterator{
+		list: this,
+		node: nil,
+	}
+}
+
+// Returns true iff the iterator is positioned at a valid node.
+func (this *SkipListIterator) Valid() bool {
+	return this.node != nil
+}
+
+// Returns the key at the current position.
+// REQUIRES: Valid()
+func (this *SkipListIterator) Key() string {
+	return this.node.key
+}
+
+// Advances to the next position.
+// REQUIRES: Valid()
+func (this *SkipListIterator) Next() {
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	this.node = this.node.next[0]
+}
+
+// Advances to the previous position.
+// REQUIRES: Valid()
+func (this *SkipListIterator) Prev() {
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	// Instead of using explicit "prev" links, we just search for the
+	// last node that falls before key.
+	this.node = this.list.findLessThan(this.node.key)
+	if this.node == this.list.head {
+		this.node = nil
+	}
+}
+
+// Advance to the first entry with a key >= target
+func (this *SkipListIterator) Seek(target string) {
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	this.node = this.list.findGreaterOrEqual(target, nil)
+}
+
+// Position at the first entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (this *SkipListIterator) SeekToFirst() {
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	this.node = this.list.head.next[0]
+}
+
+// Position at the last entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (this *SkipListIterator) SeekToLast() {
+	this.list.mutex.RLock()
+	defer this.list.mutex.RUnlock()
+
+	this.node = this.list.findLast()
+	if this.node == this.list.head {
+		this.node = nil
+	}
+}
@@ -7,35 +7,389 @@ type Table struct {
 	options *Options
 	s Status
 	file *RandomAccessFile
+	cacheId uint64
 	filter *FilterBlockReader
 	filterData []byte
-	metaIndexHandle *BlockHandle
+	metaIndexHandle *BlockHandle	// Handle to metaindex_block: saved from footer
 	indexBlock *Block
+	comparatorName string	// Recorded in the metaindex block; "" if absent
+	comparator Comparator	// Orders the keys of the blocks
+
+	// An ingested table (see DB.IngestExternalFiles) holds user keys,
+	// which are read as internal keys carrying globalSequence.
+	userKeys bool
+	globalSequence sequenceNumber
 }
 
-func BlockReader(arg interface{}, options *ReadOptions, indexValue string) Iterator {
-	// table, _ := arg.(*Table)
-	// blockCache := table.options.BlockCache
-	// var (block Block
-	// 	 Handle interface{}
-	// 	 handle BlockHandle
-	// 	)
-	// input := indexValue
+// Attempt to open the table that is stored in bytes [0..size)
+// of "file", and read the metadata entries necessary to allow
+// retrieving data from the table.
+//
+// If successful, returns ok and sets "*table" to the newly opened
+// table.  If there was an error while initializing the table, sets
+// "*table" to nil and returns a non-ok status.
+//
+// file must remain live while this Table is in use.
+func OpenTable(options *Options, file RandomAccessFile, size uint64, table **Table) Status {
+	*table = nil
+	if size < kEncodedLength {
+		return Corruption("file is too short to be an sstable")
+	}
+
+	footerSpace := make([]byte, kEncodedLength)
+	var footerInput []byte
+	s := file.Read(int64(size - kEncodedLength), footerSpace, &footerInput)
+	if !s.OK() {
+		return s
+	}
+	if len(footerInput) != kEncodedLength {
+		return Corruption("truncated footer read")
+	}
+
+	var footer Footer
+	s = footer.DecodeFrom(&footerInput)
+	if !s.OK() {
+		return s
+	}
+
+	// Read the index block
+	var indexBlockContents BlockContents
+	var opt ReadOptions
+	if options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	s = ReadBlock(file, &opt, &footer.indexHandle, &indexBlockContents)
+
+	if s.OK() {
+		// We've successfully read the footer and the index block: we're
+		// ready to serve requests.
+		t := &Table{
+			options: options,
+			s: OK(),
+			file: &file,
+			metaIndexHandle: &footer.metaindexHandle,
+			indexBlock: newBlock(&indexBlockContents),
+		}
+		if options.BlockCache != nil {
+			t.cacheId = options.BlockCache.NewId()
+		}
+		t.readMeta(&footer)
+		s = t.resolveComparator()
+		if s.OK() {
+			*table = t
+		}
+	}
+
+	return s
+}
+
+func (this *Table) readMeta(footer *Footer) {
+	var opt ReadOptions
+	if this.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	var contents BlockContents
+	if !ReadBlock(*this.file, &opt, &footer.metaindexHandle, &contents).OK() {
+		// Do not propagate errors since meta info is not needed for operation
+		return
+	}
+
+	meta := newBlock(&contents)
+	iter := meta.NewIterator(BytewiseComparator())
+	iter.Seek(kComparatorMetaKey)
+	if iter.Valid() && iter.Key() == kComparatorMetaKey {
+		this.comparatorName = iter.Value()
+	}
+	if this.options.FilterPolicy != nil {
+		key := "filter." + this.options.FilterPolicy.Name()
+		iter.Seek(key)
+		if iter.Valid() && iter.Key() == key {
+			this.readFilter(iter.Value())
+		}
+	}
+	iter.Close()
+}
+
+func (this *Table) readFilter(filterHandleValue string) {
+	input := []byte(filterHandleValue)
+	var filterHandle BlockHandle
+	if !filterHandle.DecodeFrom(&input).OK() {
+		return
+	}
+
+	var opt ReadOptions
+	if this.options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	var block BlockContents
+	if !ReadBlock(*this.file, &opt, &filterHandle, &block).OK() {
+		return
+	}
+	if block.heapAllocated {
+		this.filterData = block.data	// Owned by the table
+	}
+	this.filter = newFilterBlockReader(this.options.FilterPolicy, block.data)
+}
+
+// Pick the comparator that orders the keys of the table.  Tables built
+// before the comparator name was recorded are assumed to use
+// options.Comparator.  The DB opens its tables with an internal key
+// comparator; a table built with the user comparator is an ingested
+// table, whose user keys are read as internal keys.
+func (this *Table) resolveComparator() Status {
+	this.comparator = this.options.Comparator
+	if this.comparatorName == "" || this.comparatorName == this.comparator.Name() {
+		return OK()
+	}
+
+	if icmp, ok := this.comparator.(*internalKeyComparator); ok && this.comparatorName == icmp.user.Name() {
+		this.comparator = icmp.user
+		this.userKeys = true
+		return OK()
+	}
+
+	return InvalidArgument("table was built with comparator " + this.comparatorName +
+		", which does not match " + this.comparator.Name())
+}
 
-	//s := handle.DecodeFrom([]byte(input) )
-	// We intentionally allow extra stuff in index_value so that we
+// Return the name of the comparator recorded in the table, or "" if
+// the table does not record one.
+func (this *Table) ComparatorName() string {
+	return this.comparatorName
+}
+
+func deleteCachedBlock(key string, value *interface{}) {
+	// Blocks own nothing but memory, which the garbage collector reclaims.
+}
+
+// Convert an index iterator value (i.e., an encoded BlockHandle)
+// into an iterator over the contents of the corresponding block.
+func BlockReader(arg interface{}, options *ReadOptions, indexValue string) iterator {
+	table, _ := arg.(*Table)
+	blockCache := table.options.BlockCache
+	var block *Block
+	var cacheHandle interface{}
+
+	var handle BlockHandle
+	input := []byte(indexValue)
+	s := handle.DecodeFrom(&input)
+	// We intentionally allow extra stuff in indexValue so that we
 	// can add more features in the future.
-	//if s.OK() {
-		// var contents BlockContents
-		// if blockCache != nil {
-		// 	var cacheKeyBuffer [16]byte
-		// }
-	//}
-	// todo
 
-	return NewEmptyIterator()
+	if s.OK() {
+		var contents BlockContents
+		if blockCache != nil {
+			cacheKeyBuffer := make([]byte, 16)
+			encodeFixed64(cacheKeyBuffer, table.cacheId)
+			encodeFixed64(cacheKeyBuffer[8:], handle.offset)
+			key := string(cacheKeyBuffer)
+
+			cacheHandle = blockCache.Lookup(key)
+			if cacheHandle != nil {
+				block = (*blockCache.Value(&cacheHandle)).(*Block)
+			} else {
+				s = ReadBlock(*table.file, options, &handle, &contents)
+				if s.OK() {
+					block = newBlock(&contents)
+					if contents.cachable {
+						var value interface{} = block
+						cacheHandle = blockCache.Insert(key, &value, block.Size(), deleteCachedBlock)
+					}
+				}
+			}
+		} else {
+			s = ReadBlock(*table.file, options, &handle, &contents)
+			if s.OK() {
+				block = newBlock(&contents)
+			}
+		}
+	}
+
+	if block == nil {
+		return NewErrorIterator(s)
+	}
+
+	iter := block.NewIterator(table.comparator)
+	if cacheHandle != nil {
+		// The iterator keeps the block reachable on its own, so the
+		// cache entry does not need to stay pinned.
+		blockCache.Release(cacheHandle)
+	}
+
+	return iter
+}
+
+// Returns a new iterator over the table contents.
+// The result of NewIterator() is initially invalid (caller must
+// call one of the Seek methods on the iterator before using it).
+func (this *Table) NewIterator(readOptions *ReadOptions) iterator {
+	iter := NewTwoLevelIterator(this.indexBlock.NewIterator(this.comparator), BlockReader, this, readOptions)
+	if this.userKeys {
+		return newGlobalSequenceIterator(this.options.Comparator, iter, this.globalSequence)
+	}
+
+	return iter
+}
+
+// Given a key, return an approximate byte offset in the file where
+// the data for that key begins (or would begin if the key were
+// present in the file).  The returned value is in terms of file
+// bytes, and so includes effects like compression of the underlying data.
+// E.g., the approximate offset of the last key in the table will
+// be close to the file length.
+func (this *Table) ApproximateOffsetOf(key string) uint64 {
+	if this.userKeys {
+		key = extractUserKey(key)
+	}
+	indexIter := this.indexBlock.NewIterator(this.comparator)
+	indexIter.Seek(key)
+
+	var result uint64
+	if indexIter.Valid() {
+		var handle BlockHandle
+		input := []byte(indexIter.Value())
+		s := handle.DecodeFrom(&input)
+		if s.OK() {
+			result = handle.offset
+		} else {
+			// Strange: we can't decode the block handle in the index block.
+			// We'll just return the offset of the metaindex block, which is
+			// close to the whole file size for this case.
+			result = this.metaIndexHandle.offset
+		}
+	} else {
+		// key is past the last key in the file.  Approximate the offset
+		// by returning the offset of the metaindex block (which is
+		// right near the end of the file).
+		result = this.metaIndexHandle.offset
+	}
+	indexIter.Close()
+
+	return result
 }
 
-func (this *Table) NewIterator(readOptions *ReadOptions) Iterator {
-	return NewTwoLevelIterator(this.indexBlock.NewIterator(this.options.Comparator), BlockReader, this, readOptions)
-}
\ No newline at end of file
+// Calls saver(arg, ...) with the entry found after a call
+// to Seek(key).  May not make such a call if filter policy says
+// that key is not present.
+func (this *Table) InternalGet(options *ReadOptions, k string, arg interface{}, saver func(arg interface{}, k string, v string)) Status {
+	if this.userKeys {
+		// The entry found must honour the sequence number of "k", which
+		// the iterator takes care of.
+		iter := this.NewIterator(options)
+		iter.Seek(k)
+		if iter.Valid() {
+			saver(arg, iter.Key(), iter.Value())
+		}
+		s := iter.Status()
+		iter.Close()
+		return s
+	}
+
+	s := OK()
+	iiter := this.indexBlock.NewIterator(this.comparator)
+	iiter.Seek(k)
+
+	if iiter.Valid() {
+		handleValue := iiter.Value()
+		input := []byte(handleValue)
+		var handle BlockHandle
+		if this.filter != nil && handle.DecodeFrom(&input).OK() &&
+			!this.filter.KeyMayMatch(handle.offset, k) {
+			// Not found
+		} else {
+			blockIter := BlockReader(this, options, handleValue)
+			blockIter.Seek(k)
+			if blockIter.Valid() {
+				saver(arg, blockIter.Key(), blockIter.Value())
+			}
+			s = blockIter.Status()
+			blockIter.Close()
+		}
+	}
+
+	if s.OK() {
+		s = iiter.Status()
+	}
+	iiter.Close()
+
+	return s
+}
+
+// An iterator over the user keys of an ingested table that presents
+// each of them as an internal key with the sequence number the table
+// was assigned at ingestion.
+type globalSequenceIterator struct {
+	icmp Comparator
+	iter iterator
+	tag string	// Encoded sequence number and kTypeValue
+	key string	// Internal key of the current entry
+}
+
+func newGlobalSequenceIterator(icmp Comparator, iter iterator, sequence sequenceNumber) iterator {
+	tag := make([]byte, kKeyHead)
+	encodeFixed64(tag, packSequenceAndType(uint64(sequence), kTypeValue))
+
+	return &globalSequenceIterator{
+		icmp: icmp,
+		iter: iter,
+		tag: string(tag),
+	}
+}
+
+func (this *globalSequenceIterator) update() {
+	if this.iter.Valid() {
+		this.key = this.iter.Key() + this.tag
+	} else {
+		this.key = ""
+	}
+}
+
+func (this *globalSequenceIterator) Valid() bool {
+	return this.iter.Valid()
+}
+
+func (this *globalSequenceIterator) Seek(target string) {
+	this.iter.Seek(extractUserKey(target))
+	this.update()
+	if this.iter.Valid() && this.icmp.Compare(this.key, target) < 0 {
+		// Same user key, but newer than the sequence number of "target"
+		this.iter.Next()
+		this.update()
+	}
+}
+
+func (this *globalSequenceIterator) SeekToFirst() {
+	this.iter.SeekToFirst()
+	this.update()
+}
+
+func (this *globalSequenceIterator) SeekToLast() {
+	this.iter.SeekToLast()
+	this.update()
+}
+
+func (this *globalSequenceIterator) Next() {
+	this.iter.Next()
+	this.update()
+}
+
+func (this *globalSequenceIterator) Prev() {
+	this.iter.Prev()
+	this.update()
+}
+
+func (this *globalSequenceIterator) Key() string {
+	return this.key
+}
+
+func (this *globalSequenceIterator) Value() string {
+	return this.iter.Value()
+}
+
+func (this *globalSequenceIterator) Status() Status {
+	return this.iter.Status()
+}
+
+func (this *globalSequenceIterator) Close() {
+	this.iter.Close()
+}
@@ -0,0 +1,185 @@
+package table
+
+import (
+	"io"
+	"unsafe"
+	"../../leveldb"
+)
+
+// Options that control how a Reader reads a table.
+type ReaderOptions struct {
+	// Comparator the table was written with.
+	// If nil, leveldb.BytewiseComparator() is used.  NewReader fails if
+	// the table records a comparator with another name.
+	Comparator leveldb.Comparator
+
+	// If true, all data read from the table is verified against its
+	// checksums.
+	VerifyChecksums bool
+
+	// If non-nil, use the specified cache for blocks.
+	BlockCache leveldb.Cache
+
+	// If non-nil and the table stores filters built with a policy of
+	// the same name, Get() consults them before reading a block.
+	FilterPolicy leveldb.FilterPolicy
+}
+
+// A Reader serves lookups and scans over a table written by a Writer
+// or by a DB.
+//
+// Multiple goroutines can use a Reader without external synchronization.
+type Reader struct {
+	comparator leveldb.Comparator
+	table *leveldb.Table
+	readOptions *leveldb.ReadOptions
+}
+
+// The iterators of leveldb.Table, as far as a Reader uses them.
+type tableIterator interface {
+	Valid() bool
+	SeekToFirst()
+	SeekToLast()
+	Seek(target string)
+	Next()
+	Prev()
+	Key() string
+	Value() string
+	Status() leveldb.Status
+	Close()
+}
+
+// Return a Reader for the table stored in bytes [0..size) of "r".
+// "r" must stay usable for as long as the Reader and its iterators
+// are in use.
+func NewReader(r io.ReaderAt, size int64, o ReaderOptions) (*Reader, error) {
+	options := leveldb.NewOptions()
+	if o.Comparator != nil {
+		options.Comparator = o.Comparator
+	}
+	options.ParanoidChecks = o.VerifyChecksums
+	options.BlockCache = o.BlockCache
+	options.FilterPolicy = o.FilterPolicy
+
+	var table *leveldb.Table
+	s := leveldb.OpenTable(options, &randomAccessFile{r: r}, uint64(size), &table)
+	if !s.OK() {
+		return nil, s
+	}
+
+	return &Reader{
+		comparator: options.Comparator,
+		table: table,
+		readOptions: &leveldb.ReadOptions{VerifyChecksums: o.VerifyChecksums},
+	}, nil
+}
+
+// Return the value stored for "key".  If there is none, the error
+// matches leveldb.ErrNotFound.
+func (this *Reader) Get(key []byte) ([]byte, error) {
+	var value []byte
+	found := false
+	s := this.table.InternalGet(this.readOptions, string(key), nil, func(arg interface{}, k string, v string) {
+		if this.comparator.Compare(k, string(key)) == 0 {
+			value = []byte(v)
+			found = true
+		}
+	})
+	if !s.OK() {
+		return nil, s
+	}
+	if !found {
+		return nil, leveldb.NotFound("")
+	}
+
+	return value, nil
+}
+
+// Return an iterator over the contents of the table.  The result is
+// initially invalid (caller must call one of the Seek methods on the
+// iterator before using it), and must be closed when no longer needed.
+func (this *Reader) NewIterator() leveldb.Iterator {
+	return &iterator{iter: this.table.NewIterator(this.readOptions)}
+}
+
+// Given a key, return an approximate byte offset in the table where
+// the data for that key begins (or would begin if the key were
+// present in the table).
+func (this *Reader) ApproximateOffsetOf(key []byte) uint64 {
+	return this.table.ApproximateOffsetOf(string(key))
+}
+
+// iterator adapts the iterators of leveldb.Table to leveldb.Iterator.
+type iterator struct {
+	iter tableIterator
+}
+
+func (this *iterator) Valid() bool {
+	return this.iter.Valid()
+}
+
+func (this *iterator) SeekToFirst() {
+	this.iter.SeekToFirst()
+}
+
+func (this *iterator) SeekToLast() {
+	this.iter.SeekToLast()
+}
+
+func (this *iterator) Seek(target []byte) {
+	this.iter.Seek(string(target))
+}
+
+func (this *iterator) Next() {
+	this.iter.Next()
+}
+
+func (this *iterator) Prev() {
+	this.iter.Prev()
+}
+
+// The slices returned by Key() and Value() share storage with the
+// iterator and must not be modified.
+
+func (this *iterator) Key() []byte {
+	return stringToBytes(this.iter.Key())
+}
+
+func (this *iterator) Value() []byte {
+	return stringToBytes(this.iter.Value())
+}
+
+func (this *iterator) Error() error {
+	return this.iter.Status().Err()
+}
+
+func (this *iterator) Close() error {
+	err := this.Error()
+	this.iter.Close()
+
+	return err
+}
+
+func stringToBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// randomAccessFile adapts an io.ReaderAt to leveldb.RandomAccessFile.
+type randomAccessFile struct {
+	r io.ReaderAt
+}
+
+func (this *randomAccessFile) Read(offset int64, scratch []byte, result *[]byte) leveldb.Status {
+	n, err := this.r.ReadAt(scratch, offset)
+	*result = scratch[:n]
+	if err != nil && err != io.EOF {
+		return leveldb.IOError("read failed").WithCause(err)
+	}
+
+	return leveldb.OK()
+}
+
+func (this *randomAccessFile) Close() leveldb.Status {
+	// The caller owns the reader.
+	return leveldb.OK()
+}
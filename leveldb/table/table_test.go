@@ -0,0 +1,301 @@
+package table
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"../../leveldb"
+)
+
+// Build a table holding "kvs", in order, and return its contents.
+func buildTable(t *testing.T, o WriterOptions, kvs [][2]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, o)
+	for _, kv := range kvs {
+		if err := w.Add([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Add(%q): %v", kv[0], err)
+		}
+	}
+	if err := w.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if w.NumEntries() != int64(len(kvs)) || w.FileSize() != uint64(buf.Len()) {
+		t.Fatalf("%d entries in %d bytes, wrote %d entries in %d bytes",
+			w.NumEntries(), w.FileSize(), len(kvs), buf.Len())
+	}
+
+	return buf.Bytes()
+}
+
+func openReader(t *testing.T, contents []byte, o ReaderOptions) *Reader {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(contents), int64(len(contents)), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+// Keys spread over many blocks, with values of varying length.
+func testKVs() [][2]string {
+	var kvs [][2]string
+	for i := 0; i < 500; i++ {
+		kvs = append(kvs, [2]string{fmt.Sprintf("key%05d", i * 2), strings.Repeat(fmt.Sprint(i), i % 13)})
+	}
+
+	return kvs
+}
+
+func TestRoundTrip(t *testing.T) {
+	kvs := testKVs()
+	for _, compression := range []leveldb.CompressionType{leveldb.NoCompression, leveldb.SnappyCompression,
+		leveldb.FlateCompression, leveldb.LZ4Compression} {
+		contents := buildTable(t, WriterOptions{BlockSize: 256, BlockRestartInterval: 4, Compression: compression}, kvs)
+		r := openReader(t, contents, ReaderOptions{VerifyChecksums: true})
+
+		for _, kv := range kvs {
+			value, err := r.Get([]byte(kv[0]))
+			if err != nil || string(value) != kv[1] {
+				t.Fatalf("compression %d: Get(%q) = %q, %v", compression, kv[0], value, err)
+			}
+		}
+		for _, key := range []string{"", "key00001", "key00999", "zzz"} {
+			if _, err := r.Get([]byte(key)); !errors.Is(err, leveldb.ErrNotFound) {
+				t.Fatalf("compression %d: Get(%q): %v", compression, key, err)
+			}
+		}
+
+		iter := r.NewIterator()
+		i := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			if i >= len(kvs) || string(iter.Key()) != kvs[i][0] || string(iter.Value()) != kvs[i][1] {
+				t.Fatalf("compression %d: entry %d is %q", compression, i, iter.Key())
+			}
+			i++
+		}
+		if i != len(kvs) {
+			t.Fatalf("compression %d: iterated over %d of %d entries", compression, i, len(kvs))
+		}
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			i--
+			if i < 0 || string(iter.Key()) != kvs[i][0] || string(iter.Value()) != kvs[i][1] {
+				t.Fatalf("compression %d: entry %d from the end is %q", compression, len(kvs) - i, iter.Key())
+			}
+		}
+		if i != 0 {
+			t.Fatalf("compression %d: iterated back over %d of %d entries", compression, len(kvs) - i, len(kvs))
+		}
+
+		// A target between two keys lands on the later one.
+		iter.Seek([]byte("key00501"))
+		if !iter.Valid() || string(iter.Key()) != "key00502" {
+			t.Fatalf("compression %d: Seek landed on %q", compression, iter.Key())
+		}
+		iter.Prev()
+		if !iter.Valid() || string(iter.Key()) != "key00500" {
+			t.Fatalf("compression %d: Prev after Seek landed on %q", compression, iter.Key())
+		}
+		iter.Seek([]byte("zzz"))
+		if iter.Valid() {
+			t.Fatalf("compression %d: Seek past the last key yielded %q", compression, iter.Key())
+		}
+		if err := iter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAddOutOfOrder(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{}, WriterOptions{})
+	if err := w.Add([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := w.Add([]byte(key), nil); !errors.Is(err, leveldb.ErrInvalidArgument) {
+			t.Fatalf("Add(%q) after b: %v", key, err)
+		}
+	}
+
+	// The comparator decides the order.
+	w = NewWriter(&bytes.Buffer{}, WriterOptions{Comparator: leveldb.ReverseBytewiseComparator()})
+	if err := w.Add([]byte("b"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add([]byte("a"), nil); err != nil {
+		t.Fatalf("Add(a) after b in reverse order: %v", err)
+	}
+}
+
+func TestComparator(t *testing.T) {
+	kvs := [][2]string{{"c", "3"}, {"b", "2"}, {"a", "1"}}
+	contents := buildTable(t, WriterOptions{Comparator: leveldb.ReverseBytewiseComparator()}, kvs)
+
+	if _, err := NewReader(bytes.NewReader(contents), int64(len(contents)), ReaderOptions{}); !errors.Is(err, leveldb.ErrInvalidArgument) {
+		t.Fatalf("reading with the wrong comparator: %v", err)
+	}
+
+	r := openReader(t, contents, ReaderOptions{Comparator: leveldb.ReverseBytewiseComparator()})
+	iter := r.NewIterator()
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.Key()) != kvs[i][0] {
+			t.Fatalf("entry %d is %q", i, iter.Key())
+		}
+		i++
+	}
+	if value, err := r.Get([]byte("b")); err != nil || string(value) != "2" {
+		t.Fatalf("Get(b) = %q, %v", value, err)
+	}
+}
+
+// A FilterPolicy whose filters list their keys exactly, so that a
+// filter only matches the keys it was built from.
+type exactFilterPolicy struct {
+	probes int32
+}
+
+func (this *exactFilterPolicy) Name() string {
+	return "test.ExactFilter"
+}
+
+func (this *exactFilterPolicy) CreateFilter(keys []string, dst *string) {
+	for _, key := range keys {
+		*dst += string(rune(len(key))) + key
+	}
+}
+
+func (this *exactFilterPolicy) KeyMayMatch(key string, filter string) bool {
+	atomic.AddInt32(&this.probes, 1)
+	for len(filter) > 0 {
+		n := int(filter[0])
+		if filter[1 : 1 + n] == key {
+			return true
+		}
+		filter = filter[1 + n:]
+	}
+
+	return false
+}
+
+// An io.ReaderAt that counts the reads made through it.
+type countingReaderAt struct {
+	r *bytes.Reader
+	reads int32
+}
+
+func (this *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt32(&this.reads, 1)
+	return this.r.ReadAt(p, off)
+}
+
+func TestFilterPolicy(t *testing.T) {
+	kvs := testKVs()
+	policy := &exactFilterPolicy{}
+	contents := buildTable(t, WriterOptions{BlockSize: 256, FilterPolicy: policy}, kvs)
+
+	file := &countingReaderAt{r: bytes.NewReader(contents)}
+	r, err := NewReader(file, int64(len(contents)), ReaderOptions{FilterPolicy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Keys that are missing never reach their block.
+	reads := atomic.LoadInt32(&file.reads)
+	for _, key := range []string{"key00001", "key00501", "key00997"} {
+		if _, err := r.Get([]byte(key)); !errors.Is(err, leveldb.ErrNotFound) {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+	}
+	if got := atomic.LoadInt32(&file.reads); got != reads {
+		t.Fatalf("%d blocks read for keys the filters rule out", got - reads)
+	}
+	if atomic.LoadInt32(&policy.probes) != 3 {
+		t.Fatalf("%d filter probes for 3 lookups", policy.probes)
+	}
+
+	// Keys that are present still are.
+	for _, kv := range kvs {
+		if value, err := r.Get([]byte(kv[0])); err != nil || string(value) != kv[1] {
+			t.Fatalf("Get(%q) = %q, %v", kv[0], value, err)
+		}
+	}
+
+	// A reader without the policy does not use the filters.
+	r = openReader(t, contents, ReaderOptions{})
+	probes := atomic.LoadInt32(&policy.probes)
+	if _, err := r.Get([]byte("key00001")); !errors.Is(err, leveldb.ErrNotFound) {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&policy.probes) != probes {
+		t.Fatal("a reader without the policy consulted the filters")
+	}
+}
+
+func TestApproximateOffsetOf(t *testing.T) {
+	rnd := rand.New(rand.NewSource(301))
+	random := func(n int) string {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return string(b)
+	}
+	kvs := [][2]string{
+		{"k01", "hello"},
+		{"k02", "hello2"},
+		{"k03", strings.Repeat("x", 10000)},
+		{"k04", random(200000)},
+		{"k05", random(300000)},
+		{"k06", "hello3"},
+		{"k07", random(100000)},
+	}
+	contents := buildTable(t, WriterOptions{BlockSize: 1024, Compression: leveldb.SnappyCompression}, kvs)
+	r := openReader(t, contents, ReaderOptions{})
+
+	for _, c := range []struct {
+		key string
+		low, high uint64
+	}{
+		{"abc", 0, 0},
+		{"k01", 0, 0},
+		{"k01a", 0, 0},
+		{"k02", 0, 0},
+		{"k03", 0, 0},
+		{"k04", 10, 1000},	// "x"s compress well
+		{"k04a", 200000, 201500},	// Random bytes do not
+		{"k05", 200000, 201500},
+		{"k06", 500000, 502500},
+		{"k07", 500000, 502500},
+		{"xyz", 600000, 603000},
+	} {
+		if got := r.ApproximateOffsetOf([]byte(c.key)); got < c.low || got > c.high {
+			t.Errorf("ApproximateOffsetOf(%q) = %d, want [%d, %d]", c.key, got, c.low, c.high)
+		}
+	}
+}
+
+func TestNotATable(t *testing.T) {
+	for _, contents := range [][]byte{
+		nil,
+		[]byte("short"),
+		bytes.Repeat([]byte("not a table "), 100),
+	} {
+		_, err := NewReader(bytes.NewReader(contents), int64(len(contents)), ReaderOptions{})
+		if !errors.Is(err, leveldb.ErrCorruption) {
+			t.Errorf("reading %d bytes that are not a table: %v", len(contents), err)
+		}
+	}
+
+	// A damaged block is caught when checksums are verified.
+	contents := buildTable(t, WriterOptions{}, [][2]string{{"a", "1"}, {"b", "2"}})
+	contents[1] ^= 0xff
+	r := openReader(t, contents, ReaderOptions{VerifyChecksums: true})
+	if _, err := r.Get([]byte("a")); !errors.Is(err, leveldb.ErrCorruption) {
+		t.Fatalf("Get from a damaged block: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+// Package table reads and writes sorted string tables in the format a
+// leveldb DB keeps its data in, without opening a DB.
+//
+// The keys of a table are the keys added to it, ordered by a
+// leveldb.Comparator, and the same comparator must be given to read it.
+package table
+
+import (
+	"io"
+	"../../leveldb"
+)
+
+// Options that control how a Writer lays out a table.  The zero value
+// writes uncompressed blocks with the defaults of leveldb.Options.
+type WriterOptions struct {
+	// Comparator that orders the keys of the table.
+	// If nil, leveldb.BytewiseComparator() is used.
+	Comparator leveldb.Comparator
+
+	// Approximate size of user data packed per block.  If zero, the
+	// default of leveldb.Options is used.
+	BlockSize uint
+
+	// Number of keys between restart points for delta encoding of keys.
+	// If zero, the default of leveldb.Options is used.
+	BlockRestartInterval int
+
+	// Compress blocks using the specified compression algorithm.
+	Compression leveldb.CompressionType
+
+	// If non-nil, a filter built with this policy is stored for every
+	// block, so that a Reader with the same policy can skip the blocks
+	// that cannot hold a key.
+	FilterPolicy leveldb.FilterPolicy
+}
+
+// A Writer builds a table from keys added in increasing order.
+//
+// A Writer is not safe for concurrent use.
+type Writer struct {
+	comparator leveldb.Comparator
+	builder *leveldb.TableBuilder
+	lastKey []byte
+}
+
+// Return a Writer that stores the table it builds in "w".  Writes go to
+// "w" as each block fills up.  If "w" has a Flush() error method it is
+// called after every block.  The caller owns "w".
+func NewWriter(w io.Writer, o WriterOptions) *Writer {
+	options := leveldb.NewOptions()
+	if o.Comparator != nil {
+		options.Comparator = o.Comparator
+	}
+	if o.BlockSize != 0 {
+		options.BlockSize = o.BlockSize
+	}
+	if o.BlockRestartInterval != 0 {
+		options.BlockRestartInterval = o.BlockRestartInterval
+	}
+	options.Compression = o.Compression
+	options.FilterPolicy = o.FilterPolicy
+
+	return &Writer{
+		comparator: options.Comparator,
+		builder: leveldb.NewTableBuilder(options, &writableFile{w: w}),
+	}
+}
+
+// Add key, value to the table being built.
+// REQUIRES: key is after any previously added key according to the
+// comparator, and Finish() has not been called.
+func (this *Writer) Add(key, value []byte) error {
+	if this.builder.NumEntries() > 0 && this.comparator.Compare(string(key), string(this.lastKey)) <= 0 {
+		return leveldb.InvalidArgument("keys added out of order")
+	}
+	this.lastKey = append(this.lastKey[:0], key...)
+
+	this.builder.Add(string(key), string(value))
+	return this.builder.Status().Err()
+}
+
+// Finish building the table.  Writes the remaining blocks, the index
+// and the footer to the underlying writer, which is not used after.
+func (this *Writer) Finish() error {
+	return this.builder.Finish().Err()
+}
+
+// Number of calls to Add() so far.
+func (this *Writer) NumEntries() int64 {
+	return this.builder.NumEntries()
+}
+
+// Size of the table written so far.  After Finish() returns, the size
+// of the whole table.
+func (this *Writer) FileSize() uint64 {
+	return this.builder.FileSize()
+}
+
+// writableFile adapts an io.Writer to leveldb.WritableFile.
+type writableFile struct {
+	w io.Writer
+}
+
+func (this *writableFile) Append(data []byte) leveldb.Status {
+	if _, err := this.w.Write(data); err != nil {
+		return leveldb.IOError("write failed").WithCause(err)
+	}
+
+	return leveldb.OK()
+}
+
+func (this *writableFile) Close() leveldb.Status {
+	// The caller owns the writer.
+	return leveldb.OK()
+}
+
+func (this *writableFile) Flush() leveldb.Status {
+	if f, ok := this.w.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return leveldb.IOError("flush failed").WithCause(err)
+		}
+	}
+
+	return leveldb.OK()
+}
+
+func (this *writableFile) Sync() leveldb.Status {
+	if f, ok := this.w.(interface{ Sync() error }); ok {
+		if err := f.Sync(); err != nil {
+			return leveldb.IOError("sync failed").WithCause(err)
+		}
+	}
+
+	return leveldb.OK()
+}
@@ -1,6 +1,8 @@
 package leveldb
 
-import "./utilties"
+import (
+	"./utilties"
+)
 
 type TableBuilder struct {
 	options *Options
@@ -33,7 +35,7 @@ type TableBuilder struct {
 // Create a builder that will store the contents of the table it is
 // building in *file.  Does not close the file.  It is up to the
 // caller to close the file after calling Finish().
-func newTableBuilder(options *Options, file WritableFile) *TableBuilder {
+func NewTableBuilder(options *Options, file WritableFile) *TableBuilder {
 
 	copyOptions := *options
 
@@ -47,6 +49,7 @@ func newTableBuilder(options *Options, file WritableFile) *TableBuilder {
 		numEntries: 0,
 		closed: false,
 		pendingIndexEntry: false,
+		pendingHandle: &BlockHandle{},
 	}
 
 	if result.options.FilterPolicy == nil {
@@ -103,7 +106,7 @@ func (this *TableBuilder) Add(key string, value string) {
 		this.options.Comparator.FindShortestSeparator(&this.lastKey, key)
 		var handleEncoding []byte
 		this.pendingHandle.EncodeTo(&handleEncoding)
-		this.indexBlock.Add(keyByte, handleEncoding)
+		this.indexBlock.Add([]byte(this.lastKey), handleEncoding)
 		this.pendingIndexEntry = false
 	}
 
@@ -163,13 +166,19 @@ func (this *TableBuilder) writeBlock(block *BlockBuilder, handle *BlockHandle) {
 
 	compressionType := this.options.Compression
 
-	switch compressionType {
-	case NoCompression:
-		blockContents = raw
-	case SnappyCompression:
-		// this would be done in the future~~~
-		// for now, just do the same as NoCompression 
+	if compressionType == NoCompression {
 		blockContents = raw
+	} else {
+		compressor := GetCompressor(compressionType)
+		if compressor != nil && compressor.Compress(raw, &this.compressedOutput) &&
+			len(this.compressedOutput) < len(raw) - (len(raw) / 8) {
+			blockContents = this.compressedOutput
+		} else {
+			// Compression not supported, or compressed less than 12.5%,
+			// so just store uncompressed form
+			blockContents = raw
+			compressionType = NoCompression
+		}
 	}
 
 	this.writeRawBlock(blockContents, compressionType, handle)
@@ -186,9 +195,9 @@ func (this *TableBuilder) writeRawBlock(blockContents []byte, compressionType Co
 
 	if (this.s.OK()) {
 		trailer := make([]byte, kBlockTrailerSize)
-		trailer = append(trailer, byte(compressionType))
+		trailer[0] = byte(compressionType)
 		crc := utilties.Value(blockContents)
-		crc = utilties.Extend(crc, trailer)
+		crc = utilties.Extend(crc, trailer[:1])	// Extend crc to cover block type
 		encodeFixed32(trailer[1:], utilties.Mask(crc))
 		this.s = this.file.Append(trailer)
 
@@ -197,3 +206,89 @@ func (this *TableBuilder) writeRawBlock(blockContents []byte, compressionType Co
 		}
 	}
 }
+
+// Return non-ok iff some error has been detected.
+func (this *TableBuilder) Status() Status {
+	return this.s
+}
+
+// Finish building the table.  Stops using the file passed to the
+// constructor after this function returns.
+// REQUIRES: Finish(), Abandon() have not been called
+func (this *TableBuilder) Finish() Status {
+	this.Flush()
+	this.closed = true
+
+	var filterBlockHandle, metaindexBlockHandle, indexBlockHandle BlockHandle
+
+	// Write filter block
+	if this.ok() && this.filterBlock != nil {
+		this.writeRawBlock(this.filterBlock.Finish(), NoCompression, &filterBlockHandle)
+	}
+
+	// Write metaindex block
+	if this.ok() {
+		metaIndexBlock := newBlockBuilder(this.options)
+		if this.filterBlock != nil {
+			// Add mapping from "filter.Name" to location of filter data
+			key := "filter." + this.options.FilterPolicy.Name()
+			var handleEncoding []byte
+			filterBlockHandle.EncodeTo(&handleEncoding)
+			metaIndexBlock.Add([]byte(key), handleEncoding)
+		}
+
+		// Record the comparator, so that readers can check they order
+		// keys the same way.  "leveldb.comparator" sorts after "filter.".
+		metaIndexBlock.Add([]byte(kComparatorMetaKey), []byte(this.options.Comparator.Name()))
+
+		// TODO(postrelease): Add stats and other meta blocks
+		this.writeBlock(metaIndexBlock, &metaindexBlockHandle)
+	}
+
+	// Write index block
+	if this.ok() {
+		if this.pendingIndexEntry {
+			this.options.Comparator.FindShortSuccessor(&this.lastKey)
+			var handleEncoding []byte
+			this.pendingHandle.EncodeTo(&handleEncoding)
+			this.indexBlock.Add([]byte(this.lastKey), handleEncoding)
+			this.pendingIndexEntry = false
+		}
+		this.writeBlock(this.indexBlock, &indexBlockHandle)
+	}
+
+	// Write footer
+	if this.ok() {
+		var footer Footer
+		footer.metaindexHandle = metaindexBlockHandle
+		footer.indexHandle = indexBlockHandle
+		var footerEncoding []byte
+		footer.EncodeTo(&footerEncoding)
+		this.s = this.file.Append(footerEncoding)
+		if this.ok() {
+			this.offset += uint64(len(footerEncoding))
+		}
+	}
+
+	return this.s
+}
+
+// Indicate that the contents of this builder should be abandoned.  Stops
+// using the file passed to the constructor after this function returns.
+// If the caller is not going to call Finish(), it must call Abandon()
+// before destroying this builder.
+// REQUIRES: Finish(), Abandon() have not been called
+func (this *TableBuilder) Abandon() {
+	this.closed = true
+}
+
+// Number of calls to Add() so far.
+func (this *TableBuilder) NumEntries() int64 {
+	return this.numEntries
+}
+
+// Size of the file generated so far.  If invoked after a successful
+// Finish() call, returns the size of the final generated file.
+func (this *TableBuilder) FileSize() uint64 {
+	return this.offset
+}
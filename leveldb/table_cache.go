@@ -16,21 +16,34 @@ func newTableCache(dbName string, options *Options, entries int) *TableCache {
 	}
 }
 
-func (this *TableCache) NewIterator(options *ReadOptions, fileNumber uint64, fileSize uint64, tablePtr **Table) Iterator {
+// Return an iterator for the specified file number (the corresponding
+// file length must be exactly "fileSize" bytes).  "globalSequence" is
+// the sequence number of every entry of an ingested file, and is
+// ignored for the tables the DB writes itself.  If "tablePtr" is
+// non-nil, also sets "*tablePtr" to point to the Table object
+// underlying the returned iterator, or nil if no Table object underlies
+// the returned iterator.  The returned "*tablePtr" object is owned by
+// the cache and should not be deleted, and is valid for as long as the
+// returned iterator is live.
+func (this *TableCache) NewIterator(options *ReadOptions, fileNumber uint64, fileSize uint64, globalSequence sequenceNumber, tablePtr **Table) iterator {
 	if  tablePtr != nil {
 		*tablePtr = nil
 	}
 
 	var handle interface{}
 
-	s := this.FindTable(fileNumber, fileSize, &handle)
+	s := this.FindTable(fileNumber, fileSize, globalSequence, &handle)
 	if !s.OK() {
 		return NewErrorIterator(s)
 	}
 
+	// The handle stays pinned, so that the file is not closed under
+	// the iterator, until the iterator is closed.
 	tableAndFile, _ := (*this.Cache.Value(&handle)).(TableAndFile)
 	table := tableAndFile.table
-	result := table.NewIterator(options)
+	result := newCleanupIterator(table.NewIterator(options), func() {
+		this.Cache.Release(handle)
+	})
 	if tablePtr != nil {
 		*tablePtr = table
 	}
@@ -38,12 +51,81 @@ func (this *TableCache) NewIterator(options *ReadOptions, fileNumber uint64, fil
 	return result
 }
 
-func (this *TableCache) FindTable(fileNumber, fileSize uint64, handle *interface{}) Status {
+// If a seek to internal key "k" in specified file finds an entry,
+// call saver(arg, found_key, found_value).
+func (this *TableCache) Get(options *ReadOptions, fileNumber uint64, fileSize uint64, globalSequence sequenceNumber, k string, arg interface{}, saver func(arg interface{}, k string, v string)) Status {
+	var handle interface{}
+
+	s := this.FindTable(fileNumber, fileSize, globalSequence, &handle)
+	if s.OK() {
+		tableAndFile, _ := (*this.Cache.Value(&handle)).(TableAndFile)
+		s = tableAndFile.table.InternalGet(options, k, arg, saver)
+		this.Cache.Release(handle)
+	}
+
+	return s
+}
+
+// Evict any entry for the specified file number
+func (this *TableCache) Evict(fileNumber uint64) {
+	this.Cache.Erase(tableCacheKey(fileNumber))
+}
+
+func tableCacheKey(fileNumber uint64) string {
+	buf := make([]byte, 8)
+	encodeFixed64(buf, fileNumber)
+	return string(buf)
+}
 
-	return OK()
+func deleteEntry(key string, value *interface{}) {
+	tableAndFile, _ := (*value).(TableAndFile)
+	(*tableAndFile.file).Close()
+}
+
+func (this *TableCache) FindTable(fileNumber, fileSize uint64, globalSequence sequenceNumber, handle *interface{}) Status {
+	s := OK()
+	key := tableCacheKey(fileNumber)
+
+	*handle = this.Cache.Lookup(key)
+	if *handle == nil {
+		fname := TableFileName(this.dbName, fileNumber)
+		var file RandomAccessFile
+		var table *Table
+
+		s = this.Env.NewRandomAccessFile(fname, &file)
+		if !s.OK() {
+			oldFname := SSTTableFileName(this.dbName, fileNumber)
+			if s2 := this.Env.NewRandomAccessFile(oldFname, &file); s2.OK() {
+				s = OK()
+			}
+		}
+
+		if s.OK() {
+			s = OpenTable(this.options, file, fileSize, &table)
+		}
+		if s.OK() {
+			table.globalSequence = globalSequence
+		}
+
+		if !s.OK() {
+			if file != nil {
+				file.Close()
+			}
+			// We do not cache error results so that if the error is transient,
+			// or somebody repairs the file, we recover automatically.
+		} else {
+			var value interface{} = TableAndFile{
+				file: &file,
+				table: table,
+			}
+			*handle = this.Cache.Insert(key, &value, 1, deleteEntry)
+		}
+	}
+
+	return s
 }
 
 type TableAndFile struct {
 	file *RandomAccessFile
 	table *Table
-}
\ No newline at end of file
+}
@@ -5,17 +5,18 @@ import "fmt"
 // Append a human-readable printout of "value" to *str.
 // Escapes any non-printable characters found in "value".
 func AppendEscapedStringTo(str *string, value string) {
-	totalStr := make([]byte, len(*str) + len(value))
-	copy(totalStr, *str)
+	totalStr := []byte(*str)
 
-	for _, char := range value {
-		if char >= ' ' && char <= '~' {
-			totalStr = append(totalStr, byte(char))
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c >= ' ' && c <= '~' {
+			totalStr = append(totalStr, c)
 		} else {
-			buf := fmt.Sprintf("\\x%02x", char & 0xff)
-			copy(totalStr, buf)
+			totalStr = append(totalStr, fmt.Sprintf("\\x%02x", c)...)
 		}
 	}
+
+	*str = string(totalStr)
 }
 
 
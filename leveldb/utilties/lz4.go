@@ -0,0 +1,196 @@
+package utilties
+
+import "encoding/binary"
+
+// An implementation of the LZ4 block format.  A block is a sequence
+// of (literals, match) pairs:
+//    token: uint8 (literal length in the high nibble, match length - 4
+//           in the low nibble; 15 means more length bytes follow)
+//    extra literal length bytes, each 255 except the last
+//    literals
+//    offset: uint16, little-endian (absent in the last sequence)
+//    extra match length bytes, each 255 except the last
+// The block does not record its uncompressed length.
+
+const (
+	kLZ4MinMatch = 4
+
+	// The last match must start at least this many bytes before the
+	// end of the block.
+	kLZ4MFLimit = 12
+
+	// The last bytes of a block are always literals.
+	kLZ4LastLiterals = 5
+
+	kLZ4MaxOffset = 65535
+	kLZ4HashLog = 16
+)
+
+func lz4Hash(u uint32) uint32 {
+	return (u * 2654435761) >> (32 - kLZ4HashLog)
+}
+
+func lz4AppendLength(dst []byte, n int) []byte {
+	for n >= 255 {
+		dst = append(dst, 255)
+		n -= 255
+	}
+	return append(dst, byte(n))
+}
+
+func lz4EmitSequence(dst []byte, literals []byte, offset int, matchLength int) []byte {
+	token := byte(0)
+	if len(literals) >= 15 {
+		token = 15 << 4
+	} else {
+		token = byte(len(literals)) << 4
+	}
+	ml := matchLength - kLZ4MinMatch
+	if ml >= 15 {
+		token |= 15
+	} else {
+		token |= byte(ml)
+	}
+
+	dst = append(dst, token)
+	if len(literals) >= 15 {
+		dst = lz4AppendLength(dst, len(literals) - 15)
+	}
+	dst = append(dst, literals...)
+	dst = append(dst, byte(offset), byte(offset >> 8))
+	if ml >= 15 {
+		dst = lz4AppendLength(dst, ml - 15)
+	}
+
+	return dst
+}
+
+func lz4EmitLastLiterals(dst []byte, literals []byte) []byte {
+	if len(literals) >= 15 {
+		dst = append(dst, 15 << 4)
+		dst = lz4AppendLength(dst, len(literals) - 15)
+	} else {
+		dst = append(dst, byte(len(literals)) << 4)
+	}
+
+	return append(dst, literals...)
+}
+
+// Append the LZ4 block encoding of "input" to "*output".
+func LZ4CompressBlock(input []byte, output *[]byte) {
+	dst := *output
+	anchor := 0
+
+	if len(input) > kLZ4MFLimit {
+		// Positions are stored plus one so that zero means "empty".
+		var table [1 << kLZ4HashLog]int32
+		sLimit := len(input) - kLZ4MFLimit
+		matchLimit := len(input) - kLZ4LastLiterals
+		s := 0
+		for s < sLimit {
+			seq := binary.LittleEndian.Uint32(input[s:])
+			h := lz4Hash(seq)
+			ref := int(table[h]) - 1
+			table[h] = int32(s + 1)
+
+			if ref < 0 || s - ref > kLZ4MaxOffset || binary.LittleEndian.Uint32(input[ref:]) != seq {
+				// Skip ahead faster the longer we go without a match.
+				s += 1 + (s - anchor) >> 6
+				continue
+			}
+
+			// Extend the match backwards over pending literals.
+			for s > anchor && ref > 0 && input[s - 1] == input[ref - 1] {
+				s--
+				ref--
+			}
+
+			matchLength := kLZ4MinMatch
+			for s + matchLength < matchLimit && input[s + matchLength] == input[ref + matchLength] {
+				matchLength++
+			}
+
+			dst = lz4EmitSequence(dst, input[anchor:s], s - ref, matchLength)
+			s += matchLength
+			anchor = s
+		}
+	}
+
+	*output = lz4EmitLastLiterals(dst, input[anchor:])
+}
+
+// Decode the LZ4 block "input" into "output", which must be exactly as
+// long as the uncompressed data.  Returns false if "input" is not a
+// valid block of that length.
+func LZ4UncompressBlock(input []byte, output []byte) bool {
+	d := 0
+	s := 0
+	for {
+		if s >= len(input) {
+			return false
+		}
+		token := input[s]
+		s++
+
+		literalLength := int(token >> 4)
+		if literalLength == 15 {
+			for {
+				if s >= len(input) {
+					return false
+				}
+				b := input[s]
+				s++
+				literalLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		if literalLength > len(input) - s || literalLength > len(output) - d {
+			return false
+		}
+		copy(output[d:], input[s : s + literalLength])
+		d += literalLength
+		s += literalLength
+
+		if s == len(input) {
+			// The last sequence has no match.
+			break
+		}
+
+		if s + 2 > len(input) {
+			return false
+		}
+		offset := int(input[s]) | int(input[s + 1]) << 8
+		s += 2
+		if offset == 0 || offset > d {
+			return false
+		}
+
+		matchLength := int(token & 0x0f)
+		if matchLength == 15 {
+			for {
+				if s >= len(input) {
+					return false
+				}
+				b := input[s]
+				s++
+				matchLength += int(b)
+				if b != 255 {
+					break
+				}
+			}
+		}
+		matchLength += kLZ4MinMatch
+		if matchLength > len(output) - d {
+			return false
+		}
+
+		// The match may overlap the bytes being written.
+		for end := d + matchLength; d != end; d++ {
+			output[d] = output[d - offset]
+		}
+	}
+
+	return d == len(output)
+}
@@ -0,0 +1,301 @@
+package utilties
+
+import "encoding/binary"
+
+// An implementation of the Snappy block format, byte-compatible with
+// the C++ snappy library used by leveldb.
+//
+// A compressed block is the varint32 length of the uncompressed data
+// followed by a sequence of elements.  The low two bits of the first
+// byte of an element give its kind:
+//    00: literal; the length is in the upper six bits, or in the
+//        following 1-4 bytes when those bits hold 60-63
+//    01: copy with a 3-bit length (4..11) and an 11-bit offset
+//    10: copy with a 6-bit length (1..64) and a 16-bit offset
+//    11: copy with a 6-bit length (1..64) and a 32-bit offset
+
+const (
+	snappyTagLiteral = 0x00
+	snappyTagCopy1 = 0x01
+	snappyTagCopy2 = 0x02
+	snappyTagCopy4 = 0x03
+)
+
+const (
+	// The input is compressed in independent blocks of this size, so
+	// that every back reference fits into a 16-bit offset.
+	kSnappyBlockSize = 1 << 16
+
+	// Blocks shorter than this are emitted as a single literal.
+	kSnappyMinNonLiteralBlockSize = 1 + 1 + kSnappyInputMargin
+
+	// Bytes at the end of a block that the match finder never looks
+	// at, so that it can load 8 bytes at a time without bounds checks.
+	kSnappyInputMargin = 16 - 1
+
+	kSnappyMaxTableSize = 1 << 14
+)
+
+// Store the snappy compressed form of "input" in "*output".  Returns
+// false if the input is too large to be compressed.
+func SnappyCompress(input []byte, output *[]byte) bool {
+	if uint64(len(input)) > 0xffffffff {
+		return false
+	}
+
+	var header [binary.MaxVarintLen32]byte
+	n := binary.PutUvarint(header[:], uint64(len(input)))
+	dst := append((*output)[:0], header[:n]...)
+
+	for len(input) > 0 {
+		block := input
+		if len(block) > kSnappyBlockSize {
+			block = block[:kSnappyBlockSize]
+		}
+		input = input[len(block):]
+
+		if len(block) < kSnappyMinNonLiteralBlockSize {
+			dst = snappyEmitLiteral(dst, block)
+		} else {
+			dst = snappyCompressBlock(dst, block)
+		}
+	}
+
+	*output = dst
+	return true
+}
+
+// Store the length of the data "input" decompresses to in "*result".
+// Returns false if the length header is malformed.
+func SnappyGetUncompressedLength(input []byte, result *int) bool {
+	v, n := binary.Uvarint(input)
+	if n <= 0 || v > 0xffffffff {
+		return false
+	}
+
+	*result = int(v)
+	return true
+}
+
+// Decompress "input" into "output", which must be exactly as long as
+// reported by SnappyGetUncompressedLength.  Returns false if "input"
+// is not a valid compressed block.
+func SnappyUncompress(input []byte, output []byte) bool {
+	v, n := binary.Uvarint(input)
+	if n <= 0 || v != uint64(len(output)) {
+		return false
+	}
+
+	return snappyDecode(output, input[n:])
+}
+
+func snappyLoad32(b []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(b[i : i + 4])
+}
+
+func snappyLoad64(b []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(b[i : i + 8])
+}
+
+func snappyHash(u uint32, shift uint) uint32 {
+	return (u * 0x1e35a7bd) >> shift
+}
+
+func snappyEmitLiteral(dst []byte, literal []byte) []byte {
+	n := uint32(len(literal) - 1)
+	switch {
+	case n < 60:
+		dst = append(dst, byte(n) << 2 | snappyTagLiteral)
+	case n < 1 << 8:
+		dst = append(dst, 60 << 2 | snappyTagLiteral, byte(n))
+	case n < 1 << 16:
+		dst = append(dst, 61 << 2 | snappyTagLiteral, byte(n), byte(n >> 8))
+	case n < 1 << 24:
+		dst = append(dst, 62 << 2 | snappyTagLiteral, byte(n), byte(n >> 8), byte(n >> 16))
+	default:
+		dst = append(dst, 63 << 2 | snappyTagLiteral, byte(n), byte(n >> 8), byte(n >> 16), byte(n >> 24))
+	}
+
+	return append(dst, literal...)
+}
+
+// REQUIRES: 0 < offset < 65536 and length >= 4
+func snappyEmitCopy(dst []byte, offset int, length int) []byte {
+	for length >= 68 {
+		// Emit a length 64 copy, encoded as 3 bytes.
+		dst = append(dst, 63 << 2 | snappyTagCopy2, byte(offset), byte(offset >> 8))
+		length -= 64
+	}
+	if length > 64 {
+		// Emit a length 60 copy, so that the rest is at least 4 long.
+		dst = append(dst, 59 << 2 | snappyTagCopy2, byte(offset), byte(offset >> 8))
+		length -= 60
+	}
+	if length >= 12 || offset >= 2048 {
+		return append(dst, byte(length - 1) << 2 | snappyTagCopy2, byte(offset), byte(offset >> 8))
+	}
+
+	return append(dst, byte(offset >> 8) << 5 | byte(length - 4) << 2 | snappyTagCopy1, byte(offset))
+}
+
+// Compress one block of at most kSnappyBlockSize bytes.
+// REQUIRES: len(src) >= kSnappyMinNonLiteralBlockSize
+func snappyCompressBlock(dst []byte, src []byte) []byte {
+	shift := uint(32 - 8)
+	tableSize := 1 << 8
+	for tableSize < kSnappyMaxTableSize && tableSize < len(src) {
+		tableSize *= 2
+		shift--
+	}
+	var table [kSnappyMaxTableSize]int32
+
+	// Matches are never started within the last kSnappyInputMargin
+	// bytes, so every 8-byte load below stays inside src.
+	sLimit := len(src) - kSnappyInputMargin
+	nextEmit := 0
+	s := 1
+	nextHash := snappyHash(snappyLoad32(src, s), shift)
+
+	for {
+		// Look for a 4-byte match.  The longer we go without finding
+		// one, the more bytes we skip between probes, so that
+		// incompressible data is handled quickly.
+		skip := 32
+		nextS := s
+		candidate := 0
+		for {
+			s = nextS
+			bytesBetweenHashLookups := skip >> 5
+			nextS = s + bytesBetweenHashLookups
+			skip += bytesBetweenHashLookups
+			if nextS > sLimit {
+				if nextEmit < len(src) {
+					dst = snappyEmitLiteral(dst, src[nextEmit:])
+				}
+				return dst
+			}
+			candidate = int(table[nextHash])
+			table[nextHash] = int32(s)
+			nextHash = snappyHash(snappyLoad32(src, nextS), shift)
+			if snappyLoad32(src, s) == snappyLoad32(src, candidate) {
+				break
+			}
+		}
+
+		// Everything between nextEmit and s is unmatched.
+		dst = snappyEmitLiteral(dst, src[nextEmit:s])
+
+		// Emit copies for as long as the next bytes keep matching.
+		for {
+			base := s
+			s += 4
+			for i := candidate + 4; s < len(src) && src[i] == src[s]; i, s = i + 1, s + 1 {
+			}
+			dst = snappyEmitCopy(dst, base - candidate, s - base)
+			nextEmit = s
+			if s >= sLimit {
+				if nextEmit < len(src) {
+					dst = snappyEmitLiteral(dst, src[nextEmit:])
+				}
+				return dst
+			}
+
+			// Index the positions just before and at s, and check whether
+			// a match starts right at s.
+			x := snappyLoad64(src, s - 1)
+			prevHash := snappyHash(uint32(x), shift)
+			table[prevHash] = int32(s - 1)
+			currHash := snappyHash(uint32(x >> 8), shift)
+			candidate = int(table[currHash])
+			table[currHash] = int32(s)
+			if uint32(x >> 8) != snappyLoad32(src, candidate) {
+				nextHash = snappyHash(uint32(x >> 16), shift)
+				s++
+				break
+			}
+		}
+	}
+}
+
+func snappyDecode(dst []byte, src []byte) bool {
+	d := 0
+	s := 0
+	for s < len(src) {
+		var offset, length int
+		switch src[s] & 0x03 {
+		case snappyTagLiteral:
+			x := uint32(src[s] >> 2)
+			switch {
+			case x < 60:
+				s++
+			case x == 60:
+				s += 2
+				if s > len(src) {
+					return false
+				}
+				x = uint32(src[s - 1])
+			case x == 61:
+				s += 3
+				if s > len(src) {
+					return false
+				}
+				x = uint32(src[s - 2]) | uint32(src[s - 1]) << 8
+			case x == 62:
+				s += 4
+				if s > len(src) {
+					return false
+				}
+				x = uint32(src[s - 3]) | uint32(src[s - 2]) << 8 | uint32(src[s - 1]) << 16
+			default:
+				s += 5
+				if s > len(src) {
+					return false
+				}
+				x = binary.LittleEndian.Uint32(src[s - 4 : s])
+			}
+			length = int(x) + 1
+			if length <= 0 || length > len(dst) - d || length > len(src) - s {
+				return false
+			}
+			copy(dst[d:], src[s : s + length])
+			d += length
+			s += length
+			continue
+
+		case snappyTagCopy1:
+			s += 2
+			if s > len(src) {
+				return false
+			}
+			length = 4 + int(src[s - 2] >> 2 & 0x07)
+			offset = int(src[s - 2]) & 0xe0 << 3 | int(src[s - 1])
+
+		case snappyTagCopy2:
+			s += 3
+			if s > len(src) {
+				return false
+			}
+			length = 1 + int(src[s - 3] >> 2)
+			offset = int(src[s - 2]) | int(src[s - 1]) << 8
+
+		case snappyTagCopy4:
+			s += 5
+			if s > len(src) {
+				return false
+			}
+			length = 1 + int(src[s - 5] >> 2)
+			offset = int(binary.LittleEndian.Uint32(src[s - 4 : s]))
+		}
+
+		if offset <= 0 || d < offset || length > len(dst) - d {
+			return false
+		}
+		// The regions may overlap, in which case the copy repeats the
+		// most recent "offset" bytes; copy forward one byte at a time.
+		for end := d + length; d != end; d++ {
+			dst[d] = dst[d - offset]
+		}
+	}
+
+	return d == len(dst)
+}
@@ -2,8 +2,10 @@ package leveldb
 
 import "strconv"
 
+// Tag numbers for serialized VersionEdit.  These numbers are written to
+// disk and should not be changed.
 const (
-	kComparator = iota
+	kComparator = iota + 1
 	kLogNumber
 	kNextFileNumber
 	kLastSequence
@@ -22,6 +24,13 @@ type FileMetaData struct {
 	largest *internalKey
 }
 
+// The sequence number every entry of the file carries if the file was
+// ingested (see DB.IngestExternalFiles), in which case it holds user
+// keys only.  Ingestion records it in both bounds of the file.
+func (this *FileMetaData) globalSequence() sequenceNumber {
+	return this.smallest.sequence()
+}
+
 func newFileMetaData() *FileMetaData {
 	return &FileMetaData{
 		allowedSeeks: 1 << 30,
@@ -98,6 +107,7 @@ func (this *VersionEdit) Clear() {
 	this.hasLogNumber = false
 	this.hasPrevLogNumber = false
 	this.hasNextFileNumber = false
+	this.hasLastSequence = false
 	this.deletedFiles =  make(map[string]deletedFilePair)
 	this.newFiles = this.newFiles[:0]
 	this.compactPointers = this.compactPointers[:0]
@@ -110,7 +120,7 @@ func (this *VersionEdit) SetComparatorName(name string) {
 
 func (this *VersionEdit) SetLogNumber(num uint64) {
 	this.hasLogNumber = true
-	this.prevLogNumber = num
+	this.logNumber = num
 }
 
 func (this *VersionEdit) SetPrevLogNumber(num uint64) {
@@ -162,66 +172,167 @@ func (this *VersionEdit)DeleteFile(level int, file uint64) {
 	}
 }
 
-func (this *VersionEdit) EncodeTo(dst* []byte, start int) int {
+func (this *VersionEdit) EncodeTo(dst *[]byte) {
 	if this.hasComparator {
-		start += putVarint32(dst, start, kComparator)
-		start += putLengthPrefixedSlice(dst, start, this.comparator)
+		putVarint32(dst, kComparator)
+		putLengthPrefixedSlice(dst, this.comparator)
 	}
 
 	if this.hasLogNumber {
-		start += putVarint32(dst, start, kLogNumber)
-		start += putVarint64(dst, start, this.logNumber)
+		putVarint32(dst, kLogNumber)
+		putVarint64(dst, this.logNumber)
 	}
 
 	if this.hasPrevLogNumber {
-		start += putVarint32(dst, start, kPrevLogNumber)
-		start += putVarint64(dst, start, this.prevLogNumber)
+		putVarint32(dst, kPrevLogNumber)
+		putVarint64(dst, this.prevLogNumber)
 	}
 
 	if this.hasNextFileNumber {
-		start += putVarint32(dst, start, kNextFileNumber)
-		start += putVarint64(dst, start, this.nextFileNumber)
+		putVarint32(dst, kNextFileNumber)
+		putVarint64(dst, this.nextFileNumber)
 	}
 
 	if this.hasLastSequence {
-		start += putVarint32(dst, start, kLastSequence)
-		start += putVarint64(dst, start, uint64(this.lastSequence) )
+		putVarint32(dst, kLastSequence)
+		putVarint64(dst, uint64(this.lastSequence) )
 	}
 
 	for _, v := range this.compactPointers {
-		start += putVarint32(dst, start, kCompactPointer)
-		start += putVarint32(dst, start, uint32(v.level) )
-		start += putLengthPrefixedSlice(dst, start, v.key.encode())
+		putVarint32(dst, kCompactPointer)
+		putVarint32(dst, uint32(v.level) )	// level
+		putLengthPrefixedSlice(dst, v.key.encode())
 	}
 
 	for _, v := range this.deletedFiles {
-		start += putVarint32(dst, start, kDeletedFile)
-		start += putVarint32(dst, start, uint32(v.level) )
-		start += putVarint64(dst, start, v.file)
+		putVarint32(dst, kDeletedFile)
+		putVarint32(dst, uint32(v.level) )	// level
+		putVarint64(dst, v.file)
 	}
 
 	for _, v := range this.newFiles {
 		f := v.FileMetaData
-		start += putVarint32(dst, start, kNewFile)
-		start += putVarint32(dst, start, uint32(v.level) )
-		start += putVarint64(dst, start, f.number)
-		start += putVarint64(dst, start, f.fileSize)
-		start += putLengthPrefixedSlice(dst, start, f.smallest.encode())
-		start += putLengthPrefixedSlice(dst, start, f.largest.encode())
+		putVarint32(dst, kNewFile)
+		putVarint32(dst, uint32(v.level) )	// level
+		putVarint64(dst, f.number)
+		putVarint64(dst, f.fileSize)
+		putLengthPrefixedSlice(dst, f.smallest.encode())
+		putLengthPrefixedSlice(dst, f.largest.encode())
 	}
 }
 
+func getInternalKey(input *[]byte, dst *internalKey) bool {
+	var str string
+	if getLengthPrefixedBytes(input, &str) {
+		dst.decodeFrom(str)
+		return true
+	}
+
+	return false
+}
+
+func getLevel(input *[]byte, level *int) bool {
+	var v uint32
+	if getVarint32(input, &v) && v < kNumLevels {
+		*level = int(v)
+		return true
+	}
+
+	return false
+}
+
 func (this *VersionEdit) DecodeFrom(src []byte) Status {
 	this.Clear()
-
+	input := src
+	var msg string
+	var tag uint32
 
 	// Temporary storage for parsing
-	var level int 
+	var level int
 	var number uint64
-	var f FileMetaData
-	var key internalKey
+	var str string
+
+	for msg == "" && getVarint32(&input, &tag) {
+		switch tag {
+		case kComparator:
+			if getLengthPrefixedBytes(&input, &str) {
+				this.comparator = str
+				this.hasComparator = true
+			} else {
+				msg = "comparator name"
+			}
+
+		case kLogNumber:
+			if getVarint64(&input, &this.logNumber) {
+				this.hasLogNumber = true
+			} else {
+				msg = "log number"
+			}
+
+		case kPrevLogNumber:
+			if getVarint64(&input, &this.prevLogNumber) {
+				this.hasPrevLogNumber = true
+			} else {
+				msg = "previous log number"
+			}
+
+		case kNextFileNumber:
+			if getVarint64(&input, &this.nextFileNumber) {
+				this.hasNextFileNumber = true
+			} else {
+				msg = "next file number"
+			}
+
+		case kLastSequence:
+			var seq uint64
+			if getVarint64(&input, &seq) {
+				this.lastSequence = sequenceNumber(seq)
+				this.hasLastSequence = true
+			} else {
+				msg = "last sequence number"
+			}
+
+		case kCompactPointer:
+			var key internalKey
+			if getLevel(&input, &level) && getInternalKey(&input, &key) {
+				this.SetCompactPointer(level, key)
+			} else {
+				msg = "compaction pointer"
+			}
+
+		case kDeletedFile:
+			if getLevel(&input, &level) && getVarint64(&input, &number) {
+				this.DeleteFile(level, number)
+			} else {
+				msg = "deleted file"
+			}
+
+		case kNewFile:
+			f := newFileMetaData()
+			f.smallest = new(internalKey)
+			f.largest = new(internalKey)
+			if getLevel(&input, &level) &&
+				getVarint64(&input, &f.number) &&
+				getVarint64(&input, &f.fileSize) &&
+				getInternalKey(&input, f.smallest) &&
+				getInternalKey(&input, f.largest) {
+				this.AddFile(level, f.number, f.fileSize, f.smallest, f.largest)
+			} else {
+				msg = "new-file entry"
+			}
+
+		default:
+			msg = "unknown tag"
+		}
+	}
 
-	//for len(src) >= 0 && getVarint32()
+	if msg == "" && len(input) != 0 {
+		msg = "invalid tag"
+	}
+
+	if msg != "" {
+		return Corruption("VersionEdit: " + msg)
+	}
 
 	return OK()
 }
@@ -232,11 +343,34 @@ func (this *VersionEdit) DebugString() string {
 	result += "VersionEdit {"
 
 	if this.hasComparator {
-		result += "\n	Comparator: "
+		result += "\n  Comparator: "
 		result += this.comparator
 	}
-
-
+	if this.hasLogNumber {
+		result += "\n  LogNumber: " + strconv.FormatUint(this.logNumber, 10)
+	}
+	if this.hasPrevLogNumber {
+		result += "\n  PrevLogNumber: " + strconv.FormatUint(this.prevLogNumber, 10)
+	}
+	if this.hasNextFileNumber {
+		result += "\n  NextFile: " + strconv.FormatUint(this.nextFileNumber, 10)
+	}
+	if this.hasLastSequence {
+		result += "\n  LastSeq: " + strconv.FormatUint(uint64(this.lastSequence), 10)
+	}
+	for _, v := range this.compactPointers {
+		result += "\n  CompactPointer: " + strconv.Itoa(v.level) + " " + v.key.String()
+	}
+	for _, v := range this.deletedFiles {
+		result += "\n  DeleteFile: " + strconv.Itoa(v.level) + " " + strconv.FormatUint(v.file, 10)
+	}
+	for _, v := range this.newFiles {
+		f := v.FileMetaData
+		result += "\n  AddFile: " + strconv.Itoa(v.level) + " " +
+			strconv.FormatUint(f.number, 10) + " " + strconv.FormatUint(f.fileSize, 10) + " " +
+			f.smallest.String() + " .. " + f.largest.String()
+	}
+	result += "\n}\n"
 
 	return result
-}
\ No newline at end of file
+}
@@ -2,6 +2,7 @@ package leveldb
 
 import (
 	"sort"
+	"strconv"
 	"sync"
 )
 
@@ -18,6 +19,57 @@ type saver struct {
 	value *string
 }
 
+func saveValue(arg interface{}, ikey string, v string) {
+	s := arg.(*saver)
+	var parsedKey parsedInternalKey
+	if !parseInternalKey(ikey, &parsedKey) {
+		s.state = saver_state_corrupt
+	} else {
+		if s.ucmp.Compare(parsedKey.userKey, s.userKey) == 0 {
+			if parsedKey.vt == kTypeValue {
+				s.state = saver_state_found
+				*s.value = v
+			} else {
+				s.state = saver_state_deleted
+			}
+		}
+	}
+}
+
+const kTargetFileSize = 2 * 1048576
+
+// Maximum bytes of overlaps in grandparent (i.e., level+2) before we
+// stop building a single file in a level->level+1 compaction.
+const kMaxGrandParentOverlapBytes = 10 * kTargetFileSize
+
+// Maximum number of bytes in all compacted files.  We avoid expanding
+// the lower level file set of a compaction if it would make the
+// total compaction cover more than this many bytes.
+const kExpandedCompactionByteSizeLimit = 25 * kTargetFileSize
+
+func MaxBytesForLevel(level int) float64 {
+	// Note: the result for level zero is not really used since we set
+	// the level-0 compaction threshold based on number of files.
+	result := 10.0 * 1048576.0	// Result for both level-0 and level-1
+	for level > 1 {
+		result *= 10
+		level--
+	}
+	return result
+}
+
+func MaxFileSizeForLevel(level int) uint64 {
+	return kTargetFileSize	// We could vary per level to reduce number of files?
+}
+
+func TotalFileSize(files []*FileMetaData) int64 {
+	var sum int64
+	for _, f := range files {
+		sum += int64(f.fileSize)
+	}
+	return sum
+}
+
 
 type Version struct {
 	vSet *VersionSet // VersionSet to which this Version belongs
@@ -35,6 +87,8 @@ type Version struct {
   	// are initialized by Finalize().
 	compactionScore float64
 	CompactionLevel	int
+
+	refs int // Number of live refs to this version
 }
 
 func newVersion(vSet *VersionSet) *Version {
@@ -46,10 +100,26 @@ func newVersion(vSet *VersionSet) *Version {
 	v.fileToCompactLevel = 0
 	v.compactionScore = -1
 	v.CompactionLevel = -1
+	v.refs = 0
 
 	return &v
 }
 
+// Reference count management (so Versions do not disappear out from
+// under live iterators)
+func (this *Version) Ref() {
+	this.refs++
+}
+
+func (this *Version) Unref() {
+	this.refs--
+	if this.refs == 0 {
+		// Remove from linked list
+		this.prev.next = this.next
+		this.next.prev = this.prev
+	}
+}
+
 type VersionSet struct {
 	Env
 	dbName string
@@ -77,6 +147,7 @@ func newVersionSet( dbName string, options *Options, tableCache *TableCache, int
 	var versionSet VersionSet
 	versionSet.Env = options.Env
 	versionSet.dbName = dbName
+	versionSet.options = options
 	versionSet.tableCache = tableCache
 	versionSet.icmp = internalKeyComparator
 	versionSet.nextFileNumber = 2
@@ -95,12 +166,18 @@ func newVersionSet( dbName string, options *Options, tableCache *TableCache, int
 }
 
 func (this *VersionSet) AppendVersion(v *Version) {
+	// Make "v" current
+	if this.current != nil {
+		this.current.Unref()
+	}
 	this.current = v
+	v.Ref()
 
+	// Append to linked list
 	v.prev = this.dummyVersions.prev
 	v.next = this.dummyVersions
 	v.prev.next = v
-	v.prev.prev = v
+	v.next.prev = v
 }
 
 // Allocate and return a new file number
@@ -110,17 +187,891 @@ func (this *VersionSet) NewFileNumber() uint64 {
 	return result
 }
 
+// Arrange to reuse "fileNumber" unless a newer file number has
+// already been allocated.
+// REQUIRES: "fileNumber" was returned by a call to NewFileNumber().
+func (this *VersionSet) ReuseFileNumber(fileNumber uint64) {
+	if this.nextFileNumber == fileNumber + 1 {
+		this.nextFileNumber = fileNumber
+	}
+}
+
+// Return the current manifest file number
+func (this *VersionSet) ManifestFileNumber() uint64 {
+	return this.mainfestFileNumber
+}
+
+// Return the last sequence number.
+func (this *VersionSet) LastSequence() sequenceNumber {
+	return sequenceNumber(this.lastSequence)
+}
+
+// Set the last sequence number to s.
+func (this *VersionSet) SetLastSequence(s sequenceNumber) {
+	// assert(uint64(s) >= this.lastSequence)
+	this.lastSequence = uint64(s)
+}
+
+// Return the current log file number.
+func (this *VersionSet) LogNumber() uint64 {
+	return this.logNumber
+}
+
+// Return the log file number for the log file that is currently
+// being compacted, or zero if there is no such log file.
+func (this *VersionSet) PrevLogNumber() uint64 {
+	return this.prevLogNumber
+}
+
+// Return the number of Table files at the specified level.
+func (this *VersionSet) NumLevelFiles(level int) int {
+	return len(this.current.files[level])
+}
+
+// Return the combined file size of all files at the specified level.
+func (this *VersionSet) NumLevelBytes(level int) int64 {
+	return TotalFileSize(this.current.files[level])
+}
+
+// Return a human-readable short (single-line) summary of the number
+// of files per level.
+func (this *VersionSet) LevelSummary() string {
+	result := "files[ "
+	for level := 0; level < kNumLevels; level++ {
+		result += strconv.Itoa(len(this.current.files[level])) + " "
+	}
+	return result + "]"
+}
+
+// Add all files listed in any live version to *live.
+// May also mutate some internal state.
+func (this *VersionSet) AddLiveFiles(live map[uint64]bool) {
+	for v := this.dummyVersions.next; v != this.dummyVersions; v = v.next {
+		for level := 0; level < kNumLevels; level++ {
+			for _, f := range v.files[level] {
+				live[f.number] = true
+			}
+		}
+	}
+}
+
+// Return the approximate offset in the database of the data for
+// "ikey" as of version "v".
+func (this *VersionSet) ApproximateOffsetOf(v *Version, ikey *internalKey) uint64 {
+	var result uint64
+	for level := 0; level < kNumLevels; level++ {
+		for _, f := range v.files[level] {
+			if this.icmp.Compare(f.largest.encode(), ikey.encode()) <= 0 {
+				// Entire file is before "ikey", so just add the file size
+				result += f.fileSize
+			} else if this.icmp.Compare(f.smallest.encode(), ikey.encode()) > 0 {
+				// Entire file is after "ikey", so ignore
+				if level > 0 {
+					// Files other than level 0 are sorted by smallest, so
+					// no further files in this level will contain data for
+					// "ikey".
+					break
+				}
+			} else {
+				// "ikey" falls in the range for this table.  Add the
+				// approximate offset of "ikey" within the table.
+				var tablePtr *Table
+				iter := this.tableCache.NewIterator(&ReadOptions{}, f.number, f.fileSize, f.globalSequence(), &tablePtr)
+				if tablePtr != nil {
+					result += tablePtr.ApproximateOffsetOf(ikey.encode())
+				}
+				iter.Close()
+			}
+		}
+	}
+
+	return result
+}
+
+// Create an iterator that reads over the compaction inputs for "*c".
+func (this *VersionSet) MakeInputIterator(c *Compaction) iterator {
+	var options ReadOptions
+	options.VerifyChecksums = this.options.ParanoidChecks
+
+	// Level-0 files have to be merged together.  For other levels,
+	// we will make a concatenating iterator per level.
+	var list []iterator
+	for which := 0; which < 2; which++ {
+		if len(c.inputs[which]) != 0 {
+			if c.Level() + which == 0 {
+				for _, f := range c.inputs[which] {
+					list = append(list, this.tableCache.NewIterator(&options, f.number, f.fileSize, f.globalSequence(), nil))
+				}
+			} else {
+				// Create concatenating iterator for the files from this level
+				list = append(list, NewTwoLevelIterator(
+					newLevelFileNumIterator(this.icmp, c.inputs[which]),
+					GetFileIterator, this.tableCache, &options))
+			}
+		}
+	}
+
+	return NewMergingIterator(this.icmp, list)
+}
+
+// Apply *edit to the current version to form a new descriptor that
+// is both saved to persistent state and installed as the new
+// current version.
+// REQUIRES: *mu is held on entry.
 func (this *VersionSet) LogAndApply(edit *VersionEdit, mu *sync.Mutex) Status {
+	if edit.hasLogNumber {
+		// assert(edit.logNumber >= this.logNumber)
+		// assert(edit.logNumber < this.nextFileNumber)
+	} else {
+		edit.SetLogNumber(this.logNumber)
+	}
+
+	if !edit.hasPrevLogNumber {
+		edit.SetPrevLogNumber(this.prevLogNumber)
+	}
+
+	edit.SetNextFile(this.nextFileNumber)
+	edit.SetLastSequence(sequenceNumber(this.lastSequence))
+
+	v := newVersion(this)
+	builder := newVersionSetBuilder(this, this.current)
+	builder.Apply(edit)
+	builder.SaveTo(v)
+	this.Finalize(v)
+
+	// Initialize new descriptor log file if necessary by creating
+	// a temporary file that contains a snapshot of the current version.
+	var newManifestFile string
+	s := OK()
+	if this.descriptorLog == nil {
+		// No reason to unlock mu here since we only hit this path in the
+		// first call to LogAndApply (when opening the database).
+		// assert(this.descriptorFile == nil)
+		newManifestFile = DescriptorFileName(this.dbName, this.mainfestFileNumber)
+		var file WritableFile
+		s = this.Env.NewWritableFile(newManifestFile, &file)
+		if s.OK() {
+			this.descriptorFile = &file
+			this.descriptorLog = newLogWriter(this.descriptorFile)
+			s = this.WriteSnapshot(this.descriptorLog)
+		}
+	}
+
+	// Unlock during expensive MANIFEST log write
+	mu.Unlock()
+
+	// Write new record to MANIFEST log
+	if s.OK() {
+		var record []byte
+		edit.EncodeTo(&record)
+		s = this.descriptorLog.AddRecord(record)
+		if s.OK() {
+			s = (*this.descriptorFile).Sync()
+		}
+		if !s.OK() {
+			Log(this.options.InfoLog, "MANIFEST write: %s", s.String())
+		}
+	}
+
+	// If we just created a new descriptor file, install it by writing a
+	// new CURRENT file that points to it.
+	if s.OK() && newManifestFile != "" {
+		s = SetCurrentFile(this.Env, this.dbName, this.mainfestFileNumber)
+	}
+
+	mu.Lock()
+
+	// Install the new version
+	if s.OK() {
+		this.AppendVersion(v)
+		this.logNumber = edit.logNumber
+		this.prevLogNumber = edit.prevLogNumber
+	} else {
+		if newManifestFile != "" {
+			if this.descriptorFile != nil {
+				(*this.descriptorFile).Close()
+			}
+			this.descriptorLog = nil
+			this.descriptorFile = nil
+			this.Env.DeleteFile(newManifestFile)
+		}
+	}
+
+	return s
+}
+
+// Close the descriptor log.  The VersionSet must not be used afterwards.
+func (this *VersionSet) Close() Status {
+	s := OK()
+	if this.descriptorFile != nil {
+		s = (*this.descriptorFile).Close()
+		this.descriptorLog = nil
+		this.descriptorFile = nil
+	}
+
+	return s
+}
+
+type versionSetLogReporter struct {
+	status *Status
+}
+
+func (this *versionSetLogReporter) Corruption(bytes int, s Status) {
+	if this.status.OK() {
+		*this.status = s
+	}
+}
+
+// Open the manifest file the "CURRENT" file of "dbName" points to,
+// and store its name relative to "dbName" in "*current".
+func openCurrentManifest(env Env, dbName string, current *string, file *SequentialFile) Status {
+	// Read "CURRENT" file, which contains a pointer to the current manifest file
+	s := ReadFileToString(env, CurrentFileName(dbName), current)
+	if !s.OK() {
+		return s
+	}
+	if *current == "" || (*current)[len(*current) - 1] != '\n' {
+		return Corruption("CURRENT file does not end with newline")
+	}
+	*current = (*current)[:len(*current) - 1]
+
+	dscname := dbName + "/" + *current
+	s = env.NewSequentialFile(dscname, file)
+	if !s.OK() {
+		if s.IsNotFound() {
+			return Corruption("CURRENT points to a non-existent file: " + s.String())
+		}
+		return s
+	}
+
 	return OK()
 }
 
+// Return the name of the comparator the DB "name" was created with, as
+// recorded in its MANIFEST.  Pass it to GetComparator to obtain a
+// Comparator that can open the DB.
+func ReadComparatorName(name string, options *Options) (string, error) {
+	var current string
+	var file SequentialFile
+	s := openCurrentManifest(options.Env, name, &current, &file)
+	if !s.OK() {
+		return "", s
+	}
+	defer file.Close()
+
+	reporter := &versionSetLogReporter{
+		status: &s,
+	}
+	reader := newLogReader(file, reporter, true /*checksum*/, 0 /*initialOffset*/)
+	var record, scratch []byte
+	for reader.ReadRecord(&record, &scratch) && s.OK() {
+		edit := newVersionEdit()
+		s = edit.DecodeFrom(record)
+		if s.OK() && edit.hasComparator {
+			return edit.comparator, nil
+		}
+	}
+	if !s.OK() {
+		return "", s
+	}
+
+	return "", Corruption("no comparator entry in descriptor")
+}
+
+// Recover the last saved descriptor from persistent storage.
+func (this *VersionSet) Recover(saveManifest *bool) Status {
+	var current string
+	var file SequentialFile
+	s := openCurrentManifest(this.Env, this.dbName, &current, &file)
+	if !s.OK() {
+		return s
+	}
+	dscname := this.dbName + "/" + current
+
+	haveLogNumber := false
+	havePrevLogNumber := false
+	haveNextFile := false
+	haveLastSequence := false
+	var nextFile uint64
+	var lastSequence uint64
+	var logNumber uint64
+	var prevLogNumber uint64
+	builder := newVersionSetBuilder(this, this.current)
+	readRecords := 0
+
+	{
+		reporter := &versionSetLogReporter{
+			status: &s,
+		}
+		reader := newLogReader(file, reporter, true /*checksum*/, 0 /*initialOffset*/)
+		var record, scratch []byte
+		for reader.ReadRecord(&record, &scratch) && s.OK() {
+			readRecords++
+			edit := newVersionEdit()
+			s = edit.DecodeFrom(record)
+			if s.OK() {
+				if edit.hasComparator && edit.comparator != this.icmp.userComparator().Name() {
+					s = InvalidArgument(edit.comparator + " does not match existing comparator " +
+						this.icmp.userComparator().Name())
+				}
+			}
+
+			if s.OK() {
+				builder.Apply(edit)
+			}
+
+			if edit.hasLogNumber {
+				logNumber = edit.logNumber
+				haveLogNumber = true
+			}
+
+			if edit.hasPrevLogNumber {
+				prevLogNumber = edit.prevLogNumber
+				havePrevLogNumber = true
+			}
+
+			if edit.hasNextFileNumber {
+				nextFile = edit.nextFileNumber
+				haveNextFile = true
+			}
+
+			if edit.hasLastSequence {
+				lastSequence = uint64(edit.lastSequence)
+				haveLastSequence = true
+			}
+		}
+	}
+	file.Close()
+
+	if s.OK() {
+		if !haveNextFile {
+			s = Corruption("no meta-nextfile entry in descriptor")
+		} else if !haveLogNumber {
+			s = Corruption("no meta-lognumber entry in descriptor")
+		} else if !haveLastSequence {
+			s = Corruption("no last-sequence-number entry in descriptor")
+		}
+
+		if !havePrevLogNumber {
+			prevLogNumber = 0
+		}
+
+		this.MarkFileNumberUsed(prevLogNumber)
+		this.MarkFileNumberUsed(logNumber)
+	}
+
+	if s.OK() {
+		v := newVersion(this)
+		builder.SaveTo(v)
+		// Install recovered version
+		this.Finalize(v)
+		this.AppendVersion(v)
+		this.mainfestFileNumber = nextFile
+		this.nextFileNumber = nextFile + 1
+		this.lastSequence = lastSequence
+		this.logNumber = logNumber
+		this.prevLogNumber = prevLogNumber
+
+		// See if we can reuse the existing MANIFEST file.
+		if this.ReuseManifest(dscname, current) {
+			// No need to save new manifest
+		} else {
+			*saveManifest = true
+		}
+	} else {
+		Log(this.options.InfoLog, "Error recovering version set with %d records: %s", readRecords, s.String())
+	}
+
+	return s
+}
+
+func (this *VersionSet) ReuseManifest(dscname string, dscbase string) bool {
+	if !this.options.ReuseLogs {
+		return false
+	}
+
+	var manifestNumber uint64
+	var manifestType FileType
+	if !ParseFileName(dscbase, &manifestNumber, &manifestType) || manifestType != kDescriptorFile {
+		return false
+	}
+	manifestSize, s := this.Env.GetFileSize(dscname)
+	// Make new compacted MANIFEST if old one is too big
+	if !s.OK() || uint64(manifestSize) >= MaxFileSizeForLevel(0) {
+		return false
+	}
+
+	// assert(this.descriptorFile == nil)
+	// assert(this.descriptorLog == nil)
+	var file WritableFile
+	r := this.Env.NewAppendableFile(dscname, &file)
+	if !r.OK() {
+		Log(this.options.InfoLog, "Reuse MANIFEST: %s", r.String())
+		return false
+	}
+
+	Log(this.options.InfoLog, "Reusing MANIFEST %s", dscname)
+	this.descriptorFile = &file
+	this.descriptorLog = newLogWriterWithLength(this.descriptorFile, uint64(manifestSize))
+	this.mainfestFileNumber = manifestNumber
+	return true
+}
+
+// Mark the specified file number as used.
+func (this *VersionSet) MarkFileNumberUsed(number uint64) {
+	if this.nextFileNumber <= number {
+		this.nextFileNumber = number + 1
+	}
+}
+
+// Save current contents to log
+func (this *VersionSet) WriteSnapshot(log *LogWriter) Status {
+	// TODO: Break up into multiple records to reduce memory usage on recovery?
+
+	// Save metadata
+	edit := newVersionEdit()
+	edit.SetComparatorName(this.icmp.userComparator().Name())
+
+	// Save compaction pointers
+	for level := 0; level < kNumLevels; level++ {
+		if this.CompactPointer[level] != "" {
+			var key internalKey
+			key.decodeFrom(this.CompactPointer[level])
+			edit.SetCompactPointer(level, key)
+		}
+	}
+
+	// Save files
+	for level := 0; level < kNumLevels; level++ {
+		for _, f := range this.current.files[level] {
+			edit.AddFile(level, f.number, f.fileSize, f.smallest, f.largest)
+		}
+	}
+
+	var record []byte
+	edit.EncodeTo(&record)
+
+	return log.AddRecord(record)
+}
+
+// Returns true iff some level needs a compaction.
+func (this *VersionSet) NeedsCompaction() bool {
+	v := this.current
+	return v.compactionScore >= 1 || v.fileToCompact != nil
+}
+
+// Precomputed best level for next compaction
+func (this *VersionSet) Finalize(v *Version) {
+	bestLevel := -1
+	bestScore := -1.0
+
+	for level := 0; level < kNumLevels - 1; level++ {
+		var score float64
+		if level == 0 {
+			// We treat level-0 specially by bounding the number of files
+			// instead of number of bytes for two reasons:
+			//
+			// (1) With larger write-buffer sizes, it is nice not to do too
+			// many level-0 compactions.
+			//
+			// (2) The files in level-0 are merged on every read and
+			// therefore we wish to avoid too many files when the individual
+			// file size is small (perhaps because of a small write-buffer
+			// setting, or very high compression ratios, or lots of
+			// overwrites/deletions).
+			score = float64(len(v.files[level])) / float64(kL0_CompactionTrigger)
+		} else {
+			// Compute the ratio of current size to size limit.
+			levelBytes := TotalFileSize(v.files[level])
+			score = float64(levelBytes) / MaxBytesForLevel(level)
+		}
+
+		if score > bestScore {
+			bestLevel = level
+			bestScore = score
+		}
+	}
+
+	v.CompactionLevel = bestLevel
+	v.compactionScore = bestScore
+}
+
+// A helper class so we can efficiently apply a whole sequence
+// of edits to a particular state without creating intermediate
+// Versions that contain full copies of the intermediate state.
+type versionSetBuilder struct {
+	vSet *VersionSet
+	base *Version
+	levels [kNumLevels]levelState
+}
+
+type levelState struct {
+	deletedFiles map[uint64]bool
+	addedFiles []*FileMetaData
+}
+
+// Initialize a builder with the files from *base and other info from *vset
+func newVersionSetBuilder(vSet *VersionSet, base *Version) *versionSetBuilder {
+	var builder versionSetBuilder
+	builder.vSet = vSet
+	builder.base = base
+	base.Ref()
+
+	for level := 0; level < kNumLevels; level++ {
+		builder.levels[level].deletedFiles = make(map[uint64]bool)
+	}
+
+	return &builder
+}
+
+// Apply all of the edits in *edit to the current state.
+func (this *versionSetBuilder) Apply(edit *VersionEdit) {
+	// Update compaction pointers
+	for _, v := range edit.compactPointers {
+		this.vSet.CompactPointer[v.level] = v.key.encode()
+	}
+
+	// Delete files
+	for _, v := range edit.deletedFiles {
+		this.levels[v.level].deletedFiles[v.file] = true
+	}
+
+	// Add new files
+	for i := range edit.newFiles {
+		level := edit.newFiles[i].level
+		f := new(FileMetaData)
+		*f = edit.newFiles[i].FileMetaData
+
+		// We arrange to automatically compact this file after
+		// a certain number of seeks.  Let's assume:
+		//   (1) One seek costs approximately the same as the compaction
+		//   of 40KB of data
+		//   (2) Writing or reading 1MB costs 10ms (100MB/s)
+		//   (3) A compaction of 1MB does 25MB of IO:
+		//         1MB read from this level
+		//         10-12MB read from next level (boundaries may be misaligned)
+		//         10-12MB written to next level
+		// This implies that 25 seeks cost the same as the compaction
+		// of 1MB of data.  I.e., one seek costs approximately the
+		// same as the compaction of 40KB of data.  We are a little
+		// conservative and allow approximately one seek for every 16KB
+		// of data before triggering a compaction.
+		f.allowedSeeks = int(f.fileSize / 16384)
+		if f.allowedSeeks < 100 {
+			f.allowedSeeks = 100
+		}
+
+		delete(this.levels[level].deletedFiles, f.number)
+		this.levels[level].addedFiles = append(this.levels[level].addedFiles, f)
+	}
+}
+
+// Save the current state in *v.
+func (this *versionSetBuilder) SaveTo(v *Version) {
+	icmp := this.vSet.icmp
+	bySmallestKey := func(a, b *FileMetaData) bool {
+		r := icmp.Compare(a.smallest.encode(), b.smallest.encode())
+		if r != 0 {
+			return r < 0
+		}
+		// Break ties by file number
+		return a.number < b.number
+	}
+
+	for level := 0; level < kNumLevels; level++ {
+		// Merge the set of added files with the set of pre-existing files.
+		// Drop any deleted files.  Store the result in *v.
+		files := make([]*FileMetaData, 0, len(this.base.files[level]) + len(this.levels[level].addedFiles))
+		files = append(files, this.base.files[level]...)
+		files = append(files, this.levels[level].addedFiles...)
+
+		sort.Stable(&FileMetaDataSort{
+			fileMetaData: files,
+			less: bySmallestKey,
+		})
+
+		for _, f := range files {
+			if this.levels[level].deletedFiles[f.number] {
+				// File is deleted: do nothing
+				continue
+			}
+			v.files[level] = append(v.files[level], f)
+		}
+	}
+
+	this.base.Unref()
+}
+
+// Stores the minimal range that covers all entries in inputs in
+// *smallest, *largest.
+// REQUIRES: inputs is not empty
+func (this *VersionSet) GetRange(inputs []*FileMetaData, smallest *internalKey, largest *internalKey) {
+	smallest.clear()
+	largest.clear()
+
+	for i, f := range inputs {
+		if i == 0 {
+			*smallest = *f.smallest
+			*largest = *f.largest
+		} else {
+			if this.icmp.Compare(f.smallest.encode(), smallest.encode()) < 0 {
+				*smallest = *f.smallest
+			}
+			if this.icmp.Compare(f.largest.encode(), largest.encode()) > 0 {
+				*largest = *f.largest
+			}
+		}
+	}
+}
+
+// Stores the minimal range that covers all entries in inputs1 and inputs2
+// in *smallest, *largest.
+// REQUIRES: inputs is not empty
+func (this *VersionSet) GetRange2(inputs1 []*FileMetaData, inputs2 []*FileMetaData, smallest *internalKey, largest *internalKey) {
+	all := make([]*FileMetaData, 0, len(inputs1) + len(inputs2))
+	all = append(all, inputs1...)
+	all = append(all, inputs2...)
+	this.GetRange(all, smallest, largest)
+}
+
+// Pick level and inputs for a new compaction.
+// Returns nil if there is no compaction to be done.
+// Otherwise returns a pointer to a heap-allocated object that
+// describes the compaction.
+func (this *VersionSet) PickCompaction() *Compaction {
+	var c *Compaction
+	var level int
+
+	// We prefer compactions triggered by too much data in a level over
+	// the compactions triggered by seeks.
+	sizeCompaction := this.current.compactionScore >= 1
+	seekCompaction := this.current.fileToCompact != nil
+
+	if sizeCompaction {
+		level = this.current.CompactionLevel
+		c = newCompaction(level)
+
+		// Pick the first file that comes after CompactPointer[level]
+		for _, f := range this.current.files[level] {
+			if this.CompactPointer[level] == "" ||
+				this.icmp.Compare(f.largest.encode(), this.CompactPointer[level]) > 0 {
+				c.inputs[0] = append(c.inputs[0], f)
+				break
+			}
+		}
+
+		if len(c.inputs[0]) == 0 {
+			// Wrap-around to the beginning of the key space
+			c.inputs[0] = append(c.inputs[0], this.current.files[level][0])
+		}
+	} else if seekCompaction {
+		level = this.current.fileToCompactLevel
+		c = newCompaction(level)
+		c.inputs[0] = append(c.inputs[0], this.current.fileToCompact)
+	} else {
+		return nil
+	}
+
+	c.inputVersion = this.current
+	c.inputVersion.Ref()
+
+	// Files in level 0 may overlap each other, so pick up all overlapping ones
+	if level == 0 {
+		var smallest, largest internalKey
+		this.GetRange(c.inputs[0], &smallest, &largest)
+
+		// Note that the next call will discard the file we placed in
+		// c.inputs[0] earlier and replace it with an overlapping set
+		// which will include the picked file.
+		c.inputs[0] = this.current.GetOverlappingInputs(0, &smallest, &largest)
+	}
+
+	this.SetupOtherInputs(c)
+
+	return c
+}
+
+func (this *VersionSet) SetupOtherInputs(c *Compaction) {
+	level := c.level
+	var smallest, largest internalKey
+	this.GetRange(c.inputs[0], &smallest, &largest)
+
+	c.inputs[1] = this.current.GetOverlappingInputs(level + 1, &smallest, &largest)
+
+	// Get entire range covered by compaction
+	var allStart, allLimit internalKey
+	this.GetRange2(c.inputs[0], c.inputs[1], &allStart, &allLimit)
+
+	// See if we can grow the number of inputs in "level" without
+	// changing the number of "level+1" files we pick up.
+	if len(c.inputs[1]) > 0 {
+		expanded0 := this.current.GetOverlappingInputs(level, &allStart, &allLimit)
+		inputs1Size := TotalFileSize(c.inputs[1])
+		expanded0Size := TotalFileSize(expanded0)
+
+		if len(expanded0) > len(c.inputs[0]) &&
+			inputs1Size + expanded0Size < kExpandedCompactionByteSizeLimit {
+			var newStart, newLimit internalKey
+			this.GetRange(expanded0, &newStart, &newLimit)
+			expanded1 := this.current.GetOverlappingInputs(level + 1, &newStart, &newLimit)
+
+			if len(expanded1) == len(c.inputs[1]) {
+				smallest = newStart
+				largest = newLimit
+				c.inputs[0] = expanded0
+				c.inputs[1] = expanded1
+				this.GetRange2(c.inputs[0], c.inputs[1], &allStart, &allLimit)
+			}
+		}
+	}
+
+	// Compute the set of grandparent files that overlap this compaction
+	// (parent == level+1; grandparent == level+2)
+	if level + 2 < kNumLevels {
+		c.grandparents = this.current.GetOverlappingInputs(level + 2, &allStart, &allLimit)
+	}
+
+	// Update the place where we will do the next compaction for this level.
+	// We update this immediately instead of waiting for the VersionEdit
+	// to be applied so that if the compaction fails, we will try a different
+	// key range next time.
+	this.CompactPointer[level] = largest.encode()
+	c.edit.SetCompactPointer(level, largest)
+}
+
+// Return a compaction object for compacting the range [begin,end] in
+// the specified level.  Returns nil if there is nothing in that
+// level that overlaps the specified range.
+func (this *VersionSet) CompactRange(level int, begin *internalKey, end *internalKey) *Compaction {
+	inputs := this.current.GetOverlappingInputs(level, begin, end)
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	// Avoid compacting too much in one shot in case the range is large.
+	// But we cannot do this for level-0 since level-0 files can overlap
+	// and we must not pick one file and drop another older file if the
+	// two files overlap.
+	if level > 0 {
+		limit := MaxFileSizeForLevel(level)
+		var total uint64
+		for i := 0; i < len(inputs); i++ {
+			total += inputs[i].fileSize
+			if total >= limit {
+				inputs = inputs[:i + 1]
+				break
+			}
+		}
+	}
+
+	c := newCompaction(level)
+	c.inputVersion = this.current
+	c.inputVersion.Ref()
+	c.inputs[0] = inputs
+	this.SetupOtherInputs(c)
+
+	return c
+}
+
+// Return a human readable string that describes this version's contents.
+func (this *Version) DebugString() string {
+	var r string
+	for level := 0; level < kNumLevels; level++ {
+		// E.g.,
+		//   --- level 1 ---
+		//   17:123['a' .. 'd']
+		//   20:43['e' .. 'g']
+		r += "--- level " + strconv.Itoa(level) + " ---\n"
+		for _, f := range this.files[level] {
+			r += " " + strconv.FormatUint(f.number, 10) + ":" + strconv.FormatUint(f.fileSize, 10) +
+				"[" + f.smallest.String() + " .. " + f.largest.String() + "]\n"
+		}
+	}
+
+	return r
+}
+
+func (this *Version) NewConcatenatingIterator(readOptions *ReadOptions, level int) iterator {
+	return NewTwoLevelIterator(
+		newLevelFileNumIterator(this.vSet.icmp, this.filesInBounds(readOptions, level)),
+		GetFileIterator, this.vSet.tableCache, readOptions)
+}
+
+// Returns true iff some key of "f" lies within the bounds of "readOptions".
+func fileInBounds(ucmp Comparator, readOptions *ReadOptions, f *FileMetaData) bool {
+	if readOptions.LowerBound != nil &&
+		ucmp.Compare(f.largest.userKey(), bytesToString(readOptions.LowerBound)) < 0 {
+		return false
+	}
+	if readOptions.UpperBound != nil &&
+		ucmp.Compare(f.smallest.userKey(), bytesToString(readOptions.UpperBound)) >= 0 {
+		return false
+	}
+
+	return true
+}
+
+// Return the files of "level" that hold keys within the bounds of
+// "readOptions".  Since the files of levels > 0 are sorted and disjoint,
+// they are a contiguous part of the level.
+// REQUIRES: level > 0
+func (this *Version) filesInBounds(readOptions *ReadOptions, level int) []*FileMetaData {
+	ucmp := this.vSet.icmp.userComparator()
+	files := this.files[level]
+	if readOptions.LowerBound != nil {
+		lower := bytesToString(readOptions.LowerBound)
+		first := sort.Search(len(files), func(i int) bool {
+			return ucmp.Compare(files[i].largest.userKey(), lower) >= 0
+		})
+		files = files[first:]
+	}
+	if readOptions.UpperBound != nil {
+		upper := bytesToString(readOptions.UpperBound)
+		limit := sort.Search(len(files), func(i int) bool {
+			return ucmp.Compare(files[i].smallest.userKey(), upper) >= 0
+		})
+		files = files[:limit]
+	}
+
+	return files
+}
+
 // Append to *iters a sequence of iterators that will
 // yield the contents of this Version when merged together.
+// Files whose keys all lie outside the bounds of "readOptions"
+// are left out.
 // REQUIRES: This version has been saved (see VersionSet::SaveTo)
-func (this *Version) AddIterators(readOptions *ReadOptions, iters []*Iterator) {
-	// for key, value := range this.files[0] {
+func (this *Version) AddIterators(readOptions *ReadOptions, iters *[]iterator) {
+	ucmp := this.vSet.icmp.userComparator()
+
+	// Merge all level zero files together since they may overlap
+	for _, f := range this.files[0] {
+		if fileInBounds(ucmp, readOptions, f) {
+			*iters = append(*iters, this.vSet.tableCache.NewIterator(readOptions, f.number, f.fileSize, f.globalSequence(), nil))
+		}
+	}
 
-	// }
+	// For levels > 0, we can use a concatenating iterator that sequentially
+	// walks through the non-overlapping files in the level, opening them
+	// lazily.
+	for level := 1; level < kNumLevels; level++ {
+		if len(this.filesInBounds(readOptions, level)) != 0 {
+			*iters = append(*iters, this.NewConcatenatingIterator(readOptions, level))
+		}
+	}
+}
+
+// Orders level-0 files from the newest to the oldest entries.  Files
+// written from memtables have both the highest file numbers and the
+// highest sequence numbers, but an ingested file gets its file number
+// before its sequence number, so the sequence numbers decide.
+func newestFirst(a, b *FileMetaData) bool {
+	aSequence, bSequence := a.largest.sequence(), b.largest.sequence()
+	if aSequence != bSequence {
+		return aSequence > bSequence
+	}
+
+	return a.number > b.number
 }
 
 func (this *Version) Get(readOptions *ReadOptions, key LookupKey, value *string) (seekFile *FileMetaData, seekFileLevel int, status Status) {
@@ -167,9 +1118,7 @@ func (this *Version) Get(readOptions *ReadOptions, key LookupKey, value *string)
 
 			sort.Sort(&FileMetaDataSort{
 				fileMetaData: tmp,
-				less: func(a, b *FileMetaData) bool {
-					return a.number > b.number
-				},
+				less: newestFirst,
 			})
 
 			files = tmp
@@ -208,15 +1157,364 @@ func (this *Version) Get(readOptions *ReadOptions, key LookupKey, value *string)
 			s.userKey = userKey
 			s.value = value
 
-			//state = this.vSet.tableCache.Get(readOptions, f.number, f.fileSize, iKey, &f, )
+			status = this.vSet.tableCache.Get(readOptions, f.number, f.fileSize, f.globalSequence(), iKey, &s, saveValue)
+			if !status.OK() {
+				return seekFile, seekFileLevel, status
+			}
 
+			switch s.state {
+			case saver_state_not_found:
+				// Keep searching in other files
+			case saver_state_found:
+				return seekFile, seekFileLevel, status
+			case saver_state_deleted:
+				status = NotFound("")	// Use empty error message for speed
+				return seekFile, seekFileLevel, status
+			case saver_state_corrupt:
+				status = Corruption("corrupted key for " + userKey)
+				return seekFile, seekFileLevel, status
+			}
 		}
 	}
-	
+
+	status = NotFound("")	// Use an empty error message for speed
 	return seekFile, seekFileLevel, status
 }
 
 
+// Adds "stats" into the current state.  Returns true if a new
+// compaction may need to be triggered, false otherwise.
+// REQUIRES: lock is held
+func (this *Version) UpdateStats(seekFile *FileMetaData, seekFileLevel int) bool {
+	if seekFile != nil {
+		seekFile.allowedSeeks--
+		if seekFile.allowedSeeks <= 0 && this.fileToCompact == nil {
+			this.fileToCompact = seekFile
+			this.fileToCompactLevel = seekFileLevel
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns true iff some file in the specified level overlaps
+// some part of [smallestUserKey,largestUserKey].
+// smallestUserKey == nil represents a key smaller than all the DB's keys.
+// largestUserKey == nil represents a key largest than all the DB's keys.
+func (this *Version) OverlapInLevel(level int, smallestUserKey *string, largestUserKey *string) bool {
+	return SomeFileOverlapsRange(this.vSet.icmp, level > 0, this.files[level], smallestUserKey, largestUserKey)
+}
+
+// Return the level at which we should place a new memtable compaction
+// result that covers the range [smallestUserKey,largestUserKey].
+func (this *Version) PickLevelForMemTableOutput(smallestUserKey string, largestUserKey string) int {
+	level := 0
+	if !this.OverlapInLevel(0, &smallestUserKey, &largestUserKey) {
+		// Push to next level if there is no overlap in next level,
+		// and the #bytes overlapping in the level after that are limited.
+		start := makeInternalKey(smallestUserKey, kMaxSequenceNumber, kValueTypeForSeek)
+		limit := makeInternalKey(largestUserKey, 0, kTypeDeletion)
+		for level < kMaxMemCompactLevel {
+			if this.OverlapInLevel(level + 1, &smallestUserKey, &largestUserKey) {
+				break
+			}
+			if level + 2 < kNumLevels {
+				// Check that file does not overlap too many grandparent bytes.
+				overlaps := this.GetOverlappingInputs(level + 2, &start, &limit)
+				if TotalFileSize(overlaps) > kMaxGrandParentOverlapBytes {
+					break
+				}
+			}
+			level++
+		}
+	}
+
+	return level
+}
+
+// Return the level at which we should place an ingested file that
+// covers the range [smallestUserKey,largestUserKey]: the deepest level
+// such that no file at that level or above overlaps the range.  Every
+// entry in the version must be older than the file.
+func (this *Version) PickLevelForIngestedFile(smallestUserKey string, largestUserKey string) int {
+	level := 0
+	if !this.OverlapInLevel(0, &smallestUserKey, &largestUserKey) {
+		for level + 1 < kNumLevels && !this.OverlapInLevel(level + 1, &smallestUserKey, &largestUserKey) {
+			level++
+		}
+	}
+
+	return level
+}
+
+// Return all files in "level" that overlap [begin,end].
+// begin == nil means before all keys; end == nil means after all keys.
+func (this *Version) GetOverlappingInputs(level int, begin *internalKey, end *internalKey) []*FileMetaData {
+	var inputs []*FileMetaData
+	var userBegin, userEnd string
+	if begin != nil {
+		userBegin = begin.userKey()
+	}
+	if end != nil {
+		userEnd = end.userKey()
+	}
+
+	userCmp := this.vSet.icmp.userComparator()
+
+	for i := 0; i < len(this.files[level]); {
+		f := this.files[level][i]
+		i++
+		fileStart := f.smallest.userKey()
+		fileLimit := f.largest.userKey()
+
+		if begin != nil && userCmp.Compare(fileLimit, userBegin) < 0 {
+			// "f" is completely before specified range; skip it
+		} else if end != nil && userCmp.Compare(fileStart, userEnd) > 0 {
+			// "f" is completely after specified range; skip it
+		} else {
+			inputs = append(inputs, f)
+			if level == 0 {
+				// Level-0 files may overlap each other.  So check if the newly
+				// added file has expanded the range.  If so, restart search.
+				if begin != nil && userCmp.Compare(fileStart, userBegin) < 0 {
+					userBegin = fileStart
+					inputs = inputs[:0]
+					i = 0
+				} else if end != nil && userCmp.Compare(fileLimit, userEnd) > 0 {
+					userEnd = fileLimit
+					inputs = inputs[:0]
+					i = 0
+				}
+			}
+		}
+	}
+
+	return inputs
+}
+
+// A Compaction encapsulates information about a compaction.
+type Compaction struct {
+	level int
+	maxOutputFileSize uint64
+	inputVersion *Version
+	edit *VersionEdit
+
+	// Each compaction reads inputs from "level" and "level+1"
+	inputs [2][]*FileMetaData // The two sets of inputs
+
+	// State used to check for number of overlapping grandparent files
+	// (parent == level + 1, grandparent == level + 2)
+	grandparents []*FileMetaData
+	grandparentIndex int // Index in grandparents
+	seenKey bool // Some output key has been seen
+	overlappedBytes int64 // Bytes of overlap between current output
+	                      // and grandparent files
+
+	// State for implementing IsBaseLevelForKey
+
+	// levelPtrs holds indices into inputVersion.files: our state
+	// is that we are positioned at one of the file ranges for each
+	// higher level than the ones involved in this compaction (i.e. for
+	// all L >= level + 2).
+	levelPtrs [kNumLevels]int
+}
+
+func newCompaction(level int) *Compaction {
+	return &Compaction{
+		level: level,
+		maxOutputFileSize: MaxFileSizeForLevel(level),
+		inputVersion: nil,
+		edit: newVersionEdit(),
+		grandparentIndex: 0,
+		seenKey: false,
+		overlappedBytes: 0,
+	}
+}
+
+// Return the level that is being compacted.  Inputs from "level"
+// and "level+1" will be merged to produce a set of "level+1" files.
+func (this *Compaction) Level() int {
+	return this.level
+}
+
+// Return the object that holds the edits to the descriptor done
+// by this compaction.
+func (this *Compaction) Edit() *VersionEdit {
+	return this.edit
+}
+
+// "which" must be either 0 or 1
+func (this *Compaction) NumInputFiles(which int) int {
+	return len(this.inputs[which])
+}
+
+// Return the ith input file at "level()+which" ("which" must be 0 or 1).
+func (this *Compaction) Input(which int, i int) *FileMetaData {
+	return this.inputs[which][i]
+}
+
+// Maximum size of files to build during this compaction.
+func (this *Compaction) MaxOutputFileSize() uint64 {
+	return this.maxOutputFileSize
+}
+
+// Is this a trivial compaction that can be implemented by just
+// moving a single input file to the next level (no merging or splitting)
+func (this *Compaction) IsTrivialMove() bool {
+	// Avoid a move if there is lots of overlapping grandparent data.
+	// Otherwise, the move could create a parent file that will require
+	// a very expensive merge later on.
+	return this.NumInputFiles(0) == 1 &&
+		this.NumInputFiles(1) == 0 &&
+		TotalFileSize(this.grandparents) <= kMaxGrandParentOverlapBytes
+}
+
+// Release the input version for the compaction, once the compaction
+// is successful.
+func (this *Compaction) ReleaseInputs() {
+	if this.inputVersion != nil {
+		this.inputVersion.Unref()
+		this.inputVersion = nil
+	}
+}
+
+// Returns true if the information we have available guarantees that
+// the compaction is producing data in "level+1" for which no data exists
+// in levels greater than "level+1".
+func (this *Compaction) IsBaseLevelForKey(userKey string) bool {
+	// Maybe use binary search to find right entry instead of linear search?
+	userCmp := this.inputVersion.vSet.icmp.userComparator()
+	for lvl := this.level + 2; lvl < kNumLevels; lvl++ {
+		files := this.inputVersion.files[lvl]
+		for this.levelPtrs[lvl] < len(files) {
+			f := files[this.levelPtrs[lvl]]
+			if userCmp.Compare(userKey, f.largest.userKey()) <= 0 {
+				// We've advanced far enough
+				if userCmp.Compare(userKey, f.smallest.userKey()) >= 0 {
+					// Key falls in this file's range, so definitely not base level
+					return false
+				}
+				break
+			}
+			this.levelPtrs[lvl]++
+		}
+	}
+
+	return true
+}
+
+// Returns true iff we should stop building the current output
+// before processing "internalKey".
+func (this *Compaction) ShouldStopBefore(internalKey string) bool {
+	// Scan to find earliest grandparent file that contains key.
+	icmp := this.inputVersion.vSet.icmp
+	for this.grandparentIndex < len(this.grandparents) &&
+		icmp.Compare(internalKey, this.grandparents[this.grandparentIndex].largest.encode()) > 0 {
+		if this.seenKey {
+			this.overlappedBytes += int64(this.grandparents[this.grandparentIndex].fileSize)
+		}
+		this.grandparentIndex++
+	}
+	this.seenKey = true
+
+	if this.overlappedBytes > kMaxGrandParentOverlapBytes {
+		// Too much overlap for current output; start new output
+		this.overlappedBytes = 0
+		return true
+	}
+
+	return false
+}
+
+// Add all inputs to this compaction as delete operations to *edit.
+func (this *Compaction) AddInputDeletions(edit *VersionEdit) {
+	for which := 0; which < 2; which++ {
+		for _, f := range this.inputs[which] {
+			edit.DeleteFile(this.level + which, f.number)
+		}
+	}
+}
+
+// An internal iterator.  For a given version/level pair, yields
+// information about the files in the level.  For a given entry, key()
+// is the largest key that occurs in the file, and value() is an
+// 24-byte value containing the file number, the file size and the
+// global sequence number of the file, all encoded using encodeFixed64.
+type levelFileNumIterator struct {
+	icmp *internalKeyComparator
+	flist []*FileMetaData
+	index int
+}
+
+func newLevelFileNumIterator(icmp *internalKeyComparator, flist []*FileMetaData) *levelFileNumIterator {
+	return &levelFileNumIterator{
+		icmp: icmp,
+		flist: flist,
+		index: len(flist),	// Marks as invalid
+	}
+}
+
+func (this *levelFileNumIterator) Valid() bool {
+	return this.index < len(this.flist)
+}
+
+func (this *levelFileNumIterator) Seek(target string) {
+	this.index = FindFile(this.icmp, this.flist, target)
+}
+
+func (this *levelFileNumIterator) SeekToFirst() {
+	this.index = 0
+}
+
+func (this *levelFileNumIterator) SeekToLast() {
+	if len(this.flist) == 0 {
+		this.index = 0
+	} else {
+		this.index = len(this.flist) - 1
+	}
+}
+
+func (this *levelFileNumIterator) Next() {
+	this.index++
+}
+
+func (this *levelFileNumIterator) Prev() {
+	if this.index == 0 {
+		this.index = len(this.flist)	// Marks as invalid
+	} else {
+		this.index--
+	}
+}
+
+func (this *levelFileNumIterator) Key() string {
+	return this.flist[this.index].largest.encode()
+}
+
+func (this *levelFileNumIterator) Value() string {
+	valueBuf := make([]byte, 24)
+	encodeFixed64(valueBuf, this.flist[this.index].number)
+	encodeFixed64(valueBuf[8:], this.flist[this.index].fileSize)
+	encodeFixed64(valueBuf[16:], uint64(this.flist[this.index].globalSequence()))
+	return string(valueBuf)
+}
+
+func (this *levelFileNumIterator) Status() Status {
+	return OK()
+}
+
+func (this *levelFileNumIterator) Close() {
+}
+
+func GetFileIterator(arg interface{}, options *ReadOptions, fileValue string) iterator {
+	cache := arg.(*TableCache)
+	if len(fileValue) != 24 {
+		return NewErrorIterator(Corruption("FileReader invoked with unexpected value"))
+	}
+
+	return cache.NewIterator(options, decodeFixed64(fileValue[:8]), decodeFixed64(fileValue[8:16]),
+		sequenceNumber(decodeFixed64(fileValue[16:])), nil)
+}
 
 func FindFile(icmp *internalKeyComparator, files []*FileMetaData, key string) int {
 	left := 0
@@ -234,4 +1532,51 @@ func FindFile(icmp *internalKeyComparator, files []*FileMetaData, key string) in
 	}
 
 	return right
-}
\ No newline at end of file
+}
+
+func afterFile(ucmp Comparator, userKey *string, f *FileMetaData) bool {
+	// nil userKey occurs before all keys and is therefore never after f
+	return userKey != nil && ucmp.Compare(*userKey, f.largest.userKey()) > 0
+}
+
+func beforeFile(ucmp Comparator, userKey *string, f *FileMetaData) bool {
+	// nil userKey occurs after all keys and is therefore never before f
+	return userKey != nil && ucmp.Compare(*userKey, f.smallest.userKey()) < 0
+}
+
+// Returns true iff some file in "files" overlaps the user key range
+// [*smallestUserKey,*largestUserKey].
+// smallestUserKey == nil represents a key smaller than all keys in the DB.
+// largestUserKey == nil represents a key largest than all keys in the DB.
+// REQUIRES: If disjointSortedFiles, files[] contains disjoint ranges
+//           in sorted order.
+func SomeFileOverlapsRange(icmp *internalKeyComparator, disjointSortedFiles bool, files []*FileMetaData,
+	smallestUserKey *string, largestUserKey *string) bool {
+	ucmp := icmp.userComparator()
+	if !disjointSortedFiles {
+		// Need to check against all files
+		for _, f := range files {
+			if afterFile(ucmp, smallestUserKey, f) || beforeFile(ucmp, largestUserKey, f) {
+				// No overlap
+			} else {
+				return true	// Overlap
+			}
+		}
+		return false
+	}
+
+	// Binary search over file list
+	index := 0
+	if smallestUserKey != nil {
+		// Find the earliest possible internal key for smallestUserKey
+		small := makeInternalKey(*smallestUserKey, kMaxSequenceNumber, kValueTypeForSeek)
+		index = FindFile(icmp, files, small.encode())
+	}
+
+	if index >= len(files) {
+		// beginning of range is after all files, so no overlap.
+		return false
+	}
+
+	return !beforeFile(ucmp, largestUserKey, files[index])
+}
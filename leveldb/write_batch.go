@@ -1,11 +1,193 @@
 package leveldb
 
+// WriteBatch::rep_ :=
+//    sequence: fixed64
+//    count: fixed32
+//    data: record[count]
+// record :=
+//    kTypeValue varstring varstring         |
+//    kTypeDeletion varstring
+// varstring :=
+//    len: varint32
+//    data: uint8[len]
+
+// WriteBatch header has an 8-byte sequence number followed by a 4-byte count.
+const kWriteBatchHeader = 12
+
+// WriteBatch holds a collection of updates to apply atomically to a DB.
+//
+// The updates are applied in the order in which they are added
+// to the WriteBatch.  For example, the value of "key" will be "v3"
+// after the following batch is written:
+//
+//    batch.Put([]byte("key"), []byte("v1"))
+//    batch.Delete([]byte("key"))
+//    batch.Put([]byte("key"), []byte("v2"))
+//    batch.Put([]byte("key"), []byte("v3"))
+//
+// Multiple threads can invoke const methods on a WriteBatch without
+// external synchronization, but if any of the threads may call a
+// non-const method, all threads accessing the same WriteBatch must use
+// external synchronization.
 type WriteBatch struct {
+	rep []byte	// See comment above for the format of rep
+}
 
+// Support for iterating over the contents of a batch.  The slices
+// passed to the handler share storage with the batch, so they must not
+// be modified, and must be copied to be kept past the call.
+type WriteBatchHandler interface {
+	Put(key []byte, value []byte)
+	Delete(key []byte)
 }
 
-func newWriteBatch() *WriteBatch {
-	return &WriteBatch {
-		
+func NewWriteBatch() *WriteBatch {
+	result := &WriteBatch {
 	}
-}
\ No newline at end of file
+	result.Clear()
+
+	return result
+}
+
+// Store the mapping "key->value" in the database.
+func (this *WriteBatch) Put(key []byte, value []byte) {
+	setWriteBatchCount(this, writeBatchCount(this) + 1)
+	this.rep = append(this.rep, byte(kTypeValue))
+	putLengthPrefixedSlice(&this.rep, bytesToString(key))
+	putLengthPrefixedSlice(&this.rep, bytesToString(value))
+}
+
+// If the database contains a mapping for "key", erase it.  Else do nothing.
+func (this *WriteBatch) Delete(key []byte) {
+	setWriteBatchCount(this, writeBatchCount(this) + 1)
+	this.rep = append(this.rep, byte(kTypeDeletion))
+	putLengthPrefixedSlice(&this.rep, bytesToString(key))
+}
+
+// Clear all updates buffered in this batch.
+func (this *WriteBatch) Clear() {
+	this.rep = make([]byte, kWriteBatchHeader)
+}
+
+// The size of the database changes caused by this batch.
+//
+// This number is tied to implementation details, and may change across
+// releases. It is intended for LevelDB usage metrics.
+func (this *WriteBatch) ApproximateSize() int {
+	return len(this.rep)
+}
+
+// Copies the operations in "source" to this batch.
+//
+// This runs in O(source size) time. However, the constant factor is better
+// than calling Iterate() over the source batch with a Handler that replicates
+// the operations into this batch.
+func (this *WriteBatch) Append(source *WriteBatch) {
+	appendWriteBatch(this, source)
+}
+
+func (this *WriteBatch) Iterate(handler WriteBatchHandler) Status {
+	input := this.rep
+	if len(input) < kWriteBatchHeader {
+		return Corruption("malformed WriteBatch (too small)")
+	}
+
+	input = input[kWriteBatchHeader:]
+	found := 0
+	for len(input) > 0 {
+		found++
+		tag := ValueType(input[0])
+		input = input[1:]
+		switch tag {
+		case kTypeValue:
+			var key, value []byte
+			if getLengthPrefixedView(&input, &key) && getLengthPrefixedView(&input, &value) {
+				handler.Put(key, value)
+			} else {
+				return Corruption("bad WriteBatch Put")
+			}
+		case kTypeDeletion:
+			var key []byte
+			if getLengthPrefixedView(&input, &key) {
+				handler.Delete(key)
+			} else {
+				return Corruption("bad WriteBatch Delete")
+			}
+		default:
+			return Corruption("unknown WriteBatch tag")
+		}
+	}
+
+	if found != writeBatchCount(this) {
+		return Corruption("WriteBatch has wrong count")
+	}
+
+	return OK()
+}
+
+// Return the number of entries in the batch.
+func writeBatchCount(b *WriteBatch) int {
+	return int(decodeFixed32(bytesToString(b.rep[8:])))
+}
+
+// Set the count for the number of entries in the batch.
+func setWriteBatchCount(b *WriteBatch, n int) {
+	encodeFixed32(b.rep[8:], uint32(n))
+}
+
+// Return the sequence number for the start of this batch.
+func writeBatchSequence(b *WriteBatch) sequenceNumber {
+	return sequenceNumber(decodeFixed64(bytesToString(b.rep)))
+}
+
+// Store the specified number as the sequence number for the start of
+// this batch.
+func setWriteBatchSequence(b *WriteBatch, seq sequenceNumber) {
+	encodeFixed64(b.rep, uint64(seq))
+}
+
+func writeBatchContents(b *WriteBatch) []byte {
+	return b.rep
+}
+
+func writeBatchByteSize(b *WriteBatch) int {
+	return len(b.rep)
+}
+
+func setWriteBatchContents(b *WriteBatch, contents []byte) {
+	// assert(len(contents) >= kWriteBatchHeader)
+	b.rep = append(b.rep[:0], contents...)
+}
+
+func appendWriteBatch(dst *WriteBatch, src *WriteBatch) {
+	setWriteBatchCount(dst, writeBatchCount(dst) + writeBatchCount(src))
+	// assert(len(src.rep) >= kWriteBatchHeader)
+	dst.rep = append(dst.rep, src.rep[kWriteBatchHeader:]...)
+}
+
+type memTableInserter struct {
+	sequence sequenceNumber
+	mem *MemTable
+}
+
+// MemTable.Add copies what it is given, so the batch contents can be
+// passed to it without copying them first.
+func (this *memTableInserter) Put(key []byte, value []byte) {
+	this.mem.Add(this.sequence, kTypeValue, bytesToString(key), bytesToString(value))
+	this.sequence++
+}
+
+func (this *memTableInserter) Delete(key []byte) {
+	this.mem.Add(this.sequence, kTypeDeletion, bytesToString(key), "")
+	this.sequence++
+}
+
+// Insert the contents of the batch into memtable.
+func writeBatchInsertInto(b *WriteBatch, memtable *MemTable) Status {
+	inserter := &memTableInserter{
+		sequence: writeBatchSequence(b),
+		mem: memtable,
+	}
+
+	return b.Iterate(inserter)
+}
@@ -4,8 +4,12 @@ import "../leveldb"
 
 func main() {
 	options := leveldb.NewOptions()
-	
-	var db leveldb.DB 
+	options.CreateIfMissing = true
 
-	leveldb.Open(*options, "/tmp/testdb", &db)
-}
\ No newline at end of file
+	db, err := leveldb.Open("/tmp/testdb", options)
+	if err != nil {
+		return
+	}
+
+	db.Close()
+}